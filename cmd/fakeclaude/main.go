@@ -0,0 +1,315 @@
+// Command fakeclaude stands in for the claude CLI in end-to-end tests. It
+// speaks the stream-json protocol Desktop uses (--input-format stream-json
+// --output-format stream-json) well enough to drive a spawn through the
+// daemon without network access or credentials.
+//
+// Each user message gets an assistant reply and a "result" line. A message
+// starting with a slash command does something else instead:
+//
+//	/exit N           exit with code N
+//	/signal NAME      die from signal NAME (TERM, KILL, HUP, ...)
+//	/pwd              reply with the working directory
+//	/present PATH...  call the cowork present_files MCP tool with PATHs via
+//	                  a control_request, wait for the control_response and
+//	                  reply with its content
+//
+// Messages are appended to $CLAUDE_CONFIG_DIR/projects/<slug(cwd)>/<id>.jsonl
+// (CLAUDE_CONFIG_DIR defaults to ~/.claude), like the real CLI's transcripts.
+// --session-id picks the id. --resume <id> continues an existing transcript
+// and, like the real CLI, fails with "No conversation found with session ID"
+// when there is none under the current directory's slug.
+//
+// Environment:
+//
+//	FAKECLAUDE_EXIT_CODE       exit code at end of input (default 0)
+//	FAKECLAUDE_IGNORE_SIGINT   when set, SIGINT is ignored; otherwise it
+//	                           exits 130
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/transcript"
+)
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+type session struct {
+	id         string
+	cwd        string
+	transcript string
+
+	outMu sync.Mutex // held per output line, so SIGINT never exits mid-line
+
+	turns     int
+	nextReqID int
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fakeclaude:", err)
+		return 1
+	}
+	s := &session{cwd: cwd}
+
+	// Like the CLI, SIGINT is a graceful stop (the daemon's kill sends it
+	// first and waits before escalating).
+	sigint := make(chan os.Signal, 1)
+	signal.Notify(sigint, syscall.SIGINT)
+	go func() {
+		for range sigint {
+			if os.Getenv("FAKECLAUDE_IGNORE_SIGINT") == "" {
+				s.outMu.Lock()
+				os.Exit(130)
+			}
+		}
+	}()
+
+	resumeID := transcript.ExtractResumeID(args)
+	s.id = flagValue(args, "--session-id")
+	if resumeID != "" {
+		s.id = resumeID
+	}
+	if s.id == "" {
+		s.id = fmt.Sprintf("fake-%d-%d", os.Getpid(), time.Now().UnixNano())
+	}
+	s.transcript = filepath.Join(configDir(), "projects", transcript.Slugify(cwd), s.id+".jsonl")
+	if resumeID != "" {
+		if _, err := os.Stat(s.transcript); err != nil {
+			msg := "No conversation found with session ID: " + resumeID
+			fmt.Fprintln(os.Stderr, msg)
+			s.emit(map[string]interface{}{"type": "result", "subtype": "error_during_execution", "is_error": true, "result": msg, "session_id": s.id})
+			return 1
+		}
+	}
+
+	s.emit(map[string]interface{}{
+		"type": "system", "subtype": "init", "session_id": s.id, "cwd": cwd,
+		"model": "fakeclaude", "tools": []string{}, "mcp_servers": []string{},
+		"resumed": resumeID != "",
+	})
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	for line := range lines {
+		var msg struct {
+			Type    string `json:"type"`
+			Message struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"message"`
+		}
+		if json.Unmarshal([]byte(line), &msg) != nil || msg.Type != "user" {
+			continue
+		}
+		text := contentText(msg.Message.Content)
+		s.record("user", text)
+		if code, exit := s.handle(text, lines); exit {
+			return code
+		}
+	}
+
+	code, _ := strconv.Atoi(os.Getenv("FAKECLAUDE_EXIT_CODE"))
+	return code
+}
+
+// handle answers one user message. It reports whether to exit, and with
+// which code.
+func (s *session) handle(text string, lines <-chan string) (int, bool) {
+	cmd, rest, _ := strings.Cut(strings.TrimSpace(text), " ")
+	switch cmd {
+	case "/exit":
+		code, _ := strconv.Atoi(strings.TrimSpace(rest))
+		return code, true
+	case "/signal":
+		name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rest)), "SIG")
+		sig, ok := signals[name]
+		if !ok {
+			s.reply("unknown signal " + rest)
+			return 0, false
+		}
+		signal.Reset(sig)
+		_ = syscall.Kill(os.Getpid(), sig)
+		time.Sleep(time.Second)
+		return 1, true
+	case "/pwd":
+		s.reply(s.cwd)
+	case "/present":
+		s.reply(s.present(strings.Fields(rest), lines))
+	default:
+		s.reply("echo: " + text)
+	}
+	return 0, false
+}
+
+// present sends a present_files control_request and waits for Desktop's (or
+// the daemon's) control_response, returning its content texts one per line.
+func (s *session) present(paths []string, lines <-chan string) string {
+	s.nextReqID++
+	reqID := fmt.Sprintf("fake_req_%d", s.nextReqID)
+	files := make([]map[string]string, len(paths))
+	for i, p := range paths {
+		files[i] = map[string]string{"file_path": p}
+	}
+	s.emit(map[string]interface{}{
+		"type":       "control_request",
+		"request_id": reqID,
+		"request": map[string]interface{}{
+			"subtype":     "mcp_message",
+			"server_name": "cowork",
+			"message": map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      s.nextReqID,
+				"method":  "tools/call",
+				"params":  map[string]interface{}{"name": "present_files", "arguments": map[string]interface{}{"files": files}},
+			},
+		},
+	})
+
+	for line := range lines {
+		var resp struct {
+			Type     string `json:"type"`
+			Response struct {
+				RequestID string `json:"request_id"`
+				Response  struct {
+					MCPResponse struct {
+						Result struct {
+							Content []struct {
+								Text string `json:"text"`
+							} `json:"content"`
+							IsError bool `json:"isError"`
+						} `json:"result"`
+					} `json:"mcp_response"`
+				} `json:"response"`
+			} `json:"response"`
+		}
+		if json.Unmarshal([]byte(line), &resp) != nil || resp.Type != "control_response" || resp.Response.RequestID != reqID {
+			continue
+		}
+		result := resp.Response.Response.MCPResponse.Result
+		texts := make([]string, len(result.Content))
+		for i, c := range result.Content {
+			texts[i] = c.Text
+		}
+		reply := strings.Join(texts, "\n")
+		if result.IsError {
+			reply = "error: " + reply
+		}
+		return reply
+	}
+	return "error: input closed before control_response " + reqID
+}
+
+// reply emits an assistant message and the end-of-turn result.
+func (s *session) reply(text string) {
+	s.turns++
+	s.record("assistant", text)
+	s.emit(map[string]interface{}{
+		"type":       "assistant",
+		"session_id": s.id,
+		"message": map[string]interface{}{
+			"role":    "assistant",
+			"content": []map[string]string{{"type": "text", "text": text}},
+		},
+	})
+	s.emit(map[string]interface{}{
+		"type": "result", "subtype": "success", "is_error": false,
+		"result": text, "session_id": s.id, "num_turns": s.turns,
+	})
+}
+
+func (s *session) emit(v interface{}) {
+	data, _ := json.Marshal(v)
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	_, _ = os.Stdout.Write(append(data, '\n'))
+}
+
+// record appends a message to the session transcript.
+func (s *session) record(role, text string) {
+	if err := os.MkdirAll(filepath.Dir(s.transcript), 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, "fakeclaude: transcript:", err)
+		return
+	}
+	f, err := os.OpenFile(s.transcript, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fakeclaude: transcript:", err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	data, _ := json.Marshal(map[string]interface{}{
+		"type": role, "sessionId": s.id, "cwd": s.cwd,
+		"message": map[string]string{"role": role, "content": text},
+	})
+	_, _ = f.Write(append(data, '\n'))
+}
+
+// contentText flattens a message content (a string or a list of blocks).
+func contentText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	_ = json.Unmarshal(raw, &blocks)
+	var parts []string
+	for _, b := range blocks {
+		if b.Type == "text" {
+			parts = append(parts, b.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func configDir() string {
+	if dir := os.Getenv("CLAUDE_CONFIG_DIR"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".claude")
+}
+
+func flagValue(args []string, name string) string {
+	for i, a := range args {
+		if a == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if v, ok := strings.CutPrefix(a, name+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/client"
+)
+
+const ctlUsage = `Usage: %s ctl [flags] <command> [args]
+
+Commands:
+  status                 daemon and backend state
+  sessions               sessions with their process ids
+  ps                     tracked processes
+  kill <id> [signal]     kill a process (default SIGTERM, with graceful drain)
+  log-level <debug|info> switch debug logging at runtime
+  goroutines             dump all goroutine stacks
+
+Flags:
+`
+
+// runCtl implements `cowork-svc-linux ctl`, a thin client for the admin
+// socket (see pipe.AdminServer).
+func runCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	backendName := fs.String("backend", defaultBackend(), "Backend whose daemon to talk to (selects the default socket)")
+	socketPath := fs.String("socket", "", "Admin socket path (default derived from the backend's RPC socket)")
+	asJSON := fs.Bool("json", false, "Print raw JSON results")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), ctlUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *socketPath == "" {
+		*socketPath = pipe.AdminSocketPath(defaultSocketPath(*backendName))
+	}
+
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	var (
+		method string
+		params interface{}
+	)
+	switch cmd {
+	case "status":
+		method = "admin.status"
+	case "sessions":
+		method = "admin.listSessions"
+	case "ps":
+		method = "admin.listProcesses"
+	case "kill":
+		if len(rest) < 1 {
+			fs.Usage()
+			return 2
+		}
+		p := map[string]string{"id": rest[0]}
+		if len(rest) > 1 {
+			p["signal"] = rest[1]
+		}
+		method, params = "admin.killProcess", p
+	case "log-level":
+		if len(rest) != 1 {
+			fs.Usage()
+			return 2
+		}
+		method, params = "admin.setLogLevel", map[string]string{"level": rest[0]}
+	case "goroutines":
+		method = "admin.dumpGoroutines"
+	default:
+		fmt.Fprintf(os.Stderr, "ctl: unknown command %q\n", cmd)
+		fs.Usage()
+		return 2
+	}
+
+	result, err := adminCall(*socketPath, method, params)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ctl: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		var v interface{}
+		_ = json.Unmarshal(result, &v)
+		out, _ := json.MarshalIndent(v, "", "  ")
+		fmt.Println(string(out))
+		return 0
+	}
+	if err := printCtlResult(cmd, result); err != nil {
+		fmt.Fprintf(os.Stderr, "ctl: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// adminCall sends one request over a fresh admin connection.
+func adminCall(socketPath, method string, params interface{}) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w (is the daemon running?)", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	var result json.RawMessage
+	if err := c.Call(context.Background(), method, params, &result); err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	return result, nil
+}
+
+func printCtlResult(cmd string, result json.RawMessage) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer func() { _ = tw.Flush() }()
+
+	switch cmd {
+	case "status":
+		var st pipe.AdminStatus
+		if err := json.Unmarshal(result, &st); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "version\t%s\n", st.Version)
+		fmt.Fprintf(tw, "pid\t%d\n", st.PID)
+		fmt.Fprintf(tw, "uptime\t%s\n", st.Uptime)
+		fmt.Fprintf(tw, "goroutines\t%d\n", st.Goroutines)
+		fmt.Fprintf(tw, "debug\t%v\n", st.Debug)
+		if b := st.Backend; b != nil {
+			fmt.Fprintf(tw, "backend\t%s\n", b.Backend)
+			fmt.Fprintf(tw, "running\t%v\n", b.Running)
+			fmt.Fprintf(tw, "guest connected\t%v\n", b.GuestConnected)
+			fmt.Fprintf(tw, "subscribers\t%d\n", b.Subscribers)
+			for _, k := range sortedKeys(b.Details) {
+				fmt.Fprintf(tw, "%s\t%v\n", k, b.Details[k])
+			}
+		}
+		if st.UnknownMethodPolicy != "" {
+			fmt.Fprintf(tw, "unknown methods\t%s\n", st.UnknownMethodPolicy)
+		}
+		for _, m := range st.UnknownMethods {
+			fmt.Fprintf(tw, "  %s\tfirst seen %s, %d calls\n", m.Method, m.FirstSeen.Local().Format(time.RFC3339), m.Count)
+		}
+		if st.UnknownMethodsDropped > 0 {
+			fmt.Fprintf(tw, "  (others)\t%d calls not recorded\n", st.UnknownMethodsDropped)
+		}
+	case "sessions":
+		var r struct {
+			Sessions []pipe.SessionInfo `json:"sessions"`
+		}
+		if err := json.Unmarshal(result, &r); err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, "SESSION\tPROCESSES\tEGRESS BLOCKED\tDIR")
+		for _, s := range r.Sessions {
+			fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%s\n", s.Name, strings.Join(s.Processes, ","), s.EgressBlocked, s.EgressAllowed+s.EgressBlocked, s.Dir)
+		}
+	case "ps":
+		var r struct {
+			Processes []pipe.ProcessInfo `json:"processes"`
+		}
+		if err := json.Unmarshal(result, &r); err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, "ID\tSESSION\tPID\tSTATE\tAGE\tCOMMAND")
+		for _, p := range r.Processes {
+			state := "running"
+			if !p.Running {
+				state = fmt.Sprintf("exited(%d)", p.ExitCode)
+			}
+			age := "-"
+			if !p.StartedAt.IsZero() {
+				age = time.Since(p.StartedAt).Round(time.Second).String()
+			}
+			pid := "-"
+			if p.PID > 0 {
+				pid = fmt.Sprint(p.PID)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", p.ID, p.Session, pid, state, age, p.Command)
+		}
+	case "goroutines":
+		var r struct {
+			Goroutines string `json:"goroutines"`
+		}
+		if err := json.Unmarshal(result, &r); err != nil {
+			return err
+		}
+		fmt.Fprint(tw, r.Goroutines)
+	default:
+		fmt.Fprintln(tw, "ok")
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
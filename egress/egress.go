@@ -0,0 +1,221 @@
+// Package egress enforces a spawn's allowedDomains on the native backend:
+// an HTTP proxy that forwards plain requests and CONNECT tunnels only to
+// allowlisted hosts, and counts what each session sent and was refused.
+// In the VM the guest's own proxy does this; on the host the CLI is pointed
+// at this one through HTTP(S)_PROXY.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/metrics"
+)
+
+var requests = metrics.NewCounterVec("cowork_egress_requests_total",
+	"Requests through the native egress proxy, by result (allowed or blocked).", "result")
+
+// Allowlist is a spawn's allowedDomains, with Desktop's admin
+// coworkEgressAllowedHosts already merged in. An entry matches its host
+// exactly, "*.example.com" matches any subdomain of example.com, and "*"
+// matches everything.
+type Allowlist []string
+
+// Allows reports whether host (with or without a port) may be reached.
+func (a Allowlist) Allows(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+	for _, entry := range a {
+		entry = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(entry), "."))
+		switch {
+		case entry == "*":
+			return true
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(host, entry[1:]) {
+				return true
+			}
+		case host == entry:
+			return true
+		}
+	}
+	return false
+}
+
+// Counts are the requests one session made through the proxy.
+type Counts struct {
+	Allowed int64 `json:"allowed"`
+	Blocked int64 `json:"blocked"`
+}
+
+// Proxy serves allowlisting HTTP proxies, one listener per spawned process,
+// and keeps per-session counts for the daemon's lifetime.
+type Proxy struct {
+	// Dial connects to upstream hosts. Tests point it at local servers.
+	Dial  func(ctx context.Context, network, addr string) (net.Conn, error)
+	debug bool
+
+	mu       sync.Mutex
+	sessions map[string]*Counts
+}
+
+// New creates a proxy that dials upstream hosts directly.
+func New(debug bool) *Proxy {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return &Proxy{Dial: d.DialContext, debug: debug, sessions: make(map[string]*Counts)}
+}
+
+// Counts returns session's request counts so far.
+func (p *Proxy) Counts(session string) Counts {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c := p.sessions[session]; c != nil {
+		return *c
+	}
+	return Counts{}
+}
+
+func (p *Proxy) count(session string, allowed bool) {
+	p.mu.Lock()
+	c := p.sessions[session]
+	if c == nil {
+		c = &Counts{}
+		p.sessions[session] = c
+	}
+	if allowed {
+		c.Allowed++
+	} else {
+		c.Blocked++
+	}
+	p.mu.Unlock()
+	if allowed {
+		requests.With("allowed").Inc()
+	} else {
+		requests.With("blocked").Inc()
+	}
+}
+
+// Serve proxies connections accepted on l for session, allowing only hosts
+// in allow, until l is closed.
+func (p *Proxy) Serve(l net.Listener, session string, allow Allowlist) error {
+	transport := &http.Transport{
+		DialContext:         p.Dial,
+		MaxIdleConns:        16,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	defer transport.CloseIdleConnections()
+	h := &handler{proxy: p, session: session, allow: allow}
+	h.forward = &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			// The request line already holds the absolute URL.
+			r.RequestURI = ""
+		},
+		Transport: transport,
+		ErrorLog:  log.New(io.Discard, "", 0),
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, fmt.Sprintf("cowork egress: %v", err), http.StatusBadGateway)
+		},
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 30 * time.Second, ErrorLog: log.New(io.Discard, "", 0)}
+	err := srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+type handler struct {
+	proxy   *Proxy
+	session string
+	allow   Allowlist
+	forward *httputil.ReverseProxy
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if r.Method != http.MethodConnect {
+		if !r.URL.IsAbs() {
+			http.Error(w, "cowork egress: not a proxy request", http.StatusBadRequest)
+			return
+		}
+		host = r.URL.Host
+	}
+	if !h.allow.Allows(host) {
+		h.proxy.count(h.session, false)
+		log.Printf("[egress] %s: blocked %s %s (not in allowedDomains)", h.session, r.Method, host)
+		http.Error(w, fmt.Sprintf("cowork egress: %s is not in this session's allowedDomains", host), http.StatusForbidden)
+		return
+	}
+	h.proxy.count(h.session, true)
+	if h.proxy.debug {
+		log.Printf("[egress] %s: %s %s", h.session, r.Method, host)
+	}
+	if r.Method == http.MethodConnect {
+		h.tunnel(w, r)
+		return
+	}
+	h.forward.ServeHTTP(w, r)
+}
+
+// tunnel answers a CONNECT by splicing the client to the upstream host.
+func (h *handler) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := h.proxy.Dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cowork egress: %v", err), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		_ = upstream.Close()
+		http.Error(w, "cowork egress: connection can't be hijacked", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		return
+	}
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		_ = client.Close()
+		_ = upstream.Close()
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		// Bytes the client sent after the CONNECT headers are buffered.
+		_, _ = io.Copy(upstream, buf.Reader)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, upstream)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+	_ = client.Close()
+	_ = upstream.Close()
+}
+
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	} else {
+		_ = c.Close()
+	}
+}
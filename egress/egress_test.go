@@ -0,0 +1,135 @@
+package egress
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAllowlistAllows(t *testing.T) {
+	a := Allowlist{"api.anthropic.com", "*.npmjs.org", "PyPI.org."}
+	for host, want := range map[string]bool{
+		"api.anthropic.com":      true,
+		"api.anthropic.com:443":  true,
+		"API.Anthropic.com.":     true,
+		"anthropic.com":          false,
+		"evil-api.anthropic.com": false,
+		"registry.npmjs.org":     true,
+		"a.b.npmjs.org:443":      true,
+		"npmjs.org":              false,
+		"evilnpmjs.org":          false,
+		"pypi.org":               true,
+		"[::1]:443":              false,
+		"":                       false,
+	} {
+		if got := a.Allows(host); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if !(Allowlist{"*"}).Allows("anything.example") {
+		t.Error(`"*" does not allow everything`)
+	}
+	if (Allowlist{}).Allows("example.com") {
+		t.Error("empty allowlist allows a host")
+	}
+}
+
+// startProxy serves p for session on a loopback port, with upstream hosts
+// resolved to local test servers through hosts.
+func startProxy(t *testing.T, session string, allow Allowlist, hosts map[string]string) (*Proxy, *url.URL) {
+	t.Helper()
+	p := New(false)
+	p.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if target, ok := hosts[addr]; ok {
+			addr = target
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- p.Serve(l, session, allow) }()
+	t.Cleanup(func() {
+		_ = l.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return p, &url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func get(t *testing.T, client *http.Client, target string) (int, string) {
+	t.Helper()
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestProxyForwardsAllowedHTTP(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello from "+r.Host+r.URL.Path)
+	}))
+	defer upstream.Close()
+	addr := strings.TrimPrefix(upstream.URL, "http://")
+	p, proxyURL := startProxy(t, "s", Allowlist{"allowed.test"}, map[string]string{"allowed.test:80": addr, "blocked.test:80": addr})
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	if code, body := get(t, client, "http://allowed.test/x"); code != 200 || body != "hello from allowed.test/x" {
+		t.Fatalf("allowed GET = %d %q", code, body)
+	}
+	if code, body := get(t, client, "http://blocked.test/x"); code != http.StatusForbidden || !strings.Contains(body, "blocked.test") {
+		t.Fatalf("blocked GET = %d %q", code, body)
+	}
+	if c := p.Counts("s"); c != (Counts{Allowed: 1, Blocked: 1}) {
+		t.Fatalf("counts = %+v", c)
+	}
+}
+
+func TestProxyTunnelsAllowedHTTPS(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secure "+r.Host)
+	}))
+	defer upstream.Close()
+	addr := strings.TrimPrefix(upstream.URL, "https://")
+	p, proxyURL := startProxy(t, "s", Allowlist{"*.allowed.test"}, map[string]string{"api.allowed.test:443": addr, "evil.test:443": addr})
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+
+	if code, body := get(t, client, "https://api.allowed.test/"); code != 200 || body != "secure api.allowed.test" {
+		t.Fatalf("tunneled GET = %d %q", code, body)
+	}
+	if _, err := client.Get("https://evil.test/"); err == nil || !strings.Contains(err.Error(), "Forbidden") {
+		t.Fatalf("CONNECT to a blocked host = %v, want Forbidden", err)
+	}
+	if _, err := client.Get("https://allowed.test/"); err == nil {
+		t.Fatal("CONNECT to the parent of a wildcard entry succeeded")
+	}
+	if c := p.Counts("s"); c != (Counts{Allowed: 1, Blocked: 2}) {
+		t.Fatalf("counts = %+v", c)
+	}
+	if c := p.Counts("other"); c != (Counts{}) {
+		t.Fatalf("unrelated session counts = %+v", c)
+	}
+}
+
+func TestProxyRejectsDirectRequests(t *testing.T) {
+	_, proxyURL := startProxy(t, "s", Allowlist{"*"}, nil)
+	if code, _ := get(t, http.DefaultClient, proxyURL.String()+"/"); code != http.StatusBadRequest {
+		t.Fatalf("direct request = %d, want 400", code)
+	}
+}
@@ -1,17 +1,28 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/patrickjaja/claude-cowork-service/logx"
+	"github.com/patrickjaja/claude-cowork-service/metrics"
 	"github.com/patrickjaja/claude-cowork-service/native"
 	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/client"
+	"github.com/patrickjaja/claude-cowork-service/remote"
+	"github.com/patrickjaja/claude-cowork-service/router"
+	"github.com/patrickjaja/claude-cowork-service/sessions"
+	"github.com/patrickjaja/claude-cowork-service/systemd"
 	"github.com/patrickjaja/claude-cowork-service/vm"
 )
 
@@ -29,14 +40,55 @@ func main() {
 	if len(os.Args) > 1 && os.Args[1] == "--vfs-helper" {
 		os.Exit(vm.RunVfsHelper(os.Args[2:]))
 	}
+	// Re-exec path: launching a native session's CLI inside its mount
+	// namespace.
+	if len(os.Args) > 1 && os.Args[1] == "--session-ns" {
+		runtime.LockOSThread()
+		os.Exit(native.RunSessionLauncher(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(runCtl(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		os.Exit(runRun(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		os.Exit(runSchema())
+	}
 
-	socketPath := flag.String("socket", "", "Unix socket path (default depends on backend)")
+	socketPath := flag.String("socket", "", "Unix socket path (default depends on backend; the native socket with -backend both)")
+	kvmSocketPath := flag.String("kvm-socket", "", "both backend only: KVM socket path (default: cowork-kvm-service.sock)")
 	debug := flag.Bool("debug", false, "Enable debug logging")
-	backendName := flag.String("backend", defaultBackend(), "Backend: native or kvm")
+	backendName := flag.String("backend", defaultBackend(), "Backend: native, kvm, remote, or both (native and KVM, each on its own socket)")
+	routePolicy := flag.String("route", os.Getenv("COWORK_ROUTE"), "both backend only: fixed (each socket serves its own backend) or prefer-kvm (the KVM socket runs sessions in the VM while it is healthy, natively otherwise)")
+	nativeMountNS := flag.Bool("native-mountns", os.Getenv("COWORK_NATIVE_MOUNTNS") == "1", "native backend: run each session's CLI in a private user+mount namespace with its mounts bound at /sessions/<name>/mnt (needs unprivileged user namespaces)")
+	nativeSandbox := flag.Bool("native-sandbox", os.Getenv("COWORK_NATIVE_SANDBOX") == "1", "native backend: confine each session's CLI with Landlock to its session dir, mounts, install dir, temp dirs and system paths")
+	nativeSandboxConfig := flag.String("native-sandbox-config", os.Getenv("COWORK_NATIVE_SANDBOX_CONFIG"), "native backend: sandbox allowlist file with global and per-workspace paths (default: ~/.config/claude-cowork/sandbox.json if it exists)")
+	nativeCgroups := flag.Bool("native-cgroups", os.Getenv("COWORK_NATIVE_CGROUPS") == "1", "native backend: run each session in its own cgroup under the daemon's delegated cgroup v2 subtree, limited to the configure/startVM memory and CPUs; killing a process kills its whole cgroup")
+	nativeEgress := flag.String("native-egress", os.Getenv("COWORK_NATIVE_EGRESS"), "native backend: enforce spawn allowedDomains: off, proxy (HTTP(S)_PROXY to an allowlisting proxy in the daemon) or netns (the proxy plus a network namespace, so direct sockets can't bypass it)")
 	bundlesDir := flag.String("bundles-dir", defaultBundlesDir(), "VM bundles directory (kvm backend only)")
 	showVersion := flag.Bool("version", false, "Show version and exit")
 	logFullLines := flag.Bool("log-full-lines", false, "Don't truncate long log lines (JSON payloads, RPC params, events)")
 	logMaxLen := flag.Int("log-max-len", 160, "Max characters per log line before truncation (ignored with -log-full-lines)")
+	clientAllowlist := flag.String("client-allowlist", os.Getenv("COWORK_CLIENT_ALLOWLIST"), "Comma-separated executable patterns allowed to connect (\"default\" = Claude Desktop binaries; empty = uid check only)")
+	capturePath := flag.String("capture", os.Getenv("COWORK_CAPTURE"), "Record all RPC requests, responses and events to this JSONL file (secrets redacted)")
+	captureMaxMB := flag.Int("capture-max-mb", 64, "Rotate the capture file after this many MB (3 rotated files are kept)")
+	metricsListen := flag.String("metrics-listen", os.Getenv("COWORK_METRICS_LISTEN"), "Serve Prometheus metrics on this address (host:port or unix:/path); empty disables")
+	eventQueue := flag.Int("event-queue", pipe.DefaultEventQueueSize, "Events buffered per subscribeEvents connection before the overflow policy applies")
+	eventOverflow := flag.String("event-overflow", "drop", "When a subscriber's event queue is full: drop (and send an error event) or disconnect")
+	unknownMethods := flag.String("unknown-methods", os.Getenv("COWORK_UNKNOWN_METHODS"), "Unknown RPC methods: passthrough (success, null result), reject (-32601), or record (passthrough and log them to the state file)")
+	wsListen := flag.String("ws-listen", os.Getenv("COWORK_WS_LISTEN"), "Also serve the RPC protocol over WebSocket and HTTP POST at /rpc on this address (host:port or unix:/path); empty disables")
+	wsTokenFile := flag.String("ws-token-file", os.Getenv("COWORK_WS_TOKEN_FILE"), "Bearer token file for -ws-listen (default: <state dir>/ws-token, created if missing)")
+	remoteAddr := flag.String("remote", os.Getenv("COWORK_REMOTE"), "remote backend only: daemon to relay to, unix:/path (e.g. an ssh -L forwarded socket) or host:port (mutual TLS)")
+	remotePathMap := flag.String("remote-path-map", os.Getenv("COWORK_REMOTE_PATH_MAP"), "remote backend only: comma-separated local=remote directory pairs; mounts outside them are reported as failed")
+	tlsListen := flag.String("tls-listen", os.Getenv("COWORK_TLS_LISTEN"), "Also serve the RPC protocol over mutual TLS on this host:port, for remote backends on other machines; empty disables")
+	tlsCert := flag.String("tls-cert", os.Getenv("COWORK_TLS_CERT"), "Certificate for -tls-listen, or the client certificate for a host:port -remote")
+	tlsKey := flag.String("tls-key", os.Getenv("COWORK_TLS_KEY"), "Private key for -tls-cert")
+	tlsCA := flag.String("tls-ca", os.Getenv("COWORK_TLS_CA"), "CA the peer's certificate must chain to (-tls-listen and host:port -remote)")
+	adminSocket := flag.String("admin-socket", "", "Admin socket path for the ctl subcommand (default: <socket>-admin.sock; \"none\" disables)")
 	flag.Parse()
 
 	if *socketPath == "" {
@@ -52,36 +104,467 @@ func main() {
 	logx.Configure(*debug, fullLines, *logMaxLen)
 
 	log.Printf("cowork-svc-linux %s starting (%s backend)", version, *backendName)
-	log.Printf("Socket: %s", *socketPath)
 
-	var backend backendWithShutdown
+	overflow, err := pipe.ParseOverflowPolicy(*eventOverflow)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pipe.ConfigureEvents(*eventQueue, overflow)
+
+	route, err := router.ParsePolicy(*routePolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *kvmSocketPath == "" {
+		*kvmSocketPath = defaultSocketPath("kvm")
+	}
+	tlsFiles := tlsFiles{cert: *tlsCert, key: *tlsKey, ca: *tlsCA}
+	nativeCfg := nativeConfig{mountNS: *nativeMountNS, sandbox: *nativeSandbox, sandboxConfig: *nativeSandboxConfig, egress: *nativeEgress, cgroups: *nativeCgroups}
+	remoteCfg := remoteConfig{addr: *remoteAddr, pathMap: *remotePathMap, tls: tlsFiles}
+	sockets, backends, err := newSockets(*backendName, *socketPath, *kvmSocketPath, route, *bundlesDir, *debug, nativeCfg, remoteCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 	switch *backendName {
-	case "native":
-		backend = native.NewBackend(*debug)
 	case "kvm":
-		check := vm.CheckKvmPrerequisites()
-		if !check.OK {
-			log.Fatalf("KVM backend unavailable: %s", check.Reason)
-		}
-		backend = vm.NewKvmBackend(*bundlesDir, *debug)
 		log.Printf("Bundles dir: %s", *bundlesDir)
-	default:
-		log.Fatalf("Unknown backend %q (expected native or kvm)", *backendName)
+	case "remote":
+		log.Printf("Remote: %s (path map: %s)", *remoteAddr, *remotePathMap)
+	case "both":
+		log.Printf("Bundles dir: %s", *bundlesDir)
+		log.Printf("Route: %s", route)
 	}
 
-	server := pipe.NewServer(*socketPath, backend, *debug)
-	if err := server.Start(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// Under claude-cowork.socket, systemd has already bound the socket and
+	// may be holding Desktop's first connection in its backlog.
+	activated, err := systemd.Listeners()
+	if err != nil {
+		log.Fatalf("Socket activation: %v", err)
 	}
-	defer server.Stop()
+	adoptActivated(activated, sockets)
 
-	log.Printf("Listening on %s", *socketPath)
+	unknownPolicy, err := pipe.ParseUnknownMethodPolicy(*unknownMethods)
+	if err != nil {
+		log.Fatal(err)
+	}
+	unknown, err := pipe.NewUnknownMethods(unknownPolicy, pipe.DefaultUnknownMethodsPath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if unknownPolicy != pipe.UnknownPassthrough {
+		log.Printf("Unknown RPC methods: %s", unknownPolicy)
+	}
+
+	allow := parseClientAllowlist(*clientAllowlist)
+	if len(allow) > 0 {
+		log.Printf("Client allowlist: %s", strings.Join(allow, ", "))
+	}
+	for i, sock := range sockets {
+		sock.server = pipe.NewServer(sock.path, sock.rpc, *debug)
+		sock.server.SetVersion(version)
+		sock.server.SetUnknownMethods(unknown)
+		if sock.listener != nil {
+			sock.server.SetListener(sock.listener)
+		}
+		if len(allow) > 0 {
+			sock.server.SetPeerPolicy(&pipe.PeerPolicy{AllowedExes: allow})
+		}
+		if *adminSocket != "none" {
+			sock.adminPath = *adminSocket
+			if sock.adminPath == "" || i > 0 {
+				sock.adminPath = pipe.AdminSocketPath(sock.path)
+			}
+			// The client allowlist names Desktop's executables, so it is not
+			// applied here: ctl runs as this binary. The uid check still is.
+			sock.admin = pipe.NewAdminServer(sock.adminPath, sock.rpc, version)
+			sock.admin.SetUnknownMethods(unknown)
+		}
+	}
+	// Landlock doesn't stop a sandboxed CLI from connecting to the daemon's
+	// sockets, which would let it spawn processes outside the sandbox.
+	var daemonSockets []string
+	for _, sock := range sockets {
+		daemonSockets = append(daemonSockets, sock.path)
+		if sock.adminPath != "" {
+			daemonSockets = append(daemonSockets, sock.adminPath)
+		}
+	}
+	for _, b := range backends {
+		if nb, ok := b.(*native.Backend); ok {
+			nb.HideFromSandbox(daemonSockets...)
+		}
+	}
+	// Capture, TLS and the gateway serve the first socket: the only one, or
+	// the native one with -backend both.
+	server := sockets[0].server
+	if *capturePath != "" {
+		rec, err := pipe.NewRecorder(*capturePath, int64(*captureMaxMB)<<20)
+		if err != nil {
+			log.Fatalf("Failed to start capture: %v", err)
+		}
+		defer func() { _ = rec.Close() }()
+		server.SetRecorder(rec)
+		log.Printf("Capturing RPC traffic to %s", *capturePath)
+	}
+	if *metricsListen != "" {
+		ms, err := metrics.Listen(*metricsListen)
+		if err != nil {
+			log.Fatalf("Failed to start metrics listener: %v", err)
+		}
+		defer func() { _ = ms.Close() }()
+		log.Printf("Metrics: %s/metrics", *metricsListen)
+	}
+	for _, sock := range sockets {
+		if err := sock.server.Start(); err != nil {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+		defer sock.server.Stop()
+	}
+	if *tlsListen != "" {
+		cfg, err := pipe.ServerTLSConfig(tlsFiles.cert, tlsFiles.key, tlsFiles.ca)
+		if err != nil {
+			log.Fatalf("Failed to start TLS listener: %v", err)
+		}
+		if err := server.ListenTLS(*tlsListen, cfg); err != nil {
+			log.Fatalf("Failed to start TLS listener: %v", err)
+		}
+		log.Printf("TLS: %s (clients must present a certificate from %s)", server.TLSAddr(), tlsFiles.ca)
+	}
+	for _, sock := range sockets {
+		if sock.admin == nil {
+			continue
+		}
+		if err := sock.admin.Start(); err != nil {
+			log.Printf("Admin socket disabled: %v", err)
+		} else {
+			defer sock.admin.Stop()
+			log.Printf("Admin socket: %s", sock.adminPath)
+		}
+	}
+
+	if *wsListen != "" {
+		if *wsTokenFile == "" {
+			*wsTokenFile = pipe.DefaultGatewayTokenPath()
+		}
+		gw, err := pipe.NewGateway(server, *wsTokenFile)
+		if err != nil {
+			log.Fatalf("Failed to start gateway: %v", err)
+		}
+		if err := gw.Listen(*wsListen); err != nil {
+			log.Fatalf("Failed to start gateway: %v", err)
+		}
+		defer func() { _ = gw.Close() }()
+		log.Printf("Gateway: %s/rpc (token: %s)", *wsListen, *wsTokenFile)
+	}
+
+	for _, sock := range sockets {
+		log.Printf("Listening on %s", sock.path)
+	}
+	notifyReady(sockets)
+	stopWatchdog := startWatchdog(sockets, backends)
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-sigCh
 	log.Printf("Received %s, shutting down...", sig)
-	backend.Shutdown()
+	close(stopWatchdog)
+	if _, err := systemd.Notify("STOPPING=1"); err != nil {
+		logx.Debug("sd_notify STOPPING: %v", err)
+	}
+	for _, b := range backends {
+		b.Shutdown()
+	}
+	if err := unknown.Flush(); err != nil {
+		log.Printf("Saving unknown-method state: %v", err)
+	}
+}
+
+// notifyReady tells a Type=notify unit that the socket is accepting and the
+// backend is constructed, so units ordered after us (and Desktop) never see
+// a half-started daemon.
+func notifyReady(sockets []*daemonSocket) {
+	serving := make([]string, len(sockets))
+	for i, sock := range sockets {
+		serving[i] = fmt.Sprintf("%s backend on %s", sock.backend, sock.path)
+	}
+	status := "STATUS=Serving " + strings.Join(serving, ", ")
+	sent, err := systemd.Notify("READY=1\n" + status)
+	if err != nil {
+		log.Printf("sd_notify READY failed: %v", err)
+	} else if sent {
+		log.Printf("Notified systemd: ready")
+	}
+}
+
+// startWatchdog pings the systemd watchdog at half of WatchdogSec= for as
+// long as every socket's accept loop and every backend's event fan-out keep
+// moving. When one wedges the pings stop and systemd restarts the service.
+// Close the returned channel to stop.
+func startWatchdog(sockets []*daemonSocket, backends []backendWithShutdown) chan struct{} {
+	stop := make(chan struct{})
+	interval, ok := systemd.WatchdogInterval()
+	if !ok {
+		return stop
+	}
+	log.Printf("systemd watchdog: %s", interval)
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			var err error
+			for _, sock := range sockets {
+				if err = sock.server.Healthy(interval / 2); err != nil {
+					break
+				}
+			}
+			for _, b := range backends {
+				if hc, ok := b.(pipe.HealthChecker); ok && err == nil {
+					err = hc.Healthy()
+				}
+			}
+			if err != nil {
+				log.Printf("Withholding watchdog ping: %v", err)
+				if _, nerr := systemd.Notify("STATUS=Unhealthy: " + err.Error()); nerr != nil {
+					logx.Debug("sd_notify STATUS: %v", nerr)
+				}
+				continue
+			}
+			if _, err := systemd.Notify("WATCHDOG=1"); err != nil {
+				logx.Debug("sd_notify WATCHDOG: %v", err)
+			}
+		}
+	}()
+	return stop
+}
+
+// daemonSocket is one RPC socket and the backend serving it.
+type daemonSocket struct {
+	backend   string // names the socket: its default path and Desktop's mode
+	path      string
+	rpc       pipe.VMBackend
+	listener  net.Listener // socket-activated, or nil to bind path
+	server    *pipe.Server
+	admin     *pipe.AdminServer
+	adminPath string
+}
+
+// newSockets builds the sockets for -backend and the backends behind them,
+// which are shut down on exit. "both" serves the native and KVM backends
+// on their own sockets, sharing a session registry so neither sets up a
+// session the other has live processes in. Under router.PolicyPreferKVM the
+// KVM socket is served by a router over both.
+func newSockets(name, socketPath, kvmSocketPath string, route router.Policy, bundlesDir string, debug bool, nativeCfg nativeConfig, remoteCfg remoteConfig) ([]*daemonSocket, []backendWithShutdown, error) {
+	if name != "both" {
+		backend, err := newBackend(name, bundlesDir, debug, nativeCfg, remoteCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*daemonSocket{{backend: name, path: socketPath, rpc: backend}}, []backendWithShutdown{backend}, nil
+	}
+
+	// The VM is optional here: without KVM the native socket still works,
+	// and prefer-kvm falls back to it when startVM fails.
+	if check := vm.CheckKvmPrerequisites(); !check.OK {
+		log.Printf("Warning: KVM backend unavailable: %s", check.Reason)
+	}
+	nb, err := newNativeBackend(nativeCfg, debug)
+	if err != nil {
+		return nil, nil, err
+	}
+	kb := vm.NewKvmBackend(bundlesDir, debug)
+	registry := sessions.NewRegistry()
+	nb.SetSessions(registry)
+	kb.SetSessions(registry)
+	backends := []backendWithShutdown{nb, kb}
+
+	var kvmRPC pipe.VMBackend = kb
+	if route == router.PolicyPreferKVM {
+		r := router.New(kb, nb, debug)
+		kvmRPC = r
+		// Stop relaying before the routed backends shut down.
+		backends = append([]backendWithShutdown{r}, backends...)
+	}
+	return []*daemonSocket{
+		{backend: "native", path: socketPath, rpc: nb},
+		{backend: "kvm", path: kvmSocketPath, rpc: kvmRPC},
+	}, backends, nil
+}
+
+// adoptActivated hands systemd's listeners to the sockets. A single socket
+// takes the first listener whatever its name; with -backend both each
+// listener goes to the socket whose default name it has, so
+// claude-cowork.socket can list both.
+func adoptActivated(activated []net.Listener, sockets []*daemonSocket) {
+	for _, ln := range activated {
+		var target *daemonSocket
+		for _, sock := range sockets {
+			if sock.listener != nil {
+				continue
+			}
+			if len(sockets) == 1 || filepath.Base(ln.Addr().String()) == filepath.Base(defaultSocketPath(sock.backend)) {
+				target = sock
+				break
+			}
+		}
+		if target == nil {
+			log.Printf("Ignoring extra activated socket %s", ln.Addr())
+			_ = ln.Close()
+			continue
+		}
+		target.listener = ln
+		target.path = ln.Addr().String()
+	}
+	for _, sock := range sockets {
+		if sock.listener == nil {
+			log.Printf("Socket: %s", sock.path)
+			continue
+		}
+		log.Printf("Socket: %s (socket-activated)", sock.path)
+		if want := filepath.Base(defaultSocketPath(sock.backend)); filepath.Base(sock.path) != want {
+			log.Printf("Warning: activated socket is not %s; Desktop looks for that name in %s mode (override ListenStream in claude-cowork.socket)", want, sock.backend)
+		}
+	}
+}
+
+// tlsFiles are the -tls-cert/-tls-key/-tls-ca paths, shared by -tls-listen
+// and a host:port -remote.
+type tlsFiles struct {
+	cert, key, ca string
+}
+
+// nativeConfig holds the native backend's flags.
+type nativeConfig struct {
+	mountNS       bool
+	sandbox       bool
+	sandboxConfig string
+	egress        string
+	cgroups       bool
+}
+
+// newNativeBackend constructs the native backend. Optional isolation that
+// the host can't provide is logged and left off: native is the fallback
+// backend and must keep serving.
+func newNativeBackend(cfg nativeConfig, debug bool) (*native.Backend, error) {
+	nb := native.NewBackend(debug)
+	if cfg.mountNS {
+		if err := nb.EnableMountNamespace(); err != nil {
+			log.Printf("Warning: -native-mountns: %v; sessions use path remapping", err)
+		} else {
+			log.Printf("Native sessions run in private mount namespaces")
+		}
+	}
+	if cfg.sandbox {
+		sandboxCfg, err := loadSandboxConfig(cfg.sandboxConfig)
+		if err != nil {
+			return nil, fmt.Errorf("-native-sandbox-config: %w", err)
+		}
+		if err := nb.EnableSandbox(sandboxCfg); err != nil {
+			log.Printf("Warning: -native-sandbox: %v; sessions are not sandboxed", err)
+		} else {
+			log.Printf("Native sessions run in a Landlock sandbox")
+		}
+	}
+	if cfg.cgroups {
+		if err := nb.EnableCgroups(); err != nil {
+			log.Printf("Warning: -native-cgroups: %v; sessions are not limited", err)
+		} else {
+			log.Printf("Native sessions run in their own cgroups")
+		}
+	}
+	switch cfg.egress {
+	case "", "off":
+	case "proxy":
+		if err := nb.EnableEgress(false); err != nil {
+			return nil, err
+		}
+		log.Printf("Native allowedDomains enforced through the egress proxy")
+	case "netns":
+		if err := nb.EnableEgress(true); err != nil {
+			log.Printf("Warning: -native-egress netns: %v; using the proxy without a network namespace", err)
+			if err := nb.EnableEgress(false); err != nil {
+				return nil, err
+			}
+		} else {
+			log.Printf("Native allowedDomains enforced through the egress proxy in private network namespaces")
+		}
+	default:
+		return nil, fmt.Errorf("-native-egress must be off, proxy or netns, not %q", cfg.egress)
+	}
+	return nb, nil
+}
+
+// loadSandboxConfig reads the sandbox allowlist. Only an explicitly named
+// file has to exist, and a broken one is an error rather than ignored.
+func loadSandboxConfig(path string) (*native.SandboxConfig, error) {
+	explicit := path != ""
+	if !explicit {
+		path = native.DefaultSandboxConfigPath()
+	}
+	cfg, err := native.LoadSandboxConfig(path)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	log.Printf("Sandbox allowlist: %s", path)
+	return cfg, nil
+}
+
+// remoteConfig holds the remote backend's flags.
+type remoteConfig struct {
+	addr    string
+	pathMap string
+	tls     tlsFiles
+}
+
+// newBackend constructs the named backend, checking KVM prerequisites.
+func newBackend(name, bundlesDir string, debug bool, nativeCfg nativeConfig, remoteCfg remoteConfig) (backendWithShutdown, error) {
+	switch name {
+	case "native":
+		return newNativeBackend(nativeCfg, debug)
+	case "kvm":
+		check := vm.CheckKvmPrerequisites()
+		if !check.OK {
+			return nil, fmt.Errorf("KVM backend unavailable: %s", check.Reason)
+		}
+		return vm.NewKvmBackend(bundlesDir, debug), nil
+	case "remote":
+		return newRemoteBackend(remoteCfg, debug)
+	default:
+		return nil, fmt.Errorf("unknown backend %q (expected native, kvm or remote)", name)
+	}
+}
+
+// newRemoteBackend dials unix:/path as is (typically an ssh -L forwarded
+// socket) and anything else as host:port over mutual TLS.
+func newRemoteBackend(cfg remoteConfig, debug bool) (backendWithShutdown, error) {
+	if cfg.addr == "" {
+		return nil, fmt.Errorf("remote backend needs -remote (unix:/path or host:port)")
+	}
+	paths, err := remote.ParsePathMap(cfg.pathMap)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		log.Printf("Warning: no -remote-path-map; every mount will be reported in failedMounts")
+	}
+	var dial client.DialFunc
+	if path, ok := strings.CutPrefix(cfg.addr, "unix:"); ok {
+		dial = client.DialUnix(path)
+	} else {
+		tlsCfg, err := pipe.ClientTLSConfig(cfg.tls.cert, cfg.tls.key, cfg.tls.ca)
+		if err != nil {
+			return nil, fmt.Errorf("remote %s: %w", cfg.addr, err)
+		}
+		dial = client.DialTLS(cfg.addr, tlsCfg)
+	}
+	return remote.NewBackend(cfg.addr, dial, paths, debug), nil
 }
 
 // defaultSocketPath picks the socket name from the backend so Claude Desktop
@@ -108,6 +591,36 @@ func defaultBackend() string {
 	return "native"
 }
 
+// runSchema prints the JSON Schema of every RPC method's params, as
+// enforced by the handler, for checking COWORK_RPC_PROTOCOL.md or
+// generating client types.
+func runSchema() int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(pipe.ParamsSchema()); err != nil {
+		fmt.Fprintf(os.Stderr, "schema: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseClientAllowlist expands the -client-allowlist flag. "default" stands
+// for pipe.DefaultClientAllowlist and may be combined with extra patterns.
+func parseClientAllowlist(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimSpace(p)
+		switch p {
+		case "":
+		case "default":
+			out = append(out, pipe.DefaultClientAllowlist...)
+		default:
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
 func defaultBundlesDir() string {
 	home, _ := os.UserHomeDir()
 	return filepath.Join(home, ".config", "Claude", "vm_bundles")
@@ -0,0 +1,289 @@
+// Package metrics is a minimal Prometheus text-format exporter for the
+// daemon: counters, gauges and histograms with labels, registered once at
+// package init by the subsystems that own them and served by an opt-in
+// listener (-metrics-listen). Stdlib only — the daemon ships as a single
+// static binary and doesn't need the full client_golang feature set.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets suits RPC and IPC latencies (seconds).
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// BootBuckets suits VM boot stages, which take seconds to minutes.
+var BootBuckets = []float64{.5, 1, 2.5, 5, 10, 20, 30, 60, 90, 120, 300}
+
+// family is one metric name with its label schema and children.
+type family struct {
+	name    string
+	help    string
+	kind    string // counter, gauge, histogram
+	labels  []string
+	buckets []float64
+
+	mu       sync.Mutex
+	children map[string]*child
+}
+
+type child struct {
+	values []string
+	value  atomicFloat
+	// histogram state
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+type atomicFloat struct{ bits atomic.Uint64 }
+
+func (f *atomicFloat) add(d float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+func (f *atomicFloat) set(v float64) { f.bits.Store(math.Float64bits(v)) }
+func (f *atomicFloat) get() float64  { return math.Float64frombits(f.bits.Load()) }
+
+var (
+	regMu    sync.Mutex
+	families []*family
+)
+
+func register(f *family) *family {
+	regMu.Lock()
+	defer regMu.Unlock()
+	for _, existing := range families {
+		if existing.name == f.name {
+			panic("metrics: duplicate registration of " + f.name)
+		}
+	}
+	f.children = make(map[string]*child)
+	families = append(families, f)
+	return f
+}
+
+func (f *family) with(values []string) *child {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\x00")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.children[key]
+	if !ok {
+		c = &child{values: append([]string(nil), values...)}
+		if f.kind == "histogram" {
+			c.buckets = f.buckets
+			c.counts = make([]uint64, len(f.buckets))
+		}
+		f.children[key] = c
+	}
+	return c
+}
+
+// CounterVec is a monotonically increasing counter partitioned by labels.
+type CounterVec struct{ f *family }
+
+// Counter is one labeled child of a CounterVec.
+type Counter struct{ c *child }
+
+// NewCounterVec registers a counter. Call from a package-level var.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{register(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+// With returns the child for the given label values (in label order).
+func (v *CounterVec) With(values ...string) Counter { return Counter{v.f.with(values)} }
+
+// Inc adds one.
+func (c Counter) Inc() { c.c.value.add(1) }
+
+// Add adds d, which must be non-negative.
+func (c Counter) Add(d float64) { c.c.value.add(d) }
+
+// GaugeVec is a value that can go up and down, partitioned by labels.
+type GaugeVec struct{ f *family }
+
+// Gauge is one labeled child of a GaugeVec.
+type Gauge struct{ c *child }
+
+// NewGaugeVec registers a gauge. Call from a package-level var.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{register(&family{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// With returns the child for the given label values (in label order).
+func (v *GaugeVec) With(values ...string) Gauge { return Gauge{v.f.with(values)} }
+
+// Inc adds one.
+func (g Gauge) Inc() { g.c.value.add(1) }
+
+// Dec subtracts one.
+func (g Gauge) Dec() { g.c.value.add(-1) }
+
+// Set replaces the value.
+func (g Gauge) Set(v float64) { g.c.value.set(v) }
+
+// HistogramVec samples observations into cumulative buckets.
+type HistogramVec struct{ f *family }
+
+// Histogram is one labeled child of a HistogramVec.
+type Histogram struct{ c *child }
+
+// NewHistogramVec registers a histogram; nil buckets means DefaultBuckets.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &HistogramVec{register(&family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+// With returns the child for the given label values (in label order).
+func (v *HistogramVec) With(values ...string) Histogram {
+	return Histogram{c: v.f.with(values)}
+}
+
+// Observe records one sample.
+func (h Histogram) Observe(v float64) {
+	h.c.mu.Lock()
+	defer h.c.mu.Unlock()
+	h.c.sum += v
+	h.c.count++
+	for i, ub := range h.c.buckets {
+		if v <= ub {
+			h.c.counts[i]++
+		}
+	}
+}
+
+// ObserveSince records the seconds elapsed since start.
+func (h Histogram) ObserveSince(start time.Time) { h.Observe(time.Since(start).Seconds()) }
+
+// WriteText writes every registered metric in Prometheus text format 0.0.4.
+func WriteText(w io.Writer) error {
+	regMu.Lock()
+	fams := append([]*family(nil), families...)
+	regMu.Unlock()
+	sort.Slice(fams, func(i, j int) bool { return fams[i].name < fams[j].name })
+
+	var b strings.Builder
+	for _, f := range fams {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+		f.mu.Lock()
+		children := make([]*child, 0, len(f.children))
+		for _, c := range f.children {
+			children = append(children, c)
+		}
+		f.mu.Unlock()
+		sort.Slice(children, func(i, j int) bool {
+			return strings.Join(children[i].values, "\x00") < strings.Join(children[j].values, "\x00")
+		})
+		for _, c := range children {
+			if f.kind != "histogram" {
+				fmt.Fprintf(&b, "%s%s %s\n", f.name, labelString(f.labels, c.values, "", ""), formatFloat(c.value.get()))
+				continue
+			}
+			c.mu.Lock()
+			for i, ub := range f.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, labelString(f.labels, c.values, "le", formatFloat(ub)), c.counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, labelString(f.labels, c.values, "le", "+Inf"), c.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", f.name, labelString(f.labels, c.values, "", ""), formatFloat(c.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", f.name, labelString(f.labels, c.values, "", ""), c.count)
+			c.mu.Unlock()
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func labelString(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		parts = append(parts, n+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler serves WriteText over HTTP.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WriteText(w)
+	})
+}
+
+// Listen serves /metrics on addr: "unix:<path>" for a Unix socket (created
+// 0600, stale file removed) or a TCP host:port. Prefer a loopback address —
+// metrics carry method names and process counts, nothing sensitive, but
+// there is no authentication.
+func Listen(addr string) (io.Closer, error) {
+	var (
+		ln  net.Listener
+		err error
+	)
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if rerr := os.Remove(path); rerr != nil && !os.IsNotExist(rerr) {
+			return nil, rerr
+		}
+		ln, err = net.Listen("unix", path)
+		if err == nil {
+			err = os.Chmod(path, 0600)
+			if err != nil {
+				_ = ln.Close()
+			}
+		}
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	return srv, nil
+}
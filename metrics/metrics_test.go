@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	testCounter   = NewCounterVec("test_requests_total", "Test counter.", "method")
+	testGauge     = NewGaugeVec("test_live", "Test gauge.")
+	testHistogram = NewHistogramVec("test_duration_seconds", "Test histogram.", []float64{0.1, 1}, "stage")
+)
+
+func render(t *testing.T) string {
+	t.Helper()
+	var b strings.Builder
+	if err := WriteText(&b); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	return b.String()
+}
+
+func TestWriteTextFormats(t *testing.T) {
+	testCounter.With(`we"ird`).Add(2)
+	testCounter.With("spawn").Inc()
+	testGauge.With().Inc()
+	testGauge.With().Inc()
+	testGauge.With().Dec()
+	testHistogram.With("boot").Observe(0.05)
+	testHistogram.With("boot").Observe(0.5)
+	testHistogram.With("boot").Observe(5)
+
+	out := render(t)
+	for _, want := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{method="spawn"} 1` + "\n",
+		`test_requests_total{method="we\"ird"} 2` + "\n",
+		"# TYPE test_live gauge\ntest_live 1\n",
+		`test_duration_seconds_bucket{stage="boot",le="0.1"} 1` + "\n",
+		`test_duration_seconds_bucket{stage="boot",le="1"} 2` + "\n",
+		`test_duration_seconds_bucket{stage="boot",le="+Inf"} 3` + "\n",
+		`test_duration_seconds_sum{stage="boot"} 5.55` + "\n",
+		`test_duration_seconds_count{stage="boot"} 3` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestDuplicateRegistrationPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("registering test_live twice did not panic")
+		}
+	}()
+	NewGaugeVec("test_live", "again")
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "metrics.sock")
+	srv, err := Listen("unix:" + sock)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() { _ = srv.Close() }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://metrics/metrics")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "# TYPE test_requests_total counter") {
+		t.Fatalf("unexpected body:\n%s", body)
+	}
+}
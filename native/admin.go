@@ -0,0 +1,80 @@
+package native
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+// Healthy implements pipe.HealthChecker.
+func (b *Backend) Healthy() error {
+	return b.events.Healthy(time.Second)
+}
+
+// Status implements pipe.StatusReporter.
+func (b *Backend) Status() pipe.BackendStatus {
+	live := 0
+	for _, p := range b.tracker.list() {
+		if p.Running {
+			live++
+		}
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return pipe.BackendStatus{
+		Backend:        "native",
+		Running:        b.started,
+		GuestConnected: true,
+		Subscribers:    b.events.Len(),
+		Details: map[string]interface{}{
+			"liveProcesses": live,
+			"sessions":      len(b.sessionProcs),
+		},
+	}
+}
+
+// ListProcesses implements pipe.ProcessLister. Exited processes stay listed
+// (with their exit code) for as long as the tracker remembers them.
+func (b *Backend) ListProcesses() []pipe.ProcessInfo {
+	procs := b.tracker.list()
+	b.mu.RLock()
+	owner := make(map[string]string)
+	for name, ids := range b.sessionProcs {
+		for id := range ids {
+			owner[id] = name
+		}
+	}
+	b.mu.RUnlock()
+	for i := range procs {
+		procs[i].Session = owner[procs[i].ID]
+	}
+	return procs
+}
+
+// ListSessions implements pipe.SessionLister: every session that has had a
+// process spawned since the daemon started.
+func (b *Backend) ListSessions() []pipe.SessionInfo {
+	root, _ := sessionsRoot()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := make([]pipe.SessionInfo, 0, len(b.sessionProcs))
+	for name, ids := range b.sessionProcs {
+		info := pipe.SessionInfo{Name: name, Processes: make([]string, 0, len(ids))}
+		if root != "" {
+			info.Dir = filepath.Join(root, name)
+		}
+		for id := range ids {
+			info.Processes = append(info.Processes, id)
+		}
+		sort.Strings(info.Processes)
+		if b.egress != nil {
+			counts := b.egress.Counts(name)
+			info.EgressAllowed, info.EgressBlocked = counts.Allowed, counts.Blocked
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,42 @@
+package native
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListProcessesReportsSessionAndExit(t *testing.T) {
+	b := NewBackend(false)
+	id, _, err := b.tracker.spawn("p1", "/bin/sh", []string{"-c", "exit 3"}, nil, t.TempDir(), "", "", spawnOptions{})
+	if err != nil {
+		t.Fatalf("spawn: %v", err)
+	}
+	b.sessionProcs["sess"] = map[string]struct{}{id: {}}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		procs := b.ListProcesses()
+		if len(procs) != 1 {
+			t.Fatalf("ListProcesses = %+v, want one entry", procs)
+		}
+		p := procs[0]
+		if p.Session != "sess" || p.Command != "/bin/sh" || p.PID == 0 || p.StartedAt.IsZero() {
+			t.Fatalf("process info = %+v", p)
+		}
+		if !p.Running {
+			if p.ExitCode != 3 {
+				t.Fatalf("exit code = %d, want 3", p.ExitCode)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("process never reported as exited")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sessions := b.ListSessions()
+	if len(sessions) != 1 || sessions[0].Name != "sess" || len(sessions[0].Processes) != 1 {
+		t.Fatalf("ListSessions = %+v", sessions)
+	}
+}
@@ -11,9 +11,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/patrickjaja/claude-cowork-service/egress"
 	"github.com/patrickjaja/claude-cowork-service/pipe"
 	"github.com/patrickjaja/claude-cowork-service/probe"
 	"github.com/patrickjaja/claude-cowork-service/process"
+	"github.com/patrickjaja/claude-cowork-service/sessions"
 )
 
 // canonicalizePath resolves symlinks in the longest existing prefix of path.
@@ -74,28 +76,77 @@ type Backend struct {
 	memory  int
 	cpus    int
 
-	tracker     *processTracker
-	subscribers map[uint64]func(event interface{})
-	nextSubID   uint64
+	tracker *processTracker
+	events  *pipe.EventBus
 	// sessionProcs maps session name → process ids spawned for it, so disk
 	// management can tell which session dirs belong to live sessions.
 	sessionProcs map[string]map[string]struct{}
 	// prober checks Desktop's apiProbeURL and emits apiReachability events.
 	prober *probe.Prober
-	mu     sync.RWMutex
+	// sessions coordinates session setup with the KVM backend when both
+	// run in one daemon; nil otherwise.
+	sessions *sessions.Registry
+	// mountNS runs each CLI in a private mount namespace with the session
+	// bound at /sessions/<name> (EnableMountNamespace). views keeps each
+	// namespaced session's VM → host path mapping for readFile.
+	mountNS bool
+	views   map[string]sessionView
+	// sandbox confines each CLI with Landlock (EnableSandbox); nil when off.
+	// sandboxHide are the daemon's sockets (HideFromSandbox), hidden from
+	// sandboxed CLIs along with the user's runtime dir.
+	sandbox     *SandboxConfig
+	sandboxHide []string
+	// egress proxies spawns that carry allowedDomains (EnableEgress); nil
+	// when off. egressIsolate also gives them a network namespace.
+	egress        *egress.Proxy
+	egressIsolate bool
+	// cgroups places each session in a cgroup limited to memory and cpus
+	// (EnableCgroups); nil when off.
+	cgroups *cgroupTree
+	mu      sync.RWMutex
 }
 
 // NewBackend creates a native backend that runs processes on the host.
 func NewBackend(debug bool) *Backend {
 	b := &Backend{
 		debug:        debug,
-		subscribers:  make(map[uint64]func(event interface{})),
+		events:       pipe.NewEventBus(),
 		sessionProcs: make(map[string]map[string]struct{}),
+		views:        make(map[string]sessionView),
 	}
 	b.tracker = newProcessTracker(b.emitEvent, debug)
 	return b
 }
 
+// EnableMountNamespace makes spawns run the CLI in an unprivileged user and
+// mount namespace where /sessions/<name>/mnt/<mount> are real bind mounts,
+// as in the VM, instead of remapping paths in its arguments and streams. It
+// fails, leaving the backend unchanged, where user namespaces are not
+// available. Must be called before the backend serves requests.
+func (b *Backend) EnableMountNamespace() error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	base := nsBase(home)
+	if err := os.MkdirAll(base, 0o700); err != nil {
+		return err
+	}
+	if err := probeLauncher(&sessionNamespace{Base: base}); err != nil {
+		return fmt.Errorf("mount namespaces unavailable: %w", err)
+	}
+	b.mountNS = true
+	return nil
+}
+
+// SetSessions registers the backend with the daemon's session registry, so
+// spawns don't set up a session the KVM backend has live processes in, and
+// vice versa. Must be called before the backend serves requests.
+func (b *Backend) SetSessions(r *sessions.Registry) {
+	b.sessions = r
+	r.Register("native", b.isSessionRunning)
+}
+
 func (b *Backend) Configure(memoryMB int, cpuCount int) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -108,7 +159,11 @@ func (b *Backend) Configure(memoryMB int, cpuCount int) error {
 	}
 
 	if b.debug {
-		log.Printf("[native] configured: memoryMB=%d, cpuCount=%d (ignored, running natively)", b.memory, b.cpus)
+		if b.cgroups != nil {
+			log.Printf("[native] configured: memoryMB=%d, cpuCount=%d (per-session cgroup limits)", b.memory, b.cpus)
+		} else {
+			log.Printf("[native] configured: memoryMB=%d, cpuCount=%d (ignored, running natively)", b.memory, b.cpus)
+		}
 	}
 	return nil
 }
@@ -124,6 +179,9 @@ func (b *Backend) StartVM(name string, bundlePath string, memoryGB int, cpuCount
 	b.mu.Lock()
 
 	b.started = true
+	if memoryGB > 0 {
+		b.memory = memoryGB * 1024
+	}
 	if cpuCount > 0 {
 		b.cpus = cpuCount
 	}
@@ -178,7 +236,7 @@ func (b *Backend) StopVM(name string) error {
 	// This preserves the queue JSONL state in case the graceful drain
 	// in kill() doesn't complete before the process is terminated.
 	home, _ := os.UserHomeDir()
-	sessionDir := filepath.Join(home, ".local", "share", "claude-cowork", "sessions", name)
+	sessionDir := sessions.Dir(home, name)
 	if _, err := os.Stat(sessionDir); err == nil {
 		backupDir := sessionDir + backupInfix + time.Now().Format(backupTimeLayout)
 		if cpErr := exec.Command("cp", "-a", sessionDir, backupDir).Run(); cpErr != nil {
@@ -217,7 +275,7 @@ func (b *Backend) IsGuestConnected(name string) (bool, error) {
 	return true, nil
 }
 
-func (b *Backend) Spawn(name string, id string, cmd string, args []string, env map[string]string, cwd string, mounts map[string]pipe.MountSpec, _ []byte, oauthToken string) (string, []string, error) {
+func (b *Backend) Spawn(name string, id string, cmd string, args []string, env map[string]string, cwd string, mounts map[string]pipe.MountSpec, rawParams []byte, oauthToken string) (string, []string, error) {
 	if b.debug {
 		log.Printf("[native] spawn: %s %v (cwd=%s, mounts=%v)", cmd, args, cwd, mounts)
 	}
@@ -228,16 +286,34 @@ func (b *Backend) Spawn(name string, id string, cmd string, args []string, env m
 	// them forever even though skipping is the correct native behavior.
 	failedMounts := []string{}
 
+	// Hold the session against a concurrent KVM spawn until the process
+	// is tracked: the KVM backend removes the mnt/ symlinks created below.
+	release, err := b.sessions.Acquire(name, "native")
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+
 	// The client sends VM paths like /sessions/<name>/mnt/<mount>.
 	// We create these under ~/.local/share/claude-cowork/sessions/ and
-	// symlink /sessions/<name> → there so the absolute paths work.
+	// symlink /sessions/<name> → there so the absolute paths work, or, in
+	// a session namespace, bind-mount them there.
 	home, _ := os.UserHomeDir()
-	realSessionDir := filepath.Join(home, ".local", "share", "claude-cowork", "sessions", name)
+	realSessionDir := sessions.Dir(home, name)
 	mntDir := filepath.Join(realSessionDir, "mnt")
 	if err := os.MkdirAll(mntDir, 0755); err != nil {
 		return "", nil, fmt.Errorf("creating session dir: %w", err)
 	}
 
+	// Session prefix used for path remapping (VM paths ↔ real paths)
+	sessionPrefix := "/sessions/" + name
+
+	var ns *sessionNamespace
+	view := sessionView{dir: realSessionDir, mounts: map[string]string{}}
+	if b.mountNS {
+		ns = &sessionNamespace{Base: nsBase(home), Session: sessionPrefix, Source: realSessionDir}
+	}
+
 	for mountName, mount := range mounts {
 		hostPath := resolveSubpath(home, mount.Path)
 		// Skip mounts whose target is not a directory (e.g. app.asar).
@@ -254,6 +330,47 @@ func (b *Backend) Spawn(name string, id string, cmd string, args []string, env m
 		}
 		linkPath := filepath.Join(mntDir, mountName)
 
+		if ns != nil {
+			// The launcher binds it. A top-level symlink left by an earlier
+			// spawn outside the namespace would redirect the bind; nested
+			// names resolve into the parent mount's source, which holds
+			// user data and is never touched here.
+			if !strings.Contains(mountName, "/") {
+				if fi, err := os.Lstat(linkPath); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+					if err := os.Remove(linkPath); err != nil {
+						log.Printf("[native] remove stale link %s: %v", linkPath, err)
+					}
+				}
+			}
+			// Making these read-only would take the CLI's home or the
+			// session's own state with them, so the mode can't be honored.
+			readOnly := mount.Mode == "ro"
+			if readOnly && (underAny(home, []string{hostPath}) || underAny(realSessionDir, []string{hostPath})) {
+				log.Printf("[native] mount %s failed: read-only %s would cover the session's own state", mountName, hostPath)
+				failedMounts = append(failedMounts, mountName)
+				continue
+			}
+			ns.Binds = append(ns.Binds, nsBind{Name: mountName, Source: hostPath, Target: sessionPrefix + "/mnt/" + mountName, ReadOnly: readOnly})
+			view.mounts[mountName] = hostPath
+			continue
+		}
+		// A symlink can't make it read-only, and without the sandbox nothing
+		// else would, so it is left out rather than granted writable.
+		if mount.Mode == "ro" && b.sandbox == nil {
+			log.Printf("[native] mount %s failed: mode ro not enforced without -native-mountns or -native-sandbox", mountName)
+			failedMounts = append(failedMounts, mountName)
+			// Nested names resolve into the parent mount's source, which
+			// holds user data and is never touched here.
+			if !strings.Contains(mountName, "/") {
+				if fi, err := os.Lstat(linkPath); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+					if err := os.Remove(linkPath); err != nil {
+						log.Printf("[native] remove stale link %s: %v", linkPath, err)
+					}
+				}
+			}
+			continue
+		}
+
 		// Prevent self-referencing symlinks (ELOOP bug).
 		// When a parent mount (e.g. ".remote-plugins") is already symlinked,
 		// child mounts (e.g. ".remote-plugins/<id>/.mcpb-cache") resolve
@@ -285,22 +402,23 @@ func (b *Backend) Spawn(name string, id string, cmd string, args []string, env m
 
 	// Create /sessions/<name> symlink so absolute VM paths resolve.
 	// MkdirAll and Symlink both fail without root, which is the common case —
-	// the caller already copes by path-remapping cwd/env in that mode.
-	topSessionDir := "/sessions/" + name
-	if err := os.MkdirAll("/sessions", 0755); err != nil && b.debug {
-		log.Printf("[native] MkdirAll /sessions: %v (expected without root)", err)
-	}
-	if _, err := os.Lstat(topSessionDir); err != nil {
-		if err := os.Symlink(realSessionDir, topSessionDir); err != nil && b.debug {
-			log.Printf("[native] symlink %s → %s: %v (expected without root)", topSessionDir, realSessionDir, err)
+	// the caller already copes by path-remapping cwd/env in that mode. A
+	// session namespace has its own /sessions.
+	if ns == nil {
+		topSessionDir := "/sessions/" + name
+		if err := os.MkdirAll("/sessions", 0755); err != nil && b.debug {
+			log.Printf("[native] MkdirAll /sessions: %v (expected without root)", err)
+		}
+		if _, err := os.Lstat(topSessionDir); err != nil {
+			if err := os.Symlink(realSessionDir, topSessionDir); err != nil && b.debug {
+				log.Printf("[native] symlink %s → %s: %v (expected without root)", topSessionDir, realSessionDir, err)
+			}
 		}
 	}
 
-	// Session prefix used for path remapping (VM paths ↔ real paths)
-	sessionPrefix := "/sessions/" + name
-
-	// If /sessions isn't writable (no root), remap cwd and env to real paths
-	if _, err := os.Stat(cwd); err != nil {
+	// If /sessions isn't writable (no root), remap cwd and env to real paths,
+	// unless the CLI runs in a session namespace where they resolve
+	if _, err := os.Stat(cwd); ns == nil && err != nil {
 		// Replace the /sessions/<name> prefix with the real session dir,
 		// preserving any sub-path (e.g. /mnt/outputs). Using filepath.Base
 		// here would drop everything but the final segment, turning
@@ -346,7 +464,14 @@ func (b *Backend) Spawn(name string, id string, cmd string, args []string, env m
 	// the CLI resolves --resume only under the project slug of its cwd, so
 	// re-spawns must land in the directory the transcript was created under
 	// (issue #66).
-	cwd = chooseSpawnCwd(home, cwd, args, env, mounts, b.debug)
+	//
+	// In a session namespace Glob works through the binds, so the CLI keeps
+	// the VM cwd Desktop sent, as in the VM.
+	if ns != nil {
+		resumeInNamespace(home, cwd, args, env, mounts, name, view)
+	} else {
+		cwd = chooseSpawnCwd(home, cwd, args, env, mounts, b.debug)
+	}
 
 	// Remove empty env vars that might confuse auth (e.g. empty ANTHROPIC_API_KEY)
 	for k, v := range env {
@@ -431,7 +556,7 @@ func (b *Backend) Spawn(name string, id string, cmd string, args []string, env m
 		// trying /sessions/ paths (which only exist when /sessions is root-writable).
 		outputsHint := ""
 		for mountName, mount := range mounts {
-			if mountName == "outputs" {
+			if mountName == "outputs" && ns == nil {
 				hostOutputs := resolveSubpath(home, mount.Path)
 				outputsHint = " The outputs directory for this session is at: " + hostOutputs +
 					" — write files there directly. The /sessions/ directory does NOT exist in this environment."
@@ -485,10 +610,46 @@ func (b *Backend) Spawn(name string, id string, cmd string, args []string, env m
 		}
 	}
 
-	processID, err := b.tracker.spawn(id, cmd, args, env, cwd, sessionPrefix, realSessionDir, mountRemap, reverseMountRemap)
+	var sandbox *SandboxPaths
+	if b.sandbox != nil {
+		var exposed []string
+		sandbox, exposed = b.sandboxFor(home, sessionPrefix, realSessionDir, mounts, env, failedMounts)
+		// A namespace binds them read-only whatever Landlock allows. A
+		// symlink would leave them writable, so they are left out instead.
+		if ns != nil {
+			exposed = nil
+		}
+		for _, mountName := range exposed {
+			log.Printf("[native] mount %s failed: read-only mount is inside a path the sandbox leaves writable", mountName)
+			failedMounts = append(failedMounts, mountName)
+			linkPath := filepath.Join(mntDir, mountName)
+			if fi, err := os.Lstat(linkPath); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				if err := os.Remove(linkPath); err != nil {
+					log.Printf("[native] remove link %s: %v", linkPath, err)
+				}
+			}
+		}
+	}
+
+	network := b.networkFor(name, rawParams)
+
+	var hide []string
+	if sandbox != nil {
+		hide = b.sandboxHidden()
+	}
+	processID, nsFailed, err := b.tracker.spawn(id, cmd, args, env, cwd, sessionPrefix, realSessionDir, spawnOptions{
+		mountRemap:        mountRemap,
+		reverseMountRemap: reverseMountRemap,
+		ns:                ns,
+		sandbox:           sandbox,
+		hide:              hide,
+		network:           network,
+		cgroup:            b.cgroupFor(name),
+	})
 	if err != nil {
 		return "", nil, err
 	}
+	failedMounts = append(failedMounts, nsFailed...)
 
 	// Record which session this process belongs to so disk management
 	// (deleteSessionDirs, pruneSessionCaches) can refuse to touch sessions
@@ -498,6 +659,9 @@ func (b *Backend) Spawn(name string, id string, cmd string, args []string, env m
 		b.sessionProcs[name] = make(map[string]struct{})
 	}
 	b.sessionProcs[name][processID] = struct{}{}
+	if ns != nil {
+		b.views[name] = view
+	}
 	b.mu.Unlock()
 
 	return processID, failedMounts, nil
@@ -528,7 +692,7 @@ func (b *Backend) MountPath(processID string, subpath string, mountName string,
 }
 
 func (b *Backend) ReadFile(processName string, filePath string) ([]byte, error) {
-	resolved := collapseRepeatedCoworkPrefix(filePath)
+	resolved := collapseRepeatedCoworkPrefix(b.hostPath(filePath))
 	if b.debug {
 		if resolved != filePath {
 			log.Printf("[native] readFile %s (de-duped from %s)", resolved, filePath)
@@ -539,6 +703,26 @@ func (b *Backend) ReadFile(processName string, filePath string) ([]byte, error)
 	return os.ReadFile(resolved)
 }
 
+// hostPath translates a /sessions/<name>/... path printed by a namespaced
+// CLI to the host path it is bound from. Other paths are returned as is.
+func (b *Backend) hostPath(filePath string) string {
+	rest, ok := strings.CutPrefix(filePath, "/sessions/")
+	if !ok {
+		return filePath
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	b.mu.RLock()
+	view, ok := b.views[name]
+	b.mu.RUnlock()
+	if !ok {
+		return filePath
+	}
+	if p, ok := view.hostPath(name, filePath); ok {
+		return p
+	}
+	return filePath
+}
+
 // collapseRepeatedCoworkPrefix works around an upstream Claude Desktop bug
 // (issue #136): when create_artifact/update_artifact reads an html_path that is
 // an absolute path inside the cowork scratch root, Desktop prepends the
@@ -640,19 +824,12 @@ func (b *Backend) SetDebugLogging(enabled bool) {
 }
 
 func (b *Backend) SubscribeEvents(name string, callback func(event interface{})) (func(), error) {
-	b.mu.Lock()
-	b.nextSubID++
-	id := b.nextSubID
-	b.subscribers[id] = callback
-	b.mu.Unlock()
-
-	cancel := func() {
-		b.mu.Lock()
-		delete(b.subscribers, id)
-		b.mu.Unlock()
-	}
+	return b.events.Subscribe(callback), nil
+}
 
-	return cancel, nil
+// SubscribeEventsSince implements pipe.EventReplayer.
+func (b *Backend) SubscribeEventsSince(name string, sinceSeq uint64, callback func(event interface{})) (func(), error) {
+	return b.events.SubscribeSince(sinceSeq, callback), nil
 }
 
 // Touch is part of pipe.VMBackend; native has no dead-client watchdog, so no-op.
@@ -695,17 +872,15 @@ func (b *Backend) Shutdown() {
 	b.tracker.killAll()
 }
 
+// emitEvent publishes synchronously so each subscriber sees events in the
+// order the process goroutines produced them.
 func (b *Backend) emitEvent(event interface{}) {
-	b.mu.RLock()
-	subs := make([]func(event interface{}), 0, len(b.subscribers))
-	for _, cb := range b.subscribers {
-		subs = append(subs, cb)
-	}
-	b.mu.RUnlock()
+	b.events.Publish(event)
+}
 
-	for _, cb := range subs {
-		go cb(event)
-	}
+// PublishEvent implements pipe.EventPublisher.
+func (b *Backend) PublishEvent(event interface{}) {
+	b.emitEvent(event)
 }
 
 // checkSessionIntegrity runs background integrity checks on session files
@@ -716,7 +891,7 @@ func (b *Backend) emitEvent(event interface{}) {
 // which can be run interactively. We only log diagnostics here.
 func (b *Backend) checkSessionIntegrity(name string) {
 	home, _ := os.UserHomeDir()
-	sessionsDir := filepath.Join(home, ".local", "share", "claude-cowork", "sessions")
+	sessionsDir := sessions.Root(home)
 
 	if _, err := os.Stat(sessionsDir); err != nil {
 		return
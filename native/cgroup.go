@@ -0,0 +1,333 @@
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cpuPeriod is the cpu.max period, in microseconds; a session with n CPUs
+// gets n periods of quota.
+const cpuPeriod = 100000
+
+// cgroupTree is the daemon's delegated cgroup v2 subtree. The daemon moves
+// itself into a daemon/ leaf, so the tree can enable controllers for
+// sessions/<name>, which carries the session's limits, with one leaf per
+// spawned process below it.
+type cgroupTree struct {
+	root        string
+	controllers []string // memory and cpu, as far as the tree has them
+	debug       bool
+
+	// mu keeps remove from deleting a session's cgroup while create
+	// sets up another process in it.
+	mu sync.Mutex
+}
+
+// sessionCgroup is one spawn's cgroup placement: a leaf of the session's
+// cgroup, which tree limits to limits.
+type sessionCgroup struct {
+	tree    *cgroupTree
+	session string
+	limits  cgroupLimits
+}
+
+// cgroupLimits are a session's configured VM size. Zero means no limit.
+type cgroupLimits struct {
+	memoryMB int
+	cpus     int
+}
+
+// processCgroup is the leaf cgroup one process and everything it starts
+// run in.
+type processCgroup struct {
+	tree *cgroupTree
+	path string
+}
+
+// EnableCgroups runs each session in a cgroup of its own under the daemon's
+// delegated cgroup v2 subtree, limited to the memory and CPUs from
+// configure/startVM, and kills a process's whole cgroup rather than its
+// process group. It fails, leaving the backend unchanged, when the daemon's
+// cgroup is not delegated to it. Must be called before the backend serves
+// requests.
+func (b *Backend) EnableCgroups() error {
+	root, err := ownCgroup()
+	if err != nil {
+		return err
+	}
+	tree, err := newCgroupTree(root, b.debug)
+	if err != nil {
+		return err
+	}
+	for _, c := range []string{"memory", "cpu"} {
+		if !contains(tree.controllers, c) {
+			log.Printf("[native] cgroup controller %s not delegated to %s; sessions are not limited by it", c, root)
+		}
+	}
+	b.cgroups = tree
+	return nil
+}
+
+// cgroupFor returns the cgroup placement for a spawn in session, or nil
+// when cgroups are off.
+func (b *Backend) cgroupFor(session string) *sessionCgroup {
+	if b.cgroups == nil {
+		return nil
+	}
+	b.mu.RLock()
+	limits := cgroupLimits{memoryMB: b.memory, cpus: b.cpus}
+	b.mu.RUnlock()
+	return &sessionCgroup{tree: b.cgroups, session: session, limits: limits}
+}
+
+// ownCgroup returns the directory of the daemon's cgroup in the cgroup v2
+// hierarchy, wherever that is mounted.
+func ownCgroup() (string, error) {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	mount := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		// Optional fields end at "-", followed by the filesystem type.
+		fields := strings.Fields(line)
+		for i, f := range fields {
+			if f == "-" && i+1 < len(fields) && fields[i+1] == "cgroup2" && len(fields) > 4 {
+				mount = unescapeMountinfo(fields[4])
+				break
+			}
+		}
+		if mount != "" {
+			break
+		}
+	}
+	if mount == "" {
+		return "", errors.New("no cgroup v2 hierarchy mounted")
+	}
+	data, err = os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(mount, path), nil
+		}
+	}
+	return "", errors.New("daemon is not in a cgroup v2 cgroup")
+}
+
+// newCgroupTree takes over root: the daemon moves out of it if it is there,
+// and memory and cpu are enabled for the sessions below it.
+func newCgroupTree(root string, debug bool) (*cgroupTree, error) {
+	procs, err := os.ReadFile(filepath.Join(root, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	// A cgroup with processes in it can't hand controllers to its children.
+	if len(bytes.TrimSpace(procs)) > 0 {
+		daemon := filepath.Join(root, "daemon")
+		if err := os.Mkdir(daemon, 0o755); err != nil && !os.IsExist(err) {
+			return nil, fmt.Errorf("cgroup %s is not delegated to the daemon: %w", root, err)
+		}
+		if err := writeCgroup(daemon, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+			return nil, fmt.Errorf("moving the daemon to %s: %w", daemon, err)
+		}
+	}
+	t := &cgroupTree{root: root, debug: debug}
+	available, err := os.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range []string{"memory", "cpu"} {
+		if contains(strings.Fields(string(available)), c) {
+			t.controllers = append(t.controllers, c)
+		}
+	}
+	sessions := filepath.Join(root, "sessions")
+	if err := os.Mkdir(sessions, 0o755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("cgroup %s is not delegated to the daemon: %w", root, err)
+	}
+	if err := t.enableControllers(root); err != nil {
+		return nil, err
+	}
+	if err := t.enableControllers(sessions); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *cgroupTree) enableControllers(dir string) error {
+	if len(t.controllers) == 0 {
+		return nil
+	}
+	enable := "+" + strings.Join(t.controllers, " +")
+	if err := writeCgroup(dir, "cgroup.subtree_control", enable); err != nil {
+		return fmt.Errorf("enabling %s in %s: %w", t.controllers, dir, err)
+	}
+	return nil
+}
+
+// create makes the leaf cgroup for process id in session, and sets the
+// session's limits.
+func (t *cgroupTree) create(session, id string, limits cgroupLimits) (*processCgroup, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dir := filepath.Join(t.root, "sessions", cgroupName(session))
+	if err := os.Mkdir(dir, 0o755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	if err := t.enableControllers(dir); err != nil {
+		return nil, err
+	}
+	if contains(t.controllers, "memory") {
+		if err := writeCgroup(dir, "memory.max", limits.memoryMax()); err != nil {
+			return nil, err
+		}
+	}
+	if contains(t.controllers, "cpu") {
+		if err := writeCgroup(dir, "cpu.max", limits.cpuMax()); err != nil {
+			return nil, err
+		}
+	}
+	leaf := filepath.Join(dir, cgroupName(id))
+	if err := os.Mkdir(leaf, 0o755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	if t.debug {
+		log.Printf("[native] cgroup %s: memory.max=%s cpu.max=%s", leaf, limits.memoryMax(), limits.cpuMax())
+	}
+	return &processCgroup{tree: t, path: leaf}, nil
+}
+
+func (l cgroupLimits) memoryMax() string {
+	if l.memoryMB <= 0 {
+		return "max"
+	}
+	return strconv.FormatInt(int64(l.memoryMB)<<20, 10)
+}
+
+func (l cgroupLimits) cpuMax() string {
+	if l.cpus <= 0 {
+		return "max " + strconv.Itoa(cpuPeriod)
+	}
+	return fmt.Sprintf("%d %d", l.cpus*cpuPeriod, cpuPeriod)
+}
+
+// cgroupName makes a session or process id usable as a cgroup directory
+// name.
+func cgroupName(s string) string {
+	s = strings.ReplaceAll(s, "/", "_")
+	if s == "" || strings.HasPrefix(s, ".") || strings.HasPrefix(s, "cgroup.") {
+		s = "_" + s
+	}
+	return s
+}
+
+// open returns the cgroup's directory, for SysProcAttr.CgroupFD.
+func (cg *processCgroup) open() (*os.File, error) {
+	return os.OpenFile(cg.path, os.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+}
+
+// oomKills returns how many processes in the cgroup the OOM killer killed.
+func (cg *processCgroup) oomKills() int {
+	n, _ := cgroupKey(filepath.Join(cg.path, "memory.events"), "oom_kill")
+	return n
+}
+
+// populated reports whether any process is left in the cgroup. One that is
+// gone has none.
+func (cg *processCgroup) populated() bool {
+	n, err := cgroupKey(filepath.Join(cg.path, "cgroup.events"), "populated")
+	return err == nil && n != 0
+}
+
+// signal sends sig to every process in the cgroup, including any that left
+// the process group with setsid. SIGKILL goes through cgroup.kill, which
+// also catches processes forked meanwhile.
+func (cg *processCgroup) signal(sig syscall.Signal) {
+	if sig == syscall.SIGKILL {
+		if err := writeCgroup(cg.path, "cgroup.kill", "1"); err == nil {
+			return
+		}
+	}
+	// Before Linux 5.14 there is no cgroup.kill: repeat until the list
+	// stops turning up processes the last round missed.
+	seen := map[int]bool{}
+	for round := 0; round < 10; round++ {
+		pids, err := cgroupPids(cg.path)
+		if err != nil {
+			return
+		}
+		fresh := false
+		for _, pid := range pids {
+			if !seen[pid] {
+				seen[pid] = true
+				fresh = true
+				_ = syscall.Kill(pid, sig)
+			}
+		}
+		if !fresh {
+			return
+		}
+	}
+}
+
+// remove deletes the cgroup, and the session's once its last process
+// cgroup is gone, after waiting up to wait for processes still in it to
+// exit. A cgroup that stays populated is left for a later kill.
+func (cg *processCgroup) remove(wait time.Duration) {
+	for deadline := time.Now().Add(wait); cg.populated() && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+	}
+	cg.tree.mu.Lock()
+	defer cg.tree.mu.Unlock()
+	if err := os.Remove(cg.path); err != nil {
+		return
+	}
+	_ = os.Remove(filepath.Dir(cg.path))
+}
+
+func cgroupPids(dir string) ([]int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, f := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(f); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// cgroupKey reads one value from a flat-keyed cgroup file such as
+// memory.events.
+func cgroupKey(path, key string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), key+" "); ok {
+			return strconv.Atoi(v)
+		}
+	}
+	return 0, fmt.Errorf("%s: no %s", path, key)
+}
+
+func writeCgroup(dir, file, value string) error {
+	return os.WriteFile(filepath.Join(dir, file), []byte(value), 0o644)
+}
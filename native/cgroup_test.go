@@ -0,0 +1,216 @@
+package native
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	for _, tc := range []struct {
+		limits         cgroupLimits
+		memory, cpuMax string
+	}{
+		{cgroupLimits{}, "max", "max 100000"},
+		{cgroupLimits{memoryMB: 4096, cpus: 2}, "4294967296", "200000 100000"},
+	} {
+		if got := tc.limits.memoryMax(); got != tc.memory {
+			t.Errorf("%+v memory.max = %s, want %s", tc.limits, got, tc.memory)
+		}
+		if got := tc.limits.cpuMax(); got != tc.cpuMax {
+			t.Errorf("%+v cpu.max = %s, want %s", tc.limits, got, tc.cpuMax)
+		}
+	}
+	for in, want := range map[string]string{"s": "s", "a/b": "a_b", "..": "_..", "": "_", "cgroup.procs": "_cgroup.procs"} {
+		if got := cgroupName(in); got != want {
+			t.Errorf("cgroupName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCgroupKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.events")
+	if err := os.WriteFile(path, []byte("low 0\nhigh 0\nmax 12\noom 2\noom_kill 1\noom_group_kill 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := cgroupKey(path, "oom_kill"); err != nil || n != 1 {
+		t.Fatalf("oom_kill = %d, %v", n, err)
+	}
+	if _, err := cgroupKey(path, "populated"); err == nil {
+		t.Fatal("missing key found")
+	}
+}
+
+// testCgroupTree sets up a cgroup tree in a fresh child of the test's own
+// cgroup, where the test process can create and populate cgroups.
+func testCgroupTree(t *testing.T) *cgroupTree {
+	t.Helper()
+	own, err := ownCgroup()
+	if err != nil {
+		t.Skipf("no cgroup v2: %v", err)
+	}
+	root, err := os.MkdirTemp(own, "cowork-test-")
+	if err != nil {
+		t.Skipf("cgroup %s not writable: %v", own, err)
+	}
+	t.Cleanup(func() { removeCgroupTree(root) })
+	tree, err := newCgroupTree(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// removeCgroupTree kills whatever a test left in dir and removes it,
+// children first.
+func removeCgroupTree(dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.IsDir() {
+			removeCgroupTree(filepath.Join(dir, e.Name()))
+		}
+	}
+	cg := &processCgroup{tree: &cgroupTree{}, path: dir}
+	cg.signal(syscall.SIGKILL)
+	for i := 0; i < 100 && cg.populated(); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	_ = os.Remove(dir)
+}
+
+// alive reports whether pid is a process that hasn't exited yet.
+func alive(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesized command name.
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func waitGone(t *testing.T, pid int) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if !alive(pid) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("pid %d still running", pid)
+}
+
+// TestKillCgroup checks that killing a process also kills what it started
+// with setsid, which a process group kill misses, and that its cgroup goes
+// away with it.
+func TestKillCgroup(t *testing.T) {
+	tree := testCgroupTree(t)
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+	t.Cleanup(pt.killAll)
+
+	script := `setsid sleep 300 & echo "escaped=$!"; exec sleep 300`
+	cgroup := &sessionCgroup{tree: tree, session: "s", limits: cgroupLimits{memoryMB: 512, cpus: 1}}
+	id, _, err := pt.spawn("p1", "/bin/sh", []string{"-c", script}, nil, t.TempDir(), "", "", spawnOptions{cgroup: cgroup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := events.stdoutLine(t, id, "escaped=")
+	escaped, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "escaped=")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := filepath.Join(tree.root, "sessions", "s", "p1")
+	pids, err := cgroupPids(leaf)
+	if err != nil || !containsPid(pids, escaped) {
+		t.Fatalf("cgroup %s holds %v (%v), want %d in it", leaf, pids, err, escaped)
+	}
+
+	if err := pt.kill(id, "SIGKILL"); err != nil {
+		t.Fatal(err)
+	}
+	if ev := events.exit(t, id); ev.Signal != "SIGKILL" {
+		t.Fatalf("exit = %+v", ev)
+	}
+	waitGone(t, escaped)
+	waitRemoved(t, leaf)
+}
+
+// TestKillCgroupAfterExit checks that a process's leftovers outlive it in
+// its cgroup until the process is killed.
+func TestKillCgroupAfterExit(t *testing.T) {
+	tree := testCgroupTree(t)
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+	t.Cleanup(pt.killAll)
+
+	cgroup := &sessionCgroup{tree: tree, session: "s"}
+	id, _, err := pt.spawn("p1", "/bin/sh", []string{"-c", `setsid sleep 300 >/dev/null 2>&1 & echo "escaped=$!"`}, nil, t.TempDir(), "", "", spawnOptions{cgroup: cgroup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := events.stdoutLine(t, id, "escaped=")
+	escaped, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "escaped=")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := events.exit(t, id); ev.ExitCode != 0 || ev.OOMKillCount != 0 {
+		t.Fatalf("exit = %+v", ev)
+	}
+	if !alive(escaped) {
+		t.Fatal("leftover process died with its parent")
+	}
+
+	if err := pt.kill(id, ""); err != nil {
+		t.Fatal(err)
+	}
+	waitGone(t, escaped)
+	waitRemoved(t, filepath.Join(tree.root, "sessions", "s"))
+}
+
+// TestCgroupOOMKill checks that a session over its memory limit is
+// OOM-killed and the exit event says so.
+func TestCgroupOOMKill(t *testing.T) {
+	tree := testCgroupTree(t)
+	if !contains(tree.controllers, "memory") {
+		t.Skip("memory controller not available")
+	}
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+	t.Cleanup(pt.killAll)
+
+	cgroup := &sessionCgroup{tree: tree, session: "s", limits: cgroupLimits{memoryMB: 32}}
+	hog := `x=$(head -c 268435456 /dev/zero | tr '\0' a); echo "survived ${#x}"`
+	id, _, err := pt.spawn("p1", "/bin/sh", []string{"-c", hog}, nil, t.TempDir(), "", "", spawnOptions{cgroup: cgroup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := events.exit(t, id)
+	if ev.OOMKillCount == 0 {
+		t.Fatalf("exit = %+v, want an OOM kill", ev)
+	}
+}
+
+func containsPid(pids []int, pid int) bool {
+	for _, p := range pids {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func waitRemoved(t *testing.T, dir string) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("cgroup %s not removed", dir)
+}
@@ -0,0 +1,33 @@
+package native
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	removeSessionLink(t, conformance.Session)
+
+	script := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(script, []byte(conformance.EchoScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	conformance.Run(t, conformance.Target{
+		New:     func(t *testing.T) pipe.VMBackend { return NewBackend(false) },
+		Command: script,
+		File: func(t *testing.T) (string, []byte) {
+			path := filepath.Join(t.TempDir(), "artifact.html")
+			content := []byte("<html>\x00\xff</html>")
+			if err := os.WriteFile(path, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return path, content
+		},
+	})
+}
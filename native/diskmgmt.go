@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/sessions"
 )
 
 // backupInfix joins a session name and timestamp in pre-stop backup dir
@@ -25,7 +26,7 @@ func sessionsRoot() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(home, ".local", "share", "claude-cowork", "sessions"), nil
+	return sessions.Root(home), nil
 }
 
 // isSessionRunning reports whether any process spawned for the session is
@@ -60,6 +61,12 @@ func (b *Backend) isSessionRunning(name string) bool {
 	return running
 }
 
+// sessionInUse is isSessionRunning, extended to processes the KVM backend
+// runs in the session when both backends share this daemon.
+func (b *Backend) sessionInUse(name string) bool {
+	return b.isSessionRunning(name) || b.sessions.Busy(name, "native")
+}
+
 // dirSize sums the sizes of all regular files under path. Unreadable
 // entries are skipped — an approximate answer beats an error here.
 func dirSize(path string) int64 {
@@ -172,7 +179,7 @@ func (b *Backend) DeleteSessionDirs(names []string) (pipe.DeleteSessionDirsResul
 			result.Errors[name] = "invalid session name"
 			continue
 		}
-		if b.isSessionRunning(name) {
+		if b.sessionInUse(name) {
 			result.Errors[name] = "session has a running process"
 			continue
 		}
@@ -240,7 +247,7 @@ func (b *Backend) PruneSessionCaches(onlyIfFreeBytesBelow int64, includeSessionT
 			continue
 		}
 		owner := name[:i]
-		if b.isSessionRunning(owner) {
+		if b.sessionInUse(owner) {
 			skipped[owner] = true
 			continue
 		}
@@ -0,0 +1,176 @@
+package native
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/patrickjaja/claude-cowork-service/egress"
+)
+
+// nsProxyAddr is where the egress proxy listens inside a session's network
+// namespace, which has nothing else on it.
+const (
+	nsProxyAddr = "127.0.0.1:3128"
+	nsProxyPort = 3128
+)
+
+const (
+	siocsifflags = 0x8914 // SIOCSIFFLAGS
+	iffUp        = 0x1    // IFF_UP
+)
+
+// sessionNetwork is one spawn's egress policy: the CLI reaches the network
+// only through the proxy, which allows the hosts in allow. With isolate the
+// CLI runs in a network namespace where the proxy is all there is;
+// otherwise HTTP(S)_PROXY points at it and direct sockets still work.
+type sessionNetwork struct {
+	proxy   *egress.Proxy
+	session string
+	allow   egress.Allowlist
+	isolate bool
+}
+
+// EnableEgress makes spawns that carry allowedDomains go through the
+// daemon's egress proxy. With isolate, each such CLI also gets a network
+// namespace of its own so it can't bypass the proxy; that fails, leaving
+// the backend unchanged, where unprivileged user namespaces are not
+// available. Must be called before the backend serves requests.
+func (b *Backend) EnableEgress(isolate bool) error {
+	if isolate {
+		if err := probeLauncher(&sessionNamespace{Network: true}); err != nil {
+			return fmt.Errorf("network namespaces unavailable: %w", err)
+		}
+	}
+	b.egress = egress.New(b.debug)
+	b.egressIsolate = isolate
+	return nil
+}
+
+// networkFor returns the egress policy for a spawn, or nil when the spawn
+// has no allowedDomains (Desktop's unrestricted policy) or egress is off.
+func (b *Backend) networkFor(session string, rawParams []byte) *sessionNetwork {
+	var p struct {
+		AllowedDomains []string `json:"allowedDomains"`
+	}
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &p); err != nil && b.debug {
+			log.Printf("[native] spawn params: %v", err)
+		}
+	}
+	if p.AllowedDomains == nil {
+		return nil
+	}
+	if b.egress == nil {
+		if b.debug {
+			log.Printf("[native] allowedDomains %v not enforced (egress proxy off)", p.AllowedDomains)
+		}
+		return nil
+	}
+	if b.debug {
+		log.Printf("[native] egress for %s limited to %v", session, p.AllowedDomains)
+	}
+	return &sessionNetwork{proxy: b.egress, session: session, allow: p.AllowedDomains, isolate: b.egressIsolate}
+}
+
+// proxyEnv points env's proxy variables at proxyURL, replacing any the
+// daemon or Desktop set, and keeps loopback direct.
+func proxyEnv(env []string, proxyURL string) []string {
+	out := env[:0]
+	for _, kv := range env {
+		switch strings.ToUpper(kv[:strings.IndexByte(kv+"=", '=')]) {
+		case "HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "NO_PROXY":
+			continue
+		}
+		out = append(out, kv)
+	}
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		out = append(out, name+"="+proxyURL)
+	}
+	return append(out, "NO_PROXY=localhost,127.0.0.1,::1", "no_proxy=localhost,127.0.0.1,::1")
+}
+
+// setupNetwork runs in the launcher, inside the session's new network
+// namespace: it brings up loopback and, unless probing, opens the proxy's
+// listening socket there and sends it to the daemon on launcherNetFd. The
+// daemon serves the proxy on it, so the CLI's only way out is a socket that
+// belongs to its own namespace.
+func setupNetwork(send bool) error {
+	if err := loopbackUp(); err != nil {
+		return fmt.Errorf("bringing up lo: %w", err)
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = syscall.Close(fd) }()
+	addr := &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}, Port: nsProxyPort}
+	if err := syscall.Bind(fd, addr); err != nil {
+		return fmt.Errorf("binding %s: %w", nsProxyAddr, err)
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		return err
+	}
+	if !send {
+		return nil
+	}
+	defer func() { _ = syscall.Close(launcherNetFd) }()
+	if err := syscall.Sendmsg(launcherNetFd, []byte{0}, syscall.UnixRights(fd), nil, 0); err != nil {
+		return fmt.Errorf("handing the proxy socket to the daemon: %w", err)
+	}
+	return nil
+}
+
+// loopbackUp sets IFF_UP on lo, which a new network namespace starts
+// without.
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = syscall.Close(fd) }()
+	var ifr [40]byte // struct ifreq: name, then flags as a short
+	copy(ifr[:], "lo")
+	*(*uint16)(unsafe.Pointer(&ifr[syscall.IFNAMSIZ])) = iffUp
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocsifflags, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// networkSocketpair returns the daemon's end of the socket the launcher
+// sends the proxy listener on, and the end to pass as launcherNetFd.
+func networkSocketpair() (daemon, launcher *os.File, err error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return os.NewFile(uintptr(fds[0]), "egress"), os.NewFile(uintptr(fds[1]), "egress-launcher"), nil
+}
+
+// receiveListener reads the proxy listener setupNetwork sent.
+func receiveListener(sock *os.File) (net.Listener, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := syscall.Recvmsg(int(sock.Fd()), buf, oob, 0)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return nil, errors.New("launcher sent no proxy socket")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) == 0 {
+		return nil, errors.New("launcher sent no proxy socket")
+	}
+	f := os.NewFile(uintptr(fds[0]), "egress-listener")
+	defer func() { _ = f.Close() }()
+	return net.FileListener(f)
+}
@@ -0,0 +1,128 @@
+package native
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestProxyEnv(t *testing.T) {
+	env := proxyEnv([]string{"PATH=/usr/bin", "https_proxy=http://corp:8080", "NO_PROXY=*", "All_Proxy=socks5://x", "HOME=/h"}, "http://127.0.0.1:3128")
+	got := strings.Join(env, " ")
+	want := "PATH=/usr/bin HOME=/h HTTP_PROXY=http://127.0.0.1:3128 HTTPS_PROXY=http://127.0.0.1:3128 http_proxy=http://127.0.0.1:3128 https_proxy=http://127.0.0.1:3128 NO_PROXY=localhost,127.0.0.1,::1 no_proxy=localhost,127.0.0.1,::1"
+	if got != want {
+		t.Fatalf("proxyEnv =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNetworkFor(t *testing.T) {
+	b := NewBackend(false)
+	t.Cleanup(b.Shutdown)
+	withDomains := []byte(`{"name":"s","allowedDomains":["api.anthropic.com"]}`)
+	if n := b.networkFor("s", withDomains); n != nil {
+		t.Fatalf("egress off: network = %+v", n)
+	}
+	if err := b.EnableEgress(false); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.networkFor("s", []byte(`{"name":"s"}`)); n != nil {
+		t.Fatalf("no allowedDomains: network = %+v, want unrestricted", n)
+	}
+	if n := b.networkFor("s", withDomains); n == nil || n.session != "s" || len(n.allow) != 1 || n.isolate {
+		t.Fatalf("network = %+v", n)
+	}
+	if n := b.networkFor("s", []byte(`{"allowedDomains":[]}`)); n == nil || len(n.allow) != 0 {
+		t.Fatalf("empty allowedDomains: network = %+v, want everything blocked", n)
+	}
+}
+
+// egressUpstream starts a local server that stands in for every allowlisted
+// host, and points b's proxy at it.
+func egressUpstream(t *testing.T, b *Backend) string {
+	t.Helper()
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl not installed")
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello "+r.Host)
+	}))
+	t.Cleanup(upstream.Close)
+	addr := strings.TrimPrefix(upstream.URL, "http://")
+	b.egress.Dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	return addr
+}
+
+func runEgressScript(t *testing.T, b *Backend, session, script string, lines ...string) {
+	t.Helper()
+	removeSessionLink(t, session)
+	events := newEventRecorder()
+	unsubscribe, _ := b.SubscribeEvents("", events.emit)
+	defer unsubscribe()
+	params := []byte(`{"allowedDomains":["allowed.test"]}`)
+	id, _, err := b.Spawn(session, "", "/bin/sh", []string{"-c", script}, map[string]string{}, "", nil, params, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.Kill(id, "SIGKILL") })
+	for _, line := range lines {
+		events.stdoutLine(t, id, line)
+	}
+	events.exit(t, id)
+}
+
+func curlStatus(label, url string, flags ...string) string {
+	return `echo "` + label + `=$(curl -s -o /dev/null -m 5 -w '%{http_code}' ` + strings.Join(flags, " ") + ` ` + url + `)"`
+}
+
+func TestSpawnThroughEgressProxy(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	b := NewBackend(false)
+	t.Cleanup(b.Shutdown)
+	if err := b.EnableEgress(false); err != nil {
+		t.Fatal(err)
+	}
+	egressUpstream(t, b)
+
+	runEgressScript(t, b, "egress-proxy", strings.Join([]string{
+		curlStatus("allowed", "http://allowed.test/"),
+		curlStatus("blocked", "http://blocked.test/"),
+		curlStatus("tunnel", "https://blocked.test/"),
+	}, "\n"), "allowed=200", "blocked=403", "tunnel=000")
+
+	for _, s := range b.ListSessions() {
+		if s.Name == "egress-proxy" {
+			if s.EgressAllowed != 1 || s.EgressBlocked != 2 {
+				t.Fatalf("session counts = %d allowed, %d blocked; want 1, 2", s.EgressAllowed, s.EgressBlocked)
+			}
+			return
+		}
+	}
+	t.Fatal("session not listed")
+}
+
+// TestSpawnInNetworkNamespace checks that in netns mode the proxy still
+// works from inside the namespace, while a direct connection that ignores
+// the proxy goes nowhere.
+func TestSpawnInNetworkNamespace(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	b := NewBackend(false)
+	t.Cleanup(b.Shutdown)
+	if err := b.EnableEgress(true); err != nil {
+		t.Skipf("no network namespaces here: %v", err)
+	}
+	addr := egressUpstream(t, b)
+
+	runEgressScript(t, b, "egress-netns", strings.Join([]string{
+		curlStatus("allowed", "http://allowed.test/"),
+		curlStatus("blocked", "http://blocked.test/"),
+		curlStatus("direct", "http://"+addr+"/", "--noproxy", "'*'"),
+	}, "\n"), "allowed=200", "blocked=403", "direct=000")
+}
@@ -0,0 +1,335 @@
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/client"
+	"github.com/patrickjaja/claude-cowork-service/process"
+	"github.com/patrickjaja/claude-cowork-service/transcript"
+)
+
+// fakeClaude is the path of cmd/fakeclaude built as "claude" by TestMain,
+// or "" with fakeClaudeErr set if the build failed.
+var (
+	fakeClaude    string
+	fakeClaudeErr error
+)
+
+func TestMain(m *testing.M) {
+	// Namespaced spawns re-exec the test binary as the session launcher.
+	if len(os.Args) > 1 && os.Args[1] == launcherArg {
+		runtime.LockOSThread()
+		os.Exit(RunSessionLauncher(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == dialArg {
+		os.Exit(dialSockets(os.Args[2:]))
+	}
+	dir, err := os.MkdirTemp("", "fakeclaude")
+	if err != nil {
+		fakeClaudeErr = err
+	} else {
+		path := filepath.Join(dir, "claude")
+		out, err := exec.Command("go", "build", "-o", path, "github.com/patrickjaja/claude-cowork-service/cmd/fakeclaude").CombinedOutput()
+		if err != nil {
+			fakeClaudeErr = fmt.Errorf("building fakeclaude: %v\n%s", err, out)
+		} else {
+			fakeClaude = path
+		}
+	}
+	code := m.Run()
+	if dir != "" {
+		_ = os.RemoveAll(dir)
+	}
+	os.Exit(code)
+}
+
+func fakeClaudePath(t *testing.T) string {
+	t.Helper()
+	if fakeClaudeErr != nil {
+		t.Fatal(fakeClaudeErr)
+	}
+	return fakeClaude
+}
+
+// removeSessionLink drops /sessions/<name>, which Spawn creates when it runs
+// as root, before and after the test so it never dangles into a removed
+// temp HOME.
+func removeSessionLink(t *testing.T, name string) {
+	t.Helper()
+	link := "/sessions/" + name
+	remove := func() {
+		if fi, err := os.Lstat(link); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			_ = os.Remove(link)
+		}
+	}
+	remove()
+	t.Cleanup(remove)
+}
+
+// eventRecorder collects emitted events for one test.
+type eventRecorder chan interface{}
+
+func newEventRecorder() eventRecorder {
+	return make(eventRecorder, 256)
+}
+
+func (r eventRecorder) emit(event interface{}) {
+	// Bus subscribers get events pre-encoded; decode them like a client.
+	if enc, ok := event.(pipe.EncodedEvent); ok {
+		decoded, _, err := client.DecodeEvent(enc.JSON)
+		if err != nil {
+			return
+		}
+		event = decoded
+	}
+	r <- event
+}
+
+// stdoutLine waits for a stdout line of processID that contains all of want,
+// failing on exit or timeout. Control requests seen on the way are returned
+// too, so callers can assert the daemon swallowed them.
+func (r eventRecorder) stdoutLine(t *testing.T, processID string, want ...string) (line string, skipped []string) {
+	t.Helper()
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case ev := <-r:
+			switch ev := ev.(type) {
+			case process.StdoutEvent:
+				if ev.ProcessID != processID {
+					continue
+				}
+				if containsAll(ev.Data, want) {
+					return ev.Data, skipped
+				}
+				skipped = append(skipped, ev.Data)
+			case process.ExitEvent:
+				if ev.ProcessID == processID {
+					t.Fatalf("%s exited (%+v) while waiting for %q; output %q", processID, ev, want, skipped)
+				}
+			}
+		case <-deadline:
+			t.Fatalf("no stdout line with %q from %s; output %q", want, processID, skipped)
+		}
+	}
+}
+
+func (r eventRecorder) exit(t *testing.T, processID string) process.ExitEvent {
+	t.Helper()
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case ev := <-r:
+			if ev, ok := ev.(process.ExitEvent); ok && ev.ProcessID == processID {
+				return ev
+			}
+		case <-deadline:
+			t.Fatalf("no exit event from %s", processID)
+		}
+	}
+}
+
+func containsAll(s string, subs []string) bool {
+	for _, sub := range subs {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
+
+// say writes one stream-json user message to the process's stdin.
+func say(t *testing.T, pt *processTracker, processID, text string) {
+	t.Helper()
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":    "user",
+		"message": map[string]string{"role": "user", "content": text},
+	})
+	if err := pt.writeStdin(processID, append(data, '\n')); err != nil {
+		t.Fatalf("writeStdin %q: %v", text, err)
+	}
+}
+
+// spawnFake starts fakeclaude on a tracker and waits for its init line, by
+// which point its SIGINT handler is installed.
+func spawnFake(t *testing.T, pt *processTracker, events eventRecorder, cmd, cwd string, env map[string]string, vmPrefix, realPrefix string, reverseMountRemap []pathRemap) string {
+	t.Helper()
+	id, _, err := pt.spawn("", cmd, []string{"--output-format", "stream-json", "--input-format", "stream-json"}, env, cwd, vmPrefix, realPrefix, spawnOptions{reverseMountRemap: reverseMountRemap})
+	if err != nil {
+		t.Fatalf("spawn: %v", err)
+	}
+	t.Cleanup(func() { _ = pt.kill(id, "SIGKILL") })
+	events.stdoutLine(t, id, `"subtype":"init"`)
+	return id
+}
+
+func TestSpawnResolvesClaudeFromPATH(t *testing.T) {
+	fake := fakeClaudePath(t)
+	t.Setenv("PATH", filepath.Dir(fake)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+
+	// Desktop sends its own install path, which doesn't exist here.
+	id := spawnFake(t, pt, events, "/opt/Claude/resources/claude", t.TempDir(), nil, "", "", nil)
+	if got := pt.processes[id].command; got != fake {
+		t.Fatalf("resolved command = %q, want %q", got, fake)
+	}
+	say(t, pt, id, "hi")
+	events.stdoutLine(t, id, `"type":"result"`, `"result":"echo: hi"`)
+	say(t, pt, id, "/exit 4")
+	if ev := events.exit(t, id); ev.ExitCode != 4 || ev.Signal != "" {
+		t.Fatalf("exit = %+v, want code 4", ev)
+	}
+}
+
+func TestSpawnReportsSignalExit(t *testing.T) {
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+	id := spawnFake(t, pt, events, fakeClaudePath(t), t.TempDir(), nil, "", "", nil)
+	say(t, pt, id, "/signal HUP")
+	if ev := events.exit(t, id); ev.Signal != "SIGHUP" {
+		t.Fatalf("exit = %+v, want signal SIGHUP", ev)
+	}
+}
+
+func TestSpawnReverseMapsOutputPaths(t *testing.T) {
+	vmPrefix := t.TempDir() // must exist for reverse mapping to switch on
+	realPrefix := t.TempDir()
+	project := t.TempDir()
+	rev := []pathRemap{{from: []byte(project), to: []byte(vmPrefix + "/mnt/project")}}
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+
+	id := spawnFake(t, pt, events, fakeClaudePath(t), project, nil, vmPrefix, realPrefix, rev)
+	say(t, pt, id, "/pwd")
+	line, _ := events.stdoutLine(t, id, `"type":"result"`)
+	if !strings.Contains(line, `"result":"`+vmPrefix+`/mnt/project"`) {
+		t.Fatalf("result = %s, want the cwd as its VM mount path", line)
+	}
+}
+
+func TestPresentFilesHandledLocally(t *testing.T) {
+	vmPrefix := t.TempDir()
+	realPrefix := t.TempDir()
+	writeSizedFile(t, filepath.Join(realPrefix, "outputs", "report.md"), 10)
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+	id := spawnFake(t, pt, events, fakeClaudePath(t), realPrefix, nil, vmPrefix, realPrefix, nil)
+
+	// The CLI names files by their VM paths; the daemon checks the real
+	// file and answers on stdin without involving Desktop.
+	say(t, pt, id, "/present "+vmPrefix+"/outputs/report.md")
+	line, skipped := events.stdoutLine(t, id, `"type":"result"`)
+	if !strings.Contains(line, `"result":"`+vmPrefix+`/outputs/report.md"`) || strings.Contains(line, `"is_error":true`) {
+		t.Fatalf("result = %s, want the presented file", line)
+	}
+	for _, l := range skipped {
+		if strings.Contains(l, "control_request") {
+			t.Fatalf("present_files control_request reached Desktop: %s", l)
+		}
+	}
+
+	say(t, pt, id, "/present "+vmPrefix+"/outputs/missing.md")
+	line, _ = events.stdoutLine(t, id, `"type":"result"`)
+	if !strings.Contains(line, "error: Cannot present 1 file(s)") {
+		t.Fatalf("result = %s, want a not-found error", line)
+	}
+}
+
+func TestKillDrainsWithSIGINT(t *testing.T) {
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+	id := spawnFake(t, pt, events, fakeClaudePath(t), t.TempDir(), nil, "", "", nil)
+
+	start := time.Now()
+	if err := pt.kill(id, "SIGTERM"); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+	if ev := events.exit(t, id); ev.ExitCode != 130 || ev.Signal != "" {
+		t.Fatalf("exit = %+v, want a clean exit 130 from SIGINT", ev)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("kill took %s; the graceful drain should end as soon as the CLI exits", elapsed)
+	}
+}
+
+func TestKillEscalatesWhenSIGINTIgnored(t *testing.T) {
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+	id := spawnFake(t, pt, events, fakeClaudePath(t), t.TempDir(), map[string]string{"FAKECLAUDE_IGNORE_SIGINT": "1"}, "", "", nil)
+
+	if err := pt.kill(id, "SIGTERM"); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+	if ev := events.exit(t, id); ev.Signal != "SIGTERM" {
+		t.Fatalf("exit = %+v, want SIGTERM after the drain timed out", ev)
+	}
+}
+
+// TestSpawnResumeFollowsTranscript runs two spawns of one session through
+// Backend.Spawn. The second resumes with a workspace hint that would pick a
+// different cwd; the CLI only finds the transcript under the first cwd's
+// slug, so chooseSpawnCwd must keep it.
+func TestSpawnResumeFollowsTranscript(t *testing.T) {
+	fake := fakeClaudePath(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	removeSessionLink(t, "resume-e2e")
+	mounts, paths := makeWorkspaceMounts(t, home, "alpha", "gamma")
+	cfgDir := filepath.Join(home, "claude-config")
+	mounts[".claude"] = pipe.MountSpec{Path: strings.TrimPrefix(cfgDir, "/"), Mode: "rw"}
+
+	b := NewBackend(false)
+	events := newEventRecorder()
+	unsubscribe, _ := b.SubscribeEvents("", events.emit)
+	defer unsubscribe()
+
+	spawn := func(args []string, env map[string]string) string {
+		t.Helper()
+		env["CLAUDE_CONFIG_DIR"] = cfgDir
+		args = append([]string{"--output-format", "stream-json", "--input-format", "stream-json"}, args...)
+		id, _, err := b.Spawn("resume-e2e", "", fake, args, env, "/sessions/resume-e2e", mounts, nil, "")
+		if err != nil {
+			t.Fatalf("Spawn: %v", err)
+		}
+		t.Cleanup(func() { _ = b.tracker.kill(id, "SIGKILL") })
+		return id
+	}
+
+	first := spawn([]string{"--session-id", "sess-1"}, map[string]string{})
+	events.stdoutLine(t, first, `"subtype":"init"`)
+	say(t, b.tracker, first, "remember this")
+	events.stdoutLine(t, first, `"type":"result"`)
+	say(t, b.tracker, first, "/exit 0")
+	events.exit(t, first)
+	if _, err := os.Stat(filepath.Join(cfgDir, "projects", transcript.Slugify(paths["alpha"]), "sess-1.jsonl")); err != nil {
+		t.Fatalf("first spawn did not write its transcript under alpha: %v", err)
+	}
+
+	second := spawn([]string{"--resume", "sess-1"}, map[string]string{
+		"CLAUDE_CODE_WORKSPACE_HOST_PATHS": paths["gamma"],
+	})
+	line, _ := events.stdoutLine(t, second, `"session_id":"sess-1"`)
+	if !strings.Contains(line, `"resumed":true`) {
+		t.Fatalf("resume failed: %s", line)
+	}
+	say(t, b.tracker, second, "still there?")
+	events.stdoutLine(t, second, `"result":"echo: still there?"`)
+
+	data, err := os.ReadFile(filepath.Join(cfgDir, "projects", transcript.Slugify(paths["alpha"]), "sess-1.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "remember this") || !strings.Contains(string(data), "still there?") {
+		t.Fatalf("transcript lacks one of the turns:\n%s", data)
+	}
+}
@@ -0,0 +1,619 @@
+package native
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// launcherArg is the argv[1] under which the daemon binary runs as a session
+// launcher (main dispatches it to RunSessionLauncher).
+const launcherArg = "--session-ns"
+
+// launcherExe re-execs the running daemon binary. /proc/self/exe keeps
+// working after a package upgrade replaced the file on disk.
+const launcherExe = "/proc/self/exe"
+
+const (
+	capNetAdmin           = 12 // CAP_NET_ADMIN
+	capSysAdmin           = 21 // CAP_SYS_ADMIN
+	prCapAmbient          = 47 // PR_CAP_AMBIENT
+	prCapAmbientClearAll  = 4  // PR_CAP_AMBIENT_CLEAR_ALL
+	launcherStatusFd      = 3  // first of exec.Cmd.ExtraFiles
+	launcherNetFd         = 4  // socket the egress listener is sent back on
+	launcherSetupExitCode = 125
+)
+
+// sessionNamespace tells the launcher how to build the root a session's CLI
+// runs in: the host's root with /sessions/<name> bind-mounted from the real
+// session dir, and each mount bound at /sessions/<name>/mnt/<mount>.
+type sessionNamespace struct {
+	// Base is an existing empty directory the launcher mounts its scratch
+	// tmpfs on. Only the launcher's namespace ever sees that mount. Without
+	// it the launcher stays in the daemon's namespaces and only applies
+	// Sandbox.
+	Base    string   `json:"base,omitempty"`
+	Session string   `json:"session,omitempty"` // /sessions/<name>
+	Source  string   `json:"source,omitempty"`  // real session dir bound at Session
+	Binds   []nsBind `json:"binds,omitempty"`
+	Cwd     string   `json:"cwd,omitempty"`
+	// Sandbox is the Landlock allowlist applied right before exec.
+	Sandbox *SandboxPaths `json:"sandbox,omitempty"`
+	// Hide lists paths covered up in the launcher's mount namespace:
+	// directories by an empty read-only tmpfs, anything else by /dev/null.
+	// Landlock doesn't govern connecting to a Unix socket, so this is what
+	// keeps a sandboxed CLI off the daemon's sockets and the session bus.
+	Hide []string `json:"hide,omitempty"`
+	// Network gives the session a network namespace with only loopback,
+	// where the daemon's egress proxy listens (see setupNetwork).
+	Network bool `json:"network,omitempty"`
+	// Probe builds the root and exits instead of exec'ing a command.
+	Probe bool `json:"probe,omitempty"`
+}
+
+// nsBind is one mount: Source (a host path) is bound at Target (a path
+// inside the session, /sessions/<name>/mnt/<mount>), read-only for "ro"
+// mounts.
+type nsBind struct {
+	Name     string `json:"name"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+}
+
+// launchStatus is what the launcher reports on its status fd before it
+// execs the CLI (or gives up).
+type launchStatus struct {
+	FailedMounts []nsFailure `json:"failedMounts,omitempty"`
+	Error        string      `json:"error,omitempty"`
+}
+
+type nsFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// nsSysProcAttr starts the launcher in a new user namespace, plus a mount
+// and/or network namespace as ns asks. Unlike the KVM vfs helper's
+// `unshare --map-root-user`, the caller's uid maps to itself: the CLI must
+// keep running as the user (it refuses some flags as root), so the launcher
+// gets CAP_SYS_ADMIN for its mounts and CAP_NET_ADMIN for loopback as
+// ambient capabilities and clears them again before exec.
+func nsSysProcAttr(ns *sessionNamespace) *syscall.SysProcAttr {
+	uid, gid := os.Getuid(), os.Getgid()
+	attr := &syscall.SysProcAttr{
+		Setpgid:     true,
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}},
+	}
+	if ns.Base != "" || len(ns.Hide) > 0 {
+		attr.Cloneflags |= syscall.CLONE_NEWNS
+		attr.AmbientCaps = append(attr.AmbientCaps, capSysAdmin)
+	}
+	if ns.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+		attr.AmbientCaps = append(attr.AmbientCaps, capNetAdmin)
+	}
+	return attr
+}
+
+// launcherCommand wraps cmd and args in the session launcher.
+func launcherCommand(ns *sessionNamespace, cmd string, args []string) (*exec.Cmd, error) {
+	spec, err := json.Marshal(ns)
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(launcherExe, append([]string{launcherArg, string(spec), cmd}, args...)...)
+	if ns.Base != "" || ns.Network || len(ns.Hide) > 0 {
+		c.SysProcAttr = nsSysProcAttr(ns)
+	}
+	return c, nil
+}
+
+// startLauncher starts c and waits until the launcher has set up the
+// session and exec'd its command, or reported why it could not. The status
+// pipe becomes fd 3, ahead of any ExtraFiles the caller set.
+func startLauncher(c *exec.Cmd) (launchStatus, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return launchStatus{}, fmt.Errorf("creating launcher status pipe: %w", err)
+	}
+	defer func() { _ = r.Close() }()
+	c.ExtraFiles = append([]*os.File{w}, c.ExtraFiles...)
+	err = c.Start()
+	_ = w.Close()
+	if err != nil {
+		return launchStatus{}, err
+	}
+	// The launcher's copy of the pipe is close-on-exec, so EOF means it
+	// either exec'd or died.
+	var status launchStatus
+	reported := false
+	dec := json.NewDecoder(r)
+	for {
+		var s launchStatus
+		if err := dec.Decode(&s); err != nil {
+			break
+		}
+		reported = true
+		status.FailedMounts = append(status.FailedMounts, s.FailedMounts...)
+		if s.Error != "" {
+			status.Error = s.Error
+		}
+	}
+	if !reported {
+		status.Error = "launcher exited before setting up the session"
+	}
+	return status, nil
+}
+
+// probeLauncher runs the launcher with ns in probe mode, which fails where
+// unprivileged user namespaces are disabled or restricted (sysctl
+// kernel.unprivileged_userns_clone, AppArmor on Ubuntu 24.04).
+func probeLauncher(ns *sessionNamespace) error {
+	ns.Probe = true
+	c, err := launcherCommand(ns, "", nil)
+	if err != nil {
+		return err
+	}
+	status, err := startLauncher(c)
+	if err != nil {
+		return err
+	}
+	waitErr := c.Wait()
+	if status.Error != "" {
+		return errors.New(status.Error)
+	}
+	return waitErr
+}
+
+// RunSessionLauncher is the entry point of the session launcher: the daemon
+// binary re-exec'd as `--session-ns SPEC CMD ARGS...`, usually in a new user
+// and mount namespace (see nsSysProcAttr). It builds the session's root,
+// applies the sandbox, reports on fd 3, and replaces itself with CMD, so the
+// process the tracker waits on, signals and reads from is the CLI itself.
+//
+// Nothing may be written to stdout or stderr: both already belong to the
+// CLI's event stream.
+//
+// The Landlock restriction, no_new_privs and the ambient capability reset
+// only apply to the calling thread, so the launcher stays on one thread
+// from the first of them to exec; callers lock it before dispatching here.
+func RunSessionLauncher(args []string) int {
+	runtime.LockOSThread()
+	status := os.NewFile(launcherStatusFd, "status")
+	syscall.CloseOnExec(launcherStatusFd)
+	report := func(s launchStatus) {
+		data, _ := json.Marshal(s)
+		_, _ = status.Write(append(data, '\n'))
+	}
+	fail := func(format string, a ...interface{}) int {
+		report(launchStatus{Error: fmt.Sprintf(format, a...)})
+		return launcherSetupExitCode
+	}
+
+	if len(args) < 2 {
+		return fail("usage: %s SPEC CMD [ARGS...]", launcherArg)
+	}
+	var ns sessionNamespace
+	if err := json.Unmarshal([]byte(args[0]), &ns); err != nil {
+		return fail("parsing launcher spec: %v", err)
+	}
+	var failed []nsFailure
+	if ns.Base != "" {
+		var err error
+		if failed, err = ns.build(); err != nil {
+			return fail("session namespace: %v", err)
+		}
+	}
+	if ns.Network {
+		if err := setupNetwork(!ns.Probe); err != nil {
+			return fail("session network: %v", err)
+		}
+	}
+	if len(ns.Hide) > 0 {
+		if err := hidePaths(ns.Hide, ns.Base != ""); err != nil {
+			return fail("hiding paths from the session: %v", err)
+		}
+	}
+	if ns.Probe {
+		report(launchStatus{})
+		return 0
+	}
+	if ns.Cwd != "" {
+		if err := os.Chdir(ns.Cwd); err != nil {
+			return fail("chdir %s: %v", ns.Cwd, err)
+		}
+	}
+	if ns.Sandbox != nil {
+		if err := ns.Sandbox.restrict(); err != nil {
+			return fail("sandbox: %v", err)
+		}
+	}
+	report(launchStatus{FailedMounts: failed})
+
+	// Without the ambient capability, exec as a non-root uid leaves the CLI
+	// with no capabilities at all. Like the sandbox, the reset only holds
+	// for this thread, which is the one that execs.
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 {
+		return fail("clearing ambient capabilities: %v", errno)
+	}
+	cmd := args[1]
+	err := syscall.Exec(cmd, append([]string{cmd}, args[2:]...), os.Environ())
+	return fail("exec %s: %v", cmd, err)
+}
+
+// build replaces the launcher's root with a new one: the host's top-level
+// entries bound in place, plus the session. It pivots into a scratch tmpfs
+// first so the host tree can be bound from /oldroot without also binding
+// the scratch mount into itself.
+func (ns *sessionNamespace) build() ([]nsFailure, error) {
+	if ns.Base == "" || !filepath.IsAbs(ns.Base) {
+		return nil, fmt.Errorf("base must be absolute: %q", ns.Base)
+	}
+	// Keep receiving host mounts (removable media, network shares) but never
+	// propagate ours back.
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_SLAVE, ""); err != nil {
+		return nil, fmt.Errorf("making / a slave mount: %w", err)
+	}
+	if err := syscall.Mount("tmpfs", ns.Base, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+		return nil, fmt.Errorf("mounting scratch tmpfs on %s: %w", ns.Base, err)
+	}
+	for _, dir := range []string{"oldroot", "newroot"} {
+		if err := os.Mkdir(filepath.Join(ns.Base, dir), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	if err := syscall.Mount("tmpfs", filepath.Join(ns.Base, "newroot"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+		return nil, fmt.Errorf("mounting root tmpfs: %w", err)
+	}
+	if err := syscall.PivotRoot(ns.Base, filepath.Join(ns.Base, "oldroot")); err != nil {
+		return nil, fmt.Errorf("pivot_root into scratch: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return nil, err
+	}
+
+	if err := bindHostRoot("/oldroot", "/newroot"); err != nil {
+		return nil, err
+	}
+	var failed []nsFailure
+	if ns.Session != "" {
+		target := "/newroot" + ns.Session
+		if err := os.MkdirAll(target, 0o755); err != nil {
+			return nil, err
+		}
+		if err := bind("/oldroot"+ns.Source, target); err != nil {
+			return nil, fmt.Errorf("binding session dir: %w", err)
+		}
+		failed = ns.bindMounts()
+	}
+
+	if err := os.Chdir("/newroot"); err != nil {
+		return nil, err
+	}
+	// pivot_root(".", ".") stacks the scratch root on top of the new one;
+	// detaching it leaves the new root and drops /oldroot with it.
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return nil, fmt.Errorf("pivot_root into session root: %w", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return nil, fmt.Errorf("detaching scratch root: %w", err)
+	}
+	return failed, os.Chdir("/")
+}
+
+// hidePaths covers up each of paths that exists, skipping those inside one
+// already hidden. Without a root of its own (see build), the launcher's
+// mount namespace is first made a slave, so nothing propagates back.
+func hidePaths(paths []string, ownRoot bool) error {
+	if !ownRoot {
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_SLAVE, ""); err != nil {
+			return fmt.Errorf("making / a slave mount: %w", err)
+		}
+	}
+	var hidden []string
+	for _, path := range paths {
+		if underAny(path, hidden) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			err = syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=0700")
+		} else {
+			err = syscall.Mount("/dev/null", path, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("covering %s: %w", path, err)
+		}
+		hidden = append(hidden, path)
+	}
+	return nil
+}
+
+// bindMounts binds the session's mounts and enforces their modes. A
+// read-only mount is also made read-only at its host path, which the rest
+// of the host tree exposes too; writable mounts inside it are bound back.
+// A mount that can't be attached with its mode is left out and reported.
+func (ns *sessionNamespace) bindMounts() []nsFailure {
+	var failed []nsFailure
+	skip := map[string]bool{}
+	fail := func(name string, err error) {
+		failed = append(failed, nsFailure{Name: name, Error: err.Error()})
+		skip[name] = true
+	}
+	for _, o := range hostOverrides(ns.Binds) {
+		target := "/newroot" + o.Source
+		err := bind("/oldroot"+o.Source, target)
+		if err == nil && o.ReadOnly {
+			if err = remountReadOnly(target); err != nil {
+				_ = syscall.Unmount(target, syscall.MNT_DETACH)
+			}
+		}
+		if err != nil {
+			fail(o.Name, err)
+		}
+	}
+
+	// Parents before children: a nested mount's target is created inside
+	// its parent's bind, so nothing is made read-only until all are bound.
+	binds := append([]nsBind(nil), ns.Binds...)
+	sort.Slice(binds, func(i, j int) bool { return binds[i].Target < binds[j].Target })
+	var bound []nsBind
+	for _, b := range binds {
+		if skip[b.Name] {
+			continue
+		}
+		target := "/newroot" + b.Target
+		err := os.MkdirAll(target, 0o755)
+		if err == nil {
+			err = bind("/oldroot"+b.Source, target)
+		}
+		if err != nil {
+			fail(b.Name, err)
+			continue
+		}
+		bound = append(bound, b)
+	}
+	var targets []string
+	for _, b := range bound {
+		targets = append(targets, "/newroot"+b.Target)
+	}
+	for _, b := range bound {
+		if !b.ReadOnly {
+			continue
+		}
+		target := "/newroot" + b.Target
+		if err := remountReadOnly(target, targets...); err != nil {
+			_ = syscall.Unmount(target, syscall.MNT_DETACH)
+			fail(b.Name, err)
+		}
+	}
+	return failed
+}
+
+// hostOverrides returns the host paths to rebind in the new root: the source
+// of every read-only mount, and the source of every writable mount inside
+// one of those, parents first.
+func hostOverrides(binds []nsBind) []nsBind {
+	var out []nsBind
+	seen := map[string]bool{}
+	for _, b := range binds {
+		under := b.ReadOnly
+		for _, ro := range binds {
+			if ro.ReadOnly && strings.HasPrefix(b.Source, ro.Source+"/") {
+				under = true
+			}
+		}
+		if under && !seen[b.Source] {
+			seen[b.Source] = true
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Source < out[j].Source })
+	return out
+}
+
+// remountReadOnly makes the bind mount at path, and everything mounted below
+// it except the session's own nested binds in keep, read-only. A remount
+// must repeat the flags the kernel locked when the
+// host mounts were copied into the user namespace (nosuid, nodev, noexec,
+// atime), or it fails with EPERM.
+func remountReadOnly(path string, keep ...string) error {
+	mounts, err := mountsUnder(path)
+	if err != nil {
+		return err
+	}
+	var nested []string
+	for _, k := range keep {
+		if strings.HasPrefix(k, path+"/") {
+			nested = append(nested, k)
+		}
+	}
+	for _, m := range mounts {
+		if underAny(m, nested) {
+			continue
+		}
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(m, &st); err != nil {
+			return fmt.Errorf("statfs %s: %w", m, err)
+		}
+		flags := uintptr(syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_RDONLY)
+		for _, f := range []struct{ st, ms uintptr }{
+			{stNosuid, syscall.MS_NOSUID},
+			{stNodev, syscall.MS_NODEV},
+			{stNoexec, syscall.MS_NOEXEC},
+			{stNoatime, syscall.MS_NOATIME},
+			{stNodiratime, syscall.MS_NODIRATIME},
+			{stRelatime, syscall.MS_RELATIME},
+		} {
+			if uintptr(st.Flags)&f.st != 0 {
+				flags |= f.ms
+			}
+		}
+		if err := syscall.Mount("", m, "", flags, ""); err != nil {
+			return fmt.Errorf("remount %s read-only: %w", strings.TrimPrefix(m, "/newroot"), err)
+		}
+	}
+	return nil
+}
+
+// underAny reports whether path is one of dirs or below one of them.
+func underAny(path string, dirs []string) bool {
+	for _, d := range dirs {
+		if path == d || strings.HasPrefix(path, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// statfs f_flags (ST_*), which differ from the MS_* mount flags.
+const (
+	stNosuid     = 0x2
+	stNodev      = 0x4
+	stNoexec     = 0x8
+	stNoatime    = 0x400
+	stNodiratime = 0x800
+	stRelatime   = 0x1000
+)
+
+// mountsUnder lists path and every mount point below it, from
+// /proc/self/mountinfo.
+func mountsUnder(path string) ([]string, error) {
+	// Only called between the two pivots, while the host's /proc sits
+	// under /oldroot.
+	data, err := os.ReadFile("/oldroot/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	mounts := []string{path}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		if mp := unescapeMountinfo(fields[4]); strings.HasPrefix(mp, path+"/") {
+			mounts = append(mounts, mp)
+		}
+	}
+	return mounts, nil
+}
+
+// unescapeMountinfo decodes the octal escapes (\040 for a space) mountinfo
+// uses in paths.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// bindHostRoot recreates every top-level entry of oldRoot in newRoot:
+// directories and files are bound (with everything mounted below them),
+// symlinks such as /bin → usr/bin are copied. A host /sessions is skipped;
+// the session provides its own.
+func bindHostRoot(oldRoot, newRoot string) error {
+	entries, err := os.ReadDir(oldRoot)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == "sessions" {
+			continue
+		}
+		src, dst := filepath.Join(oldRoot, e.Name()), filepath.Join(newRoot, e.Name())
+		switch {
+		case e.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, dst); err != nil {
+				return err
+			}
+			continue
+		case e.IsDir():
+			if err := os.Mkdir(dst, 0o755); err != nil {
+				return err
+			}
+		default:
+			f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY, 0o644)
+			if err != nil {
+				return err
+			}
+			_ = f.Close()
+		}
+		if err := bind(src, dst); err != nil {
+			return fmt.Errorf("binding /%s: %w", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+func bind(src, dst string) error {
+	if err := syscall.Mount(src, dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", strings.TrimPrefix(src, "/oldroot"), err)
+	}
+	return nil
+}
+
+// nsBase is the directory session launchers mount their scratch tmpfs on.
+func nsBase(home string) string {
+	return filepath.Join(home, ".local", "share", "claude-cowork", "ns-root")
+}
+
+// sessionView maps a namespaced session's VM paths back to the host, for
+// readFile and the present_files interception.
+type sessionView struct {
+	dir    string            // real session dir, seen as /sessions/<name>
+	mounts map[string]string // mount name → host path
+}
+
+// hostPath translates a path inside the session (/sessions/<name>/...) to
+// the host path it is bound from. The longest mount wins, so nested mounts
+// resolve to their own source.
+func (v sessionView) hostPath(session, path string) (string, bool) {
+	prefix := "/sessions/" + session
+	if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+		return "", false
+	}
+	rest := path[len(prefix):]
+	best, host := "", ""
+	for name, src := range v.mounts {
+		p := "/mnt/" + name
+		if (rest == p || strings.HasPrefix(rest, p+"/")) && len(p) > len(best) {
+			best, host = p, src
+		}
+	}
+	if best != "" {
+		return host + rest[len(best):], true
+	}
+	return v.dir + rest, true
+}
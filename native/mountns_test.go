@@ -0,0 +1,279 @@
+package native
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/sessions"
+	"github.com/patrickjaja/claude-cowork-service/transcript"
+)
+
+func TestSessionViewHostPath(t *testing.T) {
+	view := sessionView{dir: "/state/s", mounts: map[string]string{
+		".claude":        "/home/u/.claude",
+		".claude/skills": "/opt/skills",
+		"work":           "/home/u/work",
+	}}
+	for _, tc := range []struct {
+		path, want string
+		ok         bool
+	}{
+		{"/sessions/s", "/state/s", true},
+		{"/sessions/s/notes.md", "/state/s/notes.md", true},
+		{"/sessions/s/mnt/work", "/home/u/work", true},
+		{"/sessions/s/mnt/work/a/b.txt", "/home/u/work/a/b.txt", true},
+		{"/sessions/s/mnt/workbench/x", "/state/s/mnt/workbench/x", true},
+		{"/sessions/s/mnt/.claude/settings.json", "/home/u/.claude/settings.json", true},
+		{"/sessions/s/mnt/.claude/skills/pdf/SKILL.md", "/opt/skills/pdf/SKILL.md", true},
+		{"/sessions/other/mnt/work", "", false},
+		{"/home/u/work", "", false},
+	} {
+		got, ok := view.hostPath("s", tc.path)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("hostPath(%q) = %q, %v; want %q, %v", tc.path, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestHostOverrides(t *testing.T) {
+	got := hostOverrides([]nsBind{
+		{Name: "work", Source: "/home/u/work"},
+		{Name: "lib", Source: "/opt/lib", ReadOnly: true},
+		{Name: "out", Source: "/opt/lib/out"},
+		{Name: "ref", Source: "/home/u/ref", ReadOnly: true},
+		{Name: "lib2", Source: "/opt/lib", ReadOnly: true},
+		{Name: "libx", Source: "/opt/libx"},
+	})
+	var sources []string
+	for _, b := range got {
+		sources = append(sources, fmt.Sprintf("%s:%v", b.Source, b.ReadOnly))
+	}
+	want := "/home/u/ref:true /opt/lib:true /opt/lib/out:false"
+	if strings.Join(sources, " ") != want {
+		t.Fatalf("hostOverrides = %v, want %s", sources, want)
+	}
+}
+
+func TestUnescapeMountinfo(t *testing.T) {
+	for in, want := range map[string]string{
+		"/home/u/work":            "/home/u/work",
+		`/home/u/My\040Documents`: "/home/u/My Documents",
+		`/a\011b\134c`:            "/a\tb\\c",
+	} {
+		if got := unescapeMountinfo(in); got != want {
+			t.Errorf("unescapeMountinfo(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestResumeInNamespaceCopiesTranscript(t *testing.T) {
+	home := t.TempDir()
+	cfg := filepath.Join(home, "cfg")
+	hostSlug := transcript.Slugify(filepath.Join(home, "work"))
+	writeTranscript(t, cfg, hostSlug, "sess-1")
+	mounts := map[string]pipe.MountSpec{".claude": {Path: strings.TrimPrefix(cfg, "/")}}
+	cwd := "/sessions/s/mnt/work"
+
+	resumeInNamespace(home, cwd, []string{"--resume", "sess-1"}, map[string]string{}, mounts, "s", sessionView{})
+	if _, err := os.Stat(filepath.Join(cfg, "projects", transcript.Slugify(cwd), "sess-1.jsonl")); err != nil {
+		t.Fatalf("transcript not copied under the namespace cwd's slug: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg, "projects", hostSlug, "sess-1.jsonl")); err != nil {
+		t.Fatalf("original transcript gone: %v", err)
+	}
+}
+
+// TestSpawnInMountNamespace runs a session through the launcher: the CLI
+// must see the VM layout with real directories, keep its VM cwd, and get
+// stdin unrewritten, while the host gets no /sessions entry.
+func TestSpawnInMountNamespace(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	b := NewBackend(false)
+	t.Cleanup(b.Shutdown)
+	if err := b.EnableMountNamespace(); err != nil {
+		t.Skipf("no mount namespaces here: %v", err)
+	}
+	removeSessionLink(t, "ns-e2e")
+
+	mounts, paths := makeWorkspaceMounts(t, home, "work", "outputs", "skills")
+	if err := os.WriteFile(filepath.Join(paths["work"], "hello.txt"), []byte("hello from the host\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(paths["skills"], "SKILL.md"), []byte("skill\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mounts["work/.skills"] = mounts["skills"]
+	delete(mounts, "skills")
+
+	events := newEventRecorder()
+	unsubscribe, _ := b.SubscribeEvents("", events.emit)
+	defer unsubscribe()
+
+	const mnt = "/sessions/ns-e2e/mnt"
+	script := strings.Join([]string{
+		`echo "cwd=$(pwd)"`,
+		`echo "uid=$(id -u)"`,
+		`echo "capeff=$(awk '/^CapEff/ {print $2}' /proc/self/status)"`,
+		`[ -d ` + mnt + `/work ] && [ ! -L ` + mnt + `/work ] && echo "work=bound"`,
+		`cat ` + mnt + `/work/hello.txt`,
+		`cat ` + mnt + `/work/.skills/SKILL.md`,
+		`echo written > ` + mnt + `/outputs/result.txt`,
+		`read line; echo "stdin=$line"`,
+	}, "\n")
+	id, failed, err := b.Spawn("ns-e2e", "", "/bin/sh", []string{"-c", script}, map[string]string{}, mnt+"/work", mounts, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.Kill(id, "SIGKILL") })
+	if len(failed) != 0 {
+		t.Fatalf("failedMounts = %v", failed)
+	}
+
+	events.stdoutLine(t, id, "cwd="+mnt+"/work")
+	events.stdoutLine(t, id, fmt.Sprintf("uid=%d", os.Getuid()))
+	if caps, _ := events.stdoutLine(t, id, "capeff="); os.Getuid() != 0 && !strings.Contains(caps, "capeff=0000000000000000") {
+		t.Fatalf("CLI kept capabilities: %s", caps)
+	}
+	events.stdoutLine(t, id, "work=bound")
+	events.stdoutLine(t, id, "hello from the host")
+	events.stdoutLine(t, id, "skill")
+	if err := b.WriteStdin(id, []byte(mnt+"/outputs/result.txt\n")); err != nil {
+		t.Fatal(err)
+	}
+	events.stdoutLine(t, id, "stdin="+mnt+"/outputs/result.txt")
+	if ev := events.exit(t, id); ev.ExitCode != 0 {
+		t.Fatalf("exit = %+v", ev)
+	}
+
+	data, err := b.ReadFile("", mnt+"/outputs/result.txt")
+	if err != nil || string(data) != "written\n" {
+		t.Fatalf("readFile through the session view = %q, %v", data, err)
+	}
+	if _, err := os.Lstat("/sessions/ns-e2e"); err == nil {
+		t.Fatal("namespaced spawn created /sessions/ns-e2e on the host")
+	}
+}
+
+// TestMountModesInNamespace checks that an ro mount is read-only both at its
+// /sessions path and at its host path, that an rw mount nested inside it
+// stays writable, and that an ro mount of the home directory is reported
+// instead of silently granted.
+func TestMountModesInNamespace(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	b := NewBackend(false)
+	t.Cleanup(b.Shutdown)
+	if err := b.EnableMountNamespace(); err != nil {
+		t.Skipf("no mount namespaces here: %v", err)
+	}
+	removeSessionLink(t, "ns-modes")
+
+	mounts, paths := makeWorkspaceMounts(t, home, "lib", "work")
+	out := filepath.Join(paths["lib"], "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mounts["lib"] = pipe.MountSpec{Path: mounts["lib"].Path, Mode: "ro"}
+	mounts["out"] = pipe.MountSpec{Path: strings.TrimPrefix(out, "/"), Mode: "rw"}
+	mounts["home"] = pipe.MountSpec{Path: strings.TrimPrefix(home, "/"), Mode: "ro"}
+
+	events := newEventRecorder()
+	unsubscribe, _ := b.SubscribeEvents("", events.emit)
+	defer unsubscribe()
+
+	const mnt = "/sessions/ns-modes/mnt"
+	try := func(label, path string) string {
+		return `if echo x > ` + path + ` 2>/dev/null; then echo "` + label + `=rw"; else echo "` + label + `=ro"; fi`
+	}
+	script := strings.Join([]string{
+		try("lib", mnt+"/lib/a.txt"),
+		try("libhost", paths["lib"]+"/b.txt"),
+		try("out", mnt+"/out/c.txt"),
+		try("outhost", out+"/d.txt"),
+		try("work", mnt+"/work/e.txt"),
+	}, "\n")
+	id, failed, err := b.Spawn("ns-modes", "", "/bin/sh", []string{"-c", script}, map[string]string{}, mnt+"/work", mounts, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.Kill(id, "SIGKILL") })
+	if len(failed) != 1 || failed[0] != "home" {
+		t.Fatalf("failedMounts = %v, want [home]", failed)
+	}
+
+	for _, line := range []string{"lib=ro", "libhost=ro", "out=rw", "outhost=rw", "work=rw"} {
+		events.stdoutLine(t, id, line)
+	}
+	if ev := events.exit(t, id); ev.ExitCode != 0 {
+		t.Fatalf("exit = %+v", ev)
+	}
+	if _, err := os.Stat(filepath.Join(paths["lib"], "a.txt")); err == nil {
+		t.Fatal("write through the ro mount reached the host")
+	}
+}
+
+// TestReadOnlyMountWithoutEnforcement checks that with path remapping and no
+// sandbox an ro mount is reported in failedMounts and not linked into the
+// session, where it would be writable.
+func TestReadOnlyMountWithoutEnforcement(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	b := NewBackend(false)
+	t.Cleanup(b.Shutdown)
+	removeSessionLink(t, "ro-remap")
+
+	mounts, _ := makeWorkspaceMounts(t, home, "lib", "work")
+	mounts["lib"] = pipe.MountSpec{Path: mounts["lib"].Path, Mode: "ro"}
+	id, failed, err := b.Spawn("ro-remap", "", "/bin/true", nil, map[string]string{}, "", mounts, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.Kill(id, "SIGKILL") })
+	if len(failed) != 1 || failed[0] != "lib" {
+		t.Fatalf("failedMounts = %v, want [lib]", failed)
+	}
+	mnt := filepath.Join(sessions.Dir(home, "ro-remap"), "mnt")
+	if _, err := os.Lstat(filepath.Join(mnt, "lib")); err == nil {
+		t.Fatal("ro mount linked into the session")
+	}
+	if _, err := os.Lstat(filepath.Join(mnt, "work")); err != nil {
+		t.Fatalf("rw mount not linked: %v", err)
+	}
+}
+
+// TestNamespacedCLIHasNoCapabilities spawns into a session namespace
+// repeatedly and checks that the CLI never keeps the launcher's ambient
+// CAP_SYS_ADMIN, which would let it undo its read-only binds. As root only
+// the ambient set can be checked.
+func TestNamespacedCLIHasNoCapabilities(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	b := NewBackend(false)
+	t.Cleanup(b.Shutdown)
+	if err := b.EnableMountNamespace(); err != nil {
+		t.Skipf("no mount namespaces here: %v", err)
+	}
+	removeSessionLink(t, "ns-caps")
+	events := newEventRecorder()
+	unsubscribe, _ := b.SubscribeEvents("", events.emit)
+	defer unsubscribe()
+
+	script := `awk '/^Cap(Amb|Eff)/ {printf "%s%s ", $1, $2} END {print ""}' /proc/self/status`
+	for i := 0; i < 20; i++ {
+		id, _, err := b.Spawn("ns-caps", "", "/bin/sh", []string{"-c", script}, map[string]string{}, "/sessions/ns-caps", nil, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		caps, _ := events.stdoutLine(t, id, "CapAmb:0000000000000000")
+		// Root keeps its capabilities across exec regardless.
+		if os.Getuid() != 0 && !strings.Contains(caps, "CapEff:0000000000000000") {
+			t.Fatalf("spawn %d: CLI kept capabilities: %s", i, caps)
+		}
+		events.exit(t, id)
+	}
+}
@@ -4,19 +4,23 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/patrickjaja/claude-cowork-service/logx"
+	"github.com/patrickjaja/claude-cowork-service/pipe"
 	"github.com/patrickjaja/claude-cowork-service/process"
 )
 
@@ -29,17 +33,23 @@ type pathRemap struct {
 // localProcess tracks a single spawned host process.
 type localProcess struct {
 	id                string
+	command           string // resolved binary path, for admin listings
+	startedAt         time.Time
 	cmd               *exec.Cmd
-	stdin             io.WriteCloser
+	stdin             *stdinQueue
 	done              chan struct{}
 	exitCode          int
 	mu                sync.Mutex
-	vmPrefix          []byte      // e.g. "/sessions/optimistic-nice-brahmagupta"
-	realPrefix        []byte      // e.g. "/home/user/.local/share/claude-cowork/sessions/optimistic-nice-brahmagupta"
-	reverseMap        bool        // only reverse-map output if VM path exists on filesystem
-	mountRemap        []pathRemap // fwd: session/mnt/<mount> → real host path (for stdin)
-	reverseMountRemap []pathRemap // rev: real host path → VM /sessions/<name>/mnt/<mount> (for stdout)
-	isDispatch        bool        // dispatch/agent session (CLAUDE_CODE_BRIEF=1): user is on a remote client
+	vmPrefix          []byte          // e.g. "/sessions/optimistic-nice-brahmagupta"
+	realPrefix        []byte          // e.g. "/home/user/.local/share/claude-cowork/sessions/optimistic-nice-brahmagupta"
+	reverseMap        bool            // only reverse-map output if VM path exists on filesystem
+	mountRemap        []pathRemap     // fwd: session/mnt/<mount> → real host path (for stdin)
+	reverseMountRemap []pathRemap     // rev: real host path → VM /sessions/<name>/mnt/<mount> (for stdout)
+	isDispatch        bool            // dispatch/agent session (CLAUDE_CODE_BRIEF=1): user is on a remote client
+	namespaced        bool            // runs in a session namespace: sees VM paths, so stdin/stdout are not rewritten
+	sandbox           *SandboxPaths   // Landlock allowlist, nil when not sandboxed
+	sandboxDenied     map[string]bool // paths already reported as denied (guarded by mu)
+	cgroup            *processCgroup  // the process's own cgroup, nil when cgroups are off
 }
 
 // processTracker manages all spawned processes and streams their output via event callbacks.
@@ -59,8 +69,28 @@ func newProcessTracker(emit func(event interface{}), debug bool) *processTracker
 	}
 }
 
-// spawn starts a new process and streams its stdout/stderr via events.
-func (pt *processTracker) spawn(id string, cmd string, args []string, env map[string]string, cwd string, vmPrefix string, realPrefix string, mountRemap []pathRemap, reverseMountRemap []pathRemap) (string, error) {
+// spawnOptions says how a spawned process is isolated and how paths in its
+// I/O are translated. The zero value runs it on the host as is.
+type spawnOptions struct {
+	mountRemap        []pathRemap // fwd: session/mnt/<mount> → real host path (for stdin)
+	reverseMountRemap []pathRemap // rev: real host path → VM /sessions/<name>/mnt/<mount> (for stdout)
+
+	// With ns set, the process runs through the session launcher. With
+	// sandbox set, the launcher confines it to those paths plus the CLI's
+	// install dir, and hide lists what it covers up beforehand. With
+	// network set, its HTTP(S) traffic goes through the egress proxy. With
+	// cgroup set, it starts in a cgroup of its own within the session's.
+	ns      *sessionNamespace
+	sandbox *SandboxPaths
+	hide    []string
+	network *sessionNetwork
+	cgroup  *sessionCgroup
+}
+
+// spawn starts a new process and streams its stdout/stderr via events,
+// isolated as opts says. When it runs in a session namespace, the names of
+// mounts it could not bind are returned.
+func (pt *processTracker) spawn(id string, cmd string, args []string, env map[string]string, cwd string, vmPrefix string, realPrefix string, opts spawnOptions) (string, []string, error) {
 	if id == "" {
 		pt.mu.Lock()
 		pt.nextID++
@@ -122,8 +152,32 @@ func (pt *processTracker) spawn(id string, cmd string, args []string, env map[st
 		}
 	}
 
+	launch := opts.ns
+	if opts.sandbox != nil {
+		opts.sandbox.ReadOnly = append(opts.sandbox.ReadOnly, cliInstallDir(cmd))
+		if launch == nil {
+			launch = &sessionNamespace{}
+		}
+		launch.Sandbox = opts.sandbox
+		launch.Hide = opts.hide
+	}
+	if opts.network != nil && opts.network.isolate {
+		if launch == nil {
+			launch = &sessionNamespace{}
+		}
+		launch.Network = true
+	}
+
 	c := exec.Command(cmd, args...)
-	if cwd != "" {
+	if launch != nil {
+		// In a namespace, cwd is a /sessions/<name> path that only exists
+		// there; the launcher changes into it.
+		launch.Cwd = cwd
+		var err error
+		if c, err = launcherCommand(launch, cmd, args); err != nil {
+			return "", nil, err
+		}
+	} else if cwd != "" {
 		c.Dir = cwd
 	}
 	if len(env) > 0 {
@@ -150,54 +204,159 @@ func (pt *processTracker) spawn(id string, cmd string, args []string, env map[st
 		}
 	}
 
+	// The proxy listens on the host's loopback, or, in a network namespace,
+	// on the namespace's own, from where the launcher hands it over.
+	var proxy net.Listener
+	var netSock, launcherSock *os.File
+	if opts.network != nil {
+		proxyAddr := nsProxyAddr
+		if opts.network.isolate {
+			var err error
+			if netSock, launcherSock, err = networkSocketpair(); err != nil {
+				return "", nil, fmt.Errorf("creating egress socket: %w", err)
+			}
+			defer func() { _ = netSock.Close() }()
+			c.ExtraFiles = []*os.File{launcherSock}
+		} else {
+			var err error
+			if proxy, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				return "", nil, fmt.Errorf("starting egress proxy: %w", err)
+			}
+			proxyAddr = proxy.Addr().String()
+		}
+		c.Env = proxyEnv(c.Env, "http://"+proxyAddr)
+	}
+	// release frees what the spawn holds besides the process itself.
+	release := func() {
+		if proxy != nil {
+			_ = proxy.Close()
+		}
+	}
+
 	// Set up process group so we can kill children too
-	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+
+	// The process starts inside its cgroup, so nothing it forks can slip
+	// out before it is moved there.
+	var cg *processCgroup
+	if opts.cgroup != nil {
+		var err error
+		cg, err = opts.cgroup.tree.create(opts.cgroup.session, id, opts.cgroup.limits)
+		var dir *os.File
+		if err == nil {
+			dir, err = cg.open()
+		}
+		if err != nil {
+			release()
+			return "", nil, fmt.Errorf("creating session cgroup: %w", err)
+		}
+		defer func() { _ = dir.Close() }()
+		c.SysProcAttr.UseCgroupFD = true
+		c.SysProcAttr.CgroupFD = int(dir.Fd())
+		// Killed children leave the cgroup a moment after the process is
+		// reaped, so it is removed once they are gone.
+		inner := release
+		release = func() {
+			inner()
+			go cg.remove(5 * time.Second)
+		}
+	}
 
 	stdin, err := c.StdinPipe()
 	if err != nil {
-		return "", fmt.Errorf("creating stdin pipe: %w", err)
+		release()
+		return "", nil, fmt.Errorf("creating stdin pipe: %w", err)
 	}
 
 	stdout, err := c.StdoutPipe()
 	if err != nil {
-		return "", fmt.Errorf("creating stdout pipe: %w", err)
+		release()
+		return "", nil, fmt.Errorf("creating stdout pipe: %w", err)
 	}
 
 	stderr, err := c.StderrPipe()
 	if err != nil {
-		return "", fmt.Errorf("creating stderr pipe: %w", err)
+		release()
+		return "", nil, fmt.Errorf("creating stderr pipe: %w", err)
 	}
 
-	if err := c.Start(); err != nil {
+	var failedMounts []string
+	if launch != nil {
+		status, err := startLauncher(c)
+		if launcherSock != nil {
+			_ = launcherSock.Close()
+		}
+		if err == nil && status.Error != "" {
+			_ = c.Wait()
+			err = errors.New(status.Error)
+		}
+		if err == nil && netSock != nil {
+			if proxy, err = receiveListener(netSock); err != nil {
+				_ = c.Process.Kill()
+				_ = c.Wait()
+				err = fmt.Errorf("egress proxy: %w", err)
+			}
+		}
+		if err != nil {
+			release()
+			pt.emit(process.NewErrorEvent(id, fmt.Sprintf("failed to start process: %v", err), true))
+			return "", nil, fmt.Errorf("starting process: %w", err)
+		}
+		for _, f := range status.FailedMounts {
+			log.Printf("[native] mount %s failed: %s", f.Name, f.Error)
+			failedMounts = append(failedMounts, f.Name)
+		}
+	} else if err := c.Start(); err != nil {
+		release()
 		pt.emit(process.NewErrorEvent(id, fmt.Sprintf("failed to start process: %v", err), true))
-		return "", fmt.Errorf("starting process: %w", err)
+		return "", nil, fmt.Errorf("starting process: %w", err)
 	}
 
 	lp := &localProcess{
 		id:                id,
+		command:           cmd,
+		startedAt:         time.Now(),
 		cmd:               c,
-		stdin:             stdin,
 		done:              make(chan struct{}),
-		mountRemap:        mountRemap,
-		reverseMountRemap: reverseMountRemap,
+		mountRemap:        opts.mountRemap,
+		reverseMountRemap: opts.reverseMountRemap,
 		isDispatch:        env["CLAUDE_CODE_BRIEF"] == "1",
+		namespaced:        opts.ns != nil,
+		sandbox:           opts.sandbox,
+		cgroup:            cg,
 	}
+	lp.stdin = newStdinQueue(id, stdin, lp.done)
 	if vmPrefix != "" && realPrefix != "" {
 		lp.vmPrefix = []byte(vmPrefix)
 		lp.realPrefix = []byte(realPrefix)
 		// Only reverse-map output if the VM path exists on the filesystem.
 		// Without root, /sessions/<name> can't be created, so reverse-mapping
-		// would produce paths the model can't access for tool calls.
-		if _, err := os.Stat(vmPrefix); err == nil {
-			lp.reverseMap = true
-		} else if pt.debug {
-			log.Printf("[native] VM path %s not accessible, disabling output reverse-mapping", vmPrefix)
+		// would produce paths the model can't access for tool calls. In a
+		// session namespace the CLI already prints VM paths.
+		if !lp.namespaced {
+			if _, err := os.Stat(vmPrefix); err == nil {
+				lp.reverseMap = true
+			} else if pt.debug {
+				log.Printf("[native] VM path %s not accessible, disabling output reverse-mapping", vmPrefix)
+			}
 		}
 	}
 
 	pt.mu.Lock()
 	pt.processes[id] = lp
 	pt.mu.Unlock()
+	pipe.ProcessesSpawned.With("native").Inc()
+	pipe.ProcessesLive.With("native").Inc()
+
+	if proxy != nil {
+		go func() {
+			if err := opts.network.proxy.Serve(proxy, opts.network.session, opts.network.allow); err != nil {
+				log.Printf("[native] %s egress proxy: %v", id, err)
+			}
+		}()
+	}
 
 	if pt.debug {
 		log.Printf("[native] spawned %s: %s %v (pid=%d)", id, cmd, args, c.Process.Pid)
@@ -250,16 +409,26 @@ func (pt *processTracker) spawn(id string, cmd string, args []string, env map[st
 			}
 		}
 
+		oomKills := 0
+		if cg != nil {
+			if oomKills = cg.oomKills(); oomKills > 0 {
+				log.Printf("[native] %s: OOM killer killed %d process(es) in %s", id, oomKills, cg.path)
+			}
+		}
+
 		lp.exitCode = code
+		release()
+		pipe.ProcessesLive.With("native").Dec()
+		ev := process.NewExitEvent(id, code)
 		if sig != "" {
-			pt.emit(process.NewExitEventWithSignal(id, code, sig))
-		} else {
-			pt.emit(process.NewExitEvent(id, code))
+			ev = process.NewExitEventWithSignal(id, code, sig)
 		}
+		ev.OOMKillCount = oomKills
+		pt.emit(ev)
 		close(lp.done)
 	}()
 
-	return id, nil
+	return id, failedMounts, nil
 }
 
 // streamOutput reads lines from a reader and emits events.
@@ -281,6 +450,12 @@ func (pt *processTracker) streamOutput(id string, r io.Reader, stream string) {
 	for scanner.Scan() {
 		line := scanner.Text() + "\n"
 
+		// Check for sandbox denials before remapping: the paths outside
+		// the allowlist are host paths.
+		if lp != nil && lp.sandbox != nil {
+			pt.reportSandboxDenials(lp, line)
+		}
+
 		// Remap real paths → VM paths in output (only when /sessions/ is accessible).
 		// Without this guard, native Linux (no root) would produce /sessions/ paths
 		// that don't exist, causing the model's subsequent bash commands to fail.
@@ -484,11 +659,9 @@ func (pt *processTracker) tryHandlePresentFiles(lp *localProcess, line string) b
 	}
 	respBytes = append(respBytes, '\n')
 
-	// Write response directly to CLI's stdin
-	lp.mu.Lock()
-	_, writeErr := lp.stdin.Write(respBytes)
-	lp.mu.Unlock()
-	if writeErr != nil {
+	// Queue the response on the CLI's stdin behind anything Desktop already
+	// sent. It bypasses the queue limit: dropping it would hang the tool call.
+	if writeErr := lp.stdin.push(respBytes, true); writeErr != nil {
 		log.Printf("[native] present_files: failed to write response: %v", writeErr)
 		return false
 	}
@@ -509,7 +682,7 @@ func (pt *processTracker) kill(processID string, signal string) error {
 	pt.mu.RUnlock()
 
 	if !ok {
-		return fmt.Errorf("process %s not found", processID)
+		return fmt.Errorf("%w: %s", pipe.ErrProcessNotFound, processID)
 	}
 
 	if lp.cmd.Process == nil {
@@ -529,13 +702,28 @@ func (pt *processTracker) kill(processID string, signal string) error {
 		select {
 		case <-lp.done:
 			log.Printf("[native] graceful drain: %s exited cleanly after SIGINT", processID)
+			// Whatever it started may still be running in its cgroup.
+			if lp.cgroup != nil && lp.cgroup.populated() {
+				lp.cgroup.signal(sig)
+				go lp.cgroup.remove(5 * time.Second)
+			}
 			return nil
 		case <-time.After(3 * time.Second):
 			log.Printf("[native] graceful drain: %s did not exit in 3s, escalating to %s", processID, signalName(sig))
 		}
 	}
 
-	// Kill the entire process group
+	// Kill the entire cgroup, which also holds children that left the
+	// process group, or else the process group.
+	if lp.cgroup != nil {
+		lp.cgroup.signal(sig)
+		select {
+		case <-lp.done:
+			go lp.cgroup.remove(5 * time.Second)
+		default:
+		}
+		return nil
+	}
 	pgid, err := syscall.Getpgid(lp.cmd.Process.Pid)
 	if err == nil {
 		_ = syscall.Kill(-pgid, sig)
@@ -602,19 +790,23 @@ func (pt *processTracker) writeStdin(processID string, data []byte) error {
 	pt.mu.RUnlock()
 
 	if !ok {
-		return fmt.Errorf("process %s not found", processID)
+		return fmt.Errorf("%w: %s", pipe.ErrProcessNotFound, processID)
 	}
 
-	// Remap VM paths to real paths in stdin data
-	if lp.vmPrefix != nil {
-		data = bytes.ReplaceAll(data, lp.vmPrefix, lp.realPrefix)
-	}
+	// A namespaced CLI sees the VM paths themselves; everything else gets
+	// real paths.
+	if !lp.namespaced {
+		// Remap VM paths to real paths in stdin data
+		if lp.vmPrefix != nil {
+			data = bytes.ReplaceAll(data, lp.vmPrefix, lp.realPrefix)
+		}
 
-	// Remap session/mnt/<mount> paths to real mount targets.
-	// Glob doesn't follow directory symlinks, so the model must see
-	// the real target paths instead of symlinked mnt/ paths.
-	for _, rm := range lp.mountRemap {
-		data = bytes.ReplaceAll(data, rm.from, rm.to)
+		// Remap session/mnt/<mount> paths to real mount targets.
+		// Glob doesn't follow directory symlinks, so the model must see
+		// the real target paths instead of symlinked mnt/ paths.
+		for _, rm := range lp.mountRemap {
+			data = bytes.ReplaceAll(data, rm.from, rm.to)
+		}
 	}
 
 	// Detect MCP control_response messages from Claude Desktop.
@@ -656,28 +848,19 @@ func (pt *processTracker) writeStdin(processID string, data []byte) error {
 	// rationale): with --input-format stream-json the CLI produces no output
 	// until it receives input, so the wait always hit its 5s timeout and then
 	// wrote anyway - a fixed 5s startup delay on every session with no
-	// protection gained. Process-exit races are covered by the pre-write done
-	// check above and the <-lp.done case in the write select below; early
-	// writes while a healthy CLI initializes simply land in the pipe buffer.
-
-	// Write with timeout to avoid blocking forever if stdin buffer is full
-	type writeResult struct{ err error }
-	ch := make(chan writeResult, 1)
-	go func() {
-		lp.mu.Lock()
-		defer lp.mu.Unlock()
-		_, err := lp.stdin.Write(data)
-		ch <- writeResult{err}
-	}()
-
-	select {
-	case res := <-ch:
-		return res.err
-	case <-lp.done:
-		return fmt.Errorf("process %s exited during write", processID)
-	case <-time.After(10 * time.Second):
-		return fmt.Errorf("stdin write timeout for process %s", processID)
+	// protection gained. Early writes while a healthy CLI initializes simply
+	// wait in the stdin queue.
+
+	// The queue keeps RPC arrival order (the server dispatches writeStdin
+	// serially per connection) and never blocks; when the CLI stops reading
+	// and the queue fills, Desktop gets -32002 and retries with backoff.
+	if err := lp.stdin.push(data, false); err != nil {
+		if errors.Is(err, pipe.ErrBackpressure) {
+			pipe.StdinBackpressure.With("native").Inc()
+		}
+		return err
 	}
+	return nil
 }
 
 // isRunning checks if a tracked process is still running and returns its exit code.
@@ -711,3 +894,30 @@ func (pt *processTracker) killAll() {
 		_ = pt.kill(id, "")
 	}
 }
+
+// list snapshots every tracked process, sorted by start time.
+func (pt *processTracker) list() []pipe.ProcessInfo {
+	pt.mu.RLock()
+	procs := make([]*localProcess, 0, len(pt.processes))
+	for _, lp := range pt.processes {
+		procs = append(procs, lp)
+	}
+	pt.mu.RUnlock()
+
+	out := make([]pipe.ProcessInfo, 0, len(procs))
+	for _, lp := range procs {
+		info := pipe.ProcessInfo{ID: lp.id, Command: lp.command, StartedAt: lp.startedAt, Running: true}
+		if lp.cmd.Process != nil {
+			info.PID = lp.cmd.Process.Pid
+		}
+		select {
+		case <-lp.done:
+			info.Running = false
+			info.ExitCode = lp.exitCode
+		default:
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
@@ -0,0 +1,451 @@
+package native
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+// SandboxPaths lists the paths a sandboxed CLI may use, each with
+// everything below it.
+type SandboxPaths struct {
+	ReadOnly  []string `json:"readOnly,omitempty"`
+	ReadWrite []string `json:"readWrite,omitempty"`
+}
+
+// SandboxConfig is the sandbox allowlist file. Allow applies to every
+// session; a Workspaces entry applies to sessions with a mount at or below
+// its directory. Paths are absolute or start with "~/".
+type SandboxConfig struct {
+	Allow      SandboxPaths            `json:"allow"`
+	Workspaces map[string]SandboxPaths `json:"workspaces,omitempty"`
+}
+
+// Paths every sandboxed session gets: the system, devices and temp dirs,
+// plus /run without /run/user (see sandboxRunDirs). The home directory is
+// deliberately not among them.
+var (
+	sandboxSystemReadOnly = []string{
+		"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32",
+		"/etc", "/opt", "/nix", "/snap", "/proc", "/sys",
+	}
+	sandboxSystemReadWrite = []string{"/dev", "/tmp", "/var/tmp"}
+)
+
+// DefaultSandboxConfigPath is $XDG_CONFIG_HOME/claude-cowork/sandbox.json
+// (or ~/.config/claude-cowork/sandbox.json).
+func DefaultSandboxConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "claude-cowork", "sandbox.json")
+}
+
+// LoadSandboxConfig reads a sandbox allowlist file and expands "~/" in its
+// paths and workspace keys.
+func LoadSandboxConfig(path string) (*SandboxConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg SandboxConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	home, _ := os.UserHomeDir()
+	if err := cfg.Allow.expand(home); err != nil {
+		return nil, fmt.Errorf("%s: allow: %w", path, err)
+	}
+	workspaces := make(map[string]SandboxPaths, len(cfg.Workspaces))
+	for dir, paths := range cfg.Workspaces {
+		expanded, err := expandSandboxPath(home, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: workspaces: %w", path, err)
+		}
+		if err := paths.expand(home); err != nil {
+			return nil, fmt.Errorf("%s: workspace %s: %w", path, dir, err)
+		}
+		workspaces[expanded] = paths
+	}
+	cfg.Workspaces = workspaces
+	return &cfg, nil
+}
+
+func (p *SandboxPaths) expand(home string) error {
+	for _, list := range []*[]string{&p.ReadOnly, &p.ReadWrite} {
+		for i, path := range *list {
+			expanded, err := expandSandboxPath(home, path)
+			if err != nil {
+				return err
+			}
+			(*list)[i] = expanded
+		}
+	}
+	return nil
+}
+
+func expandSandboxPath(home, path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		path = home + path[1:]
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path must be absolute or start with ~/: %q", path)
+	}
+	return filepath.Clean(path), nil
+}
+
+func (p *SandboxPaths) add(other SandboxPaths) {
+	p.ReadOnly = append(p.ReadOnly, other.ReadOnly...)
+	p.ReadWrite = append(p.ReadWrite, other.ReadWrite...)
+}
+
+// covers reports whether path is at or below one of p's paths.
+func (p *SandboxPaths) covers(path string) bool {
+	path = filepath.Clean(path)
+	return underAny(path, p.ReadOnly) || underAny(path, p.ReadWrite)
+}
+
+// EnableSandbox makes spawns apply a Landlock ruleset to the CLI before it
+// starts, so it can only use the session dir, its mounts (read-only for
+// "ro" mounts), its own install dir, temp dirs, the system paths and what
+// cfg allows. The user's runtime dir and the daemon's sockets are hidden
+// in a mount namespace, since Landlock doesn't stop the CLI from connecting
+// to the Unix sockets there. It fails, leaving the backend unchanged, where
+// the kernel doesn't support Landlock or user namespaces are unavailable.
+// Must be called before the backend serves requests.
+func (b *Backend) EnableSandbox(cfg *SandboxConfig) error {
+	abi, err := landlockABI()
+	if err != nil {
+		return fmt.Errorf("Landlock unavailable: %w", err)
+	}
+	if err := probeLauncher(&sessionNamespace{Hide: runtimeDirs()}); err != nil {
+		return fmt.Errorf("can't hide sockets from the sandbox without user namespaces: %w", err)
+	}
+	if cfg == nil {
+		cfg = &SandboxConfig{}
+	}
+	if b.debug {
+		log.Printf("[native] Landlock ABI %d", abi)
+	}
+	b.sandbox = cfg
+	return nil
+}
+
+// sandboxFor builds the Landlock allowlist for one spawn. Mounts listed in
+// skip were not attached and get no access either. The CLI's install dir is
+// added by the tracker once it has resolved the binary.
+//
+// Landlock rights add up down the tree, so a read-only mount below a
+// writable path is writable after all; those mounts are returned as
+// exposed.
+func (b *Backend) sandboxFor(home, sessionPrefix, realSessionDir string, mounts map[string]pipe.MountSpec, env map[string]string, skip []string) (p *SandboxPaths, exposed []string) {
+	p = &SandboxPaths{
+		ReadOnly:  append(append([]string(nil), sandboxSystemReadOnly...), sandboxRunDirs()...),
+		ReadWrite: append([]string(nil), sandboxSystemReadWrite...),
+	}
+	p.ReadWrite = append(p.ReadWrite, realSessionDir, sessionPrefix)
+	tmp := env["TMPDIR"]
+	if tmp == "" {
+		tmp = os.Getenv("TMPDIR")
+	}
+	if filepath.IsAbs(tmp) {
+		p.ReadWrite = append(p.ReadWrite, tmp)
+	}
+	// Desktop points the CLI's config dir at a mount; a CLI started
+	// without one keeps its state in the home directory.
+	if dir := env["CLAUDE_CONFIG_DIR"]; dir != "" {
+		p.ReadWrite = append(p.ReadWrite, dir)
+	} else {
+		p.ReadWrite = append(p.ReadWrite, filepath.Join(home, ".claude"), filepath.Join(home, ".claude.json"))
+	}
+
+	var hostPaths []string
+	readOnly := map[string]string{}
+	for name, mount := range mounts {
+		hostPath := resolveSubpath(home, mount.Path)
+		if info, err := os.Stat(hostPath); err != nil || !info.IsDir() || contains(skip, name) {
+			continue
+		}
+		hostPaths = append(hostPaths, hostPath)
+		paths := []string{hostPath, sessionPrefix + "/mnt/" + name}
+		if mount.Mode == "ro" {
+			readOnly[name] = hostPath
+			p.ReadOnly = append(p.ReadOnly, paths...)
+		} else {
+			p.ReadWrite = append(p.ReadWrite, paths...)
+		}
+	}
+
+	p.add(b.sandbox.Allow)
+	for dir, extra := range b.sandbox.Workspaces {
+		for _, hostPath := range hostPaths {
+			if underAny(hostPath, []string{dir}) {
+				p.add(extra)
+				break
+			}
+		}
+	}
+	for name, hostPath := range readOnly {
+		if underAny(hostPath, p.ReadWrite) {
+			exposed = append(exposed, name)
+		}
+	}
+	sort.Strings(exposed)
+	return p, exposed
+}
+
+// HideFromSandbox adds paths, such as the daemon's own sockets, that
+// sandboxed CLIs must not reach. Must be called before the backend serves
+// requests.
+func (b *Backend) HideFromSandbox(paths ...string) {
+	for _, p := range paths {
+		b.sandboxHide = append(b.sandboxHide, filepath.Clean(p))
+	}
+}
+
+// sandboxHidden lists what a sandboxed spawn hides, directories ahead of
+// the paths inside them.
+func (b *Backend) sandboxHidden() []string {
+	hide := append(runtimeDirs(), b.sandboxHide...)
+	sort.Strings(hide)
+	return hide
+}
+
+// runtimeDirs is the user's runtime dir, which holds the session bus and
+// the daemon's default sockets: $XDG_RUNTIME_DIR and /run/user/<uid>.
+func runtimeDirs() []string {
+	dirs := []string{"/run/user/" + strconv.Itoa(os.Getuid())}
+	if xdg := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(xdg) && filepath.Clean(xdg) != dirs[0] {
+		dirs = append(dirs, filepath.Clean(xdg))
+	}
+	return dirs
+}
+
+// sandboxRunDirs is what the sandbox allows of /run: everything but the
+// per-user runtime dirs, so /etc/resolv.conf and friends still resolve.
+func sandboxRunDirs() []string {
+	entries, err := os.ReadDir("/run")
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, e := range entries {
+		if e.Name() != "user" {
+			dirs = append(dirs, filepath.Join("/run", e.Name()))
+		}
+	}
+	return dirs
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// cliInstallDir is the directory the sandbox opens for the CLI binary. For
+// an npm install that is the whole prefix, which also holds the node
+// binary the CLI's shebang runs.
+func cliInstallDir(cmd string) string {
+	if real, err := filepath.EvalSymlinks(cmd); err == nil {
+		cmd = real
+	}
+	if i := strings.Index(cmd, "/lib/node_modules/"); i > 0 {
+		return cmd[:i]
+	}
+	return filepath.Dir(cmd)
+}
+
+// sandboxDenialPatterns match the permission errors the CLI and its tools
+// print: Node's EACCES, coreutils/shells and Python.
+var sandboxDenialPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`EACCES: permission denied, \w+ '(/[^']*)'`),
+	regexp.MustCompile(`(/[^\s"':\\]+): Permission denied`),
+	regexp.MustCompile(`Permission denied: '(/[^']*)'`),
+}
+
+// reportSandboxDenials logs permission errors in a sandboxed process's
+// output for paths outside its allowlist and emits each path once as a
+// non-fatal error event. Landlock itself tells unprivileged processes
+// nothing about denials, so the CLI's own error messages are all there is.
+func (pt *processTracker) reportSandboxDenials(lp *localProcess, line string) {
+	if !strings.Contains(line, "ermission denied") && !strings.Contains(line, "EACCES") {
+		return
+	}
+	for _, re := range sandboxDenialPatterns {
+		for _, m := range re.FindAllStringSubmatch(line, -1) {
+			path := filepath.Clean(m[1])
+			if lp.sandbox.covers(path) {
+				continue // a plain permission error, or a write to a read-only path
+			}
+			lp.mu.Lock()
+			seen := lp.sandboxDenied[path]
+			if lp.sandboxDenied == nil {
+				lp.sandboxDenied = map[string]bool{}
+			}
+			lp.sandboxDenied[path] = true
+			lp.mu.Unlock()
+			if seen {
+				continue
+			}
+			log.Printf("[native] %s: sandbox denied access to %s", lp.id, path)
+			pt.emit(process.NewErrorEvent(lp.id, fmt.Sprintf("sandbox denied access to %s: outside the session's allowlist", path), false))
+		}
+	}
+}
+
+// Landlock's syscalls have the same numbers on every architecture.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	oPath                        = 0x200000 // O_PATH, missing from package syscall
+	landlockRulePathBeneath      = 1
+	prSetNoNewPrivs              = 38
+)
+
+// Filesystem access rights (LANDLOCK_ACCESS_FS_*).
+const (
+	llExecute uint64 = 1 << iota
+	llWriteFile
+	llReadFile
+	llReadDir
+	llRemoveDir
+	llRemoveFile
+	llMakeChar
+	llMakeDir
+	llMakeReg
+	llMakeSock
+	llMakeFifo
+	llMakeBlock
+	llMakeSym
+	llRefer    // ABI 2
+	llTruncate // ABI 3
+	llIoctlDev // ABI 5
+)
+
+const (
+	llReadOnly = llExecute | llReadFile | llReadDir
+	// Only these rights apply to a rule on a file rather than a directory.
+	llFileRights = llExecute | llWriteFile | llReadFile | llTruncate | llIoctlDev
+)
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// landlockPathBeneathAttr is packed in the kernel; the trailing padding Go
+// adds here is never read.
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// landlockABI returns the kernel's Landlock ABI version.
+func landlockABI() (int, error) {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	switch {
+	case errno == syscall.ENOSYS:
+		return 0, errors.New("kernel built without Landlock")
+	case errno == syscall.EOPNOTSUPP:
+		return 0, errors.New("Landlock disabled at boot (add it to the lsm= kernel parameter)")
+	case errno != 0:
+		return 0, errno
+	}
+	return int(abi), nil
+}
+
+// landlockHandled is every filesystem right the kernel's ABI knows, so
+// each one is denied unless a rule grants it.
+func landlockHandled(abi int) uint64 {
+	handled := llMakeSym<<1 - 1
+	if abi >= 2 {
+		handled |= llRefer
+	}
+	if abi >= 3 {
+		handled |= llTruncate
+	}
+	if abi >= 5 {
+		handled |= llIoctlDev
+	}
+	return handled
+}
+
+// restrict confines the calling thread, and everything it execs, to p. It
+// is called by the launcher right before exec, on the OS thread the exec
+// runs on. Paths that don't exist are skipped.
+func (p *SandboxPaths) restrict() error {
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+	handled := landlockHandled(abi)
+	attr := landlockRulesetAttr{handledAccessFS: handled}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("creating ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer func() { _ = syscall.Close(ruleset) }()
+
+	for _, rule := range []struct {
+		paths  []string
+		access uint64
+	}{{p.ReadOnly, llReadOnly}, {p.ReadWrite, handled}} {
+		for _, path := range rule.paths {
+			if err := landlockAllow(ruleset, path, rule.access&handled); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("setting no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("restricting self: %w", errno)
+	}
+	return nil
+}
+
+func landlockAllow(ruleset int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err == syscall.ENOENT || err == syscall.ENOTDIR {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer func() { _ = syscall.Close(fd) }()
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= llFileRights
+	}
+	attr := landlockPathBeneathAttr{allowedAccess: access, parentFd: int32(fd)}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("allowing %s: %w", path, errno)
+	}
+	return nil
+}
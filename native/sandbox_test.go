@@ -0,0 +1,353 @@
+package native
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+func TestLoadSandboxConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	path := filepath.Join(t.TempDir(), "sandbox.json")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"allow": {"readOnly": ["~/.gitconfig", "/srv/ref/"]},
+		"workspaces": {"~/src/app": {"readWrite": ["~/.cache/cargo"]}}}`)
+	cfg, err := LoadSandboxConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cfg.Allow.ReadOnly, " "); got != home+"/.gitconfig /srv/ref" {
+		t.Fatalf("allow.readOnly = %s", got)
+	}
+	if got := cfg.Workspaces[home+"/src/app"].ReadWrite; len(got) != 1 || got[0] != home+"/.cache/cargo" {
+		t.Fatalf("workspaces = %+v", cfg.Workspaces)
+	}
+
+	write(`{"allow": {"readWrite": ["relative/dir"]}}`)
+	if _, err := LoadSandboxConfig(path); err == nil || !strings.Contains(err.Error(), "relative/dir") {
+		t.Fatalf("relative path accepted: %v", err)
+	}
+}
+
+func TestSandboxForSession(t *testing.T) {
+	home := t.TempDir()
+	mounts, paths := makeWorkspaceMounts(t, home, "app", "ref", "other", "broken")
+	mounts["ref"] = pipe.MountSpec{Path: mounts["ref"].Path, Mode: "ro"}
+	b := &Backend{sandbox: &SandboxConfig{
+		Allow: SandboxPaths{ReadOnly: []string{"/srv/shared"}},
+		Workspaces: map[string]SandboxPaths{
+			paths["app"]:                   {ReadWrite: []string{"/var/cache/app"}},
+			filepath.Join(home, "nowhere"): {ReadWrite: []string{"/var/cache/nowhere"}},
+		},
+	}}
+	sessionDir := filepath.Join(home, "state", "s")
+
+	p, _ := b.sandboxFor(home, "/sessions/s", sessionDir, mounts, map[string]string{}, []string{"broken"})
+	for _, want := range []string{sessionDir, "/sessions/s", paths["app"], "/sessions/s/mnt/app", paths["other"], "/var/cache/app", filepath.Join(home, ".claude"), "/tmp"} {
+		if !contains(p.ReadWrite, want) {
+			t.Errorf("readWrite lacks %s: %v", want, p.ReadWrite)
+		}
+	}
+	for _, want := range []string{paths["ref"], "/sessions/s/mnt/ref", "/srv/shared", "/usr"} {
+		if !contains(p.ReadOnly, want) {
+			t.Errorf("readOnly lacks %s: %v", want, p.ReadOnly)
+		}
+	}
+	for _, denied := range []string{home, paths["broken"], "/var/cache/nowhere", "/run", "/run/user"} {
+		if contains(p.ReadOnly, denied) || contains(p.ReadWrite, denied) {
+			t.Errorf("sandbox allows %s", denied)
+		}
+	}
+
+	p, _ = b.sandboxFor(home, "/sessions/s", sessionDir, mounts, map[string]string{"CLAUDE_CONFIG_DIR": paths["app"] + "/.claude"}, nil)
+	if contains(p.ReadWrite, filepath.Join(home, ".claude")) {
+		t.Error("home ~/.claude allowed although CLAUDE_CONFIG_DIR points elsewhere")
+	}
+}
+
+func TestCliInstallDir(t *testing.T) {
+	prefix := t.TempDir()
+	cli := filepath.Join(prefix, "lib", "node_modules", "@anthropic-ai", "claude-code", "cli.js")
+	if err := os.MkdirAll(filepath.Dir(cli), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cli, nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(prefix, "bin", "claude")
+	if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../lib/node_modules/@anthropic-ai/claude-code/cli.js", link); err != nil {
+		t.Fatal(err)
+	}
+	if got := cliInstallDir(link); got != prefix {
+		t.Errorf("cliInstallDir(npm link) = %s, want %s", got, prefix)
+	}
+	if got := cliInstallDir("/opt/claude/versions/2.0.1"); got != "/opt/claude/versions" {
+		t.Errorf("cliInstallDir(binary) = %s", got)
+	}
+}
+
+func TestReportSandboxDenials(t *testing.T) {
+	var events []process.ErrorEvent
+	pt := newProcessTracker(func(ev interface{}) {
+		if e, ok := ev.(process.ErrorEvent); ok {
+			events = append(events, e)
+		}
+	}, false)
+	lp := &localProcess{id: "p", sandbox: &SandboxPaths{ReadOnly: []string{"/usr"}, ReadWrite: []string{"/work"}}}
+
+	for _, line := range []string{
+		`{"type":"user","content":"EACCES: permission denied, open '/home/u/.ssh/id_ed25519'"}`,
+		`cat: /home/u/.aws/credentials: Permission denied`,
+		`PermissionError: [Errno 13] Permission denied: '/home/u/.netrc'`,
+		`cat: /home/u/.aws/credentials: Permission denied`,
+		`sh: 1: cannot create /usr/local/x: Permission denied`,
+		`touch: cannot touch '/work/ro.txt': Permission denied`,
+		`rm: /work/locked: Permission denied`,
+		`no denial here`,
+	} {
+		pt.reportSandboxDenials(lp, line)
+	}
+
+	var got []string
+	for _, ev := range events {
+		if ev.Fatal || ev.ProcessID != "p" {
+			t.Errorf("event = %+v, want non-fatal for p", ev)
+		}
+		got = append(got, ev.Message)
+	}
+	want := []string{"/home/u/.ssh/id_ed25519", "/home/u/.aws/credentials", "/home/u/.netrc"}
+	if len(got) != len(want) {
+		t.Fatalf("events = %q, want one each for %v", got, want)
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Errorf("event %d = %q, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+// TestSpawnSandboxed runs a process through the launcher with a Landlock
+// allowlist: it can write where allowed, only read read-only paths, and
+// reading outside the allowlist fails and is reported as a non-fatal error.
+func TestSpawnSandboxed(t *testing.T) {
+	if _, err := landlockABI(); err != nil {
+		t.Skipf("no Landlock here: %v", err)
+	}
+	dir := t.TempDir()
+	allowed, ref, secret := filepath.Join(dir, "allowed"), filepath.Join(dir, "ref"), filepath.Join(dir, "secret")
+	for _, d := range []string{allowed, ref, secret} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(secret, "token"), []byte("s3cret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+	t.Cleanup(pt.killAll)
+	sandbox := &SandboxPaths{
+		ReadOnly:  append([]string{ref}, sandboxSystemReadOnly...),
+		ReadWrite: []string{"/dev", allowed},
+	}
+	script := strings.Join([]string{
+		`echo "cwd=$(pwd)"`,
+		`echo ok > ` + allowed + `/out.txt && echo "allowed=rw"`,
+		`ls ` + ref + ` >/dev/null && echo "ref=readable"`,
+		`echo x > ` + ref + `/w.txt 2>/dev/null || echo "ref=ro"`,
+		`cat ` + secret + `/token || echo "secret=denied"`,
+	}, "\n")
+	id, _, err := pt.spawn("", "/bin/sh", []string{"-c", script}, nil, allowed, "", "", spawnOptions{sandbox: sandbox})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The denial comes from stderr, which is read concurrently with stdout,
+	// so collect everything up to the exit before checking.
+	var denial *process.ErrorEvent
+	var out []string
+	for exited := false; !exited; {
+		select {
+		case ev := <-events:
+			switch ev := ev.(type) {
+			case process.ErrorEvent:
+				if denial == nil {
+					denial = &ev
+				}
+			case process.StdoutEvent:
+				if strings.Contains(ev.Data, "s3cret") {
+					t.Fatal("sandboxed process read a file outside its allowlist")
+				}
+				out = append(out, strings.TrimSpace(ev.Data))
+			case process.ExitEvent:
+				exited = ev.ProcessID == id
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("sandboxed process did not exit")
+		}
+	}
+	for _, want := range []string{"cwd=" + allowed, "allowed=rw", "ref=readable", "ref=ro", "secret=denied"} {
+		if !contains(out, want) {
+			t.Errorf("output lacks %q: %q", want, out)
+		}
+	}
+	if denial == nil || denial.Fatal || !strings.Contains(denial.Message, secret+"/token") {
+		t.Fatalf("denial event = %+v", denial)
+	}
+	if data, err := os.ReadFile(filepath.Join(allowed, "out.txt")); err != nil || string(data) != "ok\n" {
+		t.Fatalf("allowed write = %q, %v", data, err)
+	}
+}
+
+// TestSandboxAppliesToExecThread spawns sandboxed processes repeatedly: the
+// launcher must exec on the thread it restricted, or some of them would
+// start unconfined.
+func TestSandboxAppliesToExecThread(t *testing.T) {
+	if _, err := landlockABI(); err != nil {
+		t.Skipf("no Landlock here: %v", err)
+	}
+	dir := t.TempDir()
+	secret := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	events := newEventRecorder()
+	pt := newProcessTracker(events.emit, false)
+	t.Cleanup(pt.killAll)
+	script := `echo "nnp=$(awk '/^NoNewPrivs/ {print $2}' /proc/self/status)"; cat ` + secret + ` 2>/dev/null || echo "secret=denied"`
+	for i := 0; i < 20; i++ {
+		sandbox := &SandboxPaths{
+			ReadOnly:  append([]string{}, sandboxSystemReadOnly...),
+			ReadWrite: []string{"/dev", dir},
+		}
+		id, _, err := pt.spawn("", "/bin/sh", []string{"-c", script}, nil, dir, "", "", spawnOptions{sandbox: sandbox})
+		if err != nil {
+			t.Fatal(err)
+		}
+		events.stdoutLine(t, id, "nnp=1")
+		events.stdoutLine(t, id, "secret=denied")
+	}
+}
+
+// TestSandboxForExposedReadOnlyMount checks that a read-only mount nested in
+// a writable one is reported, since Landlock would let the CLI write to it.
+func TestSandboxForExposedReadOnlyMount(t *testing.T) {
+	home := t.TempDir()
+	mounts, paths := makeWorkspaceMounts(t, home, "app")
+	ref := filepath.Join(paths["app"], "ref")
+	if err := os.Mkdir(ref, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mounts["app/ref"] = pipe.MountSpec{Path: strings.TrimPrefix(ref, "/"), Mode: "ro"}
+	b := &Backend{sandbox: &SandboxConfig{}}
+
+	_, exposed := b.sandboxFor(home, "/sessions/s", filepath.Join(home, "state", "s"), mounts, map[string]string{}, nil)
+	if len(exposed) != 1 || exposed[0] != "app/ref" {
+		t.Fatalf("exposed = %v, want [app/ref]", exposed)
+	}
+
+	mounts["app"] = pipe.MountSpec{Path: mounts["app"].Path, Mode: "ro"}
+	_, exposed = b.sandboxFor(home, "/sessions/s", filepath.Join(home, "state", "s"), mounts, map[string]string{}, nil)
+	// The temp dir itself may sit under a writable system path such as /tmp.
+	if !underAny(home, sandboxSystemReadWrite) && len(exposed) != 0 {
+		t.Fatalf("exposed = %v with both mounts read-only", exposed)
+	}
+}
+
+// dialArg makes the test binary try to connect to each Unix socket named
+// after it and print the outcome, standing in for a CLI that does.
+const dialArg = "--dial"
+
+func dialSockets(paths []string) int {
+	for _, path := range paths {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			continue
+		}
+		_ = conn.Close()
+		fmt.Printf("%s: connected\n", path)
+	}
+	return 0
+}
+
+// TestSandboxHidesSockets checks that a sandboxed CLI can't connect to the
+// daemon's RPC socket or to sockets in the user's runtime dir, which
+// Landlock alone doesn't prevent, with and without a session namespace.
+func TestSandboxHidesSockets(t *testing.T) {
+	for _, mountNS := range []bool{false, true} {
+		t.Run(fmt.Sprintf("mountns=%v", mountNS), func(t *testing.T) {
+			testSandboxHidesSockets(t, mountNS)
+		})
+	}
+}
+
+func testSandboxHidesSockets(t *testing.T, mountNS bool) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	b := NewBackend(false)
+	t.Cleanup(b.Shutdown)
+	if mountNS {
+		if err := b.EnableMountNamespace(); err != nil {
+			t.Skipf("no mount namespaces here: %v", err)
+		}
+	}
+	if err := b.EnableSandbox(nil); err != nil {
+		t.Skipf("no sandbox here: %v", err)
+	}
+	removeSessionLink(t, "sandbox-sockets")
+
+	rpc := filepath.Join(t.TempDir(), "cowork-vm-service.sock")
+	bus := filepath.Join(runtimeDir, "bus")
+	for _, path := range []string{rpc, bus} {
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ln.Close() })
+		// The test itself still reaches them.
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+	}
+	b.HideFromSandbox(rpc)
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := newEventRecorder()
+	unsubscribe, _ := b.SubscribeEvents("", events.emit)
+	defer unsubscribe()
+	id, _, err := b.Spawn("sandbox-sockets", "", self, []string{dialArg, rpc, bus}, map[string]string{}, "", nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.Kill(id, "SIGKILL") })
+	for _, path := range []string{rpc, bus} {
+		if line, _ := events.stdoutLine(t, id, path+": "); strings.Contains(line, "connected") {
+			t.Errorf("sandboxed CLI reached %s", path)
+		}
+	}
+}
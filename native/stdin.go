@@ -0,0 +1,103 @@
+package native
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+// stdinQueueLimit bounds the bytes waiting for one process's stdin. Desktop
+// retries writeStdin on -32002 with backoff and buffers up to 16 MB itself,
+// so a few MB here is enough to absorb a CLI that is busy between turns
+// without letting a wedged one grow the daemon without bound.
+const stdinQueueLimit = 4 << 20
+
+// stdinQueue delivers writes to a process's stdin in the order they were
+// queued, from a single goroutine. Callers never block on the pipe: a full
+// queue is reported as pipe.ErrBackpressure instead.
+type stdinQueue struct {
+	id    string
+	w     io.Writer
+	limit int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	chunks [][]byte
+	queued int
+	closed bool
+}
+
+func newStdinQueue(id string, w io.Writer, done <-chan struct{}) *stdinQueue {
+	q := &stdinQueue{id: id, w: w, limit: stdinQueueLimit}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	go func() {
+		<-done
+		q.close()
+	}()
+	return q
+}
+
+// push queues data. Unless force is set, it fails with pipe.ErrBackpressure
+// when data would take the queue past its limit; a single write larger than
+// the limit is still accepted once the queue is empty, or it could never go
+// through at all.
+func (q *stdinQueue) push(data []byte, force bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return fmt.Errorf("process %s has exited", q.id)
+	}
+	if !force && q.queued > 0 && q.queued+len(data) > q.limit {
+		return pipe.ErrBackpressure
+	}
+	q.chunks = append(q.chunks, data)
+	q.queued += len(data)
+	q.cond.Signal()
+	return nil
+}
+
+func (q *stdinQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.chunks = nil
+	q.queued = 0
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+func (q *stdinQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.chunks) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		data := q.chunks[0]
+		q.chunks[0] = nil
+		q.chunks = q.chunks[1:]
+		q.mu.Unlock()
+
+		_, err := q.w.Write(data)
+
+		q.mu.Lock()
+		q.queued -= len(data)
+		if q.queued < 0 {
+			q.queued = 0
+		}
+		q.mu.Unlock()
+		if err != nil {
+			// The exit event tells Desktop what happened; the pipe only
+			// fails once the process is gone.
+			log.Printf("[native] stdin write for %s failed: %v", q.id, err)
+			q.close()
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package native
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+// gatedWriter blocks every Write until the gate is opened.
+type gatedWriter struct {
+	gate chan struct{}
+	mu   sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *gatedWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestStdinQueueKeepsOrderAndAppliesBackpressure(t *testing.T) {
+	w := &gatedWriter{gate: make(chan struct{})}
+	done := make(chan struct{})
+	defer close(done)
+	q := newStdinQueue("p1", w, done)
+	q.limit = 16
+
+	var want string
+	for i := 0; i < 4; i++ {
+		line := fmt.Sprintf("msg%d\n", i) // 5 bytes each
+		if i == 3 {
+			if err := q.push([]byte(line), false); !errors.Is(err, pipe.ErrBackpressure) {
+				t.Fatalf("push over limit: err = %v, want ErrBackpressure", err)
+			}
+			if err := q.push([]byte(line), true); err != nil {
+				t.Fatalf("forced push: %v", err)
+			}
+		} else if err := q.push([]byte(line), false); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+		want += line
+	}
+
+	close(w.gate)
+	deadline := time.Now().Add(5 * time.Second)
+	for w.String() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("stdin got %q, want %q", w.String(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStdinQueueAcceptsOversizedWriteWhenEmpty(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	q := newStdinQueue("p1", io.Discard, done)
+	q.limit = 4
+	if err := q.push(bytes.Repeat([]byte("x"), 64), false); err != nil {
+		t.Fatalf("oversized push into empty queue: %v", err)
+	}
+}
+
+func TestStdinQueueRejectsAfterExit(t *testing.T) {
+	done := make(chan struct{})
+	q := newStdinQueue("p1", io.Discard, done)
+	close(done)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := q.push([]byte("late\n"), false)
+		if err != nil {
+			if errors.Is(err, pipe.ErrBackpressure) {
+				t.Fatalf("exited process reported backpressure: %v", err)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("push still accepted after the process exited")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
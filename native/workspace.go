@@ -170,3 +170,43 @@ func chooseSpawnCwd(home, currentCwd string, args []string, env map[string]strin
 	}
 	return chosen
 }
+
+// resumeInNamespace keeps --resume working for a session whose CLI now runs
+// in a session namespace. There it keeps the VM cwd Desktop sent, so it
+// resolves --resume under that cwd's slug, while spawns outside the
+// namespace stored the transcript under a host workspace slug. Copy (never
+// move) it across, as the KVM backend does for the guest. Best effort: a
+// failure is logged and the CLI reports the missing conversation.
+func resumeInNamespace(home, cwd string, args []string, env map[string]string, mounts map[string]pipe.MountSpec, session string, view sessionView) {
+	id := transcript.ExtractResumeID(args)
+	if id == "" {
+		return
+	}
+	cfg := resolveClaudeConfigDir(home, env, mounts)
+	if p, ok := view.hostPath(session, cfg); ok {
+		// CLAUDE_CONFIG_DIR in its namespace form.
+		cfg = p
+	}
+	want := transcript.Slugify(cwd)
+	if cfg == "" || want == "" {
+		log.Printf("[native] resume: no claude config dir or project slug for %s; skipping transcript migration", cwd)
+		return
+	}
+	dirs := transcript.FindTranscript(cfg, id)
+	if len(dirs) == 0 {
+		log.Printf("[native] resume: no transcript found for %s - CLI will report it and Desktop starts a fresh session", id)
+		return
+	}
+	for _, d := range dirs {
+		if d == want {
+			return
+		}
+	}
+	copied, err := transcript.CopyTranscript(cfg, dirs[0], want, id)
+	switch {
+	case err != nil:
+		log.Printf("[native] resume: transcript %s copy %s -> %s failed: %v", id, dirs[0], want, err)
+	case copied:
+		log.Printf("[native] resume: transcript %s copied %s -> %s for the session namespace", id, dirs[0], want)
+	}
+}
@@ -56,6 +56,7 @@ echo "=== Building claude-cowork-service pacman package ==="
 # Stage source files alongside PKGBUILD so makepkg can pick them up via local source=()
 cp "$BINARY"                              "$WORK_DIR/cowork-svc-linux"
 cp "$REPO_ROOT/claude-cowork.service"     "$WORK_DIR/claude-cowork.service"
+cp "$REPO_ROOT/claude-cowork.socket"      "$WORK_DIR/claude-cowork.socket"
 cp "$REPO_ROOT/LICENSE"                   "$WORK_DIR/LICENSE"
 cp "$REPO_ROOT/claude-cowork-service.install" "$WORK_DIR/claude-cowork-service.install"
 
@@ -76,13 +77,15 @@ install="claude-cowork-service.install"
 
 source=('cowork-svc-linux'
         'claude-cowork.service'
+        'claude-cowork.socket'
         'LICENSE'
         'claude-cowork-service.install')
-sha256sums=('SKIP' 'SKIP' 'SKIP' 'SKIP')
+sha256sums=('SKIP' 'SKIP' 'SKIP' 'SKIP' 'SKIP')
 
 package() {
     install -Dm755 "\$srcdir/cowork-svc-linux"          "\$pkgdir/usr/bin/cowork-svc-linux"
     install -Dm644 "\$srcdir/claude-cowork.service"     "\$pkgdir/usr/lib/systemd/user/claude-cowork.service"
+    install -Dm644 "\$srcdir/claude-cowork.socket"      "\$pkgdir/usr/lib/systemd/user/claude-cowork.socket"
     install -Dm644 "\$srcdir/LICENSE"                   "\$pkgdir/usr/share/licenses/\$pkgname/LICENSE"
 }
 EOF
@@ -24,6 +24,7 @@ let
   }).config;
 
   defaultSvc = defaultEval.systemd.user.services.claude-cowork;
+  defaultSock = defaultEval.systemd.user.sockets.claude-cowork;
 
   # Check if the module exposes the extraPath option
   hasExtraPath = builtins.hasAttr "extraPath" defaultEval.services.claude-cowork;
@@ -92,6 +93,15 @@ in pkgs.runCommand "nixos-module-eval-test" { } ''
   run_test "after default.target" \
     '[[ "${toString defaultSvc.after}" == *default.target* ]]'
 
+  run_test "Type = notify" \
+    '[[ "${defaultSvc.serviceConfig.Type}" == "notify" ]]'
+
+  run_test "Delegate = memory cpu" \
+    '[[ "${defaultSvc.serviceConfig.Delegate}" == "memory cpu" ]]'
+
+  run_test "socket listens on cowork-vm-service.sock" \
+    '[[ "${defaultSock.socketConfig.ListenStream}" == *cowork-vm-service.sock ]]'
+
   run_test "package in systemPackages" \
     '[[ "${toString defaultEval.environment.systemPackages}" == *claude-cowork-service* ]]'
 
@@ -33,16 +33,34 @@ in
   };
 
   config = lib.mkIf cfg.enable {
+    systemd.user.sockets.claude-cowork = {
+      description = "Claude Cowork Service socket (native Linux backend)";
+      wantedBy = [ "sockets.target" ];
+      socketConfig = {
+        ListenStream = "%t/cowork-vm-service.sock";
+        SocketMode = "0600";
+        RemoveOnStop = true;
+      };
+    };
+
     systemd.user.services.claude-cowork = {
       description = "Claude Cowork Service (native Linux backend)";
-      after = [ "default.target" ];
+      after = [ "default.target" "claude-cowork.socket" ];
+      requires = [ "claude-cowork.socket" ];
       wantedBy = [ "default.target" ];
       path = cfg.extraPath;
       serviceConfig = {
+        # READY=1 once the socket is served; watchdog pings while healthy.
+        Type = "notify";
+        NotifyAccess = "main";
+        WatchdogSec = 30;
         # Import Wayland/display environment from the user session so spawned processes
         # (Claude Code CLI) can access display, clipboard, and D-Bus services.
         ExecStartPre = "-${pkgs.bash}/bin/bash -c '${pkgs.systemd}/bin/systemctl --user import-environment WAYLAND_DISPLAY XDG_SESSION_TYPE XDG_CURRENT_DESKTOP DISPLAY DBUS_SESSION_BUS_ADDRESS HYPRLAND_INSTANCE_SIGNATURE SWAYSOCK YDOTOOL_SOCKET 2>/dev/null'";
         ExecStart = "${cfg.package}/bin/cowork-svc-linux";
+        # Hand the service's cgroup to the daemon, so -native-cgroups can give
+        # each session its own memory and CPU limits.
+        Delegate = "memory cpu";
         Restart = "on-failure";
         RestartSec = 5;
       };
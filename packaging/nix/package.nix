@@ -16,6 +16,9 @@ buildGoModule rec {
 
   vendorHash = null; # Pure stdlib, no external dependencies
 
+  # cmd/fakeclaude is a test helper, not something to install.
+  subPackages = [ "." ];
+
   env.CGO_ENABLED = 0;
 
   ldflags = [
@@ -31,6 +34,7 @@ buildGoModule rec {
 
     mkdir -p $out/lib/systemd/user
     cp $src/claude-cowork.service $out/lib/systemd/user/claude-cowork.service
+    cp $src/claude-cowork.socket $out/lib/systemd/user/claude-cowork.socket
   '';
 
   meta = with lib; {
@@ -28,6 +28,8 @@ install -m755 %{_sourcedir}/cowork-svc-linux %{buildroot}/usr/bin/cowork-svc-lin
 # Install systemd user service
 mkdir -p %{buildroot}/usr/lib/systemd/user
 install -m644 %{_sourcedir}/claude-cowork.service %{buildroot}/usr/lib/systemd/user/claude-cowork.service
+/usr/lib/systemd/user/claude-cowork.socket
+install -m644 %{_sourcedir}/claude-cowork.socket %{buildroot}/usr/lib/systemd/user/claude-cowork.socket
 
 %post
 echo ""
@@ -0,0 +1,307 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/logx"
+)
+
+// ProcessInfo describes one process a backend is tracking.
+type ProcessInfo struct {
+	ID        string    `json:"id"`
+	Session   string    `json:"session,omitempty"`
+	PID       int       `json:"pid,omitempty"`
+	Command   string    `json:"command,omitempty"`
+	Running   bool      `json:"running"`
+	ExitCode  int       `json:"exitCode"`
+	StartedAt time.Time `json:"startedAt,omitempty"`
+}
+
+// SessionInfo describes one session a backend knows about.
+type SessionInfo struct {
+	Name      string   `json:"name"`
+	Dir       string   `json:"dir,omitempty"`
+	Processes []string `json:"processes"`
+	// Requests through the native egress proxy; zero when it is off.
+	EgressAllowed int64 `json:"egressAllowed,omitempty"`
+	EgressBlocked int64 `json:"egressBlocked,omitempty"`
+}
+
+// BackendStatus is a backend's self-reported state for admin.status.
+type BackendStatus struct {
+	Backend        string                 `json:"backend"`
+	Running        bool                   `json:"running"`
+	GuestConnected bool                   `json:"guestConnected"`
+	Subscribers    int                    `json:"subscribers"`
+	Details        map[string]interface{} `json:"details,omitempty"`
+}
+
+// StatusReporter is implemented by backends that can describe their state
+// for the admin socket. Optional: admin.status degrades to daemon-only info.
+type StatusReporter interface {
+	Status() BackendStatus
+}
+
+// SessionLister is implemented by backends that can list their sessions.
+type SessionLister interface {
+	ListSessions() []SessionInfo
+}
+
+// ProcessLister is implemented by backends that can list their processes.
+type ProcessLister interface {
+	ListProcesses() []ProcessInfo
+}
+
+// HealthChecker is implemented by backends that can tell when their own
+// machinery (event fan-out) is wedged. main gates systemd watchdog pings on
+// it.
+type HealthChecker interface {
+	Healthy() error
+}
+
+// AdminStatus is the admin.status result.
+type AdminStatus struct {
+	Version    string         `json:"version"`
+	PID        int            `json:"pid"`
+	StartedAt  time.Time      `json:"startedAt"`
+	Uptime     string         `json:"uptime"`
+	Goroutines int            `json:"goroutines"`
+	Debug      bool           `json:"debug"`
+	Backend    *BackendStatus `json:"backend,omitempty"`
+	// UnknownMethodPolicy, UnknownMethods and UnknownMethodsDropped are set
+	// when the RPC server's unknown-method policy was passed to the admin
+	// server.
+	UnknownMethodPolicy   string          `json:"unknownMethodPolicy,omitempty"`
+	UnknownMethods        []UnknownMethod `json:"unknownMethods,omitempty"`
+	UnknownMethodsDropped int             `json:"unknownMethodsDropped,omitempty"`
+}
+
+// AdminSocketPath derives the admin socket from the RPC socket path:
+// cowork-vm-service.sock → cowork-vm-service-admin.sock.
+func AdminSocketPath(rpcSocket string) string {
+	return strings.TrimSuffix(rpcSocket, ".sock") + "-admin.sock"
+}
+
+// AdminServer serves the admin.* introspection methods on their own socket
+// so operators (and `cowork-svc-linux ctl`) never share a connection, or a
+// method namespace, with Claude Desktop. It speaks the same length-prefixed
+// JSON protocol and applies the same peer policy as Server.
+type AdminServer struct {
+	socketPath string
+	backend    VMBackend
+	version    string
+	started    time.Time
+	peerPolicy *PeerPolicy
+	unknown    *UnknownMethods
+	listener   net.Listener
+	wg         sync.WaitGroup
+	quit       chan struct{}
+}
+
+// NewAdminServer creates an admin server for backend.
+func NewAdminServer(socketPath string, backend VMBackend, version string) *AdminServer {
+	return &AdminServer{
+		socketPath: socketPath,
+		backend:    backend,
+		version:    version,
+		started:    time.Now(),
+		peerPolicy: &PeerPolicy{},
+		quit:       make(chan struct{}),
+	}
+}
+
+// SetPeerPolicy replaces the client authentication policy. Must be called
+// before Start.
+func (s *AdminServer) SetPeerPolicy(p *PeerPolicy) {
+	s.peerPolicy = p
+}
+
+// SetUnknownMethods makes admin.status report the RPC server's
+// unknown-method policy and, under record, the methods seen so far.
+func (s *AdminServer) SetUnknownMethods(u *UnknownMethods) {
+	s.unknown = u
+}
+
+// Start begins listening on the admin socket.
+func (s *AdminServer) Start() error {
+	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(s.socketPath, 0700); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	s.listener = listener
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return nil
+}
+
+// Stop closes the listener and waits for open admin connections to finish.
+func (s *AdminServer) Stop() {
+	close(s.quit)
+	if s.listener != nil {
+		if err := s.listener.Close(); err != nil {
+			logx.Debug("closing admin listener on Stop: %v", err)
+		}
+	}
+	s.wg.Wait()
+	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
+		logx.Debug("removing admin socket %s on Stop: %v", s.socketPath, err)
+	}
+}
+
+func (s *AdminServer) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+				log.Printf("Admin accept error: %v", err)
+				continue
+			}
+		}
+		s.wg.Add(1)
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *AdminServer) handleConnection(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() { _ = conn.Close() }()
+
+	if cred, err := s.peerPolicy.Check(conn); err != nil {
+		log.Printf("Rejected admin client (%s): %v", cred, err)
+		return
+	}
+	for {
+		payload, err := ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		s.handle(conn, payload)
+	}
+}
+
+type adminKillParams struct {
+	ID     string `json:"id"`
+	Signal string `json:"signal"`
+}
+
+type adminLogLevelParams struct {
+	Level string `json:"level"`
+}
+
+func (s *AdminServer) handle(conn net.Conn, payload []byte) {
+	var req Request
+	if err := json.Unmarshal(payload, &req); err != nil {
+		WriteError(conn, nil, -32700, "Parse error")
+		return
+	}
+	logx.Debug("ADMIN: %s (id=%v) params: %s", req.Method, req.ID, logx.Trunc(string(req.Params)))
+
+	switch req.Method {
+	case "admin.status":
+		WriteResponse(conn, req.ID, s.status())
+	case "admin.listSessions":
+		lister, ok := s.backend.(SessionLister)
+		if !ok {
+			WriteError(conn, req.ID, -32601, "admin.listSessions not supported by this backend")
+			return
+		}
+		sessions := lister.ListSessions()
+		if sessions == nil {
+			sessions = []SessionInfo{}
+		}
+		WriteResponse(conn, req.ID, map[string]interface{}{"sessions": sessions})
+	case "admin.listProcesses":
+		lister, ok := s.backend.(ProcessLister)
+		if !ok {
+			WriteError(conn, req.ID, -32601, "admin.listProcesses not supported by this backend")
+			return
+		}
+		procs := lister.ListProcesses()
+		if procs == nil {
+			procs = []ProcessInfo{}
+		}
+		WriteResponse(conn, req.ID, map[string]interface{}{"processes": procs})
+	case "admin.killProcess":
+		var p adminKillParams
+		if err := json.Unmarshal(req.Params, &p); err != nil || p.ID == "" {
+			WriteError(conn, req.ID, -32602, "Invalid params: id is required")
+			return
+		}
+		log.Printf("admin: killing process %s (signal=%s)", p.ID, p.Signal)
+		if err := s.backend.Kill(p.ID, p.Signal); err != nil {
+			writeBackendError(conn, req.ID, err)
+			return
+		}
+		WriteResponse(conn, req.ID, nil)
+	case "admin.setLogLevel":
+		var p adminLogLevelParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+			return
+		}
+		var debug bool
+		switch p.Level {
+		case "debug":
+			debug = true
+		case "info":
+		default:
+			WriteError(conn, req.ID, -32602, fmt.Sprintf("Invalid params: level must be debug or info, got %q", p.Level))
+			return
+		}
+		s.backend.SetDebugLogging(debug)
+		logx.SetDebug(debug)
+		log.Printf("admin: log level set to %s", p.Level)
+		WriteResponse(conn, req.ID, map[string]string{"level": p.Level})
+	case "admin.dumpGoroutines":
+		var buf bytes.Buffer
+		if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+			WriteError(conn, req.ID, -32000, err.Error())
+			return
+		}
+		WriteResponse(conn, req.ID, map[string]string{"goroutines": buf.String()})
+	default:
+		WriteError(conn, req.ID, -32601, "Method not found: "+req.Method)
+	}
+}
+
+func (s *AdminServer) status() AdminStatus {
+	st := AdminStatus{
+		Version:    s.version,
+		PID:        os.Getpid(),
+		StartedAt:  s.started,
+		Uptime:     time.Since(s.started).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		Debug:      logx.DebugEnabled(),
+	}
+	if r, ok := s.backend.(StatusReporter); ok {
+		bs := r.Status()
+		st.Backend = &bs
+	}
+	if s.unknown != nil {
+		st.UnknownMethodPolicy = s.unknown.Policy().String()
+		st.UnknownMethods = s.unknown.List()
+		st.UnknownMethodsDropped = s.unknown.Dropped()
+	}
+	return st
+}
@@ -0,0 +1,124 @@
+package pipe
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type introspectableBackend struct {
+	recordingBackend
+	killed string
+	debug  bool
+}
+
+func (b *introspectableBackend) Kill(processID string, signal string) error {
+	b.killed = processID + "/" + signal
+	return nil
+}
+func (b *introspectableBackend) SetDebugLogging(enabled bool) { b.debug = enabled }
+func (b *introspectableBackend) Status() BackendStatus {
+	return BackendStatus{Backend: "test", Running: true, Subscribers: 2}
+}
+func (b *introspectableBackend) ListProcesses() []ProcessInfo {
+	return []ProcessInfo{{ID: "p1", Session: "s1", PID: 42, Running: true}}
+}
+
+func startAdmin(t *testing.T, backend VMBackend) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	srv := NewAdminServer(sock, backend, "v-test")
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+	return sock
+}
+
+func adminRoundTrip(t *testing.T, sock, method string, params interface{}) Response {
+	t.Helper()
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	req := Request{Method: method, ID: 1}
+	if params != nil {
+		req.Params = mustRawJSON(t, params)
+	}
+	data, _ := json.Marshal(req)
+	if err := WriteMessage(conn, data); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	raw, err := ReadMessage(conn)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	return resp
+}
+
+func TestAdminStatusIncludesBackend(t *testing.T) {
+	sock := startAdmin(t, &introspectableBackend{})
+	resp := adminRoundTrip(t, sock, "admin.status", nil)
+	if !resp.Success {
+		t.Fatalf("admin.status failed: %s", resp.Error)
+	}
+	var st AdminStatus
+	if err := json.Unmarshal(mustRawJSON(t, resp.Result), &st); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if st.Version != "v-test" || st.Backend == nil || st.Backend.Backend != "test" || st.Backend.Subscribers != 2 {
+		t.Fatalf("status = %+v", st)
+	}
+}
+
+func TestAdminListsProcessesAndRejectsUnsupported(t *testing.T) {
+	sock := startAdmin(t, &introspectableBackend{})
+	resp := adminRoundTrip(t, sock, "admin.listProcesses", nil)
+	if !resp.Success || !strings.Contains(string(mustRawJSON(t, resp.Result)), `"pid":42`) {
+		t.Fatalf("listProcesses = %+v", resp)
+	}
+	// introspectableBackend does not implement SessionLister.
+	resp = adminRoundTrip(t, sock, "admin.listSessions", nil)
+	if resp.Success || !strings.Contains(resp.Error, "not supported") {
+		t.Fatalf("listSessions on a non-lister = %+v", resp)
+	}
+	resp = adminRoundTrip(t, sock, "spawn", nil)
+	if resp.Success {
+		t.Fatalf("admin socket accepted a Desktop RPC")
+	}
+}
+
+func TestAdminKillAndLogLevel(t *testing.T) {
+	backend := &introspectableBackend{}
+	sock := startAdmin(t, backend)
+
+	if resp := adminRoundTrip(t, sock, "admin.killProcess", map[string]string{"id": "p1", "signal": "SIGKILL"}); !resp.Success {
+		t.Fatalf("killProcess failed: %s", resp.Error)
+	}
+	if backend.killed != "p1/SIGKILL" {
+		t.Fatalf("killed = %q", backend.killed)
+	}
+	if resp := adminRoundTrip(t, sock, "admin.killProcess", map[string]string{}); resp.Success {
+		t.Fatalf("killProcess without id succeeded")
+	}
+	if resp := adminRoundTrip(t, sock, "admin.setLogLevel", map[string]string{"level": "verbose"}); resp.Success {
+		t.Fatalf("setLogLevel accepted an invalid level")
+	}
+	if resp := adminRoundTrip(t, sock, "admin.setLogLevel", map[string]string{"level": "debug"}); !resp.Success || !backend.debug {
+		t.Fatalf("setLogLevel debug = %+v (backend debug=%v)", resp, backend.debug)
+	}
+	adminRoundTrip(t, sock, "admin.setLogLevel", map[string]string{"level": "info"})
+}
+
+func TestAdminSocketPath(t *testing.T) {
+	if got := AdminSocketPath("/run/user/1000/cowork-vm-service.sock"); got != "/run/user/1000/cowork-vm-service-admin.sock" {
+		t.Fatalf("AdminSocketPath = %q", got)
+	}
+}
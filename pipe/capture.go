@@ -0,0 +1,214 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/logx"
+)
+
+// Capture record directions.
+const (
+	CaptureIn    = "in"    // request read from the client
+	CaptureOut   = "out"   // response written to the client
+	CaptureEvent = "event" // event pushed on a subscribeEvents connection
+)
+
+// captureKeep is how many rotated capture files are kept next to the live
+// one (<path>.1 is the newest).
+const captureKeep = 3
+
+// redacted replaces secret values in captures.
+const redacted = "[REDACTED]"
+
+// CaptureRecord is one line of a capture file.
+type CaptureRecord struct {
+	Time time.Time       `json:"ts"`
+	Conn uint64          `json:"conn"`
+	Dir  string          `json:"dir"`
+	Msg  json.RawMessage `json:"msg"`
+}
+
+// Recorder appends every message crossing the socket to a rotating JSONL
+// file, so a user's broken session can be replayed offline against a new
+// Desktop/backend build (see Replay). Secrets are redacted before anything
+// touches disk.
+type Recorder struct {
+	path     string
+	maxBytes int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewRecorder opens (appending) the capture file at path. maxBytes bounds
+// a single file before it is rotated; <= 0 disables rotation.
+func NewRecorder(path string, maxBytes int64) (*Recorder, error) {
+	r := &Recorder{path: path, maxBytes: maxBytes}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("opening capture file: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat capture file: %w", err)
+	}
+	r.f = f
+	r.size = st.Size()
+	return nil
+}
+
+// Close flushes and closes the capture file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// Record appends one message. Invalid JSON is stored as a JSON string so
+// parse-error requests still show up in the capture.
+func (r *Recorder) Record(conn uint64, dir string, payload []byte) {
+	msg, err := RedactJSON(payload)
+	if err != nil {
+		msg, _ = json.Marshal(string(payload))
+	}
+	line, err := json.Marshal(CaptureRecord{Time: time.Now().UTC(), Conn: conn, Dir: dir, Msg: msg})
+	if err != nil {
+		logx.Debug("capture: marshaling record: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return
+	}
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			logx.Debug("capture: rotating %s: %v", r.path, err)
+			if r.f == nil {
+				return
+			}
+		}
+	}
+	n, err := r.f.Write(line)
+	r.size += int64(n)
+	if err != nil {
+		logx.Debug("capture: writing record: %v", err)
+	}
+}
+
+// rotate shifts <path>.N up by one, moves the live file to <path>.1 and
+// reopens a fresh one. Caller holds r.mu.
+func (r *Recorder) rotate() error {
+	if err := r.f.Close(); err != nil {
+		logx.Debug("capture: closing %s for rotation: %v", r.path, err)
+	}
+	r.f = nil
+	for i := captureKeep - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1)); err != nil && !os.IsNotExist(err) {
+			logx.Debug("capture: rotating %s.%d: %v", r.path, i, err)
+		}
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// wrap returns conn with every outbound frame recorded. WriteMessage sends
+// each frame in a single Write, so frames are recovered per call.
+func (r *Recorder) wrap(conn net.Conn, id uint64) net.Conn {
+	return &captureConn{Conn: conn, rec: r, id: id}
+}
+
+type captureConn struct {
+	net.Conn
+	rec *Recorder
+	id  uint64
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err == nil && len(b) > 4 && binary.BigEndian.Uint32(b[:4]) == uint32(len(b)-4) {
+		payload := b[4:]
+		dir := CaptureEvent
+		var probe struct {
+			Success *bool `json:"success"`
+		}
+		if json.Unmarshal(payload, &probe) == nil && probe.Success != nil {
+			dir = CaptureOut
+		}
+		c.rec.Record(c.id, dir, payload)
+	}
+	return n, err
+}
+
+// RedactJSON returns data with the values of secret-looking keys replaced:
+// oauthToken on spawn, the token param of addApprovedOauthToken, and auth
+// env vars such as ANTHROPIC_API_KEY or CLAUDE_CODE_OAUTH_TOKEN. Keys are
+// re-serialized in sorted order.
+func RedactJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactValue(v))
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if s, ok := val.(string); ok && s != "" && sensitiveKey(k) {
+				t[k] = redacted
+				continue
+			}
+			t[k] = redactValue(val)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = redactValue(t[i])
+		}
+	}
+	return v
+}
+
+// sensitiveKey matches key names that carry credentials. Counters such as
+// CLAUDE_CODE_MAX_OUTPUT_TOKENS are left alone.
+func sensitiveKey(k string) bool {
+	n := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(k))
+	if strings.HasSuffix(n, "tokens") {
+		return false
+	}
+	for _, s := range []string{"token", "secret", "password", "apikey", "credential", "authorization", "cookie"} {
+		if strings.Contains(n, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package pipe
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventBackend emits a fixed event sequence for every spawn.
+type eventBackend struct {
+	recordingBackend
+	events []interface{}
+	pid    string
+
+	callback func(event interface{})
+}
+
+func (b *eventBackend) SubscribeEvents(name string, callback func(event interface{})) (func(), error) {
+	b.callback = callback
+	return func() {}, nil
+}
+
+func (b *eventBackend) Spawn(name string, id string, cmd string, args []string, env map[string]string, cwd string, mounts map[string]MountSpec, rawParams []byte, oauthToken string) (string, []string, error) {
+	for _, ev := range b.events {
+		b.callback(ev)
+	}
+	return b.pid, nil, nil
+}
+
+func TestRedactJSON(t *testing.T) {
+	in := `{"method":"spawn","params":{"oauthToken":"sk-ant-oat01-x","env":{"ANTHROPIC_API_KEY":"sk-ant-api","CLAUDE_CODE_OAUTH_TOKEN":"tok","CLAUDE_CODE_MAX_OUTPUT_TOKENS":"32000","HOME":"/home/u"},"args":["--model","opus"]}}`
+	out, err := RedactJSON([]byte(in))
+	if err != nil {
+		t.Fatalf("RedactJSON: %v", err)
+	}
+	s := string(out)
+	for _, secret := range []string{"sk-ant-oat01-x", "sk-ant-api", `"tok"`} {
+		if strings.Contains(s, secret) {
+			t.Fatalf("secret %s survived redaction: %s", secret, s)
+		}
+	}
+	for _, keep := range []string{`"32000"`, `"/home/u"`, `"opus"`} {
+		if !strings.Contains(s, keep) {
+			t.Fatalf("non-secret %s was lost: %s", keep, s)
+		}
+	}
+}
+
+func TestServerCapturesRequestsAndResponses(t *testing.T) {
+	dir := t.TempDir()
+	capture := filepath.Join(dir, "capture.jsonl")
+	rec, err := NewRecorder(capture, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	sock := filepath.Join(dir, "s.sock")
+	srv := NewServer(sock, &recordingBackend{}, false)
+	srv.SetRecorder(rec)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	req, _ := json.Marshal(Request{Method: "addApprovedOauthToken", ID: 1, Params: mustRawJSON(t, map[string]string{"token": "sk-ant-secret"})})
+	if err := WriteMessage(conn, req); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if _, err := ReadMessage(conn); err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	_ = conn.Close()
+	srv.Stop()
+	_ = rec.Close()
+
+	raw, err := os.ReadFile(capture)
+	if err != nil {
+		t.Fatalf("reading capture: %v", err)
+	}
+	if strings.Contains(string(raw), "sk-ant-secret") {
+		t.Fatalf("capture contains the token: %s", raw)
+	}
+	f, _ := os.Open(capture)
+	defer func() { _ = f.Close() }()
+	records, err := ReadCapture(f)
+	if err != nil {
+		t.Fatalf("ReadCapture: %v", err)
+	}
+	if len(records) != 2 || records[0].Dir != CaptureIn || records[1].Dir != CaptureOut {
+		t.Fatalf("records = %+v, want in+out", records)
+	}
+	if records[0].Conn == 0 || records[0].Conn != records[1].Conn {
+		t.Fatalf("connection ids = %d/%d, want equal and non-zero", records[0].Conn, records[1].Conn)
+	}
+}
+
+func TestRecorderRotates(t *testing.T) {
+	capture := filepath.Join(t.TempDir(), "capture.jsonl")
+	rec, err := NewRecorder(capture, 200)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		rec.Record(1, CaptureIn, []byte(`{"method":"isRunning","id":1}`))
+	}
+	_ = rec.Close()
+
+	for _, name := range []string{capture, capture + ".1", capture + ".3"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Fatalf("expected %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(capture + ".4"); err == nil {
+		t.Fatalf("kept more than %d rotated files", captureKeep)
+	}
+}
+
+func replayCapture(t *testing.T) []CaptureRecord {
+	t.Helper()
+	lines := []string{
+		`{"ts":"2026-01-01T00:00:00Z","conn":1,"dir":"in","msg":{"method":"subscribeEvents","id":1}}`,
+		`{"ts":"2026-01-01T00:00:00Z","conn":1,"dir":"out","msg":{"id":1,"success":true,"result":{"subscribed":true}}}`,
+		`{"ts":"2026-01-01T00:00:01Z","conn":2,"dir":"in","msg":{"method":"spawn","id":5,"params":{"name":"s","id":"p1","command":"claude","oauthToken":"[REDACTED]"}}}`,
+		`{"ts":"2026-01-01T00:00:01Z","conn":1,"dir":"event","msg":{"type":"stdout","id":"p1","data":"a"}}`,
+		`{"ts":"2026-01-01T00:00:01Z","conn":1,"dir":"event","msg":{"type":"stdout","id":"p1","data":"b"}}`,
+		`{"ts":"2026-01-01T00:00:01Z","conn":1,"dir":"event","msg":{"type":"exit","id":"p1","exitCode":0}}`,
+		`{"ts":"2026-01-01T00:00:01Z","conn":2,"dir":"out","msg":{"id":5,"success":true,"result":{"id":"p1","failedMounts":[]}}}`,
+	}
+	records, err := ReadCapture(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("ReadCapture: %v", err)
+	}
+	return records
+}
+
+func TestReplayMatchingBackend(t *testing.T) {
+	backend := &eventBackend{pid: "p1", events: []interface{}{
+		map[string]string{"type": "stdout", "id": "p1", "data": "ab"},
+		map[string]interface{}{"type": "exit", "id": "p1", "exitCode": 0},
+	}}
+	res, err := Replay(replayCapture(t), backend, ReplayOptions{Timeout: 2 * time.Second, Settle: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(res.Mismatches) != 0 {
+		t.Fatalf("mismatches: %v", res.Mismatches)
+	}
+	if res.Requests != 2 || res.Responses != 2 || res.Events != 2 {
+		t.Fatalf("result = %+v", res)
+	}
+}
+
+func TestReplayReportsDivergence(t *testing.T) {
+	backend := &eventBackend{pid: "p2", events: []interface{}{
+		map[string]string{"type": "stderr", "id": "p2", "data": "boom"},
+	}}
+	res, err := Replay(replayCapture(t), backend, ReplayOptions{Timeout: 2 * time.Second, Settle: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if len(res.Mismatches) != 2 {
+		t.Fatalf("mismatches = %q, want spawn response + events", res.Mismatches)
+	}
+	if !strings.Contains(res.Mismatches[0], "spawn id=5") || !strings.Contains(res.Mismatches[1], "conn 1 events") {
+		t.Fatalf("unexpected mismatches: %q", res.Mismatches)
+	}
+}
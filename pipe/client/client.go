@@ -0,0 +1,231 @@
+// Package client is a Go client for the cowork-svc RPC socket: the
+// length-prefixed JSON protocol in COWORK_RPC_PROTOCOL.md, as spoken by
+// Claude Desktop.
+//
+// A Client multiplexes concurrent calls over one connection, matching
+// responses to requests by id. Event subscriptions take over a connection
+// of their own (the daemon streams events on it until it closes), so
+// Subscribe dials a second one.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+// ErrClosed is returned by calls on a Client whose connection is gone.
+var ErrClosed = errors.New("client: connection closed")
+
+// Client is a connection to the daemon's RPC (or admin) socket. It is safe
+// for concurrent use.
+type Client struct {
+	dial DialFunc
+	conn net.Conn
+
+	writeMu sync.Mutex // frames from concurrent calls must not interleave
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan response
+	err     error // set once the reader stops; fails pending and later calls
+	done    chan struct{}
+}
+
+// response is the wire Response as the client sees it.
+type response struct {
+	ID      *uint64         `json:"id"`
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Error   string          `json:"error"`
+	Code    int             `json:"code"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// err returns the error response as a *pipe.Error.
+func (r response) err() error {
+	e := &pipe.Error{Code: r.Code, Message: r.Error}
+	if len(r.Data) > 0 {
+		e.Data = r.Data
+	}
+	return e
+}
+
+// DialFunc opens one connection to the daemon. Subscribe calls it again for
+// the event connection.
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
+// DialUnix returns a DialFunc for the Unix socket at socketPath.
+func DialUnix(socketPath string) DialFunc {
+	return func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "unix", socketPath)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", socketPath, err)
+		}
+		return conn, nil
+	}
+}
+
+// DialTLS returns a DialFunc for a daemon serving the protocol over TLS at
+// addr (host:port), presenting the client certificate in cfg.
+func DialTLS(addr string, cfg *tls.Config) DialFunc {
+	return func(ctx context.Context) (net.Conn, error) {
+		d := tls.Dialer{Config: cfg}
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+		}
+		return conn, nil
+	}
+}
+
+// Dial connects to the socket at socketPath.
+func Dial(ctx context.Context, socketPath string) (*Client, error) {
+	return DialWith(ctx, DialUnix(socketPath))
+}
+
+// DialWith connects using dial, for transports other than a local socket.
+func DialWith(ctx context.Context, dial DialFunc) (*Client, error) {
+	conn, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		dial:    dial,
+		conn:    conn,
+		pending: make(map[uint64]chan response),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Close closes the connection. Calls in flight fail with ErrClosed.
+// Subscriptions have their own connection and stay open.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Done is closed once the connection is gone, after which every call fails
+// with ErrClosed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Call sends method with params and waits for its response, decoding the
+// result into result unless it is nil. params may be nil for methods that
+// take none. An error response is returned as a *pipe.Error carrying the
+// wire code, so errors.Is(err, pipe.ErrProcessNotFound) works; its Data is
+// the raw JSON "data" field, if any.
+//
+// Cancelling ctx abandons the call; the daemon still runs it.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	req := map[string]interface{}{"method": method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("%s: encoding params: %w", method, err)
+		}
+		req["params"] = json.RawMessage(raw)
+	}
+
+	ch := make(chan response, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+	req["id"] = id
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		c.forget(id)
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	c.writeMu.Lock()
+	err = pipe.WriteMessage(c.conn, data)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.forget(id)
+		return fmt.Errorf("%s: %w", method, err)
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return c.closedErr()
+		}
+		if !resp.Success {
+			return resp.err()
+		}
+		if result == nil || len(resp.Result) == 0 || string(resp.Result) == "null" {
+			return nil
+		}
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("%s: decoding result: %w", method, err)
+		}
+		return nil
+	case <-ctx.Done():
+		c.forget(id)
+		return ctx.Err()
+	}
+}
+
+func (c *Client) forget(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *Client) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// readLoop delivers responses to their callers until the connection fails,
+// then fails every pending call.
+func (c *Client) readLoop() {
+	defer close(c.done)
+	var err error
+	for {
+		var payload []byte
+		if payload, err = pipe.ReadMessage(c.conn); err != nil {
+			break
+		}
+		var resp response
+		if json.Unmarshal(payload, &resp) != nil || resp.ID == nil {
+			// Parse errors come back without an id; nobody to hand
+			// them to.
+			continue
+		}
+		c.mu.Lock()
+		ch := c.pending[*resp.ID]
+		delete(c.pending, *resp.ID)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- resp
+		}
+	}
+
+	c.mu.Lock()
+	c.err = fmt.Errorf("%w: %v", ErrClosed, err)
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+}
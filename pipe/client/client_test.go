@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+// fakeBackend answers from canned values. IsProcessRunning("slow") blocks
+// until release is closed, and unknown ids are ErrProcessNotFound.
+type fakeBackend struct {
+	bus     *pipe.EventBus
+	release chan struct{}
+	spawned pipe.MountSpec
+}
+
+func (b *fakeBackend) Configure(memoryMB int, cpuCount int) error { return nil }
+func (b *fakeBackend) CreateVM(name string) error                 { return nil }
+func (b *fakeBackend) StartVM(name string, bundlePath string, memoryGB int, cpuCount int, apiProbeURL string) error {
+	return nil
+}
+func (b *fakeBackend) StopVM(name string) error                       { return nil }
+func (b *fakeBackend) IsRunning(name string) (bool, error)            { return true, nil }
+func (b *fakeBackend) IsGuestConnected(name string) (bool, error)     { return true, nil }
+func (b *fakeBackend) Kill(processID string, signal string) error     { return nil }
+func (b *fakeBackend) WriteStdin(processID string, data []byte) error { return nil }
+func (b *fakeBackend) Spawn(name string, id string, cmd string, args []string, env map[string]string, cwd string, mounts map[string]pipe.MountSpec, rawParams []byte, oauthToken string) (string, []string, error) {
+	b.spawned = mounts["work"]
+	return "p-" + name, []string{"outputs"}, nil
+}
+func (b *fakeBackend) IsProcessRunning(processID string) (bool, int, error) {
+	switch processID {
+	case "slow":
+		<-b.release
+		return false, 1, nil
+	case "fast":
+		return true, 0, nil
+	}
+	return false, 0, fmt.Errorf("%w: %s", pipe.ErrProcessNotFound, processID)
+}
+func (b *fakeBackend) MountPath(processID string, subpath string, mountName string, mode string) error {
+	return nil
+}
+func (b *fakeBackend) ReadFile(processName string, filePath string) ([]byte, error) {
+	return []byte("<html>\x00</html>"), nil
+}
+func (b *fakeBackend) InstallSdk(sdkSubpath string, version string) error { return nil }
+func (b *fakeBackend) AddApprovedOauthToken(token string) error           { return nil }
+func (b *fakeBackend) SetDebugLogging(enabled bool)                       {}
+func (b *fakeBackend) SubscribeEvents(name string, callback func(event interface{})) (func(), error) {
+	return b.bus.Subscribe(callback), nil
+}
+func (b *fakeBackend) SubscribeEventsSince(name string, sinceSeq uint64, callback func(event interface{})) (func(), error) {
+	return b.bus.SubscribeSince(sinceSeq, callback), nil
+}
+func (b *fakeBackend) GetDownloadStatus() string { return "Ready" }
+func (b *fakeBackend) GetSessionsDiskInfo(lowWaterBytes int64) (pipe.SessionsDiskInfo, error) {
+	return pipe.SessionsDiskInfo{TotalBytes: 100, FreeBytes: 40}, nil
+}
+func (b *fakeBackend) DeleteSessionDirs(names []string) (pipe.DeleteSessionDirsResult, error) {
+	return pipe.DeleteSessionDirsResult{}, nil
+}
+func (b *fakeBackend) PruneSessionCaches(onlyIfFreeBytesBelow int64, includeSessionTmp bool, sessionTmpOlderThanSeconds int64) (pipe.PruneSessionCachesResult, error) {
+	return pipe.PruneSessionCachesResult{}, nil
+}
+func (b *fakeBackend) CreateDiskImage(diskName string, sizeGiB int) error { return nil }
+func (b *fakeBackend) SendGuestResponse(id string, resultJSON string, errMsg string) error {
+	return nil
+}
+func (b *fakeBackend) Touch() {}
+
+func startServer(t *testing.T) (*fakeBackend, string) {
+	t.Helper()
+	backend := &fakeBackend{bus: pipe.NewEventBus(), release: make(chan struct{})}
+	path := filepath.Join(t.TempDir(), "cowork.sock")
+	srv := pipe.NewServer(path, backend, false)
+	srv.SetCrashDir(t.TempDir())
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+	return backend, path
+}
+
+func dialTest(t *testing.T, path string) *Client {
+	t.Helper()
+	c, err := Dial(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestClientMatchesConcurrentResponsesByID(t *testing.T) {
+	backend, path := startServer(t)
+	c := dialTest(t, path)
+	ctx := context.Background()
+
+	slow := make(chan error, 1)
+	go func() {
+		_, code, err := c.IsProcessRunning(ctx, "slow")
+		if err == nil && code != 1 {
+			err = fmt.Errorf("exit code %d, want 1", code)
+		}
+		slow <- err
+	}()
+
+	// The slow call is still in flight on the same connection; the fast
+	// one must not wait for it or receive its answer.
+	running, _, err := c.IsProcessRunning(ctx, "fast")
+	if err != nil || !running {
+		t.Fatalf("fast call = %v, %v", running, err)
+	}
+	close(backend.release)
+	if err := <-slow; err != nil {
+		t.Fatalf("slow call: %v", err)
+	}
+}
+
+func TestClientTypedCalls(t *testing.T) {
+	backend, path := startServer(t)
+	c := dialTest(t, path)
+	ctx := context.Background()
+
+	id, failed, err := c.Spawn(ctx, SpawnParams{
+		Name:             "s1",
+		Command:          "/usr/bin/claude",
+		AdditionalMounts: map[string]pipe.MountSpec{"work": {Path: "home/u/work", Mode: "rw"}},
+	})
+	if err != nil || id != "p-s1" || len(failed) != 1 || failed[0] != "outputs" {
+		t.Fatalf("Spawn = %q, %v, %v", id, failed, err)
+	}
+	if backend.spawned.Mode != "rw" {
+		t.Fatalf("mount reached backend as %+v", backend.spawned)
+	}
+	data, err := c.ReadFile(ctx, "s1", "out.html")
+	if err != nil || string(data) != "<html>\x00</html>" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+	info, err := c.GetSessionsDiskInfo(ctx, 0)
+	if err != nil || info.FreeBytes != 40 {
+		t.Fatalf("GetSessionsDiskInfo = %+v, %v", info, err)
+	}
+	caps, err := c.GetCapabilities(ctx)
+	if err != nil || caps.ProtocolRevision != pipe.ProtocolRevision || len(caps.Methods) == 0 {
+		t.Fatalf("GetCapabilities = %+v, %v", caps, err)
+	}
+}
+
+func TestClientReturnsTypedErrors(t *testing.T) {
+	backend, path := startServer(t)
+	defer close(backend.release)
+	c := dialTest(t, path)
+
+	_, _, err := c.IsProcessRunning(context.Background(), "p404")
+	if !errors.Is(err, pipe.ErrProcessNotFound) {
+		t.Fatalf("err = %v, want ErrProcessNotFound", err)
+	}
+	err = c.Kill(context.Background(), "", "")
+	var perr *pipe.Error
+	if !errors.As(err, &perr) || perr.Code != pipe.CodeInvalidParams {
+		t.Fatalf("err = %v, want -32602", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.IsProcessRunning(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+}
+
+func TestSubscribeYieldsTypedEventsAndResumes(t *testing.T) {
+	backend, path := startServer(t)
+	c := dialTest(t, path)
+
+	sub, err := c.Subscribe(context.Background(), SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	backend.bus.Publish(process.NewStdoutEvent("p1", "hello\n"))
+	backend.bus.Publish(map[string]string{"type": "vmStarted", "name": "default"})
+
+	if ev := next(t, sub); ev != process.NewStdoutEvent("p1", "hello\n") {
+		t.Fatalf("first event = %#v", ev)
+	}
+	if ev, ok := next(t, sub).(map[string]interface{}); !ok || ev["type"] != "vmStarted" {
+		t.Fatalf("second event = %#v", ev)
+	}
+	seq := sub.LastSeq()
+	if seq == 0 {
+		t.Fatalf("LastSeq = 0 after two events")
+	}
+	_ = sub.Close()
+	if _, ok := <-sub.Events(); ok {
+		t.Fatalf("Events not closed after Close")
+	}
+	if sub.Err() != nil {
+		t.Fatalf("Err after Close = %v", sub.Err())
+	}
+
+	// Published while nobody listened; a resubscribe from seq gets it.
+	backend.bus.Publish(process.NewExitEvent("p1", 0))
+	resumed, err := c.Subscribe(context.Background(), SubscribeOptions{SinceSeq: &seq})
+	if err != nil {
+		t.Fatalf("resubscribe: %v", err)
+	}
+	defer func() { _ = resumed.Close() }()
+	if ev := next(t, resumed); ev != process.NewExitEvent("p1", 0) {
+		t.Fatalf("replayed event = %#v", ev)
+	}
+}
+
+func next(t *testing.T, sub *Subscription) interface{} {
+	t.Helper()
+	select {
+	case ev, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("subscription ended: %v", sub.Err())
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no event")
+	}
+	return nil
+}
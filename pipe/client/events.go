@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+// SubscribeOptions are the subscribeEvents params.
+type SubscribeOptions struct {
+	Name string
+	// SinceSeq, when set, asks the daemon to replay buffered events after
+	// this seq before live delivery, e.g. Subscription.LastSeq of a
+	// subscription that dropped.
+	SinceSeq *uint64
+}
+
+// Subscription is a subscribeEvents stream on its own connection.
+type Subscription struct {
+	ctx    context.Context
+	conn   net.Conn
+	events chan interface{}
+	done   chan struct{}
+
+	closeOnce sync.Once
+	mu        sync.Mutex
+	closing   bool
+	lastSeq   uint64
+	err       error
+}
+
+// Subscribe opens a second connection to the daemon and subscribes to its
+// events. The subscription lasts until ctx is done, Close is called or the
+// daemon drops the connection.
+func (c *Client) Subscribe(ctx context.Context, opts SubscribeOptions) (*Subscription, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]interface{}{}
+	if opts.Name != "" {
+		params["name"] = opts.Name
+	}
+	if opts.SinceSeq != nil {
+		params["sinceSeq"] = *opts.SinceSeq
+	}
+	data, err := json.Marshal(map[string]interface{}{"method": "subscribeEvents", "params": params, "id": 1})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// The ack is the first message on the connection; events follow it.
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	var ack response
+	if err = pipe.WriteMessage(conn, data); err == nil {
+		var payload []byte
+		if payload, err = pipe.ReadMessage(conn); err == nil {
+			err = json.Unmarshal(payload, &ack)
+		}
+	}
+	if err != nil {
+		stop()
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("subscribeEvents: %w", err)
+	}
+	if !ack.Success {
+		stop()
+		_ = conn.Close()
+		return nil, ack.err()
+	}
+
+	s := &Subscription{ctx: ctx, conn: conn, events: make(chan interface{}, 64), done: make(chan struct{})}
+	go func() {
+		<-s.done
+		stop()
+	}()
+	go s.readLoop()
+	return s, nil
+}
+
+// Events delivers decoded events: process.StdoutEvent, process.ExitEvent
+// and the other typed events, or a map[string]interface{} for types
+// without one (vmStarted, forwarded guest events). It is closed when the
+// subscription ends; Err then says why. A consumer that stops reading
+// stalls the stream, and the daemon eventually drops it.
+func (s *Subscription) Events() <-chan interface{} {
+	return s.events
+}
+
+// LastSeq returns the seq of the last event delivered, for resubscribing
+// with SubscribeOptions.SinceSeq.
+func (s *Subscription) LastSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeq
+}
+
+// Err returns why the subscription ended: nil after Close, ctx's error
+// once it is done, otherwise the read error. Only meaningful once Events is
+// closed.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+	return s.shutdown()
+}
+
+func (s *Subscription) shutdown() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.conn.Close()
+	})
+	return err
+}
+
+func (s *Subscription) readLoop() {
+	defer close(s.events)
+	defer func() { _ = s.shutdown() }()
+	for {
+		payload, err := pipe.ReadMessage(s.conn)
+		if err != nil {
+			s.mu.Lock()
+			switch {
+			case s.closing:
+			case s.ctx.Err() != nil:
+				s.err = s.ctx.Err()
+			default:
+				s.err = fmt.Errorf("%w: %v", ErrClosed, err)
+			}
+			s.mu.Unlock()
+			return
+		}
+		event, seq, err := DecodeEvent(payload)
+		if err != nil {
+			continue
+		}
+		if seq != 0 {
+			s.mu.Lock()
+			s.lastSeq = seq
+			s.mu.Unlock()
+		}
+		select {
+		case s.events <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// DecodeEvent decodes one event message into its typed process event, or a
+// map[string]interface{} for types without one, and returns its seq (0 if
+// the daemon didn't stamp one).
+func DecodeEvent(data []byte) (event interface{}, seq uint64, err error) {
+	var probe struct {
+		Type string `json:"type"`
+		Seq  uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, 0, fmt.Errorf("decoding event: %w", err)
+	}
+	switch probe.Type {
+	case "stdout":
+		event, err = decodeAs[process.StdoutEvent](data)
+	case "stderr":
+		event, err = decodeAs[process.StderrEvent](data)
+	case "exit":
+		event, err = decodeAs[process.ExitEvent](data)
+	case "error":
+		event, err = decodeAs[process.ErrorEvent](data)
+	case "apiReachability":
+		event, err = decodeAs[process.APIReachableEvent](data)
+	case "startupStep":
+		event, err = decodeAs[process.StartupStepEvent](data)
+	case "networkStatus":
+		event, err = decodeAs[process.NetworkStatusEvent](data)
+	default:
+		event, err = decodeAs[map[string]interface{}](data)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("decoding %s event: %w", probe.Type, err)
+	}
+	return event, probe.Seq, nil
+}
+
+func decodeAs[T any](data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+// Typed wrappers for the VMBackend RPCs. Arguments mirror the VMBackend
+// methods they end up calling, with the wire param names in
+// COWORK_RPC_PROTOCOL.md.
+
+// SpawnParams are the spawn params. Command is required; the daemon fills
+// in the rest.
+type SpawnParams struct {
+	Name              string                    `json:"name,omitempty"`
+	ID                string                    `json:"id,omitempty"`
+	Command           string                    `json:"command"`
+	Args              []string                  `json:"args,omitempty"`
+	Env               map[string]string         `json:"env,omitempty"`
+	Cwd               string                    `json:"cwd,omitempty"`
+	AdditionalMounts  map[string]pipe.MountSpec `json:"additionalMounts,omitempty"`
+	IsResume          bool                      `json:"isResume,omitempty"`
+	AllowedDomains    []string                  `json:"allowedDomains,omitempty"`
+	OneShot           bool                      `json:"oneShot,omitempty"`
+	MountSkeletonHome bool                      `json:"mountSkeletonHome,omitempty"`
+	OauthToken        string                    `json:"oauthToken,omitempty"`
+}
+
+// Configure sets the VM size for the next startVM.
+func (c *Client) Configure(ctx context.Context, memoryMB, cpuCount int) error {
+	return c.Call(ctx, "configure", map[string]int{"memoryMB": memoryMB, "cpuCount": cpuCount}, nil)
+}
+
+// CreateVM creates the named VM.
+func (c *Client) CreateVM(ctx context.Context, name string) error {
+	return c.Call(ctx, "createVM", map[string]string{"name": name}, nil)
+}
+
+// StartVM boots the session runtime.
+func (c *Client) StartVM(ctx context.Context, name, bundlePath string, memoryGB, cpuCount int, apiProbeURL string) error {
+	params := map[string]interface{}{"name": name, "bundlePath": bundlePath, "memoryGB": memoryGB, "cpuCount": cpuCount}
+	if apiProbeURL != "" {
+		params["apiProbeURL"] = apiProbeURL
+	}
+	return c.Call(ctx, "startVM", params, nil)
+}
+
+// StopVM stops the session runtime.
+func (c *Client) StopVM(ctx context.Context, name string) error {
+	return c.Call(ctx, "stopVM", nameParams(name), nil)
+}
+
+// IsRunning reports whether the VM is running.
+func (c *Client) IsRunning(ctx context.Context, name string) (bool, error) {
+	var r struct {
+		Running bool `json:"running"`
+	}
+	err := c.Call(ctx, "isRunning", nameParams(name), &r)
+	return r.Running, err
+}
+
+// IsGuestConnected reports whether the guest agent is connected.
+func (c *Client) IsGuestConnected(ctx context.Context, name string) (bool, error) {
+	var r struct {
+		Connected bool `json:"connected"`
+	}
+	err := c.Call(ctx, "isGuestConnected", nameParams(name), &r)
+	return r.Connected, err
+}
+
+// Spawn starts a session process and returns its id and the names of the
+// mounts that could not be attached.
+func (c *Client) Spawn(ctx context.Context, p SpawnParams) (processID string, failedMounts []string, err error) {
+	var r struct {
+		ID           string   `json:"id"`
+		FailedMounts []string `json:"failedMounts"`
+	}
+	err = c.Call(ctx, "spawn", p, &r)
+	return r.ID, r.FailedMounts, err
+}
+
+// Kill signals a process; signal "" means SIGTERM. The daemon holds kill
+// for a second so in-flight output reaches subscribers first.
+func (c *Client) Kill(ctx context.Context, processID, signal string) error {
+	params := map[string]string{"id": processID}
+	if signal != "" {
+		params["signal"] = signal
+	}
+	return c.Call(ctx, "kill", params, nil)
+}
+
+// WriteStdin writes data to a process's stdin. Writes on one Client reach
+// the process in call order.
+func (c *Client) WriteStdin(ctx context.Context, processID string, data []byte) error {
+	return c.Call(ctx, "writeStdin", map[string]string{"id": processID, "data": string(data)}, nil)
+}
+
+// IsProcessRunning reports whether a process is running, and its exit code
+// once it is not.
+func (c *Client) IsProcessRunning(ctx context.Context, processID string) (bool, int, error) {
+	var r struct {
+		Running  bool `json:"running"`
+		ExitCode int  `json:"exitCode"`
+	}
+	err := c.Call(ctx, "isProcessRunning", map[string]string{"id": processID}, &r)
+	return r.Running, r.ExitCode, err
+}
+
+// MountPath attaches subpath into a running process's session as mountName.
+func (c *Client) MountPath(ctx context.Context, processID, subpath, mountName, mode string) error {
+	return c.Call(ctx, "mountPath", map[string]string{"processId": processID, "subpath": subpath, "mountName": mountName, "mode": mode}, nil)
+}
+
+// ReadFile reads a file from a session. The daemon sends it base64-encoded.
+func (c *Client) ReadFile(ctx context.Context, processName, filePath string) ([]byte, error) {
+	var r struct {
+		Content string `json:"content"`
+	}
+	if err := c.Call(ctx, "readFile", map[string]string{"processName": processName, "filePath": filePath}, &r); err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(r.Content)
+	if err != nil {
+		return nil, fmt.Errorf("readFile: decoding content: %w", err)
+	}
+	return data, nil
+}
+
+// InstallSdk installs the SDK at sdkSubpath.
+func (c *Client) InstallSdk(ctx context.Context, sdkSubpath, version string) error {
+	return c.Call(ctx, "installSdk", map[string]string{"sdkSubpath": sdkSubpath, "version": version}, nil)
+}
+
+// AddApprovedOauthToken approves an OAuth token for spawned sessions.
+func (c *Client) AddApprovedOauthToken(ctx context.Context, token string) error {
+	return c.Call(ctx, "addApprovedOauthToken", map[string]string{"token": token}, nil)
+}
+
+// SetDebugLogging switches the daemon's debug logging.
+func (c *Client) SetDebugLogging(ctx context.Context, enabled bool) error {
+	return c.Call(ctx, "setDebugLogging", map[string]bool{"enabled": enabled}, nil)
+}
+
+// IsDebugLoggingEnabled reports whether debug logging is on.
+func (c *Client) IsDebugLoggingEnabled(ctx context.Context) (bool, error) {
+	var r struct {
+		Enabled bool `json:"enabled"`
+	}
+	err := c.Call(ctx, "isDebugLoggingEnabled", nil, &r)
+	return r.Enabled, err
+}
+
+// GetDownloadStatus returns the VM bundle download status.
+func (c *Client) GetDownloadStatus(ctx context.Context) (string, error) {
+	var r struct {
+		Status string `json:"status"`
+	}
+	err := c.Call(ctx, "getDownloadStatus", nil, &r)
+	return r.Status, err
+}
+
+// GetSessionsDiskInfo reports session disk usage.
+func (c *Client) GetSessionsDiskInfo(ctx context.Context, lowWaterBytes int64) (pipe.SessionsDiskInfo, error) {
+	var r pipe.SessionsDiskInfo
+	err := c.Call(ctx, "getSessionsDiskInfo", map[string]int64{"lowWaterBytes": lowWaterBytes}, &r)
+	return r, err
+}
+
+// DeleteSessionDirs deletes the named sessions' directories.
+func (c *Client) DeleteSessionDirs(ctx context.Context, names []string) (pipe.DeleteSessionDirsResult, error) {
+	var r pipe.DeleteSessionDirsResult
+	err := c.Call(ctx, "deleteSessionDirs", map[string][]string{"names": names}, &r)
+	return r, err
+}
+
+// PruneSessionCaches prunes session caches when free space is below
+// onlyIfFreeBytesBelow (0: always).
+func (c *Client) PruneSessionCaches(ctx context.Context, onlyIfFreeBytesBelow int64, includeSessionTmp bool, sessionTmpOlderThanSeconds int64) (pipe.PruneSessionCachesResult, error) {
+	var r pipe.PruneSessionCachesResult
+	err := c.Call(ctx, "pruneSessionCaches", map[string]interface{}{
+		"onlyIfFreeBytesBelow":       onlyIfFreeBytesBelow,
+		"includeSessionTmp":          includeSessionTmp,
+		"sessionTmpOlderThanSeconds": sessionTmpOlderThanSeconds,
+	}, &r)
+	return r, err
+}
+
+// CreateDiskImage creates a sizeGiB disk image named diskName.
+func (c *Client) CreateDiskImage(ctx context.Context, diskName string, sizeGiB int) error {
+	return c.Call(ctx, "createDiskImage", map[string]interface{}{"diskName": diskName, "sizeGiB": sizeGiB}, nil)
+}
+
+// SendGuestResponse answers a guest request with resultJSON, or errMsg.
+func (c *Client) SendGuestResponse(ctx context.Context, id, resultJSON, errMsg string) error {
+	params := map[string]string{"id": id, "resultJson": resultJSON}
+	if errMsg != "" {
+		params["error"] = errMsg
+	}
+	return c.Call(ctx, "sendGuestResponse", params, nil)
+}
+
+// GetCapabilities returns what the daemon implements.
+func (c *Client) GetCapabilities(ctx context.Context) (pipe.Capabilities, error) {
+	var r pipe.Capabilities
+	err := c.Call(ctx, "getCapabilities", nil, &r)
+	return r, err
+}
+
+// nameParams sends no params for an empty name, as current Desktop builds
+// do.
+func nameParams(name string) interface{} {
+	if name == "" {
+		return nil
+	}
+	return map[string]string{"name": name}
+}
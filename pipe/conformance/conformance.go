@@ -0,0 +1,340 @@
+// Package conformance runs the session lifecycle Claude Desktop drives
+// against a pipe.VMBackend, over the real RPC socket, and checks the
+// response and event shapes Desktop depends on. Each backend's tests call
+// Run with a factory, so the backends cannot drift apart silently.
+//
+// The script is configure → startVM → subscribeEvents → spawn →
+// writeStdin → exit → isProcessRunning → readFile → disk management →
+// kill → stopVM.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/client"
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+// EchoScript is a stand-in for the claude CLI: it echoes each stdin line to
+// stdout, and the line "exit N" makes it exit with code N. Backends that run
+// real processes spawn it as Target.Command.
+const EchoScript = `#!/bin/sh
+while IFS= read -r line; do
+	case "$line" in
+	"exit "*) exit "${line#exit }" ;;
+	esac
+	printf '%s\n' "$line"
+done
+`
+
+// Session is the session name Run spawns in.
+const Session = "conformance"
+
+// processID is the id Run passes to spawn, as Desktop does.
+const processID = "conformance-1"
+
+// exitCode is what Run asks the process to exit with.
+const exitCode = 3
+
+// eventTimeout bounds every wait for an event.
+const eventTimeout = 10 * time.Second
+
+// Target is a backend under test.
+type Target struct {
+	// New returns a fresh, stopped backend.
+	New func(t *testing.T) pipe.VMBackend
+	// Command is the spawn command. The spawned process must behave like
+	// EchoScript.
+	Command string
+	// File returns a path readFile serves in Session, and its contents.
+	File func(t *testing.T) (path string, content []byte)
+}
+
+// Run drives one Desktop session against target's backend.
+func Run(t *testing.T, target Target) {
+	t.Helper()
+	backend := target.New(t)
+	socket := filepath.Join(t.TempDir(), "cowork.sock")
+	srv := pipe.NewServer(socket, backend, false)
+	srv.SetCrashDir(t.TempDir())
+	if err := srv.Start(); err != nil {
+		t.Fatalf("starting server: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c, err := client.Dial(ctx, socket)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	if err := c.Configure(ctx, 2048, 2); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	if err := c.StartVM(ctx, Session, "", 2, 2, ""); err != nil {
+		t.Fatalf("startVM: %v", err)
+	}
+	if running, err := c.IsRunning(ctx, Session); err != nil || !running {
+		t.Fatalf("isRunning after startVM = %v, %v; want true", running, err)
+	}
+	sub, err := c.Subscribe(ctx, client.SubscribeOptions{Name: Session})
+	if err != nil {
+		t.Fatalf("subscribeEvents: %v", err)
+	}
+	defer func() { _ = sub.Close() }()
+
+	spawn(ctx, t, c, target.Command)
+
+	if running, code, err := c.IsProcessRunning(ctx, processID); err != nil || !running || code != 0 {
+		t.Fatalf("isProcessRunning while alive = %v, %d, %v; want true, 0", running, code, err)
+	}
+	checkDeleteRefusesLiveSession(ctx, t, c)
+
+	for _, line := range []string{"one", "two", "exit 3"} {
+		if err := c.WriteStdin(ctx, processID, []byte(line+"\n")); err != nil {
+			t.Fatalf("writeStdin %q: %v", line, err)
+		}
+	}
+	checkEvents(t, sub)
+
+	if running, code, err := c.IsProcessRunning(ctx, processID); err != nil || running || code != exitCode {
+		t.Fatalf("isProcessRunning after exit = %v, %d, %v; want false, %d", running, code, err, exitCode)
+	}
+
+	if target.File != nil {
+		path, want := target.File(t)
+		got, err := c.ReadFile(ctx, Session, path)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("readFile %s = %q, %v; want %q", path, got, err, want)
+		}
+	}
+
+	checkDiskInfo(ctx, t, c)
+	checkPrune(ctx, t, c)
+	checkDeleteAfterExit(ctx, t, c)
+
+	// Desktop kills every process it spawned on teardown, exited or not.
+	if err := c.Kill(ctx, processID, "SIGTERM"); err != nil {
+		t.Fatalf("kill after exit: %v", err)
+	}
+	if err := c.StopVM(ctx, Session); err != nil {
+		t.Fatalf("stopVM: %v", err)
+	}
+	if running, err := c.IsRunning(ctx, Session); err != nil || running {
+		t.Fatalf("isRunning after stopVM = %v, %v; want false", running, err)
+	}
+}
+
+// spawn starts the echo process and checks the raw result shape: Desktop
+// iterates failedMounts, so it must be an array even when nothing failed.
+func spawn(ctx context.Context, t *testing.T, c *client.Client, command string) {
+	t.Helper()
+	params := client.SpawnParams{
+		Name:    Session,
+		ID:      processID,
+		Command: command,
+		Env:     map[string]string{},
+		Cwd:     "/sessions/" + Session,
+	}
+	var raw json.RawMessage
+	if err := c.Call(ctx, "spawn", params, &raw); err != nil {
+		t.Fatalf("spawn: %v", err)
+	}
+	var result struct {
+		ID           string          `json:"id"`
+		FailedMounts json.RawMessage `json:"failedMounts"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		t.Fatalf("spawn result %s: %v", raw, err)
+	}
+	if result.ID != processID {
+		t.Fatalf("spawn id = %q, want %q", result.ID, processID)
+	}
+	if !isArray(result.FailedMounts) {
+		t.Fatalf("spawn failedMounts = %s, want an array", orMissing(result.FailedMounts))
+	}
+}
+
+// checkEvents waits for the process's exit and checks what came before and
+// after it: stdout in write order, exitCode (not the guest's "code"), then
+// nothing more for the process.
+func checkEvents(t *testing.T, sub *client.Subscription) {
+	t.Helper()
+	var stdout strings.Builder
+	deadline := time.After(eventTimeout)
+	for exited := false; !exited; {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("event stream ended before exit: %v", sub.Err())
+			}
+			if process.EventProcessID(ev) != processID {
+				continue
+			}
+			switch ev := ev.(type) {
+			case process.StdoutEvent:
+				stdout.WriteString(ev.Data)
+			case process.ExitEvent:
+				if ev.ExitCode != exitCode {
+					t.Fatalf("exit event %+v, want exitCode %d", ev, exitCode)
+				}
+				exited = true
+			}
+		case <-deadline:
+			t.Fatalf("no exit event within %s (stdout so far %q)", eventTimeout, stdout.String())
+		}
+	}
+	if got := stdout.String(); got != "one\ntwo\n" {
+		t.Fatalf("stdout before exit = %q, want %q", got, "one\ntwo\n")
+	}
+
+	quiet := time.After(300 * time.Millisecond)
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("event stream ended after exit: %v", sub.Err())
+			}
+			if process.EventProcessID(ev) == processID {
+				t.Fatalf("event after exit: %#v", ev)
+			}
+		case <-quiet:
+			return
+		}
+	}
+}
+
+func checkDiskInfo(ctx context.Context, t *testing.T, c *client.Client) {
+	t.Helper()
+	var raw json.RawMessage
+	if err := c.Call(ctx, "getSessionsDiskInfo", map[string]int64{"lowWaterBytes": 0}, &raw); err != nil {
+		t.Fatalf("getSessionsDiskInfo: %v", err)
+	}
+	var info struct {
+		TotalBytes int64           `json:"totalBytes"`
+		FreeBytes  int64           `json:"freeBytes"`
+		Sessions   json.RawMessage `json:"sessions"`
+	}
+	if err := json.Unmarshal(raw, &info); err != nil {
+		t.Fatalf("getSessionsDiskInfo result %s: %v", raw, err)
+	}
+	if info.FreeBytes < 0 || info.TotalBytes < info.FreeBytes {
+		t.Fatalf("getSessionsDiskInfo total=%d free=%d, want total >= free >= 0", info.TotalBytes, info.FreeBytes)
+	}
+	if !isArray(info.Sessions) {
+		t.Fatalf("getSessionsDiskInfo sessions = %s, want an array", orMissing(info.Sessions))
+	}
+}
+
+func checkPrune(ctx context.Context, t *testing.T, c *client.Client) {
+	t.Helper()
+	params := map[string]interface{}{"onlyIfFreeBytesBelow": 0, "includeSessionTmp": true, "sessionTmpOlderThanSeconds": 0}
+	var raw json.RawMessage
+	if err := c.Call(ctx, "pruneSessionCaches", params, &raw); err != nil {
+		t.Fatalf("pruneSessionCaches: %v", err)
+	}
+	var result struct {
+		Pruned     json.RawMessage `json:"prunedSessions"`
+		Skipped    json.RawMessage `json:"skippedSessions"`
+		FreedBytes int64           `json:"freedBytes"`
+		Errors     json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		t.Fatalf("pruneSessionCaches result %s: %v", raw, err)
+	}
+	if !isArray(result.Pruned) || !isArray(result.Skipped) || !isObject(result.Errors) {
+		t.Fatalf("pruneSessionCaches = %s, want prunedSessions/skippedSessions arrays and an errors object", raw)
+	}
+	if result.FreedBytes < 0 {
+		t.Fatalf("pruneSessionCaches freedBytes = %d", result.FreedBytes)
+	}
+	var pruned, skipped []string
+	_ = json.Unmarshal(result.Pruned, &pruned)
+	_ = json.Unmarshal(result.Skipped, &skipped)
+	for _, name := range pruned {
+		if contains(skipped, name) {
+			t.Fatalf("pruneSessionCaches reports %q both pruned and skipped", name)
+		}
+	}
+}
+
+// checkDeleteRefusesLiveSession: a session with a live process is reported
+// in errors and left alone.
+func checkDeleteRefusesLiveSession(ctx context.Context, t *testing.T, c *client.Client) {
+	t.Helper()
+	deleted, errs := deleteSession(ctx, t, c)
+	if contains(deleted, Session) || errs[Session] == "" {
+		t.Fatalf("deleteSessionDirs with a live process = deleted %q, errors %q; want an error for %q", deleted, errs, Session)
+	}
+}
+
+// checkDeleteAfterExit: every name lands in exactly one of deleted and
+// errors, and an idle session is deleted.
+func checkDeleteAfterExit(ctx context.Context, t *testing.T, c *client.Client) {
+	t.Helper()
+	deleted, errs := deleteSession(ctx, t, c)
+	if !contains(deleted, Session) || errs[Session] != "" {
+		t.Fatalf("deleteSessionDirs after exit = deleted %q, errors %q; want %q deleted", deleted, errs, Session)
+	}
+}
+
+func deleteSession(ctx context.Context, t *testing.T, c *client.Client) ([]string, map[string]string) {
+	t.Helper()
+	var raw json.RawMessage
+	if err := c.Call(ctx, "deleteSessionDirs", map[string][]string{"names": {Session}}, &raw); err != nil {
+		t.Fatalf("deleteSessionDirs: %v", err)
+	}
+	var result struct {
+		Deleted json.RawMessage `json:"deleted"`
+		Errors  json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		t.Fatalf("deleteSessionDirs result %s: %v", raw, err)
+	}
+	if !isArray(result.Deleted) || !isObject(result.Errors) {
+		t.Fatalf("deleteSessionDirs = %s, want a deleted array and an errors object", raw)
+	}
+	var deleted []string
+	var errs map[string]string
+	if err := errors.Join(json.Unmarshal(result.Deleted, &deleted), json.Unmarshal(result.Errors, &errs)); err != nil {
+		t.Fatalf("deleteSessionDirs result %s: %v", raw, err)
+	}
+	if contains(deleted, Session) && errs[Session] != "" {
+		t.Fatalf("deleteSessionDirs reports %q both deleted and failed", Session)
+	}
+	return deleted, errs
+}
+
+func isArray(raw json.RawMessage) bool {
+	return len(raw) > 0 && raw[0] == '['
+}
+
+func isObject(raw json.RawMessage) bool {
+	return len(raw) > 0 && raw[0] == '{'
+}
+
+func orMissing(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return "missing"
+	}
+	return string(raw)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
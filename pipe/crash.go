@@ -0,0 +1,299 @@
+package pipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+// Circuit breaker tuning: a method that panics breakerThreshold times
+// within breakerWindow is failed fast for breakerCooldown. The first call
+// after the cooldown is let through; if it succeeds the breaker closes, if
+// it panics again the breaker reopens immediately.
+const (
+	breakerThreshold = 3
+	breakerWindow    = 5 * time.Minute
+	breakerCooldown  = time.Minute
+)
+
+// crashKeep bounds how many crash reports are kept; older ones are removed
+// when a new one is written.
+const crashKeep = 50
+
+// snapshotTimeout bounds how long a crash report waits for the backend to
+// describe itself. A panic can leave a backend mutex locked, so the status
+// calls may never return.
+const snapshotTimeout = 2 * time.Second
+
+// EventPublisher is implemented by backends that let the RPC layer push an
+// event to subscribers (used to surface handler panics as error events).
+type EventPublisher interface {
+	PublishEvent(event interface{})
+}
+
+// CrashReport is the JSON document written for every recovered panic.
+type CrashReport struct {
+	Time       time.Time       `json:"time"`
+	PID        int             `json:"pid"`
+	GoVersion  string          `json:"goVersion"`
+	Method     string          `json:"method"`
+	RequestID  interface{}     `json:"requestId,omitempty"`
+	Params     json.RawMessage `json:"params,omitempty"`
+	Panic      string          `json:"panic"`
+	Stack      string          `json:"stack"`
+	Goroutines int             `json:"goroutines"`
+	Backend    *BackendStatus  `json:"backend,omitempty"`
+	Processes  []ProcessInfo   `json:"processes,omitempty"`
+	// SnapshotError explains a missing Backend/Processes section.
+	SnapshotError string `json:"snapshotError,omitempty"`
+}
+
+// DefaultCrashDir is $XDG_STATE_HOME/claude-cowork/crashes, falling back
+// to ~/.local/state/claude-cowork/crashes.
+func DefaultCrashDir() string {
+	return filepath.Join(stateDir(), "crashes")
+}
+
+// CrashGuard holds the per-method circuit breakers and writes crash
+// reports. One guard is shared by every connection of a Server, so a
+// method that keeps panicking is tripped no matter which connection calls
+// it.
+type CrashGuard struct {
+	dir string
+	now func() time.Time
+
+	mu      sync.Mutex
+	methods map[string]*breaker
+}
+
+type breaker struct {
+	panics    []time.Time // within breakerWindow
+	openUntil time.Time   // zero while closed
+}
+
+// NewCrashGuard returns a guard writing reports into dir. The directory is
+// created on the first report.
+func NewCrashGuard(dir string) *CrashGuard {
+	return &CrashGuard{dir: dir, now: time.Now, methods: make(map[string]*breaker)}
+}
+
+// allow reports whether method may run, and if not, how long until the
+// breaker lets a trial call through.
+func (g *CrashGuard) allow(method string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b := g.methods[method]
+	if b == nil {
+		return 0, true
+	}
+	if wait := b.openUntil.Sub(g.now()); wait > 0 {
+		return wait, false
+	}
+	return 0, true
+}
+
+// succeeded closes a breaker whose trial call after the cooldown went
+// through. Successes while closed don't clear the panic history, so a
+// method that panics intermittently still trips.
+func (g *CrashGuard) succeeded(method string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if b := g.methods[method]; b != nil && !b.openUntil.IsZero() {
+		delete(g.methods, method)
+	}
+}
+
+// panicked records a panic in method and reports whether the breaker is
+// now open.
+func (g *CrashGuard) panicked(method string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	b := g.methods[method]
+	if b == nil {
+		b = &breaker{}
+		g.methods[method] = b
+	}
+	if !b.openUntil.IsZero() {
+		// The trial call after a cooldown failed: reopen straight away.
+		b.openUntil = now.Add(breakerCooldown)
+		return true
+	}
+	kept := b.panics[:0]
+	for _, t := range b.panics {
+		if now.Sub(t) < breakerWindow {
+			kept = append(kept, t)
+		}
+	}
+	b.panics = append(kept, now)
+	if len(b.panics) >= breakerThreshold {
+		b.openUntil = now.Add(breakerCooldown)
+		return true
+	}
+	return false
+}
+
+// writeReport stores report as crash-<time>-<method>.json and prunes old
+// reports. It returns the file path.
+func (g *CrashGuard) writeReport(report CrashReport) (string, error) {
+	if err := os.MkdirAll(g.dir, 0700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("crash-%s-%s.json",
+		report.Time.UTC().Format("20060102T150405.000000000"), sanitizeMethod(report.Method))
+	path := filepath.Join(g.dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	g.prune()
+	return path, nil
+}
+
+// prune removes the oldest reports beyond crashKeep. Names sort by time.
+func (g *CrashGuard) prune() {
+	entries, err := os.ReadDir(g.dir)
+	if err != nil {
+		return
+	}
+	var reports []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "crash-") && strings.HasSuffix(e.Name(), ".json") {
+			reports = append(reports, e.Name())
+		}
+	}
+	sort.Strings(reports)
+	for len(reports) > crashKeep {
+		if err := os.Remove(filepath.Join(g.dir, reports[0])); err != nil {
+			log.Printf("Removing old crash report %s: %v", reports[0], err)
+		}
+		reports = reports[1:]
+	}
+}
+
+// sanitizeMethod keeps method names safe to use in a file name.
+func sanitizeMethod(m string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_':
+			return r
+		}
+		return '-'
+	}, m)
+}
+
+// recoverPanic is deferred by Handle. It turns a panic into a -32603
+// response, a crash report and an error event, and feeds the method's
+// circuit breaker. Without a guard (handlers built outside a Server) only
+// the response and event are produced.
+func (h *Handler) recoverPanic(conn net.Conn, req Request) {
+	r := recover()
+	if r == nil {
+		if h.guard != nil {
+			h.guard.succeeded(req.Method)
+		}
+		return
+	}
+	stack := debug.Stack()
+	rpcPanics.With(req.Method).Inc()
+	log.Printf("PANIC in %s (id=%v): %v\n%s", req.Method, req.ID, r, stack)
+
+	msg := fmt.Sprintf("internal error in %s: %v", req.Method, r)
+	var errData interface{}
+	if h.guard != nil {
+		if h.guard.panicked(req.Method) {
+			log.Printf("%s panicked %d times within %s; failing it fast for %s",
+				req.Method, breakerThreshold, breakerWindow, breakerCooldown)
+		}
+		report := CrashReport{
+			Time:       time.Now(),
+			PID:        os.Getpid(),
+			GoVersion:  runtime.Version(),
+			Method:     req.Method,
+			RequestID:  req.ID,
+			Panic:      fmt.Sprint(r),
+			Stack:      string(stack),
+			Goroutines: runtime.NumGoroutine(),
+		}
+		if len(req.Params) > 0 {
+			if p, err := RedactJSON(req.Params); err == nil {
+				report.Params = p
+			}
+		}
+		report.Backend, report.Processes, report.SnapshotError = snapshotBackend(h.backend)
+		if path, err := h.guard.writeReport(report); err != nil {
+			log.Printf("Writing crash report: %v", err)
+		} else {
+			log.Printf("Crash report written to %s", path)
+			errData = map[string]string{"crashReport": path}
+		}
+	}
+
+	if pub, ok := h.backend.(EventPublisher); ok {
+		pub.PublishEvent(process.NewErrorEvent(processIDFromParams(req.Params), msg, false))
+	}
+	writeError(conn, req.ID, CodeInternalError, msg, errData)
+}
+
+// snapshotBackend asks the backend for its status and process list, giving
+// up after snapshotTimeout.
+func snapshotBackend(backend VMBackend) (*BackendStatus, []ProcessInfo, string) {
+	type snapshot struct {
+		status *BackendStatus
+		procs  []ProcessInfo
+		err    string
+	}
+	done := make(chan snapshot, 1)
+	go func() {
+		var s snapshot
+		defer func() {
+			if r := recover(); r != nil {
+				s.err = fmt.Sprintf("backend snapshot panicked: %v", r)
+			}
+			done <- s
+		}()
+		if r, ok := backend.(StatusReporter); ok {
+			st := r.Status()
+			s.status = &st
+		}
+		if l, ok := backend.(ProcessLister); ok {
+			s.procs = l.ListProcesses()
+		}
+	}()
+	select {
+	case s := <-done:
+		return s.status, s.procs, s.err
+	case <-time.After(snapshotTimeout):
+		return nil, nil, fmt.Sprintf("backend did not answer within %s (lock held by the panicking call?)", snapshotTimeout)
+	}
+}
+
+// processIDFromParams picks the process a request was about, so the error
+// event can be attributed to it. Empty when the method isn't per-process.
+func processIDFromParams(params json.RawMessage) string {
+	var p struct {
+		ID        string `json:"id"`
+		ProcessID string `json:"processId"`
+	}
+	if len(params) == 0 || json.Unmarshal(params, &p) != nil {
+		return ""
+	}
+	if p.ProcessID != "" {
+		return p.ProcessID
+	}
+	return p.ID
+}
@@ -0,0 +1,176 @@
+package pipe
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+// panicBackend dereferences nil in isProcessRunning until healed, and
+// records the events the handler publishes.
+type panicBackend struct {
+	recordingBackend
+	calls  atomic.Int32
+	healed atomic.Bool
+	events chan interface{}
+}
+
+func (b *panicBackend) IsProcessRunning(processID string) (bool, int, error) {
+	b.calls.Add(1)
+	if b.healed.Load() {
+		return true, 0, nil
+	}
+	var info *ProcessInfo
+	return info.Running, 0, nil
+}
+
+func (b *panicBackend) PublishEvent(event interface{}) { b.events <- event }
+
+func (b *panicBackend) Status() BackendStatus {
+	return BackendStatus{Backend: "panicky", Running: true}
+}
+
+func callIsProcessRunning(t *testing.T, h *Handler, params interface{}) Response {
+	t.Helper()
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go h.Handle(server, mustRawJSON(t, Request{Method: "isProcessRunning", Params: mustRawJSON(t, params), ID: 1}))
+	payload, err := ReadMessage(client)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return resp
+}
+
+func TestHandlerRecoversPanicWithReportAndEvent(t *testing.T) {
+	dir := t.TempDir()
+	backend := &panicBackend{events: make(chan interface{}, 4)}
+	h := NewHandler(backend, false)
+	h.guard = NewCrashGuard(dir)
+
+	resp := callIsProcessRunning(t, h, map[string]string{"id": "p7", "authToken": "hunter2"})
+	if resp.Success || resp.Code != CodeInternalError || !strings.Contains(resp.Error, "isProcessRunning") {
+		t.Fatalf("response = %+v, want -32603 for isProcessRunning", resp)
+	}
+
+	select {
+	case ev := <-backend.events:
+		e, ok := ev.(process.ErrorEvent)
+		if !ok || e.ProcessID != "p7" || e.Fatal {
+			t.Fatalf("published %#v, want non-fatal error event for p7", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no error event published")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "crash-*-isProcessRunning.json"))
+	if len(files) != 1 {
+		t.Fatalf("crash reports = %v, want one", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("reading report: %v", err)
+	}
+	var report CrashReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("unmarshal report: %v", err)
+	}
+	if report.Method != "isProcessRunning" || !strings.Contains(report.Stack, "panicBackend") {
+		t.Fatalf("report method/stack = %q / %q", report.Method, report.Stack)
+	}
+	if strings.Contains(string(report.Params), "hunter2") {
+		t.Fatalf("report params not redacted: %s", report.Params)
+	}
+	if report.Backend == nil || report.Backend.Backend != "panicky" {
+		t.Fatalf("report backend = %+v", report.Backend)
+	}
+}
+
+func TestCircuitBreakerFailsFastAndRecovers(t *testing.T) {
+	backend := &panicBackend{events: make(chan interface{}, 16)}
+	h := NewHandler(backend, false)
+	h.guard = NewCrashGuard(t.TempDir())
+	now := time.Now()
+	h.guard.now = func() time.Time { return now }
+
+	for i := 0; i < breakerThreshold; i++ {
+		callIsProcessRunning(t, h, processIDParams{ProcessID: "p1"})
+	}
+	resp := callIsProcessRunning(t, h, processIDParams{ProcessID: "p1"})
+	if resp.Code != CodeInternalError || !strings.Contains(resp.Error, "disabled") {
+		t.Fatalf("tripped response = %+v", resp)
+	}
+	if got := backend.calls.Load(); got != breakerThreshold {
+		t.Fatalf("backend called %d times, want %d (tripped call must not reach it)", got, breakerThreshold)
+	}
+
+	// Other methods are unaffected.
+	server, client := net.Pipe()
+	go h.Handle(server, mustRawJSON(t, Request{Method: "isRunning", ID: 2}))
+	if payload, err := ReadMessage(client); err != nil || !strings.Contains(string(payload), `"success":true`) {
+		t.Fatalf("isRunning = %s, %v", payload, err)
+	}
+	_ = client.Close()
+
+	// After the cooldown a successful trial call closes the breaker.
+	now = now.Add(breakerCooldown + time.Second)
+	backend.healed.Store(true)
+	if resp := callIsProcessRunning(t, h, processIDParams{ProcessID: "p1"}); !resp.Success {
+		t.Fatalf("trial call = %+v", resp)
+	}
+	if _, ok := h.guard.allow("isProcessRunning"); !ok {
+		t.Fatalf("breaker still open after successful trial")
+	}
+}
+
+func TestCrashGuardPrunesOldReports(t *testing.T) {
+	dir := t.TempDir()
+	g := NewCrashGuard(dir)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < crashKeep+3; i++ {
+		if _, err := g.writeReport(CrashReport{Time: start.Add(time.Duration(i) * time.Second), Method: "spawn"}); err != nil {
+			t.Fatalf("writeReport: %v", err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "crash-*.json"))
+	if len(files) != crashKeep {
+		t.Fatalf("kept %d reports, want %d", len(files), crashKeep)
+	}
+	if strings.Contains(strings.Join(files, " "), "20260101T000000.") {
+		t.Fatalf("oldest report was not pruned")
+	}
+}
+
+// TestReplayKeepsCrashReportsOutOfStateDir checks that a panic during a
+// replay doesn't leave a crash report among the daemon's own.
+func TestReplayKeepsCrashReportsOutOfStateDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	records, err := ReadCapture(strings.NewReader(
+		`{"ts":"2026-01-01T00:00:00Z","conn":1,"dir":"in","msg":{"method":"isProcessRunning","id":1,"params":{"id":"p1"}}}` + "\n" +
+			`{"ts":"2026-01-01T00:00:00Z","conn":1,"dir":"out","msg":{"id":1,"success":true,"result":{"running":true}}}`))
+	if err != nil {
+		t.Fatalf("ReadCapture: %v", err)
+	}
+	backend := &panicBackend{events: make(chan interface{}, 4)}
+	res, err := Replay(records, backend, ReplayOptions{Timeout: 2 * time.Second, Settle: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if backend.calls.Load() != 1 || len(res.Mismatches) != 1 {
+		t.Fatalf("calls = %d, mismatches = %q, want the panic reported", backend.calls.Load(), res.Mismatches)
+	}
+	if entries, err := os.ReadDir(DefaultCrashDir()); !os.IsNotExist(err) {
+		t.Fatalf("crash dir %s holds %v (%v), want it untouched", DefaultCrashDir(), entries, err)
+	}
+}
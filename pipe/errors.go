@@ -0,0 +1,63 @@
+package pipe
+
+import (
+	"errors"
+	"net"
+)
+
+// RPC error codes. The JSON-RPC reserved range covers protocol failures;
+// -32001 and below are backend conditions a client can act on without
+// matching message strings.
+const (
+	CodeParseError        = -32700
+	CodeMethodNotFound    = -32601
+	CodeInvalidParams     = -32602
+	CodeInternalError     = -32603 // handler panicked, or its circuit breaker is open
+	CodeBackendError      = -32000 // anything not covered by a code below
+	CodeProcessNotFound   = -32001
+	CodeBackpressure      = -32002 // Desktop (since v1.8555.2) retries writeStdin on it
+	CodeGuestNotConnected = -32003
+	CodeVMNotStarted      = -32004
+	CodeMountFailed       = -32005
+	CodeSessionBusy       = -32006 // session has live processes on the daemon's other backend
+)
+
+// Error is a backend error with a stable wire code and optional structured
+// data for the client. Backends return one of the sentinels below, usually
+// wrapped with detail (fmt.Errorf("%w: %s", pipe.ErrProcessNotFound, id)),
+// or build their own *Error when they have Data to attach.
+type Error struct {
+	Code    int
+	Message string
+	Data    interface{}
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Is reports whether target is an *Error with the same code, so
+// errors.Is(err, ErrMountFailed) matches a custom *Error carrying Data.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrProcessNotFound   = &Error{Code: CodeProcessNotFound, Message: "process not found"}
+	ErrBackpressure      = &Error{Code: CodeBackpressure, Message: "stdin queue full, retry later"}
+	ErrGuestNotConnected = &Error{Code: CodeGuestNotConnected, Message: "guest not connected"}
+	ErrVMNotStarted      = &Error{Code: CodeVMNotStarted, Message: "VM not started"}
+	ErrMountFailed       = &Error{Code: CodeMountFailed, Message: "mount failed"}
+	ErrSessionBusy       = &Error{Code: CodeSessionBusy, Message: "session in use by another backend"}
+)
+
+// writeBackendError sends err with the code of the *Error it wraps, or
+// CodeBackendError for plain errors. The message is always err.Error(), so
+// wrapped detail reaches the client.
+func writeBackendError(conn net.Conn, id interface{}, err error) {
+	var perr *Error
+	if errors.As(err, &perr) {
+		writeError(conn, id, perr.Code, err.Error(), perr.Data)
+		return
+	}
+	WriteError(conn, id, CodeBackendError, err.Error())
+}
@@ -0,0 +1,103 @@
+package pipe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// killErrBackend fails kill with a configurable error.
+type killErrBackend struct {
+	recordingBackend
+	err error
+}
+
+func (b *killErrBackend) Kill(processID string, signal string) error { return b.err }
+
+func TestWriteBackendErrorCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantMsg  string
+		wantData string
+	}{
+		{
+			name:     "wrapped sentinel",
+			err:      fmt.Errorf("%w: p1", ErrProcessNotFound),
+			wantCode: CodeProcessNotFound,
+			wantMsg:  "process not found: p1",
+		},
+		{
+			name:     "custom error with data",
+			err:      &Error{Code: CodeMountFailed, Message: "vfs bind for work failed", Data: map[string]string{"mountName": "work"}},
+			wantCode: CodeMountFailed,
+			wantMsg:  "vfs bind for work failed",
+			wantData: `{"mountName":"work"}`,
+		},
+		{
+			name:     "plain error",
+			err:      errors.New("boom"),
+			wantCode: CodeBackendError,
+			wantMsg:  "boom",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer func() { _ = client.Close() }()
+			go writeBackendError(server, 1, tc.err)
+
+			payload, err := ReadMessage(client)
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			var resp struct {
+				Success bool            `json:"success"`
+				Error   string          `json:"error"`
+				Code    int             `json:"code"`
+				Data    json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(payload, &resp); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if resp.Success || resp.Code != tc.wantCode || resp.Error != tc.wantMsg || string(resp.Data) != tc.wantData {
+				t.Fatalf("response = %s", payload)
+			}
+		})
+	}
+}
+
+func TestErrorIsMatchesByCode(t *testing.T) {
+	custom := &Error{Code: CodeMountFailed, Message: "bind failed", Data: "x"}
+	if !errors.Is(fmt.Errorf("spawn: %w", custom), ErrMountFailed) {
+		t.Fatalf("custom mount error does not match ErrMountFailed")
+	}
+	if errors.Is(custom, ErrVMNotStarted) {
+		t.Fatalf("mount error matched ErrVMNotStarted")
+	}
+}
+
+func TestHandlerMapsBackendErrors(t *testing.T) {
+	handler := NewHandler(&killErrBackend{err: fmt.Errorf("%w: p9", ErrProcessNotFound)}, false)
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go handler.Handle(server, mustRawJSON(t, Request{
+		Method: "kill",
+		Params: mustRawJSON(t, killParams{ProcessID: "p9"}),
+		ID:     3,
+	}))
+	payload, err := ReadMessage(client)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if resp.Code != CodeProcessNotFound {
+		t.Fatalf("kill response = %s, want code %d", payload, CodeProcessNotFound)
+	}
+}
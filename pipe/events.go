@@ -0,0 +1,379 @@
+package pipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+// OverflowPolicy decides what happens when a subscriber's event queue is
+// full because the client is not reading fast enough.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop discards the event and, as soon as the writer makes
+	// progress, tells the client with an "error" event how many were lost.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowDisconnect closes the subscription connection so the client
+	// reconnects instead of silently missing output.
+	OverflowDisconnect
+)
+
+func (p OverflowPolicy) String() string {
+	if p == OverflowDisconnect {
+		return "disconnect"
+	}
+	return "drop"
+}
+
+// ParseOverflowPolicy parses the -event-overflow flag.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "drop":
+		return OverflowDrop, nil
+	case "disconnect":
+		return OverflowDisconnect, nil
+	default:
+		return OverflowDrop, fmt.Errorf("unknown event overflow policy %q (expected drop or disconnect)", s)
+	}
+}
+
+// DefaultEventQueueSize is the per-subscriber queue length used unless
+// ConfigureEvents says otherwise. A busy Claude session emits a few hundred
+// stream-json lines per turn, so this rides out a stalled renderer for a
+// while before the overflow policy kicks in.
+const DefaultEventQueueSize = 1024
+
+var (
+	eventQueueSize atomic.Int64
+	eventOverflow  atomic.Int64
+)
+
+func init() {
+	eventQueueSize.Store(DefaultEventQueueSize)
+}
+
+// ConfigureEvents sets the queue size and overflow policy for event
+// subscriptions created from now on. Call once at startup.
+func ConfigureEvents(queueSize int, policy OverflowPolicy) {
+	if queueSize <= 0 {
+		queueSize = DefaultEventQueueSize
+	}
+	eventQueueSize.Store(int64(queueSize))
+	eventOverflow.Store(int64(policy))
+}
+
+// EncodedEvent is what EventBus hands to subscriber callbacks: the event
+// marshaled once at publish time, plus its type for logging and metrics and
+// the sequence number stamped into it (0 for synthetic overflow notices).
+type EncodedEvent struct {
+	Seq  uint64
+	Type string
+	JSON json.RawMessage
+}
+
+// EventReplayer is implemented by backends that keep recent events so a
+// client whose subscribeEvents connection dropped can resubscribe with the
+// last seq it saw and receive what it missed before live delivery resumes.
+type EventReplayer interface {
+	SubscribeEventsSince(name string, sinceSeq uint64, callback func(event interface{})) (func(), error)
+}
+
+// Replay buffer sizes. Each process keeps its own ring so a chatty process
+// cannot push another's exit event out; events without a process id (VM
+// lifecycle, network, reachability) share one more ring.
+const (
+	replayPerProcess = 512
+	replayGlobal     = 128
+	replayProcesses  = 64
+)
+
+// eventRing is a fixed-capacity FIFO of encoded events.
+type eventRing struct {
+	buf   []EncodedEvent
+	start int
+	n     int
+}
+
+func newEventRing(size int) *eventRing {
+	return &eventRing{buf: make([]EncodedEvent, size)}
+}
+
+// push appends enc and returns the event it overwrote, if any.
+func (r *eventRing) push(enc EncodedEvent) (EncodedEvent, bool) {
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = enc
+		r.n++
+		return EncodedEvent{}, false
+	}
+	old := r.buf[r.start]
+	r.buf[r.start] = enc
+	r.start = (r.start + 1) % len(r.buf)
+	return old, true
+}
+
+func (r *eventRing) last() uint64 {
+	if r.n == 0 {
+		return 0
+	}
+	return r.buf[(r.start+r.n-1)%len(r.buf)].Seq
+}
+
+func (r *eventRing) since(seq uint64, out []EncodedEvent) []EncodedEvent {
+	for i := 0; i < r.n; i++ {
+		if enc := r.buf[(r.start+i)%len(r.buf)]; enc.Seq > seq {
+			out = append(out, enc)
+		}
+	}
+	return out
+}
+
+// eventOverflowed is passed to a subscriber callback when OverflowDisconnect
+// trips. It arrives on its own goroutine, possibly while the writer is still
+// stuck in a write, so the handler can close the connection to unblock it.
+type eventOverflowed struct{}
+
+// EventBus fans backend events out to subscribers. Each subscriber gets a
+// bounded FIFO queue drained by one goroutine, so events reach a client in
+// the order they were published (stdout before the matching exit) and a
+// slow client can never block the publisher.
+//
+// Every event is stamped with a "seq" field, increasing across the bus's
+// lifetime, and kept in a replay buffer for SubscribeSince.
+type EventBus struct {
+	mu     sync.RWMutex
+	subs   map[uint64]*eventSub
+	nextID uint64
+
+	seq     uint64
+	global  *eventRing
+	procs   map[string]*eventRing
+	evicted uint64 // highest seq no longer in any ring
+}
+
+// NewEventBus creates an empty bus.
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs:   make(map[uint64]*eventSub),
+		global: newEventRing(replayGlobal),
+		procs:  make(map[string]*eventRing),
+	}
+}
+
+type eventSub struct {
+	cb      func(event interface{})
+	queue   chan EncodedEvent
+	stop    chan struct{}
+	once    sync.Once
+	policy  OverflowPolicy
+	dropped atomic.Int64
+	tripped atomic.Bool
+}
+
+// Subscribe registers callback and starts its writer goroutine. The returned
+// cancel func stops delivery; events still queued are discarded.
+func (b *EventBus) Subscribe(callback func(event interface{})) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subscribeLocked(callback, nil)
+}
+
+// SubscribeSince is Subscribe preceded by a replay of every buffered event
+// with a seq above sinceSeq, in seq order. A sinceSeq beyond the latest seq
+// (the daemon restarted under the client) replays everything buffered. If
+// some of the requested events were already evicted, the replay starts with
+// a non-fatal "error" event saying so.
+func (b *EventBus) SubscribeSince(sinceSeq uint64, callback func(event interface{})) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sinceSeq > b.seq {
+		sinceSeq = 0
+	}
+	backlog := b.global.since(sinceSeq, nil)
+	for _, r := range b.procs {
+		backlog = r.since(sinceSeq, backlog)
+	}
+	sort.Slice(backlog, func(i, j int) bool { return backlog[i].Seq < backlog[j].Seq })
+	if b.evicted > sinceSeq {
+		msg := fmt.Sprintf("event replay incomplete: events up to seq %d are no longer buffered", b.evicted)
+		data, _ := json.Marshal(process.NewErrorEvent("", msg, false))
+		backlog = append([]EncodedEvent{{Type: "error", JSON: data}}, backlog...)
+	}
+	return b.subscribeLocked(callback, backlog)
+}
+
+func (b *EventBus) subscribeLocked(callback func(event interface{}), backlog []EncodedEvent) func() {
+	s := &eventSub{
+		cb:     callback,
+		queue:  make(chan EncodedEvent, int(eventQueueSize.Load())+len(backlog)),
+		stop:   make(chan struct{}),
+		policy: OverflowPolicy(eventOverflow.Load()),
+	}
+	for _, enc := range backlog {
+		s.queue <- enc
+	}
+	b.nextID++
+	id := b.nextID
+	b.subs[id] = s
+
+	go s.run()
+
+	return func() {
+		b.mu.Lock()
+		delete(b.subs, id)
+		b.mu.Unlock()
+		s.close()
+	}
+}
+
+// Len returns the number of live subscriptions.
+func (b *EventBus) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
+
+// Publish marshals event once, stamps it with the next seq, buffers it for
+// replay and queues it for every subscriber. It never blocks on a
+// subscriber; a full queue is handled by the overflow policy.
+func (b *EventBus) Publish(event interface{}) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Dropping unmarshalable event %T: %v", event, err)
+		return
+	}
+	typ, procID := process.EventType(event), process.EventProcessID(event)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	enc := EncodedEvent{Seq: b.seq, Type: typ, JSON: withSeq(data, b.seq)}
+	b.remember(procID, enc)
+	for _, s := range b.subs {
+		s.offer(enc)
+	}
+}
+
+// Healthy reports an error when the bus lock cannot be taken within
+// timeout, meaning a publish or subscribe is wedged and no event is moving.
+func (b *EventBus) Healthy(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !b.mu.TryLock() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("event fan-out blocked for over %s", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// LastSeq returns the seq of the most recently published event.
+func (b *EventBus) LastSeq() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.seq
+}
+
+func (b *EventBus) remember(procID string, enc EncodedEvent) {
+	ring := b.global
+	if procID != "" {
+		ring = b.procs[procID]
+		if ring == nil {
+			if len(b.procs) >= replayProcesses {
+				b.evictOldestProcess()
+			}
+			ring = newEventRing(replayPerProcess)
+			b.procs[procID] = ring
+		}
+	}
+	if old, overwrote := ring.push(enc); overwrote && old.Seq > b.evicted {
+		b.evicted = old.Seq
+	}
+}
+
+// evictOldestProcess drops the ring of the process that has been quiet the
+// longest, which is almost always one that exited long ago.
+func (b *EventBus) evictOldestProcess() {
+	var (
+		oldestID  string
+		oldestSeq uint64
+	)
+	for id, r := range b.procs {
+		if oldestID == "" || r.last() < oldestSeq {
+			oldestID, oldestSeq = id, r.last()
+		}
+	}
+	delete(b.procs, oldestID)
+	if oldestSeq > b.evicted {
+		b.evicted = oldestSeq
+	}
+}
+
+// withSeq adds "seq":n as the first member of a JSON object. Non-object
+// events are left alone.
+func withSeq(data []byte, n uint64) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	out := make([]byte, 0, len(data)+24)
+	out = append(out, `{"seq":`...)
+	out = strconv.AppendUint(out, n, 10)
+	if rest := data[1:]; len(rest) > 0 && rest[0] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, data[1:]...)
+}
+
+func (s *eventSub) offer(enc EncodedEvent) {
+	if s.tripped.Load() {
+		return
+	}
+	select {
+	case s.queue <- enc:
+		return
+	default:
+	}
+	eventsDropped.With(s.policy.String()).Inc()
+	if s.policy == OverflowDisconnect {
+		if s.tripped.CompareAndSwap(false, true) {
+			log.Printf("Event queue full (%d), disconnecting subscriber", cap(s.queue))
+			s.close()
+			go s.cb(eventOverflowed{})
+		}
+		return
+	}
+	if s.dropped.Add(1) == 1 {
+		log.Printf("Event queue full (%d), dropping events for slow subscriber", cap(s.queue))
+	}
+}
+
+func (s *eventSub) close() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// run is the subscriber's single writer: it delivers queued events in order
+// and, after each one, reports any events dropped since the last report.
+func (s *eventSub) run() {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case enc := <-s.queue:
+			s.cb(enc)
+		}
+		if n := s.dropped.Swap(0); n > 0 {
+			msg := fmt.Sprintf("event queue overflow: %d events dropped", n)
+			data, _ := json.Marshal(process.NewErrorEvent("", msg, false))
+			s.cb(EncodedEvent{Type: "error", JSON: data})
+		}
+	}
+}
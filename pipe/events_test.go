@@ -0,0 +1,272 @@
+package pipe
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+// busBackend publishes through a real EventBus, like native and kvm do.
+type busBackend struct {
+	recordingBackend
+	bus *EventBus
+}
+
+func (b *busBackend) SubscribeEvents(name string, callback func(event interface{})) (func(), error) {
+	return b.bus.Subscribe(callback), nil
+}
+
+func (b *busBackend) SubscribeEventsSince(name string, sinceSeq uint64, callback func(event interface{})) (func(), error) {
+	return b.bus.SubscribeSince(sinceSeq, callback), nil
+}
+
+func withEventConfig(t *testing.T, size int, policy OverflowPolicy) {
+	t.Helper()
+	ConfigureEvents(size, policy)
+	t.Cleanup(func() { ConfigureEvents(DefaultEventQueueSize, OverflowDrop) })
+}
+
+func TestEventBusPreservesOrder(t *testing.T) {
+	bus := NewEventBus()
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	done := make(chan struct{})
+	cancel := bus.Subscribe(func(event interface{}) {
+		enc := event.(EncodedEvent)
+		mu.Lock()
+		got = append(got, string(enc.JSON))
+		n := len(got)
+		mu.Unlock()
+		if n == 501 {
+			close(done)
+		}
+	})
+	defer cancel()
+
+	var want []string
+	for i := 0; i < 500; i++ {
+		ev := process.NewStdoutEvent("p1", strings.Repeat("x", i%7)+"\n")
+		bus.Publish(ev)
+		data, _ := json.Marshal(ev)
+		want = append(want, string(withSeq(data, uint64(i+1))))
+	}
+	exit := process.NewExitEvent("p1", 0)
+	bus.Publish(exit)
+	data, _ := json.Marshal(exit)
+	want = append(want, string(withSeq(data, 501)))
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for events")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("event %d out of order:\n  want %s\n  got  %s", i, want[i], got[i])
+		}
+	}
+}
+
+func TestEventBusDropsAndReportsOverflow(t *testing.T) {
+	withEventConfig(t, 2, OverflowDrop)
+	bus := NewEventBus()
+
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var (
+		mu  sync.Mutex
+		got []EncodedEvent
+	)
+	cancel := bus.Subscribe(func(event interface{}) {
+		enc := event.(EncodedEvent)
+		select {
+		case entered <- struct{}{}:
+			<-release
+		default:
+		}
+		mu.Lock()
+		got = append(got, enc)
+		mu.Unlock()
+	})
+	defer cancel()
+
+	// The first event parks the writer; two more fill the queue and the
+	// remaining seven overflow.
+	bus.Publish(process.NewStdoutEvent("p1", "0"))
+	<-entered
+	for i := 1; i < 10; i++ {
+		bus.Publish(process.NewStdoutEvent("p1", string(rune('0'+i))))
+	}
+	entered <- struct{}{} // later callbacks must not park
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d events, want 4", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	types := []string{got[0].Type, got[1].Type, got[2].Type, got[3].Type}
+	if strings.Join(types, ",") != "stdout,error,stdout,stdout" {
+		t.Fatalf("event types = %v", types)
+	}
+	var errEv process.ErrorEvent
+	if err := json.Unmarshal(got[1].JSON, &errEv); err != nil {
+		t.Fatalf("unmarshal error event: %v", err)
+	}
+	if !strings.Contains(errEv.Message, "7 events dropped") || errEv.Fatal {
+		t.Fatalf("unexpected overflow notice: %+v", errEv)
+	}
+}
+
+func TestSubscribeEventsDisconnectsOnOverflow(t *testing.T) {
+	withEventConfig(t, 2, OverflowDisconnect)
+	backend := &busBackend{bus: NewEventBus()}
+	handler := NewHandler(backend, false)
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		handler.Handle(server, mustRawJSON(t, Request{Method: "subscribeEvents", ID: 1}))
+	}()
+	if _, err := ReadMessage(client); err != nil {
+		t.Fatalf("read ack: %v", err)
+	}
+
+	// The client stops reading: the writer blocks on the first event, the
+	// queue fills, and the next publish must tear the connection down.
+	for i := 0; i < 10; i++ {
+		backend.bus.Publish(process.NewStdoutEvent("p1", "line\n"))
+	}
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("subscribeEvents did not return after queue overflow")
+	}
+	if n := backend.bus.Len(); n != 0 {
+		t.Fatalf("subscription still registered after disconnect: %d", n)
+	}
+}
+
+func TestWithSeq(t *testing.T) {
+	tests := map[string]string{
+		`{"type":"exit","id":"p"}`: `{"seq":7,"type":"exit","id":"p"}`,
+		`{}`:                       `{"seq":7}`,
+		`"bare"`:                   `"bare"`,
+	}
+	for in, want := range tests {
+		if got := string(withSeq([]byte(in), 7)); got != want {
+			t.Errorf("withSeq(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+// collectSince subscribes via SubscribeSince and returns the seqs (or "error"
+// for synthetic notices) of the first n events delivered.
+func collectSince(t *testing.T, bus *EventBus, since uint64, n int) []string {
+	t.Helper()
+	ch := make(chan EncodedEvent, n+16)
+	cancel := bus.SubscribeSince(since, func(event interface{}) {
+		ch <- event.(EncodedEvent)
+	})
+	defer cancel()
+	var out []string
+	for len(out) < n {
+		select {
+		case enc := <-ch:
+			if enc.Seq == 0 {
+				out = append(out, enc.Type)
+			} else {
+				out = append(out, strconv.FormatUint(enc.Seq, 10))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %v, want %d events", out, n)
+		}
+	}
+	return out
+}
+
+func TestEventBusReplaysMissedEventsInOrder(t *testing.T) {
+	bus := NewEventBus()
+	bus.Publish(map[string]string{"type": "vmStarted", "name": "s"}) // 1
+	bus.Publish(process.NewStdoutEvent("p1", "a"))                   // 2
+	bus.Publish(process.NewStdoutEvent("p2", "b"))                   // 3
+	bus.Publish(process.NewNetworkStatusEvent(true))                 // 4
+	bus.Publish(process.NewExitEvent("p1", 0))                       // 5
+	bus.Publish(process.NewExitEvent("p2", 1))                       // 6
+
+	if got := strings.Join(collectSince(t, bus, 2, 4), ","); got != "3,4,5,6" {
+		t.Fatalf("replay after seq 2 = %s", got)
+	}
+	// A seq from before a daemon restart is beyond LastSeq: replay it all.
+	if got := strings.Join(collectSince(t, bus, 99, 6), ","); got != "1,2,3,4,5,6" {
+		t.Fatalf("replay after stale seq = %s", got)
+	}
+}
+
+func TestEventBusReportsEvictedReplay(t *testing.T) {
+	bus := NewEventBus()
+	for i := 0; i < replayPerProcess+10; i++ {
+		bus.Publish(process.NewStdoutEvent("p1", "x"))
+	}
+	got := collectSince(t, bus, 0, 2)
+	if got[0] != "error" || got[1] != "11" {
+		t.Fatalf("replay = %v, want error notice then seq 11", got)
+	}
+}
+
+func TestSubscribeEventsSinceSeq(t *testing.T) {
+	backend := &busBackend{bus: NewEventBus()}
+	backend.bus.Publish(process.NewStdoutEvent("p1", "missed\n"))
+	backend.bus.Publish(process.NewExitEvent("p1", 0))
+
+	handler := NewHandler(backend, false)
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go handler.Handle(server, mustRawJSON(t, Request{
+		Method: "subscribeEvents",
+		Params: mustRawJSON(t, map[string]interface{}{"sinceSeq": 1}),
+		ID:     1,
+	}))
+
+	var msgs []map[string]interface{}
+	for i := 0; i < 2; i++ {
+		payload, err := ReadMessage(client)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(payload, &m); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		msgs = append(msgs, m)
+	}
+	if msgs[0]["success"] != true {
+		t.Fatalf("first message is not the ack: %v", msgs[0])
+	}
+	if msgs[1]["type"] != "exit" || msgs[1]["seq"] != float64(2) {
+		t.Fatalf("replayed event = %v, want exit with seq 2", msgs[1])
+	}
+}
@@ -0,0 +1,353 @@
+package pipe
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/logx"
+)
+
+// GatewaySubprotocol is the WebSocket subprotocol the gateway selects.
+// Browsers can't set Authorization on a WebSocket, so they offer the token
+// as a second subprotocol, "bearer.<token>", alongside this one.
+const GatewaySubprotocol = "cowork"
+
+// gatewayBearerPrefix marks the subprotocol entry carrying the token.
+const gatewayBearerPrefix = "bearer."
+
+// gatewayMaxBody bounds a POST /rpc request, like ReadMessage's limit.
+const gatewayMaxBody = 10 * 1024 * 1024
+
+// DefaultGatewayTokenPath is where the gateway token lives unless
+// -ws-token-file says otherwise.
+func DefaultGatewayTokenPath() string {
+	return filepath.Join(stateDir(), "ws-token")
+}
+
+// Gateway exposes a Server's RPC surface over WebSocket (GET /rpc) and
+// plain HTTP (POST /rpc, one request per call) for clients that can't reach
+// the Unix socket: browser dashboards, dev containers. Every message is the
+// same JSON envelope as on the socket, minus the length prefix. Each
+// WebSocket connection is bridged to the server's own connection loop, so
+// concurrency, the writeStdin ordering lane, capture and crash reports all
+// behave as on the socket; events need a second WebSocket that sends
+// subscribeEvents, exactly like a second socket connection.
+//
+// Every request must carry the token from tokenFile, as
+// "Authorization: Bearer <token>" or the "bearer.<token>" subprotocol. The
+// file is re-read per request, so it can be rotated without a restart.
+type Gateway struct {
+	server    *Server
+	tokenFile string
+
+	mu     sync.Mutex
+	srv    *http.Server
+	conns  map[*wsConn]struct{}
+	closed bool
+}
+
+// NewGateway creates a gateway for server, authenticating against the token
+// in tokenFile. A missing file is created with a random token (mode 0600);
+// an empty one is an error, so the gateway never runs unauthenticated.
+func NewGateway(server *Server, tokenFile string) (*Gateway, error) {
+	if err := ensureGatewayToken(tokenFile); err != nil {
+		return nil, err
+	}
+	return &Gateway{server: server, tokenFile: tokenFile, conns: map[*wsConn]struct{}{}}, nil
+}
+
+// ensureGatewayToken creates tokenFile if needed and checks it is usable.
+func ensureGatewayToken(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return fmt.Errorf("creating token directory: %w", err)
+		}
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return fmt.Errorf("generating token: %w", err)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return fmt.Errorf("creating token file: %w", err)
+		}
+		_, werr := f.WriteString(hex.EncodeToString(buf) + "\n")
+		if cerr := f.Close(); werr == nil {
+			werr = cerr
+		}
+		if werr != nil {
+			return fmt.Errorf("writing token file: %w", werr)
+		}
+		log.Printf("Gateway: generated token in %s", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("gateway token: %w", err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		log.Printf("Warning: gateway token file %s is readable by other users (mode %v)", path, info.Mode().Perm())
+	}
+	if _, err := readGatewayToken(path); err != nil {
+		return err
+	}
+	return nil
+}
+
+func readGatewayToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("gateway token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("gateway token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// Listen serves the gateway on addr ("host:port" or "unix:/path", like the
+// metrics listener) until Close.
+func (g *Gateway) Listen(addr string) error {
+	var (
+		ln  net.Listener
+		err error
+	)
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if rerr := os.Remove(path); rerr != nil && !os.IsNotExist(rerr) {
+			return rerr
+		}
+		ln, err = net.Listen("unix", path)
+		if err == nil {
+			err = os.Chmod(path, 0600)
+			if err != nil {
+				_ = ln.Close()
+			}
+		}
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	g.Serve(ln)
+	return nil
+}
+
+// Serve serves the gateway on ln until Close.
+func (g *Gateway) Serve(ln net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rpc", g.serveRPC)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	g.mu.Lock()
+	g.srv = srv
+	g.mu.Unlock()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Gateway stopped: %v", err)
+		}
+	}()
+}
+
+// Close stops the listener and drops open WebSocket connections.
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	srv := g.srv
+	conns := make([]*wsConn, 0, len(g.conns))
+	for c := range g.conns {
+		conns = append(conns, c)
+	}
+	g.mu.Unlock()
+
+	var err error
+	if srv != nil {
+		err = srv.Close()
+	}
+	for _, c := range conns {
+		_ = c.close(wsCloseNormal)
+	}
+	return err
+}
+
+func (g *Gateway) serveRPC(w http.ResponseWriter, r *http.Request) {
+	subprotocols, token := gatewaySubprotocols(r)
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = strings.TrimSpace(bearer)
+	}
+	if err := g.authorize(token); err != nil {
+		log.Printf("Gateway: rejected %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="cowork"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case isWebSocketUpgrade(r):
+		selected := ""
+		for _, p := range subprotocols {
+			if p == GatewaySubprotocol {
+				selected = p
+			}
+		}
+		ws, err := upgradeWebSocket(w, r, selected)
+		if err != nil {
+			logx.Debug("gateway upgrade from %s: %v", r.RemoteAddr, err)
+			return
+		}
+		g.serveWebSocket(ws, r.RemoteAddr)
+	case r.Method == http.MethodPost:
+		g.servePost(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "use a WebSocket upgrade or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// gatewaySubprotocols splits Sec-WebSocket-Protocol, pulling out the token
+// entry.
+func gatewaySubprotocols(r *http.Request) (protocols []string, token string) {
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			if t, ok := strings.CutPrefix(p, gatewayBearerPrefix); ok {
+				token = t
+				continue
+			}
+			protocols = append(protocols, p)
+		}
+	}
+	return protocols, token
+}
+
+func (g *Gateway) authorize(token string) error {
+	want, err := readGatewayToken(g.tokenFile)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return fmt.Errorf("no bearer token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return fmt.Errorf("wrong bearer token")
+	}
+	return nil
+}
+
+// serveWebSocket bridges one WebSocket to the server's connection loop over
+// an in-memory pipe: each WebSocket message becomes one framed request and
+// each framed response or event becomes one WebSocket message.
+func (g *Gateway) serveWebSocket(ws *wsConn, remote string) {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		_ = ws.close(wsCloseNormal)
+		return
+	}
+	g.conns[ws] = struct{}{}
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.conns, ws)
+		g.mu.Unlock()
+		_ = ws.close(wsCloseNormal)
+	}()
+	if g.server.debug {
+		log.Printf("Gateway client connected: %s", remote)
+	}
+
+	local, daemon := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() { _ = daemon.Close() }()
+		g.server.serveConn(daemon)
+	}()
+
+	go func() {
+		defer func() { _ = local.Close() }()
+		for {
+			msg, err := ws.readMessage()
+			if err != nil {
+				if g.server.debug {
+					log.Printf("Gateway client disconnected: %v", err)
+				}
+				return
+			}
+			if err := WriteMessage(local, msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		msg, err := ReadMessage(local)
+		if err != nil {
+			break
+		}
+		if err := ws.writeMessage(msg); err != nil {
+			break
+		}
+	}
+	_ = local.Close()
+	<-done
+}
+
+// servePost answers a single request. subscribeEvents needs a WebSocket.
+func (g *Gateway) servePost(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, gatewayMaxBody+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > gatewayMaxBody {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var probe struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(body, &probe) == nil && probe.Method == "subscribeEvents" {
+		http.Error(w, "subscribeEvents needs a WebSocket connection", http.StatusBadRequest)
+		return
+	}
+	if len(body) == 0 {
+		http.Error(w, "empty request", http.StatusBadRequest)
+		return
+	}
+
+	local, daemon := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() { _ = daemon.Close() }()
+		g.server.serveConn(daemon)
+	}()
+	defer func() {
+		_ = local.Close()
+		<-done
+	}()
+
+	if err := WriteMessage(local, body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := ReadMessage(local)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(resp)
+}
@@ -0,0 +1,300 @@
+package pipe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventingBackend hands the test the subscribeEvents callback.
+type eventingBackend struct {
+	recordingBackend
+	subscribed chan func(event interface{})
+}
+
+func (b *eventingBackend) SubscribeEvents(name string, callback func(event interface{})) (func(), error) {
+	b.subscribed <- callback
+	return func() {}, nil
+}
+
+const testGatewayToken = "s3cret"
+
+func startGateway(t *testing.T, backend VMBackend) (addr, tokenFile string) {
+	t.Helper()
+	tokenFile = filepath.Join(t.TempDir(), "ws-token")
+	if err := os.WriteFile(tokenFile, []byte(testGatewayToken+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	gw, err := NewGateway(NewServer("", backend, false), tokenFile)
+	if err != nil {
+		t.Fatalf("NewGateway: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	gw.Serve(ln)
+	t.Cleanup(func() { _ = gw.Close() })
+	return ln.Addr().String(), tokenFile
+}
+
+// dialGateway opens a WebSocket to the gateway, passing the token the way
+// a browser would (as a subprotocol), and returns the handshake response.
+func dialGateway(t *testing.T, addr, token string) (*wsConn, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	req := "GET /rpc HTTP/1.1\r\n" +
+		"Host: " + addr + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Protocol: " + GatewaySubprotocol + ", " + gatewayBearerPrefix + token + "\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Sec-WebSocket-Accept = %q", got)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != GatewaySubprotocol {
+		t.Fatalf("Sec-WebSocket-Protocol = %q, want %q", got, GatewaySubprotocol)
+	}
+	return &wsConn{conn: conn, br: br, client: true}, resp
+}
+
+func wsCall(t *testing.T, ws *wsConn, req Request) Response {
+	t.Helper()
+	if err := ws.writeMessage(mustRawJSON(t, req)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = ws.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := ws.readMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(msg, &resp); err != nil {
+		t.Fatalf("unmarshal %s: %v", msg, err)
+	}
+	return resp
+}
+
+func TestGatewayRejectsWrongToken(t *testing.T) {
+	addr, _ := startGateway(t, &recordingBackend{})
+	if _, resp := dialGateway(t, addr, "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", resp.StatusCode)
+	}
+
+	resp, err := http.Post("http://"+addr+"/rpc", "application/json", strings.NewReader(`{"method":"isRunning","id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("POST without token: status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestGatewayRereadsTokenFile(t *testing.T) {
+	addr, tokenFile := startGateway(t, &recordingBackend{})
+	if err := os.WriteFile(tokenFile, []byte("rotated"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, resp := dialGateway(t, addr, testGatewayToken); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("old token: status = %d, want 401", resp.StatusCode)
+	}
+	if ws, resp := dialGateway(t, addr, "rotated"); ws == nil {
+		t.Fatalf("new token: status = %d", resp.StatusCode)
+	}
+}
+
+func TestGatewayWebSocketRPC(t *testing.T) {
+	backend := &recordingBackend{}
+	addr, _ := startGateway(t, backend)
+	ws, resp := dialGateway(t, addr, testGatewayToken)
+	if ws == nil {
+		t.Fatalf("handshake status = %d", resp.StatusCode)
+	}
+
+	got := wsCall(t, ws, Request{Method: "startVM", Params: mustRawJSON(t, map[string]interface{}{"name": "vm1", "bundlePath": "/b"}), ID: 7})
+	if !got.Success || fmt.Sprint(got.ID) != "7" {
+		t.Fatalf("startVM response = %+v", got)
+	}
+	if backend.startName != "vm1" {
+		t.Fatalf("startVM reached backend with name %q", backend.startName)
+	}
+
+	got = wsCall(t, ws, Request{Method: "startVM", Params: mustRawJSON(t, map[string]interface{}{"bundlePath": 3}), ID: 8})
+	if got.Success || got.Code != CodeInvalidParams {
+		t.Fatalf("invalid params response = %+v", got)
+	}
+
+	// A large message uses the 64-bit length form in both directions.
+	big := strings.Repeat("x", 70000)
+	got = wsCall(t, ws, Request{Method: "writeStdin", Params: mustRawJSON(t, writeStdinParams{ProcessID: "p1", Data: big}), ID: 9})
+	if !got.Success {
+		t.Fatalf("writeStdin response = %+v", got)
+	}
+}
+
+func TestGatewayEventsOnSecondWebSocket(t *testing.T) {
+	backend := &eventingBackend{subscribed: make(chan func(event interface{}), 1)}
+	addr, _ := startGateway(t, backend)
+
+	rpc, _ := dialGateway(t, addr, testGatewayToken)
+	events, _ := dialGateway(t, addr, testGatewayToken)
+	if rpc == nil || events == nil {
+		t.Fatal("handshake failed")
+	}
+
+	ack := wsCall(t, events, Request{Method: "subscribeEvents", Params: mustRawJSON(t, map[string]string{"name": "vm1"}), ID: 1})
+	if !ack.Success {
+		t.Fatalf("subscribeEvents ack = %+v", ack)
+	}
+	var emit func(event interface{})
+	select {
+	case emit = <-backend.subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend never saw subscribeEvents")
+	}
+
+	// The subscription stream doesn't block requests on the other socket.
+	if got := wsCall(t, rpc, Request{Method: "isRunning", Params: mustRawJSON(t, map[string]string{"name": "vm1"}), ID: 2}); !got.Success {
+		t.Fatalf("isRunning response = %+v", got)
+	}
+
+	emit(map[string]interface{}{"type": "stdout", "id": "p1", "data": "hello"})
+	_ = events.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg, err := events.readMessage()
+	if err != nil {
+		t.Fatalf("reading event: %v", err)
+	}
+	var ev map[string]interface{}
+	if err := json.Unmarshal(msg, &ev); err != nil || ev["type"] != "stdout" || ev["data"] != "hello" {
+		t.Fatalf("event = %s (%v)", msg, err)
+	}
+}
+
+func TestGatewayPost(t *testing.T) {
+	addr, _ := startGateway(t, &recordingBackend{})
+	post := func(body string) (int, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/rpc", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testGatewayToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	status, body := post(`{"method":"getDownloadStatus","id":"a"}`)
+	var resp Response
+	if status != http.StatusOK || json.Unmarshal(body, &resp) != nil || !resp.Success || resp.ID != "a" || !strings.Contains(string(body), `"Ready"`) {
+		t.Fatalf("POST getDownloadStatus = %d %s", status, body)
+	}
+
+	if status, body := post(`{"method":"subscribeEvents","id":1}`); status != http.StatusBadRequest {
+		t.Fatalf("POST subscribeEvents = %d %s, want 400", status, body)
+	}
+}
+
+func TestGatewayCreatesAndRequiresToken(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "ws-token")
+	if _, err := NewGateway(NewServer("", &recordingBackend{}, false), path); err != nil {
+		t.Fatalf("NewGateway with missing token file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("token file not created: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("token file mode = %v, want 0600", info.Mode().Perm())
+	}
+	if token, err := readGatewayToken(path); err != nil || len(token) != 64 {
+		t.Fatalf("generated token = %q (%v)", token, err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGateway(NewServer("", &recordingBackend{}, false), empty); err == nil {
+		t.Fatal("NewGateway accepted an empty token file")
+	}
+}
+
+func TestWebSocketFragmentsAndPing(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	ws := &wsConn{conn: server, br: bufio.NewReader(server)}
+	peer := &wsConn{conn: client, br: bufio.NewReader(client), client: true}
+
+	var (
+		wg  sync.WaitGroup
+		got []byte
+		err error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		got, err = ws.readMessage()
+	}()
+
+	// "he" + ping + "llo", as text then continuation frames.
+	frames := [][2]interface{}{{byte(wsOpText), "he"}, {byte(wsOpPing), "p"}, {byte(0x80 | wsOpContinuation), "llo"}}
+	for _, f := range frames {
+		op, payload := f[0].(byte), f[1].(string)
+		var buf bytes.Buffer
+		first := op
+		if op == wsOpPing {
+			first |= 0x80
+		}
+		buf.WriteByte(first)
+		buf.WriteByte(0x80 | byte(len(payload)))
+		buf.Write([]byte{0, 0, 0, 0}) // zero mask
+		buf.WriteString(payload)
+		if _, werr := client.Write(buf.Bytes()); werr != nil {
+			t.Fatalf("write frame: %v", werr)
+		}
+		if op == wsOpPing {
+			_, pop, pong, perr := peer.readFrame()
+			if perr != nil || pop != wsOpPong || string(pong) != "p" {
+				t.Fatalf("pong = %#x %q (%v)", pop, pong, perr)
+			}
+		}
+	}
+	wg.Wait()
+	if err != nil || string(got) != "hello" {
+		t.Fatalf("readMessage = %q (%v)", got, err)
+	}
+}
@@ -3,19 +3,26 @@ package pipe
 import (
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net"
 	"path/filepath"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/patrickjaja/claude-cowork-service/logx"
+	"github.com/patrickjaja/claude-cowork-service/process"
 )
 
 // Handler dispatches RPC methods to the VM backend.
 type Handler struct {
 	backend VMBackend
 	debug   bool
+	guard   *CrashGuard     // shared per Server; nil disables reports and the breaker
+	version string          // daemon version reported by getCapabilities
+	unknown *UnknownMethods // nil means UnknownPassthrough
 }
 
 // NewHandler creates a new RPC handler.
@@ -38,98 +45,169 @@ func (h *Handler) Handle(conn net.Conn, payload []byte) {
 		logx.Debug("RPC: %s (id=%v) params: %s", req.Method, req.ID, logx.Trunc(string(req.Params)))
 	}
 
-	switch req.Method {
-	case "configure":
-		h.handleConfigure(conn, req)
-	case "createVM":
-		h.handleCreateVM(conn, req)
-	case "startVM":
-		h.handleStartVM(conn, req)
-	case "stopVM":
-		h.handleStopVM(conn, req)
-	case "isRunning":
-		h.handleIsRunning(conn, req)
-	case "isGuestConnected":
-		h.handleIsGuestConnected(conn, req)
-	case "spawn":
-		h.handleSpawn(conn, req)
-	case "kill":
-		h.handleKill(conn, req)
-	case "writeStdin":
-		h.handleWriteStdin(conn, req)
-	case "isProcessRunning":
-		h.handleIsProcessRunning(conn, req)
-	case "mountPath":
-		h.handleMountPath(conn, req)
-	case "readFile":
-		h.handleReadFile(conn, req)
-	case "installSdk":
-		h.handleInstallSdk(conn, req)
-	case "addApprovedOauthToken":
-		h.handleAddApprovedOauthToken(conn, req)
-	case "setDebugLogging":
-		h.handleSetDebugLogging(conn, req)
-	case "isDebugLoggingEnabled":
-		h.handleIsDebugLoggingEnabled(conn, req)
-	case "subscribeEvents":
-		h.handleSubscribeEvents(conn, req)
-	case "getDownloadStatus":
-		h.handleGetDownloadStatus(conn, req)
-	case "getSessionsDiskInfo":
-		h.handleGetSessionsDiskInfo(conn, req)
-	case "pruneSessionCaches":
-		h.handlePruneSessionCaches(conn, req)
-	case "deleteSessionDirs":
-		h.handleDeleteSessionDirs(conn, req)
-	case "createDiskImage":
-		h.handleCreateDiskImage(conn, req)
-	case "sendGuestResponse":
-		h.handleSendGuestResponse(conn, req)
-	default:
+	// Label metrics by method, bucketing unrecognized names so a client
+	// sending garbage can't grow the series set without bound.
+	method := req.Method
+	start := time.Now()
+	defer func() {
+		rpcRequests.With(method).Inc()
+		if method != "subscribeEvents" {
+			rpcDuration.With(method).ObserveSince(start)
+		}
+	}()
+
+	m, ok := methods[req.Method]
+	if !ok {
+		method = "unknown"
+		if h.unknown != nil {
+			h.unknown.handle(conn, req)
+			return
+		}
 		logx.Debug("RPC: unknown method %q — returning success (passthrough)", req.Method)
 		WriteResponse(conn, req.ID, nil)
+		return
+	}
+
+	if h.guard != nil {
+		if wait, ok := h.guard.allow(req.Method); !ok {
+			writeError(conn, req.ID, CodeInternalError,
+				fmt.Sprintf("%s is disabled after repeated internal errors; retry in %s", req.Method, wait.Round(time.Second)),
+				map[string]int64{"retryAfterMs": wait.Milliseconds()})
+			return
+		}
 	}
+	defer h.recoverPanic(conn, req)
+
+	m.handle(h, conn, req)
+}
+
+// rpcMethod is one entry in the method registry. Handle dispatches through
+// the registry and getCapabilities advertises it, so a method is added in
+// exactly one place.
+type rpcMethod struct {
+	handle func(h *Handler, conn net.Conn, req Request)
+	// params is the zero value of the method's params struct, for
+	// ParamsSchema. nil for methods that take none.
+	params interface{}
+	// optionalParams lists params the daemon understands beyond what the
+	// method originally shipped with, for clients to feature-detect.
+	optionalParams []string
+}
+
+// methods is filled in init: getCapabilities reads the registry, so a
+// composite literal would be an initialization cycle.
+var methods map[string]rpcMethod
+
+func init() {
+	methods = map[string]rpcMethod{
+		"configure":             {handle: (*Handler).handleConfigure, params: configureParams{}},
+		"createVM":              {handle: (*Handler).handleCreateVM, params: createVMParams{}},
+		"startVM":               {handle: (*Handler).handleStartVM, params: startVMParams{}},
+		"stopVM":                {handle: (*Handler).handleStopVM, params: vmNameParams{}},
+		"isRunning":             {handle: (*Handler).handleIsRunning, params: vmNameParams{}},
+		"isGuestConnected":      {handle: (*Handler).handleIsGuestConnected, params: vmNameParams{}},
+		"spawn":                 {handle: (*Handler).handleSpawn, params: spawnParams{}},
+		"kill":                  {handle: (*Handler).handleKill, params: killParams{}},
+		"writeStdin":            {handle: (*Handler).handleWriteStdin, params: writeStdinParams{}},
+		"isProcessRunning":      {handle: (*Handler).handleIsProcessRunning, params: processIDParams{}},
+		"mountPath":             {handle: (*Handler).handleMountPath, params: mountPathParams{}},
+		"readFile":              {handle: (*Handler).handleReadFile, params: readFileParams{}},
+		"installSdk":            {handle: (*Handler).handleInstallSdk, params: installSdkParams{}},
+		"addApprovedOauthToken": {handle: (*Handler).handleAddApprovedOauthToken, params: oauthTokenParams{}},
+		"setDebugLogging":       {handle: (*Handler).handleSetDebugLogging, params: debugLoggingParams{}},
+		"isDebugLoggingEnabled": {handle: (*Handler).handleIsDebugLoggingEnabled},
+		"subscribeEvents":       {handle: (*Handler).handleSubscribeEvents, params: subscribeEventsParams{}, optionalParams: []string{"sinceSeq"}},
+		"getDownloadStatus":     {handle: (*Handler).handleGetDownloadStatus},
+		"getSessionsDiskInfo":   {handle: (*Handler).handleGetSessionsDiskInfo, params: getSessionsDiskInfoParams{}},
+		"pruneSessionCaches":    {handle: (*Handler).handlePruneSessionCaches, params: pruneSessionCachesParams{}},
+		"deleteSessionDirs":     {handle: (*Handler).handleDeleteSessionDirs, params: deleteSessionDirsParams{}},
+		"createDiskImage":       {handle: (*Handler).handleCreateDiskImage, params: createDiskImageParams{}},
+		"sendGuestResponse":     {handle: (*Handler).handleSendGuestResponse, params: sendGuestResponseParams{}},
+		"getCapabilities":       {handle: (*Handler).handleGetCapabilities},
+	}
+}
+
+// Capabilities is the getCapabilities result: what this daemon implements,
+// so clients can detect features instead of sniffing versions.
+type Capabilities struct {
+	Version          string              `json:"version"`
+	Backend          string              `json:"backend,omitempty"`
+	ProtocolRevision string              `json:"protocolRevision"`
+	Methods          []string            `json:"methods"`
+	EventTypes       []string            `json:"eventTypes"`
+	OptionalParams   map[string][]string `json:"optionalParams"`
+}
+
+func (h *Handler) capabilities() Capabilities {
+	c := Capabilities{
+		Version:          h.version,
+		ProtocolRevision: ProtocolRevision,
+		EventTypes:       process.EventTypes,
+		OptionalParams:   make(map[string][]string),
+	}
+	if r, ok := h.backend.(StatusReporter); ok {
+		c.Backend = r.Status().Backend
+	}
+	for name, m := range methods {
+		c.Methods = append(c.Methods, name)
+		if len(m.optionalParams) > 0 {
+			c.OptionalParams[name] = m.optionalParams
+		}
+	}
+	sort.Strings(c.Methods)
+	return c
+}
+
+func (h *Handler) handleGetCapabilities(conn net.Conn, req Request) {
+	WriteResponse(conn, req.ID, h.capabilities())
 }
 
 // Parameter types for RPC methods
 
 type configureParams struct {
-	MemoryMB     int    `json:"memoryMB"`
-	CPUCount     int    `json:"cpuCount"`
+	MemoryMB     int    `json:"memoryMB" validate:"min=0"`
+	CPUCount     int    `json:"cpuCount" validate:"min=0"`
 	UserDataName string `json:"userDataName"`
 	UserDataRoot string `json:"userDataRoot"`
 	SessionOnly  bool   `json:"sessionOnly"`
 }
 
+// vmNameParams covers stopVM/isRunning/isGuestConnected. Desktop stopped
+// sending name in v1.7196.0 and sends nothing now; older builds still do.
 type vmNameParams struct {
-	Name         string `json:"name"`
-	UserDataName string `json:"userDataName"`
-	UserDataRoot string `json:"userDataRoot"`
+	Name string `json:"name"`
+}
+
+type subscribeEventsParams struct {
+	Name         string  `json:"name"`
+	UserDataName string  `json:"userDataName"`
+	UserDataRoot string  `json:"userDataRoot"`
+	SinceSeq     *uint64 `json:"sinceSeq"`
 }
 
 type createVMParams struct {
 	Name       string `json:"name"`
 	BundlePath string `json:"bundlePath"`
-	DiskSizeGB int    `json:"diskSizeGB"`
+	DiskSizeGB int    `json:"diskSizeGB" validate:"min=0"`
 }
 
 type startVMParams struct {
 	Name        string `json:"name"`
 	BundlePath  string `json:"bundlePath"`
-	MemoryGB    int    `json:"memoryGB"`
-	CPUCount    int    `json:"cpuCount"`
+	MemoryGB    int    `json:"memoryGB" validate:"min=0"`
+	CPUCount    int    `json:"cpuCount" validate:"min=0"`
 	APIProbeURL string `json:"apiProbeURL"`
 }
 
 type killParams struct {
-	ProcessID string `json:"id"`
+	ProcessID string `json:"id" validate:"required"`
 	Signal    string `json:"signal"`
 }
 
 type spawnParams struct {
-	Name              string               `json:"name"`
+	Name              string               `json:"name" validate:"name"`
 	ID                string               `json:"id"`
-	Cmd               string               `json:"command"`
+	Cmd               string               `json:"command" validate:"required"`
 	Args              []string             `json:"args"`
 	Env               map[string]string    `json:"env"`
 	Cwd               string               `json:"cwd"`
@@ -142,13 +220,13 @@ type spawnParams struct {
 }
 
 type getSessionsDiskInfoParams struct {
-	LowWaterBytes int64 `json:"lowWaterBytes"`
+	LowWaterBytes int64 `json:"lowWaterBytes" validate:"min=0"`
 }
 
 type pruneSessionCachesParams struct {
-	OnlyIfFreeBytesBelow       int64 `json:"onlyIfFreeBytesBelow"`
+	OnlyIfFreeBytesBelow       int64 `json:"onlyIfFreeBytesBelow" validate:"min=0"`
 	IncludeSessionTmp          bool  `json:"includeSessionTmp"`
-	SessionTmpOlderThanSeconds int64 `json:"sessionTmpOlderThanSeconds"`
+	SessionTmpOlderThanSeconds int64 `json:"sessionTmpOlderThanSeconds" validate:"min=0"`
 }
 
 type deleteSessionDirsParams struct {
@@ -156,37 +234,37 @@ type deleteSessionDirsParams struct {
 }
 
 type createDiskImageParams struct {
-	DiskName string `json:"diskName"`
-	SizeGiB  int    `json:"sizeGiB"`
+	DiskName string `json:"diskName" validate:"required,name"`
+	SizeGiB  int    `json:"sizeGiB" validate:"min=1"`
 }
 
 type processIDParams struct {
-	ProcessID string `json:"id"`
+	ProcessID string `json:"id" validate:"required"`
 }
 
 type writeStdinParams struct {
-	ProcessID string `json:"id"`
+	ProcessID string `json:"id" validate:"required"`
 	Data      string `json:"data"`
 }
 
 type mountPathParams struct {
-	ProcessID string `json:"processId"`
-	Subpath   string `json:"subpath"`
+	ProcessID string `json:"processId" validate:"required"`
+	Subpath   string `json:"subpath" validate:"relpath"`
 	MountName string `json:"mountName"`
-	Mode      string `json:"mode"`
+	Mode      string `json:"mode" validate:"oneof=ro|rw|rwd"`
 }
 
 type readFileParams struct {
 	ProcessName string `json:"processName"`
-	FilePath    string `json:"filePath"`
+	FilePath    string `json:"filePath" validate:"required"`
 }
 
 type oauthTokenParams struct {
-	Token string `json:"token"`
+	Token string `json:"token" validate:"required"`
 }
 
 type installSdkParams struct {
-	SdkSubpath string `json:"sdkSubpath"`
+	SdkSubpath string `json:"sdkSubpath" validate:"relpath"`
 	Version    string `json:"version"`
 }
 
@@ -195,19 +273,18 @@ type debugLoggingParams struct {
 }
 
 type sendGuestResponseParams struct {
-	ID         string `json:"id"`
+	ID         string `json:"id" validate:"required"`
 	ResultJSON string `json:"resultJson"`
 	Error      string `json:"error"`
 }
 
 func (h *Handler) handleConfigure(conn net.Conn, req Request) {
 	var p configureParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	if err := h.backend.Configure(p.MemoryMB, p.CPUCount); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -215,8 +292,7 @@ func (h *Handler) handleConfigure(conn net.Conn, req Request) {
 
 func (h *Handler) handleCreateVM(conn net.Conn, req Request) {
 	var p createVMParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	// Extract VM name from bundlePath if name is empty
@@ -225,7 +301,7 @@ func (h *Handler) handleCreateVM(conn net.Conn, req Request) {
 		name = filepath.Base(p.BundlePath)
 	}
 	if err := h.backend.CreateVM(name); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -233,8 +309,7 @@ func (h *Handler) handleCreateVM(conn net.Conn, req Request) {
 
 func (h *Handler) handleStartVM(conn net.Conn, req Request) {
 	var p startVMParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	// Extract VM name from bundlePath if name is empty
@@ -243,7 +318,7 @@ func (h *Handler) handleStartVM(conn net.Conn, req Request) {
 		name = filepath.Base(p.BundlePath)
 	}
 	if err := h.backend.StartVM(name, p.BundlePath, p.MemoryGB, p.CPUCount, p.APIProbeURL); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -251,17 +326,13 @@ func (h *Handler) handleStartVM(conn net.Conn, req Request) {
 
 func (h *Handler) handleStopVM(conn net.Conn, req Request) {
 	// Desktop sends stopVM with no params at all, so req.Params can be
-	// nil. json.Unmarshal(nil, ...) would otherwise reject it as
-	// "unexpected end of JSON input" and we'd never reach the backend.
+	// nil; decode treats that as an empty object.
 	var p vmNameParams
-	if len(req.Params) > 0 {
-		if err := json.Unmarshal(req.Params, &p); err != nil {
-			WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
-			return
-		}
+	if !h.decode(conn, req, &p) {
+		return
 	}
 	if err := h.backend.StopVM(p.Name); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -276,7 +347,7 @@ func (h *Handler) handleIsRunning(conn net.Conn, req Request) {
 	}
 	running, err := h.backend.IsRunning(p.Name)
 	if err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, map[string]bool{"running": running})
@@ -291,7 +362,7 @@ func (h *Handler) handleIsGuestConnected(conn net.Conn, req Request) {
 	}
 	connected, err := h.backend.IsGuestConnected(p.Name)
 	if err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, map[string]bool{"connected": connected})
@@ -300,14 +371,13 @@ func (h *Handler) handleIsGuestConnected(conn net.Conn, req Request) {
 func (h *Handler) handleSpawn(conn net.Conn, req Request) {
 	logx.Debug("spawn raw params: %s", logx.Trunc(string(req.Params)))
 	var p spawnParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	logx.Debug("spawn parsed: name=%q cmd=%q args=%v cwd=%q env=%v oauthToken=%v", p.Name, p.Cmd, p.Args, p.Cwd, p.Env, p.OauthToken != "")
 	processID, failedMounts, err := h.backend.Spawn(p.Name, p.ID, p.Cmd, p.Args, p.Env, p.Cwd, p.AdditionalMounts, req.Params, p.OauthToken)
 	if err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	// Desktop (since v1.12603.0) reads failedMounts from the spawn result to
@@ -320,8 +390,7 @@ func (h *Handler) handleSpawn(conn net.Conn, req Request) {
 
 func (h *Handler) handleKill(conn net.Conn, req Request) {
 	var p killParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 
@@ -332,7 +401,7 @@ func (h *Handler) handleKill(conn net.Conn, req Request) {
 	time.Sleep(1 * time.Second)
 
 	if err := h.backend.Kill(p.ProcessID, p.Signal); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -340,13 +409,12 @@ func (h *Handler) handleKill(conn net.Conn, req Request) {
 
 func (h *Handler) handleWriteStdin(conn net.Conn, req Request) {
 	var p writeStdinParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	logx.Debug("writeStdin processId=%s data=%s", p.ProcessID, logx.Trunc(p.Data))
 	if err := h.backend.WriteStdin(p.ProcessID, []byte(p.Data)); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -354,13 +422,12 @@ func (h *Handler) handleWriteStdin(conn net.Conn, req Request) {
 
 func (h *Handler) handleIsProcessRunning(conn net.Conn, req Request) {
 	var p processIDParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	running, exitCode, err := h.backend.IsProcessRunning(p.ProcessID)
 	if err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, map[string]interface{}{"running": running, "exitCode": exitCode})
@@ -368,12 +435,11 @@ func (h *Handler) handleIsProcessRunning(conn net.Conn, req Request) {
 
 func (h *Handler) handleMountPath(conn net.Conn, req Request) {
 	var p mountPathParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	if err := h.backend.MountPath(p.ProcessID, p.Subpath, p.MountName, p.Mode); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -381,13 +447,12 @@ func (h *Handler) handleMountPath(conn net.Conn, req Request) {
 
 func (h *Handler) handleReadFile(conn net.Conn, req Request) {
 	var p readFileParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	data, err := h.backend.ReadFile(p.ProcessName, p.FilePath)
 	if err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	// Desktop's Linux client reads `response.result.content` and ALWAYS
@@ -402,12 +467,11 @@ func (h *Handler) handleReadFile(conn net.Conn, req Request) {
 
 func (h *Handler) handleInstallSdk(conn net.Conn, req Request) {
 	var p installSdkParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	if err := h.backend.InstallSdk(p.SdkSubpath, p.Version); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -415,12 +479,11 @@ func (h *Handler) handleInstallSdk(conn net.Conn, req Request) {
 
 func (h *Handler) handleAddApprovedOauthToken(conn net.Conn, req Request) {
 	var p oauthTokenParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	if err := h.backend.AddApprovedOauthToken(p.Token); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -428,8 +491,7 @@ func (h *Handler) handleAddApprovedOauthToken(conn net.Conn, req Request) {
 
 func (h *Handler) handleSetDebugLogging(conn net.Conn, req Request) {
 	var p debugLoggingParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	h.backend.SetDebugLogging(p.Enabled)
@@ -443,7 +505,7 @@ func (h *Handler) handleIsDebugLoggingEnabled(conn net.Conn, req Request) {
 }
 
 func (h *Handler) handleSubscribeEvents(conn net.Conn, req Request) {
-	var p vmNameParams
+	var p subscribeEventsParams
 	if req.Params != nil {
 		if err := json.Unmarshal(req.Params, &p); err != nil {
 			logx.Debug("subscribeEvents: ignoring malformed params: %v", err)
@@ -452,19 +514,41 @@ func (h *Handler) handleSubscribeEvents(conn net.Conn, req Request) {
 
 	var (
 		cancelled int32      // atomic flag to stop callbacks after write failure
-		writeMu   sync.Mutex // serialize concurrent event writes on this connection
+		writeMu   sync.Mutex // serialize event writes with the subscribe ack
 	)
 
-	cancel, err := h.backend.SubscribeEvents(p.Name, func(event interface{}) {
+	// Backends publishing through an EventBus hand over pre-encoded events
+	// from one writer goroutine per subscription; anything else is
+	// marshaled here.
+	callback := func(event interface{}) {
 		if atomic.LoadInt32(&cancelled) != 0 {
 			return
 		}
-		data, err := json.Marshal(event)
-		if err != nil {
-			logx.Debug("Failed to marshal event: %v", err)
+		var (
+			data []byte
+			typ  string
+		)
+		switch ev := event.(type) {
+		case eventOverflowed:
+			atomic.StoreInt32(&cancelled, 1)
+			log.Printf("Closing subscribeEvents connection: event queue overflow")
+			_ = conn.Close()
 			return
+		case EncodedEvent:
+			data, typ = ev.JSON, ev.Type
+		default:
+			var err error
+			if data, err = json.Marshal(event); err != nil {
+				logx.Debug("Failed to marshal event: %v", err)
+				return
+			}
+			typ = process.EventType(event)
 		}
 		logx.Debug("EVENT → client: %s", logx.Trunc(string(data)))
+		if typ == "" {
+			typ = "other"
+		}
+		eventsSent.With(typ).Inc()
 		writeMu.Lock()
 		werr := WriteMessage(conn, data)
 		writeMu.Unlock()
@@ -472,14 +556,34 @@ func (h *Handler) handleSubscribeEvents(conn net.Conn, req Request) {
 			atomic.StoreInt32(&cancelled, 1)
 			logx.Debug("Event write failed, cancelling subscription: %v", werr)
 		}
-	})
+	}
+
+	// A client resubscribing after a dropped connection passes the last seq
+	// it saw; backends without a replay buffer just start live delivery.
+	// writeMu is held until the ack is out so replayed or early events
+	// cannot overtake it.
+	var (
+		cancel func()
+		err    error
+	)
+	writeMu.Lock()
+	if replayer, ok := h.backend.(EventReplayer); ok && p.SinceSeq != nil {
+		logx.Debug("subscribeEvents: replaying events after seq %d", *p.SinceSeq)
+		cancel, err = replayer.SubscribeEventsSince(p.Name, *p.SinceSeq, callback)
+	} else {
+		cancel, err = h.backend.SubscribeEvents(p.Name, callback)
+	}
 	if err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeMu.Unlock()
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 
 	// Send initial ack
 	WriteResponse(conn, req.ID, map[string]bool{"subscribed": true})
+	writeMu.Unlock()
+	eventSubscribers.With().Inc()
+	defer eventSubscribers.With().Dec()
 
 	// Block until connection closes (events are pushed via callback)
 	// When connection drops, ReadMessage will fail and we cancel
@@ -498,13 +602,12 @@ func (h *Handler) handleGetDownloadStatus(conn net.Conn, req Request) {
 
 func (h *Handler) handleGetSessionsDiskInfo(conn net.Conn, req Request) {
 	var p getSessionsDiskInfoParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	info, err := h.backend.GetSessionsDiskInfo(p.LowWaterBytes)
 	if err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, info)
@@ -518,15 +621,12 @@ func (h *Handler) handleGetSessionsDiskInfo(conn net.Conn, req Request) {
 // VM-style caches.
 func (h *Handler) handlePruneSessionCaches(conn net.Conn, req Request) {
 	var p pruneSessionCachesParams
-	if len(req.Params) > 0 {
-		if err := json.Unmarshal(req.Params, &p); err != nil {
-			WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
-			return
-		}
+	if !h.decode(conn, req, &p) {
+		return
 	}
 	result, err := h.backend.PruneSessionCaches(p.OnlyIfFreeBytesBelow, p.IncludeSessionTmp, p.SessionTmpOlderThanSeconds)
 	if err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, result)
@@ -534,13 +634,12 @@ func (h *Handler) handlePruneSessionCaches(conn net.Conn, req Request) {
 
 func (h *Handler) handleDeleteSessionDirs(conn net.Conn, req Request) {
 	var p deleteSessionDirsParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	result, err := h.backend.DeleteSessionDirs(p.Names)
 	if err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, result)
@@ -548,12 +647,11 @@ func (h *Handler) handleDeleteSessionDirs(conn net.Conn, req Request) {
 
 func (h *Handler) handleCreateDiskImage(conn net.Conn, req Request) {
 	var p createDiskImageParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	if err := h.backend.CreateDiskImage(p.DiskName, p.SizeGiB); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -561,12 +659,11 @@ func (h *Handler) handleCreateDiskImage(conn net.Conn, req Request) {
 
 func (h *Handler) handleSendGuestResponse(conn net.Conn, req Request) {
 	var p sendGuestResponseParams
-	if err := json.Unmarshal(req.Params, &p); err != nil {
-		WriteError(conn, req.ID, -32602, "Invalid params: "+err.Error())
+	if !h.decode(conn, req, &p) {
 		return
 	}
 	if err := h.backend.SendGuestResponse(p.ID, p.ResultJSON, p.Error); err != nil {
-		WriteError(conn, req.ID, -32000, err.Error())
+		writeBackendError(conn, req.ID, err)
 		return
 	}
 	WriteResponse(conn, req.ID, nil)
@@ -2,9 +2,20 @@ package pipe
 
 import (
 	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"net"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/metrics"
+	"github.com/patrickjaja/claude-cowork-service/process"
 )
 
 type recordingBackend struct {
@@ -183,3 +194,348 @@ func mustRawJSON(t *testing.T, v interface{}) json.RawMessage {
 	}
 	return raw
 }
+
+func TestHandleCountsUnknownMethodsUnderOneLabel(t *testing.T) {
+	handler := NewHandler(&recordingBackend{}, false)
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	before := rpcMetricValue(t, "unknown")
+	payload, err := json.Marshal(Request{Method: "getNetworkDrives", ID: 1})
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	go func() {
+		defer func() { _ = server.Close() }()
+		handler.Handle(server, payload)
+	}()
+	if _, err := ReadMessage(client); err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	// The counter is bumped in a deferred call after the response is written.
+	for i := 0; i < 100 && rpcMetricValue(t, "unknown") == before; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := rpcMetricValue(t, "unknown"); got != before+1 {
+		t.Fatalf("unknown counter = %v, want %v", got, before+1)
+	}
+	if strings.Contains(renderMetrics(t), `method="getNetworkDrives"`) {
+		t.Fatalf("unknown method got its own series")
+	}
+}
+
+func renderMetrics(t *testing.T) string {
+	t.Helper()
+	var b strings.Builder
+	if err := metrics.WriteText(&b); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	return b.String()
+}
+
+func rpcMetricValue(t *testing.T, method string) float64 {
+	t.Helper()
+	prefix := `cowork_rpc_requests_total{method="` + method + `"} `
+	for _, line := range strings.Split(renderMetrics(t), "\n") {
+		if v, ok := strings.CutPrefix(line, prefix); ok {
+			f, _ := strconv.ParseFloat(v, 64)
+			return f
+		}
+	}
+	return 0
+}
+
+// stdinBackend records writeStdin data in the order the backend sees it and
+// reports backpressure for data "full".
+type stdinBackend struct {
+	recordingBackend
+	mu     sync.Mutex
+	writes []string
+}
+
+func (b *stdinBackend) WriteStdin(processID string, data []byte) error {
+	if string(data) == "full" {
+		return ErrBackpressure
+	}
+	// Give a concurrently dispatched later write the chance to overtake.
+	time.Sleep(time.Millisecond)
+	b.mu.Lock()
+	b.writes = append(b.writes, string(data))
+	b.mu.Unlock()
+	return nil
+}
+
+func TestWriteStdinBackpressureCodeOnTheWire(t *testing.T) {
+	handler := NewHandler(&stdinBackend{}, false)
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	go handler.Handle(server, mustRawJSON(t, Request{
+		Method: "writeStdin",
+		Params: mustRawJSON(t, writeStdinParams{ProcessID: "p1", Data: "full"}),
+		ID:     9,
+	}))
+	payload, err := ReadMessage(client)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if resp.Success || resp.Code != CodeBackpressure {
+		t.Fatalf("response = %s, want code %d", payload, CodeBackpressure)
+	}
+}
+
+func TestServerDeliversWriteStdinInArrivalOrder(t *testing.T) {
+	backend := &stdinBackend{}
+	srv := NewServer("", backend, false)
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go srv.serveConn(server)
+
+	const n = 50
+	go func() {
+		for i := 0; i < n; i++ {
+			_ = WriteMessage(client, mustRawJSON(t, Request{
+				Method: "writeStdin",
+				Params: mustRawJSON(t, writeStdinParams{ProcessID: "p1", Data: strconv.Itoa(i)}),
+				ID:     i,
+			}))
+		}
+	}()
+	for i := 0; i < n; i++ {
+		if _, err := ReadMessage(client); err != nil {
+			t.Fatalf("ReadMessage %d: %v", i, err)
+		}
+	}
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	for i, got := range backend.writes {
+		if got != strconv.Itoa(i) {
+			t.Fatalf("write %d = %q; order %v", i, got, backend.writes)
+		}
+	}
+}
+
+// stuckStdinBackend blocks writeStdin to p1 until release is closed.
+type stuckStdinBackend struct {
+	recordingBackend
+	release chan struct{}
+}
+
+func (b *stuckStdinBackend) WriteStdin(processID string, data []byte) error {
+	if processID == "p1" {
+		<-b.release
+	}
+	return nil
+}
+
+// TestServerStuckStdinDoesNotStallConnection checks that a process whose
+// stdin doesn't drain gets backpressure once its lane is full, while other
+// processes' stdin and other requests on the connection still go through.
+func TestServerStuckStdinDoesNotStallConnection(t *testing.T) {
+	backend := &stuckStdinBackend{release: make(chan struct{})}
+	defer close(backend.release)
+	srv := NewServer("", backend, false)
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go srv.serveConn(server)
+
+	responses := make(chan Response, 2*stdinLaneDepth)
+	go func() {
+		for {
+			payload, err := ReadMessage(client)
+			if err != nil {
+				return
+			}
+			var resp Response
+			if err := json.Unmarshal(payload, &resp); err == nil {
+				responses <- resp
+			}
+		}
+	}()
+	// One write is handled and stuck, a lane's worth waits behind it, and
+	// the rest are turned away.
+	const stuck = stdinLaneDepth + 3
+	go func() {
+		for i := 0; i < stuck; i++ {
+			_ = WriteMessage(client, mustRawJSON(t, Request{
+				Method: "writeStdin",
+				Params: mustRawJSON(t, writeStdinParams{ProcessID: "p1", Data: "x"}),
+				ID:     i,
+			}))
+		}
+		_ = WriteMessage(client, mustRawJSON(t, Request{
+			Method: "writeStdin",
+			Params: mustRawJSON(t, writeStdinParams{ProcessID: "p2", Data: "y"}),
+			ID:     1000,
+		}))
+		_ = WriteMessage(client, mustRawJSON(t, Request{
+			Method: "isProcessRunning",
+			Params: mustRawJSON(t, map[string]string{"id": "p2"}),
+			ID:     1001,
+		}))
+	}()
+
+	backpressure, answered := 0, map[float64]bool{}
+	for backpressure < stuck-1-stdinLaneDepth || !answered[1000] || !answered[1001] {
+		select {
+		case resp := <-responses:
+			switch {
+			case resp.Code == CodeBackpressure:
+				backpressure++
+			case resp.Success:
+				answered[resp.ID.(float64)] = true
+			default:
+				t.Fatalf("unexpected response %+v", resp)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("stalled: %d backpressure responses, answered %v", backpressure, answered)
+		}
+	}
+}
+
+func TestServerAdoptsActivatedListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "activated.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	// Listeners rebuilt from inherited fds never unlink their path on Close.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	srv := NewServer(path, &recordingBackend{}, false)
+	srv.SetListener(l)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if err := WriteMessage(conn, mustRawJSON(t, Request{Method: "isRunning", ID: 1})); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := ReadMessage(conn); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	_ = conn.Close()
+	if err := srv.Healthy(time.Second); err != nil {
+		t.Fatalf("Healthy: %v", err)
+	}
+
+	srv.Stop()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("activated socket file removed on Stop: %v", err)
+	}
+}
+
+func TestGetCapabilitiesListsRegistry(t *testing.T) {
+	handler := NewHandler(&recordingBackend{}, false)
+	handler.version = "1.2.3"
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go handler.Handle(server, mustRawJSON(t, Request{Method: "getCapabilities", ID: 1}))
+
+	payload, err := ReadMessage(client)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var resp struct {
+		Success bool         `json:"success"`
+		Result  Capabilities `json:"result"`
+	}
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	c := resp.Result
+	if !resp.Success || c.Version != "1.2.3" || c.ProtocolRevision != ProtocolRevision {
+		t.Fatalf("capabilities = %s", payload)
+	}
+	if len(c.Methods) != len(methods) {
+		t.Fatalf("methods = %v, want %d entries", c.Methods, len(methods))
+	}
+	for _, want := range []string{"spawn", "subscribeEvents", "getCapabilities"} {
+		found := false
+		for _, m := range c.Methods {
+			found = found || m == want
+		}
+		if !found {
+			t.Fatalf("methods %v missing %q", c.Methods, want)
+		}
+	}
+	if got := c.OptionalParams["subscribeEvents"]; len(got) != 1 || got[0] != "sinceSeq" {
+		t.Fatalf("optionalParams = %v", c.OptionalParams)
+	}
+	for _, typ := range emittedEventTypes(t) {
+		found := false
+		for _, e := range c.EventTypes {
+			found = found || e == typ
+		}
+		if !found {
+			t.Errorf("eventTypes %v missing %q, which a backend emits", c.EventTypes, typ)
+		}
+	}
+}
+
+// emittedEventTypes collects the event "type" values in the source: the
+// Type of every process event, and the "type" of every map literal the
+// backends pass to their emit functions.
+func emittedEventTypes(t *testing.T) []string {
+	t.Helper()
+	seen := map[string]bool{}
+	var types []string
+	add := func(lit ast.Expr) {
+		if bl, ok := lit.(*ast.BasicLit); ok && bl.Kind == token.STRING {
+			if v, err := strconv.Unquote(bl.Value); err == nil && !seen[v] {
+				seen[v] = true
+				types = append(types, v)
+			}
+		}
+	}
+	for _, dir := range []string{"../process", "../native", "../vm", "../router", "../remote"} {
+		files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range files {
+			if strings.HasSuffix(path, "_test.go") {
+				continue
+			}
+			f, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.KeyValueExpr:
+					if key, ok := n.Key.(*ast.Ident); ok && key.Name == "Type" && dir == "../process" {
+						add(n.Value)
+					}
+				case *ast.CallExpr:
+					sel, ok := n.Fun.(*ast.SelectorExpr)
+					if !ok || !strings.HasPrefix(sel.Sel.Name, "emit") || len(n.Args) != 1 {
+						return true
+					}
+					if m, ok := n.Args[0].(*ast.CompositeLit); ok {
+						for _, el := range m.Elts {
+							if kv, ok := el.(*ast.KeyValueExpr); ok {
+								if key, ok := kv.Key.(*ast.BasicLit); ok && key.Value == `"type"` {
+									add(kv.Value)
+								}
+							}
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	if len(types) < len(process.EventTypes) {
+		t.Fatalf("found only %v in the source", types)
+	}
+	return types
+}
@@ -0,0 +1,29 @@
+package pipe
+
+import "github.com/patrickjaja/claude-cowork-service/metrics"
+
+var (
+	rpcRequests = metrics.NewCounterVec("cowork_rpc_requests_total",
+		"RPC requests handled, by method (unrecognized methods are counted as \"unknown\").", "method")
+	rpcDuration = metrics.NewHistogramVec("cowork_rpc_duration_seconds",
+		"RPC handler latency by method, excluding the long-lived subscribeEvents.", nil, "method")
+	rpcPanics = metrics.NewCounterVec("cowork_rpc_panics_total",
+		"RPC handler panics recovered, by method.", "method")
+	eventSubscribers = metrics.NewGaugeVec("cowork_event_subscribers",
+		"Active subscribeEvents connections.")
+	eventsSent = metrics.NewCounterVec("cowork_events_sent_total",
+		"Events written to subscribeEvents connections, by event type.", "type")
+	eventsDropped = metrics.NewCounterVec("cowork_events_dropped_total",
+		"Events not queued because a subscriber's queue was full, by overflow policy.", "policy")
+)
+
+// Process metrics shared by all backends, labeled with the backend name so
+// both can be told apart on one dashboard.
+var (
+	ProcessesLive = metrics.NewGaugeVec("cowork_processes_live",
+		"Spawned processes that have not exited yet.", "backend")
+	ProcessesSpawned = metrics.NewCounterVec("cowork_processes_spawned_total",
+		"Processes spawned successfully.", "backend")
+	StdinBackpressure = metrics.NewCounterVec("cowork_stdin_backpressure_total",
+		"writeStdin calls rejected with -32002 because the process's stdin queue was full.", "backend")
+)
@@ -0,0 +1,132 @@
+package pipe
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// PeerCred is the SO_PEERCRED identity of a connected socket client: the
+// kernel-reported pid/uid/gid of the process that called connect(2).
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+	// Exe is the resolved /proc/<pid>/exe target. Only populated when a
+	// PeerPolicy with an executable allowlist inspected the peer.
+	Exe string
+}
+
+func (c PeerCred) String() string {
+	s := fmt.Sprintf("pid=%d uid=%d gid=%d", c.PID, c.UID, c.GID)
+	if c.Exe != "" {
+		s += " exe=" + c.Exe
+	}
+	return s
+}
+
+// DefaultClientAllowlist matches the executables Claude Desktop runs as on
+// Linux: the Electron binary of a distro/AUR package, the renamed binary of
+// claude-desktop-bin and the official .deb, and the AppImage's main
+// executable (not the helpers such as chrome-sandbox next to it). Patterns
+// without a slash match the executable's base name; patterns with a slash
+// match the full path (filepath.Match syntax).
+var DefaultClientAllowlist = []string{
+	"electron",
+	"electron[0-9]*",
+	"claude-desktop",
+	"Claude",
+	"/tmp/.mount_*/claude-desktop",
+}
+
+// PeerPolicy decides which socket clients may talk to the daemon.
+//
+// The socket is chmod 0700, but on shared dev boxes every same-uid process
+// can still connect and spawn arbitrary commands. The uid check is always on
+// (root is let through so `sudo` debugging keeps working); the executable
+// allowlist is opt-in because Desktop's binary name differs per packaging.
+// This is the Linux counterpart of the Windows service's GetClientInfo /
+// verifyClientSignature checks.
+type PeerPolicy struct {
+	// AllowedExes is the executable allowlist; empty disables the check.
+	AllowedExes []string
+}
+
+// Check reads the peer credentials of conn and applies the policy. The
+// returned PeerCred is filled in as far as it could be read, so rejections
+// can be logged with whatever identity the kernel reported.
+func (p *PeerPolicy) Check(conn net.Conn) (PeerCred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return PeerCred{}, fmt.Errorf("not a unix socket connection (%T)", conn)
+	}
+	cred, err := peerCredentials(uc)
+	if err != nil {
+		return cred, fmt.Errorf("reading SO_PEERCRED: %w", err)
+	}
+	if euid := uint32(os.Geteuid()); cred.UID != euid && cred.UID != 0 {
+		return cred, fmt.Errorf("uid %d does not match daemon uid %d", cred.UID, euid)
+	}
+	if p == nil || len(p.AllowedExes) == 0 {
+		return cred, nil
+	}
+	exe, err := peerExecutable(cred.PID)
+	if err != nil {
+		return cred, fmt.Errorf("resolving peer executable: %w", err)
+	}
+	cred.Exe = exe
+	if !matchExecutable(p.AllowedExes, exe) {
+		return cred, fmt.Errorf("executable %s not in client allowlist", exe)
+	}
+	return cred, nil
+}
+
+// peerCredentials fetches SO_PEERCRED from a connected Unix socket.
+func peerCredentials(conn *net.UnixConn) (PeerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return PeerCred{}, err
+	}
+	var (
+		ucred *syscall.Ucred
+		serr  error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		ucred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return PeerCred{}, err
+	}
+	if serr != nil {
+		return PeerCred{}, serr
+	}
+	return PeerCred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
+
+// peerExecutable resolves /proc/<pid>/exe. The kernel appends " (deleted)"
+// when the binary was replaced on disk after the process started (a package
+// upgrade while Desktop runs); strip it so the allowlist still matches.
+func peerExecutable(pid int32) (string, error) {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(exe, " (deleted)"), nil
+}
+
+// matchExecutable reports whether exe matches any allowlist pattern.
+func matchExecutable(patterns []string, exe string) bool {
+	base := filepath.Base(exe)
+	for _, pat := range patterns {
+		target := base
+		if strings.Contains(pat, "/") {
+			target = exe
+		}
+		if ok, err := filepath.Match(pat, target); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
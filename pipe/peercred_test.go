@@ -0,0 +1,109 @@
+package pipe
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func dialTestSocket(t *testing.T) (server net.Conn, client net.Conn) {
+	t.Helper()
+
+	sock := filepath.Join(t.TempDir(), "peer.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- c
+	}()
+	client, err = net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	server = <-accepted
+	if server == nil {
+		t.Fatalf("Accept failed")
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+	return server, client
+}
+
+func TestPeerPolicyReportsOwnCredentials(t *testing.T) {
+	server, _ := dialTestSocket(t)
+
+	cred, err := (&PeerPolicy{}).Check(server)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if int(cred.PID) != os.Getpid() {
+		t.Fatalf("pid = %d, want %d", cred.PID, os.Getpid())
+	}
+	if cred.UID != uint32(os.Geteuid()) {
+		t.Fatalf("uid = %d, want %d", cred.UID, os.Geteuid())
+	}
+}
+
+func TestPeerPolicyExecutableAllowlist(t *testing.T) {
+	server, _ := dialTestSocket(t)
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable: %v", err)
+	}
+	self, _ = filepath.EvalSymlinks(self)
+
+	if _, err := (&PeerPolicy{AllowedExes: []string{"electron"}}).Check(server); err == nil {
+		t.Fatalf("test binary accepted by an allowlist that only names electron")
+	}
+	cred, err := (&PeerPolicy{AllowedExes: []string{filepath.Base(self)}}).Check(server)
+	if err != nil {
+		t.Fatalf("Check with own base name: %v", err)
+	}
+	if cred.Exe != self {
+		t.Fatalf("exe = %q, want %q", cred.Exe, self)
+	}
+}
+
+func TestMatchExecutable(t *testing.T) {
+	tests := []struct {
+		exe  string
+		want bool
+	}{
+		{"/usr/lib/electron42/electron", true},
+		{"/usr/lib/electron42/electron42", true},
+		{"/usr/lib/claude-desktop/claude-desktop", true},
+		{"/tmp/.mount_ClaudeXy12/Claude", true},
+		{"/tmp/.mount_ClaudeXy12/claude-desktop", true},
+		{"/tmp/.mount_ClaudeXy12/chrome-sandbox", false},
+		{"/tmp/.mount_ClaudeXy12/usr/bin/python3", false},
+		{"/usr/bin/python3", false},
+		{"/home/u/.local/bin/claude", false},
+	}
+	for _, tc := range tests {
+		if got := matchExecutable(DefaultClientAllowlist, tc.exe); got != tc.want {
+			t.Errorf("matchExecutable(%q) = %v, want %v", tc.exe, got, tc.want)
+		}
+	}
+}
+
+func TestPeerPolicyRejectsNonUnixConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer func() { _ = a.Close() }()
+	defer func() { _ = b.Close() }()
+
+	if _, err := (&PeerPolicy{}).Check(a); err == nil {
+		t.Fatalf("Check accepted a non-unix connection")
+	}
+}
@@ -10,6 +10,11 @@ import (
 	"github.com/patrickjaja/claude-cowork-service/logx"
 )
 
+// ProtocolRevision is the Claude Desktop build whose protocol this daemon
+// implements, i.e. the revision COWORK_RPC_PROTOCOL.md was last validated
+// against. Reported by getCapabilities.
+const ProtocolRevision = "v1.15962.0"
+
 // Request represents an incoming RPC request from Claude Desktop.
 // Uses the same length-prefixed JSON protocol as the Windows named pipe.
 type Request struct {
@@ -22,15 +27,18 @@ type Request struct {
 // The TypeScript VM client (vZe) expects:
 //
 //	Success: {"success": true, "result": {...}, "id": <request-id>}
-//	Error:   {"success": false, "error": "message", "id": <request-id>}
+//	Error:   {"success": false, "error": "message", "code": -32000, "id": <request-id>}
 //
 // The "id" field MUST echo back the request ID so the client can match
 // responses to requests. Without it, responses are treated as "orphaned".
+// Desktop branches on "code" for retryable errors (-32002 backpressure).
 type Response struct {
 	ID      interface{} `json:"id,omitempty"`
 	Success bool        `json:"success"`
 	Result  interface{} `json:"result,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Code    int         `json:"code,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
 }
 
 // ReadMessage reads a length-prefixed JSON message from the connection.
@@ -93,10 +101,16 @@ func WriteResponse(conn net.Conn, id interface{}, result interface{}) {
 // WriteError sends an error response. Errors are logged at debug level for
 // the same reason as WriteResponse — the connection is already broken.
 func WriteError(conn net.Conn, id interface{}, code int, message string) {
+	writeError(conn, id, code, message, nil)
+}
+
+func writeError(conn net.Conn, id interface{}, code int, message string, errData interface{}) {
 	resp := Response{
 		ID:      id,
 		Success: false,
 		Error:   message,
+		Code:    code,
+		Data:    errData,
 	}
 	data, err := json.Marshal(resp)
 	if err != nil {
@@ -0,0 +1,311 @@
+package pipe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReplayOptions tunes Replay.
+type ReplayOptions struct {
+	// Timeout bounds the wait for each captured response (default 35s,
+	// a little above Desktop's own 30s RPC timeout).
+	Timeout time.Duration
+	// Settle is how long events keep being collected after the last
+	// request before the comparison runs (default 2s).
+	Settle time.Duration
+}
+
+// ReplayResult summarizes a replay. Mismatches is empty when the backend
+// answered every request and emitted the same event sequence as captured.
+type ReplayResult struct {
+	Requests   int
+	Responses  int
+	Events     int
+	Mismatches []string
+}
+
+// ReadCapture parses a JSONL capture written by Recorder. Blank lines are
+// skipped; a truncated final line (daemon killed mid-write) is ignored.
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+	br := bufio.NewReader(r)
+	var out []CaptureRecord
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var rec CaptureRecord
+			if jerr := json.Unmarshal(line, &rec); jerr != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, fmt.Errorf("capture line %d: %w", lineNo, jerr)
+			}
+			out = append(out, rec)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// replayConn is the client side of one replayed connection.
+type replayConn struct {
+	client net.Conn
+	done   chan struct{}
+
+	mu        sync.Mutex
+	responses map[string]chan json.RawMessage
+	events    []json.RawMessage
+}
+
+func (c *replayConn) responseChan(key string) chan json.RawMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.responses[key]
+	if !ok {
+		ch = make(chan json.RawMessage, 1)
+		c.responses[key] = ch
+	}
+	return ch
+}
+
+func (c *replayConn) readLoop(res *ReplayResult, resMu *sync.Mutex) {
+	defer close(c.done)
+	for {
+		payload, err := ReadMessage(c.client)
+		if err != nil {
+			return
+		}
+		msg := json.RawMessage(payload)
+		if isResponse(payload) {
+			resMu.Lock()
+			res.Responses++
+			resMu.Unlock()
+			select {
+			case c.responseChan(messageID(payload)) <- msg:
+			default:
+			}
+			continue
+		}
+		resMu.Lock()
+		res.Events++
+		resMu.Unlock()
+		c.mu.Lock()
+		c.events = append(c.events, msg)
+		c.mu.Unlock()
+	}
+}
+
+// Replay feeds the requests of a capture into backend through the regular
+// server request loop, one client connection per captured connection, and
+// diffs what comes back. Requests are sent in capture order and each one
+// waits for its response when the capture has one, so cross-connection
+// causality (spawn on one connection, events on another) is preserved.
+//
+// Responses are compared without their id. Events are compared by their
+// type and process id only, with consecutive repeats collapsed, since
+// stdout chunking and content differ between runs.
+func Replay(records []CaptureRecord, backend VMBackend, opts ReplayOptions) (*ReplayResult, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 35 * time.Second
+	}
+	if opts.Settle <= 0 {
+		opts.Settle = 2 * time.Second
+	}
+
+	expectedResp := map[uint64]map[string]json.RawMessage{}
+	expectedEvents := map[uint64][]json.RawMessage{}
+	for _, rec := range records {
+		switch rec.Dir {
+		case CaptureOut:
+			if expectedResp[rec.Conn] == nil {
+				expectedResp[rec.Conn] = map[string]json.RawMessage{}
+			}
+			expectedResp[rec.Conn][messageID(rec.Msg)] = rec.Msg
+		case CaptureEvent:
+			expectedEvents[rec.Conn] = append(expectedEvents[rec.Conn], rec.Msg)
+		}
+	}
+
+	// A replayed panic is reported as a mismatch; its crash report goes to
+	// a scratch dir rather than among the daemon's own.
+	crashDir, err := os.MkdirTemp("", "cowork-replay-crashes-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(crashDir) }()
+	srv := NewServer("", backend, false)
+	srv.SetCrashDir(crashDir)
+	res := &ReplayResult{}
+	var resMu sync.Mutex
+	conns := map[uint64]*replayConn{}
+	var order []uint64
+
+	for _, rec := range records {
+		if rec.Dir != CaptureIn {
+			continue
+		}
+		rc, ok := conns[rec.Conn]
+		if !ok {
+			server, client := net.Pipe()
+			rc = &replayConn{client: client, done: make(chan struct{}), responses: map[string]chan json.RawMessage{}}
+			conns[rec.Conn] = rc
+			order = append(order, rec.Conn)
+			go func() {
+				srv.serveConn(server)
+				_ = server.Close()
+			}()
+			go rc.readLoop(res, &resMu)
+		}
+
+		res.Requests++
+		key := messageID(rec.Msg)
+		method := messageMethod(rec.Msg)
+		want, hasWant := expectedResp[rec.Conn][key]
+		if err := WriteMessage(rc.client, rec.Msg); err != nil {
+			res.Mismatches = append(res.Mismatches, fmt.Sprintf("conn %d %s id=%s: write failed: %v", rec.Conn, method, key, err))
+			continue
+		}
+		if !hasWant {
+			continue
+		}
+		select {
+		case got := <-rc.responseChan(key):
+			if diff := diffResponse(want, got); diff != "" {
+				res.Mismatches = append(res.Mismatches, fmt.Sprintf("conn %d %s id=%s: %s", rec.Conn, method, key, diff))
+			}
+		case <-time.After(opts.Timeout):
+			res.Mismatches = append(res.Mismatches, fmt.Sprintf("conn %d %s id=%s: no response within %s", rec.Conn, method, key, opts.Timeout))
+		}
+	}
+
+	time.Sleep(opts.Settle)
+	for _, id := range order {
+		_ = conns[id].client.Close()
+		<-conns[id].done
+	}
+
+	ids := make([]uint64, 0, len(expectedEvents))
+	for id := range expectedEvents {
+		ids = append(ids, id)
+	}
+	for _, id := range order {
+		if _, ok := expectedEvents[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		var got []json.RawMessage
+		if rc := conns[id]; rc != nil {
+			got = rc.events
+		}
+		if diff := diffEvents(expectedEvents[id], got); diff != "" {
+			res.Mismatches = append(res.Mismatches, fmt.Sprintf("conn %d events: %s", id, diff))
+		}
+	}
+	return res, nil
+}
+
+func isResponse(payload []byte) bool {
+	var probe struct {
+		Success *bool `json:"success"`
+	}
+	return json.Unmarshal(payload, &probe) == nil && probe.Success != nil
+}
+
+// messageID returns the message's "id" in its JSON encoding, so 7 and "7"
+// stay distinct keys.
+func messageID(payload []byte) string {
+	var probe struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(payload, &probe) != nil || len(probe.ID) == 0 {
+		return "null"
+	}
+	return string(probe.ID)
+}
+
+func messageMethod(payload []byte) string {
+	var probe struct {
+		Method string `json:"method"`
+	}
+	_ = json.Unmarshal(payload, &probe)
+	return probe.Method
+}
+
+func diffResponse(want, got json.RawMessage) string {
+	var w, g map[string]interface{}
+	if err := json.Unmarshal(want, &w); err != nil {
+		return "captured response unreadable: " + err.Error()
+	}
+	if err := json.Unmarshal(got, &g); err != nil {
+		return "response unreadable: " + err.Error()
+	}
+	delete(w, "id")
+	delete(g, "id")
+	if reflect.DeepEqual(w, g) {
+		return ""
+	}
+	wj, _ := json.Marshal(w)
+	gj, _ := json.Marshal(g)
+	return fmt.Sprintf("response differs\n  want %s\n  got  %s", truncateDiff(wj), truncateDiff(gj))
+}
+
+// eventSignatures reduces events to "type/id" with consecutive repeats
+// collapsed.
+func eventSignatures(events []json.RawMessage) []string {
+	var out []string
+	for _, ev := range events {
+		var probe struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		}
+		_ = json.Unmarshal(ev, &probe)
+		sig := probe.Type
+		if probe.ID != "" {
+			sig += "/" + probe.ID
+		}
+		if len(out) > 0 && out[len(out)-1] == sig {
+			continue
+		}
+		out = append(out, sig)
+	}
+	return out
+}
+
+func diffEvents(want, got []json.RawMessage) string {
+	w, g := eventSignatures(want), eventSignatures(got)
+	for i := 0; i < len(w) || i < len(g); i++ {
+		switch {
+		case i >= len(w):
+			return fmt.Sprintf("unexpected extra event #%d %s", i, g[i])
+		case i >= len(g):
+			return fmt.Sprintf("missing event #%d %s (got %d of %d)", i, w[i], len(g), len(w))
+		case w[i] != g[i]:
+			return fmt.Sprintf("event #%d: want %s, got %s", i, w[i], g[i])
+		}
+	}
+	return ""
+}
+
+func truncateDiff(b []byte) string {
+	const max = 400
+	if len(b) <= max {
+		return string(b)
+	}
+	return string(b[:max]) + fmt.Sprintf("... (%d more bytes)", len(b)-max)
+}
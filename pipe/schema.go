@@ -0,0 +1,431 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Params structs declare their constraints in a `validate` tag next to the
+// `json` tag, so the wire schema lives with the Go type that decodes it:
+//
+//	required     present and non-empty (zero values count as missing)
+//	min=N,max=N  numeric bounds
+//	oneof=a|b    string enum; empty is allowed unless also required
+//	relpath      no ".." segments or NUL bytes (shared/home-relative paths)
+//	name         a single path component: no "/", NUL, "." or ".."
+//
+// relpath and name apply to each element of a []string. Struct and
+// map-of-struct fields are validated recursively. Unknown fields are
+// ignored so newer Desktop builds keep working.
+
+// paramError names the offending field of an invalid request.
+type paramError struct {
+	field string
+	msg   string
+}
+
+func (e *paramError) Error() string { return e.field + " " + e.msg }
+
+// decode unmarshals and validates req.Params into v (a pointer to a params
+// struct). On failure it answers -32602 with the field in data.field and
+// returns false. Absent or null params decode as an empty object.
+func (h *Handler) decode(conn net.Conn, req Request, v interface{}) bool {
+	err := decodeParams(req.Params, v)
+	if err == nil {
+		return true
+	}
+	var errData interface{}
+	var pe *paramError
+	if errors.As(err, &pe) {
+		errData = map[string]string{"field": pe.field}
+	}
+	writeError(conn, req.ID, CodeInvalidParams, "Invalid params: "+err.Error(), errData)
+	return false
+}
+
+func decodeParams(raw json.RawMessage, v interface{}) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		raw = json.RawMessage("{}")
+	}
+	t := reflect.TypeOf(v).Elem()
+	if err := checkJSONTypes(raw, t, ""); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return err
+	}
+	return checkRules(reflect.ValueOf(v).Elem(), "")
+}
+
+// jsonKind is the JSON Schema type of a raw value.
+func jsonKind(raw json.RawMessage) string {
+	switch raw[0] {
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	case '[':
+		return "array"
+	case '{':
+		return "object"
+	}
+	return "number"
+}
+
+// schemaType is the JSON Schema type a Go type decodes from ("" for any).
+func schemaType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Ptr:
+		return schemaType(t.Elem())
+	}
+	return ""
+}
+
+// checkJSONTypes compares raw against t before decoding, so a mismatch is
+// reported by field name rather than as an encoding/json error.
+func checkJSONTypes(raw json.RawMessage, t reflect.Type, field string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	want := schemaType(t)
+	got := jsonKind(raw)
+	if want == "" || got == "null" {
+		return nil
+	}
+	if field == "" && got != "object" {
+		return &paramError{field: "params", msg: "must be an object, got " + got}
+	}
+	switch {
+	case want == "integer" && got == "number":
+		if bytes.ContainsAny(raw, ".eE") {
+			return &paramError{field: field, msg: "must be an integer, got " + string(raw)}
+		}
+		if raw[0] == '-' && t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64 {
+			return &paramError{field: field, msg: "must not be negative, got " + string(raw)}
+		}
+		return nil
+	case want == "number" && got == "number":
+		return nil
+	case want != got:
+		return &paramError{field: field, msg: fmt.Sprintf("must be %s %s, got %s", article(want), want, got)}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return err
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := jsonName(f)
+			if name == "" {
+				continue
+			}
+			if v, ok := obj[name]; ok {
+				if err := checkJSONTypes(v, f.Type, join(field, name)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Map:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return err
+		}
+		for _, k := range sortedRawKeys(obj) {
+			if err := checkJSONTypes(obj[k], t.Elem(), join(field, k)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		var arr []json.RawMessage
+		if err := json.Unmarshal(raw, &arr); err != nil {
+			return err
+		}
+		for i, v := range arr {
+			if err := checkJSONTypes(v, t.Elem(), field+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRules applies the validate tags of struct v, recursing into nested
+// structs and maps of structs.
+func checkRules(v reflect.Value, field string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonName(f)
+		if name == "" {
+			continue
+		}
+		path := join(field, name)
+		fv := v.Field(i)
+		for _, rule := range parseRules(f.Tag.Get("validate")) {
+			if err := rule.check(fv, path); err != nil {
+				return err
+			}
+		}
+		switch fv.Kind() {
+		case reflect.Struct:
+			if err := checkRules(fv, path); err != nil {
+				return err
+			}
+		case reflect.Map:
+			if fv.Type().Elem().Kind() != reflect.Struct {
+				continue
+			}
+			keys := fv.MapKeys()
+			sort.Slice(keys, func(a, b int) bool { return keys[a].String() < keys[b].String() })
+			for _, k := range keys {
+				if err := checkRules(fv.MapIndex(k), join(path, k.String())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type rule struct {
+	name string
+	arg  string
+}
+
+func parseRules(tag string) []rule {
+	var rules []rule
+	for _, r := range strings.Split(tag, ",") {
+		if r == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(r, "=")
+		rules = append(rules, rule{name: name, arg: arg})
+	}
+	return rules
+}
+
+func (r rule) check(v reflect.Value, field string) error {
+	switch r.name {
+	case "required":
+		if v.IsZero() || (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0 {
+			return &paramError{field: field, msg: "is required"}
+		}
+	case "min", "max":
+		bound, _ := strconv.ParseInt(r.arg, 10, 64)
+		var n int64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = v.Int()
+		default:
+			return nil
+		}
+		if r.name == "min" && n < bound {
+			return &paramError{field: field, msg: fmt.Sprintf("must be >= %d, got %d", bound, n)}
+		}
+		if r.name == "max" && n > bound {
+			return &paramError{field: field, msg: fmt.Sprintf("must be <= %d, got %d", bound, n)}
+		}
+	case "oneof":
+		s := v.String()
+		if s == "" {
+			return nil
+		}
+		allowed := strings.Split(r.arg, "|")
+		for _, a := range allowed {
+			if s == a {
+				return nil
+			}
+		}
+		return &paramError{field: field, msg: fmt.Sprintf("must be one of %s, got %q", strings.Join(allowed, ", "), s)}
+	case "relpath", "name":
+		if v.Kind() == reflect.Slice {
+			for i := 0; i < v.Len(); i++ {
+				if err := checkPath(r.name, v.Index(i).String(), field+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return checkPath(r.name, v.String(), field)
+	}
+	return nil
+}
+
+func checkPath(kind, s, field string) error {
+	if strings.ContainsRune(s, 0) {
+		return &paramError{field: field, msg: "must not contain NUL bytes"}
+	}
+	if kind == "name" {
+		if s == "." || s == ".." || strings.ContainsRune(s, '/') {
+			return &paramError{field: field, msg: fmt.Sprintf("must be a single path component, got %q", s)}
+		}
+		return nil
+	}
+	for _, seg := range strings.Split(s, "/") {
+		if seg == ".." {
+			return &paramError{field: field, msg: fmt.Sprintf("must not contain \"..\" segments, got %q", s)}
+		}
+	}
+	return nil
+}
+
+// jsonName is the wire name of a struct field, or "" when it isn't
+// decoded from JSON.
+func jsonName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func article(typ string) string {
+	if typ == "integer" || typ == "object" || typ == "array" {
+		return "an"
+	}
+	return "a"
+}
+
+func sortedRawKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Patterns equivalent to the relpath and name rules, for the exported
+// schema.
+const (
+	relpathPattern = `^(?!(.*/)?\.\.(/|$))[^\x00]*$`
+	namePattern    = `^(?!\.\.?$)[^/\x00]*$`
+)
+
+// ParamsSchema returns a JSON Schema (draft 2020-12) document describing
+// the params of every registered method, under $defs keyed by method name.
+// Methods that take no params are listed with an empty object schema.
+func ParamsSchema() map[string]interface{} {
+	defs := make(map[string]interface{}, len(methods))
+	for name, m := range methods {
+		if m.params == nil {
+			defs[name] = map[string]interface{}{"type": "object"}
+			continue
+		}
+		defs[name] = typeSchema(reflect.TypeOf(m.params), "")
+	}
+	return map[string]interface{}{
+		"$schema":          "https://json-schema.org/draft/2020-12/schema",
+		"title":            "cowork-svc RPC params",
+		"protocolRevision": ProtocolRevision,
+		"$defs":            defs,
+	}
+}
+
+func typeSchema(t reflect.Type, tag string) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	s := map[string]interface{}{}
+	if typ := schemaType(t); typ != "" {
+		s["type"] = typ
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := jsonName(f)
+			if name == "" {
+				continue
+			}
+			vtag := f.Tag.Get("validate")
+			props[name] = typeSchema(f.Type, vtag)
+			for _, r := range parseRules(vtag) {
+				if r.name == "required" {
+					required = append(required, name)
+				}
+			}
+		}
+		s["properties"] = props
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	case reflect.Map:
+		s["additionalProperties"] = typeSchema(t.Elem(), "")
+		return s
+	case reflect.Slice, reflect.Array:
+		s["items"] = typeSchema(t.Elem(), tag)
+		return s
+	}
+	for _, r := range parseRules(tag) {
+		switch r.name {
+		case "required":
+			if t.Kind() == reflect.String {
+				s["minLength"] = 1
+			}
+		case "min":
+			n, _ := strconv.ParseInt(r.arg, 10, 64)
+			s["minimum"] = n
+		case "max":
+			n, _ := strconv.ParseInt(r.arg, 10, 64)
+			s["maximum"] = n
+		case "oneof":
+			enum := []interface{}{}
+			for _, a := range strings.Split(r.arg, "|") {
+				enum = append(enum, a)
+			}
+			if !strings.Contains(tag, "required") {
+				enum = append(enum, "")
+			}
+			s["enum"] = enum
+		case "relpath":
+			s["pattern"] = relpathPattern
+		case "name":
+			s["pattern"] = namePattern
+		}
+	}
+	return s
+}
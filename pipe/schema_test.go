@@ -0,0 +1,121 @@
+package pipe
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHandlerRejectsInvalidParamsNamingTheField(t *testing.T) {
+	tests := []struct {
+		method  string
+		params  string
+		field   string
+		message string
+	}{
+		{"kill", `{"signal":"SIGTERM"}`, "id", "id is required"},
+		{"spawn", `{"id":"p1","command":""}`, "command", "command is required"},
+		{"spawn", `{"command":"claude","name":"../etc"}`, "name", `name must be a single path component, got "../etc"`},
+		{"spawn", `{"command":"claude","additionalMounts":{"work":{"path":"home/u/../../etc","mode":"rw"}}}`, "additionalMounts.work.path", `must not contain ".." segments`},
+		{"spawn", `{"command":"claude","additionalMounts":{"work":{"path":"home/u","mode":"exec"}}}`, "additionalMounts.work.mode", `must be one of ro, rw, rwd, got "exec"`},
+		{"spawn", `{"command":"claude","args":["-p",3]}`, "args[1]", "args[1] must be a string, got number"},
+		{"createDiskImage", `{"diskName":"d","sizeGiB":-5}`, "sizeGiB", "sizeGiB must be >= 1, got -5"},
+		{"createDiskImage", `{"diskName":"d","sizeGiB":1.5}`, "sizeGiB", "sizeGiB must be an integer, got 1.5"},
+		{"writeStdin", `{"id":7,"data":"x"}`, "id", "id must be a string, got number"},
+		{"getSessionsDiskInfo", `[1]`, "params", "params must be an object, got array"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.field, func(t *testing.T) {
+			h := NewHandler(&recordingBackend{}, false)
+			server, client := net.Pipe()
+			defer func() { _ = client.Close() }()
+			go h.Handle(server, mustRawJSON(t, Request{Method: tc.method, Params: json.RawMessage(tc.params), ID: 1}))
+
+			payload, err := ReadMessage(client)
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			var resp struct {
+				Success bool              `json:"success"`
+				Error   string            `json:"error"`
+				Code    int               `json:"code"`
+				Data    map[string]string `json:"data"`
+			}
+			if err := json.Unmarshal(payload, &resp); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if resp.Success || resp.Code != CodeInvalidParams || resp.Data["field"] != tc.field || !strings.Contains(resp.Error, tc.message) {
+				t.Fatalf("response = %s, want -32602 on %s containing %q", payload, tc.field, tc.message)
+			}
+		})
+	}
+}
+
+func TestDecodeParamsAcceptsDesktopShapes(t *testing.T) {
+	var stop vmNameParams
+	if err := decodeParams(nil, &stop); err != nil {
+		t.Fatalf("stopVM without params: %v", err)
+	}
+	var spawn spawnParams
+	raw := `{"name":"local_1","id":"p1","command":"/usr/bin/claude","args":["-p"],"env":{"A":"1"},
+		"additionalMounts":{".cowork-lib":{"path":"home/u/.local/share/lib","mode":"ro"},"outputs":{"path":".config/x","mode":""}},
+		"futureField":{"nested":true}}`
+	if err := decodeParams(json.RawMessage(raw), &spawn); err != nil {
+		t.Fatalf("valid spawn rejected: %v", err)
+	}
+	if spawn.Cmd != "/usr/bin/claude" || spawn.AdditionalMounts[".cowork-lib"].Mode != "ro" {
+		t.Fatalf("decoded spawn = %+v", spawn)
+	}
+}
+
+// TestProtocolDocMatchesSchema checks every "**Params:**" block in
+// COWORK_RPC_PROTOCOL.md against the params structs: a documented field the
+// daemon doesn't decode, or a decoded field the section never mentions,
+// means one of the two has drifted.
+func TestProtocolDocMatchesSchema(t *testing.T) {
+	doc, err := os.ReadFile("../COWORK_RPC_PROTOCOL.md")
+	if err != nil {
+		t.Fatalf("reading protocol doc: %v", err)
+	}
+	defs := ParamsSchema()["$defs"].(map[string]interface{})
+	heading := regexp.MustCompile("(?m)^### (?:\\d+\\. )?`(\\w+)`.*$")
+	docKey := regexp.MustCompile(`(?m)^  "(\w+)":`)
+
+	locs := heading.FindAllSubmatchIndex(doc, -1)
+	checked := 0
+	for i, loc := range locs {
+		method := string(doc[loc[2]:loc[3]])
+		end := len(doc)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		section := string(doc[loc[1]:end])
+		def, ok := defs[method].(map[string]interface{})
+		if !ok || strings.Contains(string(doc[loc[0]:loc[1]]), "REMOVED") {
+			continue
+		}
+		props, _ := def["properties"].(map[string]interface{})
+
+		if start := strings.Index(section, "**Params:**\n```json\n"); start >= 0 {
+			block := section[start:]
+			block = block[:strings.Index(block[len("**Params:**\n```json\n"):], "```")+len("**Params:**\n```json\n")]
+			for _, m := range docKey.FindAllStringSubmatch(block, -1) {
+				if _, ok := props[m[1]]; !ok {
+					t.Errorf("%s: documented param %q is not decoded by the daemon", method, m[1])
+				}
+			}
+		}
+		for name := range props {
+			if !strings.Contains(section, "`"+name+"`") && !strings.Contains(section, `"`+name+`"`) {
+				t.Errorf("%s: param %q is decoded but not documented", method, name)
+			}
+		}
+		checked++
+	}
+	if checked < 15 {
+		t.Fatalf("only %d method sections checked; did the doc headings change?", checked)
+	}
+}
@@ -2,10 +2,14 @@ package pipe
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/patrickjaja/claude-cowork-service/logx"
 )
@@ -13,8 +17,8 @@ import (
 // VMBackend defines the interface that the VM manager must implement.
 // This decouples the pipe server from the VM implementation.
 type MountSpec struct {
-	Path string `json:"path"`
-	Mode string `json:"mode"`
+	Path string `json:"path" validate:"relpath"`
+	Mode string `json:"mode" validate:"oneof=ro|rw|rwd"`
 }
 
 type SessionsDiskInfo struct {
@@ -77,22 +81,83 @@ type Server struct {
 	backend    VMBackend
 	debug      bool
 	listener   net.Listener
+	adopted    bool // listener came from socket activation; systemd owns the path
+	peerPolicy *PeerPolicy
+	recorder   *Recorder
+	crashes    *CrashGuard
+	version    string
+	unknown    *UnknownMethods
+	nextConn   atomic.Uint64
+	heartbeat  atomic.Int64 // unix nanos of the accept loop's last turn
 	wg         sync.WaitGroup
 	quit       chan struct{}
+
+	tlsListener net.Listener // optional mutual-TLS listener (ListenTLS)
 }
 
+// acceptHeartbeat is how often an idle accept loop wakes up to prove it is
+// still turning, for Healthy.
+const acceptHeartbeat = 5 * time.Second
+
 // NewServer creates a new Unix socket server.
 func NewServer(socketPath string, backend VMBackend, debug bool) *Server {
 	return &Server{
 		socketPath: socketPath,
 		backend:    backend,
 		debug:      debug,
+		peerPolicy: &PeerPolicy{},
+		crashes:    NewCrashGuard(DefaultCrashDir()),
 		quit:       make(chan struct{}),
 	}
 }
 
+// SetCrashDir changes where crash reports for recovered handler panics are
+// written (default DefaultCrashDir). Must be called before Start.
+func (s *Server) SetCrashDir(dir string) {
+	s.crashes = NewCrashGuard(dir)
+}
+
+// SetVersion sets the daemon version reported by getCapabilities. Must be
+// called before Start.
+func (s *Server) SetVersion(v string) {
+	s.version = v
+}
+
+// SetUnknownMethods sets how methods missing from the registry are
+// answered (default: passthrough). Must be called before Start.
+func (s *Server) SetUnknownMethods(u *UnknownMethods) {
+	s.unknown = u
+}
+
+// SetPeerPolicy replaces the client authentication policy. Must be called
+// before Start. The default policy only enforces the uid check.
+func (s *Server) SetPeerPolicy(p *PeerPolicy) {
+	s.peerPolicy = p
+}
+
+// SetRecorder enables capture of all requests, responses and events to rec.
+// Must be called before Start.
+func (s *Server) SetRecorder(rec *Recorder) {
+	s.recorder = rec
+}
+
+// SetListener makes Start serve l (a socket passed in by systemd socket
+// activation) instead of binding socketPath itself. The socket file is then
+// left alone on Stop. Must be called before Start.
+func (s *Server) SetListener(l net.Listener) {
+	s.listener = l
+	s.adopted = true
+}
+
 // Start begins listening on the Unix socket.
 func (s *Server) Start() error {
+	s.heartbeat.Store(time.Now().UnixNano())
+	if s.adopted {
+		s.wg.Add(1)
+		go s.acceptLoop()
+		return nil
+	}
+
 	// Remove stale socket file if it exists
 	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
 		return err
@@ -126,25 +191,55 @@ func (s *Server) Stop() {
 			logx.Debug("closing listener on Stop: %v", err)
 		}
 	}
+	if s.tlsListener != nil {
+		if err := s.tlsListener.Close(); err != nil {
+			logx.Debug("closing TLS listener on Stop: %v", err)
+		}
+	}
 	s.wg.Wait()
+	if s.adopted {
+		return
+	}
 	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
 		logx.Debug("removing socket %s on Stop: %v", s.socketPath, err)
 	}
 }
 
+// Healthy reports an error when the accept loop has not turned within
+// maxAge beyond its own idle wake-up period. Used to gate systemd watchdog
+// pings.
+func (s *Server) Healthy(maxAge time.Duration) error {
+	last := time.Unix(0, s.heartbeat.Load())
+	if age := time.Since(last); age > acceptHeartbeat+maxAge {
+		return fmt.Errorf("accept loop stalled for %s", age.Round(time.Second))
+	}
+	return nil
+}
+
 func (s *Server) acceptLoop() {
 	defer s.wg.Done()
 
+	deadliner, _ := s.listener.(interface{ SetDeadline(time.Time) error })
 	for {
+		s.heartbeat.Store(time.Now().UnixNano())
+		if deadliner != nil {
+			_ = deadliner.SetDeadline(time.Now().Add(acceptHeartbeat))
+		}
 		conn, err := s.listener.Accept()
 		if err != nil {
 			select {
 			case <-s.quit:
 				return
 			default:
-				log.Printf("Accept error: %v", err)
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
 				continue
 			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Accept error: %v", err)
+			continue
 		}
 
 		s.wg.Add(1)
@@ -160,11 +255,91 @@ func (s *Server) handleConnection(conn net.Conn) {
 		}
 	}()
 
+	cred, err := s.peerPolicy.Check(conn)
+	if err != nil {
+		log.Printf("Rejected client (%s): %v", cred, err)
+		return
+	}
 	if s.debug {
-		log.Printf("Client connected: %s", conn.RemoteAddr())
+		log.Printf("Client connected: %s", cred)
+	}
+
+	s.serveConn(conn)
+}
+
+// stdinLaneDepth is how many writeStdin requests for one process may wait
+// behind the one being handled.
+const stdinLaneDepth = 64
+
+// stdinLanes runs a connection's writeStdin requests in order per process.
+// A lane's goroutine exits once the lane is empty.
+type stdinLanes struct {
+	handler *Handler
+	conn    net.Conn
+
+	mu    sync.Mutex
+	lanes map[string]chan []byte
+}
+
+// push queues payload on processID's lane. A full lane is answered with
+// backpressure, which Desktop retries, rather than blocking the read loop:
+// a process whose stdin is stuck must not stall the other processes or the
+// other requests on the connection.
+func (l *stdinLanes) push(processID string, id interface{}, payload []byte) {
+	l.mu.Lock()
+	lane := l.lanes[processID]
+	if lane == nil {
+		lane = make(chan []byte, stdinLaneDepth)
+		l.lanes[processID] = lane
+		go l.run(processID, lane)
+	}
+	queued := true
+	select {
+	case lane <- payload:
+	default:
+		queued = false
+	}
+	l.mu.Unlock()
+	if !queued {
+		writeBackendError(l.conn, id, ErrBackpressure)
+	}
+}
+
+func (l *stdinLanes) run(processID string, lane chan []byte) {
+	for {
+		select {
+		case p := <-lane:
+			l.handler.Handle(l.conn, p)
+		default:
+			// push holds mu while queueing, so an empty lane stays empty
+			// until it is gone from the map.
+			l.mu.Lock()
+			if len(lane) == 0 {
+				delete(l.lanes, processID)
+				l.mu.Unlock()
+				return
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// serveConn runs the request loop for one authenticated connection.
+func (s *Server) serveConn(conn net.Conn) {
+	connID := s.nextConn.Add(1)
+	if s.recorder != nil {
+		conn = s.recorder.wrap(conn, connID)
 	}
 
 	handler := NewHandler(s.backend, s.debug)
+	handler.guard = s.crashes
+	handler.version = s.version
+	handler.unknown = s.unknown
+
+	// writeStdin must reach each process in the order Desktop sent it, so
+	// a process's requests run one at a time on a lane of their own rather
+	// than fanning out with everything else.
+	lanes := &stdinLanes{handler: handler, conn: conn, lanes: map[string]chan []byte{}}
 
 	for {
 		select {
@@ -180,17 +355,28 @@ func (s *Server) handleConnection(conn net.Conn) {
 			}
 			return
 		}
+		if s.recorder != nil {
+			s.recorder.Record(connID, CaptureIn, payload)
+		}
 
 		// subscribeEvents takes ownership of the connection: its handler
 		// streams events and reads until the client disconnects, so it must
 		// run synchronously as the sole reader.
 		var probe struct {
-			Method string `json:"method"`
+			Method string      `json:"method"`
+			ID     interface{} `json:"id"`
+			Params struct {
+				ProcessID string `json:"id"`
+			} `json:"params"`
 		}
 		if err := json.Unmarshal(payload, &probe); err == nil && probe.Method == "subscribeEvents" {
 			handler.Handle(conn, payload)
 			return
 		}
+		if probe.Method == "writeStdin" {
+			lanes.push(probe.Params.ProcessID, probe.ID, payload)
+			continue
+		}
 
 		// Desktop (since v1.12603.0) multiplexes all RPCs over one persistent
 		// connection. Dispatch concurrently so a slow handler (e.g. kill's 1s
@@ -0,0 +1,130 @@
+package pipe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the handshake, so a client that connects and
+// sends nothing doesn't hold a connection and its goroutine forever.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// ServerTLSConfig builds the mutual-TLS config for ListenTLS: the daemon
+// presents certFile/keyFile and only accepts clients whose certificate
+// chains to caFile.
+func ServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, pool, err := loadTLSFiles(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+// ClientTLSConfig builds the mutual-TLS config for dialing a ListenTLS
+// daemon: certFile/keyFile is the client certificate, and the server's
+// certificate must chain to caFile.
+func ClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, pool, err := loadTLSFiles(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+func loadTLSFiles(certFile, keyFile, caFile string) (tls.Certificate, *x509.CertPool, error) {
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return tls.Certificate{}, nil, fmt.Errorf("mutual TLS needs a certificate, a key and a CA file")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("loading TLS key pair: %w", err)
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("reading TLS CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return tls.Certificate{}, nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return cert, pool, nil
+}
+
+// ListenTLS additionally serves the RPC protocol, with the same framing,
+// on a TCP address over mutual TLS, for a remote backend on another host.
+// The verified client certificate replaces the SO_PEERCRED check. Stop
+// closes it with the Unix socket.
+func (s *Server) ListenTLS(addr string, cfg *tls.Config) error {
+	ln, err := tls.Listen("tcp", addr, cfg)
+	if err != nil {
+		return err
+	}
+	s.tlsListener = ln
+	s.wg.Add(1)
+	go s.acceptTLS(ln)
+	return nil
+}
+
+// TLSAddr returns the address ListenTLS bound, or nil.
+func (s *Server) TLSAddr() net.Addr {
+	if s.tlsListener == nil {
+		return nil
+	}
+	return s.tlsListener.Addr()
+}
+
+func (s *Server) acceptTLS(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("TLS accept error: %v", err)
+			continue
+		}
+		s.wg.Add(1)
+		go s.handleTLSConnection(conn.(*tls.Conn))
+	}
+}
+
+func (s *Server) handleTLSConnection(conn *tls.Conn) {
+	defer s.wg.Done()
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		log.Printf("Rejected TLS client %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if s.debug {
+		peer := "?"
+		if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			peer = certs[0].Subject.CommonName
+		}
+		log.Printf("TLS client connected: %s (%s)", conn.RemoteAddr(), peer)
+	}
+	s.serveConn(conn)
+}
@@ -0,0 +1,260 @@
+package pipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/logx"
+)
+
+// UnknownMethodPolicy decides how methods missing from the registry are
+// answered.
+type UnknownMethodPolicy int
+
+const (
+	// UnknownPassthrough answers success with a null result, so a Desktop
+	// build that added a call keeps working if it tolerates the empty
+	// answer (it usually does).
+	UnknownPassthrough UnknownMethodPolicy = iota
+	// UnknownReject answers -32601, surfacing drift as errors.
+	UnknownReject
+	// UnknownRecord passes through like UnknownPassthrough and persists the
+	// method name, a params sample and when it was first seen.
+	UnknownRecord
+)
+
+func (p UnknownMethodPolicy) String() string {
+	switch p {
+	case UnknownReject:
+		return "reject"
+	case UnknownRecord:
+		return "record"
+	}
+	return "passthrough"
+}
+
+// ParseUnknownMethodPolicy parses the -unknown-methods flag value.
+func ParseUnknownMethodPolicy(s string) (UnknownMethodPolicy, error) {
+	switch s {
+	case "", "passthrough":
+		return UnknownPassthrough, nil
+	case "reject":
+		return UnknownReject, nil
+	case "record":
+		return UnknownRecord, nil
+	}
+	return UnknownPassthrough, fmt.Errorf("invalid unknown-method policy %q (want passthrough, reject or record)", s)
+}
+
+// unknownParamsSampleMax bounds the params sample kept per method, so one
+// huge call doesn't bloat the state file.
+const unknownParamsSampleMax = 4096
+
+// unknownMethodsMax bounds how many distinct methods are recorded, so a
+// client making up method names can't grow the state without limit. Calls
+// to methods past it are only counted.
+const unknownMethodsMax = 256
+
+// unknownSaveInterval throttles writing the state file for repeat calls to
+// known methods; a method seen for the first time is written at once.
+const unknownSaveInterval = 30 * time.Second
+
+// UnknownMethod is what is kept about one unrecognized method.
+type UnknownMethod struct {
+	Method    string    `json:"method"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Count     int       `json:"count"`
+	// ParamsSample holds the redacted params of the first call. Samples
+	// over unknownParamsSampleMax are cut and stored as a JSON string.
+	ParamsSample json.RawMessage `json:"paramsSample,omitempty"`
+}
+
+// UnknownMethods applies the unknown-method policy and, under
+// UnknownRecord, keeps the persisted list of methods seen.
+type UnknownMethods struct {
+	policy UnknownMethodPolicy
+	path   string
+
+	mu   sync.Mutex
+	seen map[string]*UnknownMethod
+	// dropped counts calls to methods not recorded because seen was full.
+	dropped int
+	// dirty is set when seen has counts the state file lacks; save is
+	// the pending throttled write, if any.
+	dirty bool
+	save  *time.Timer
+}
+
+// DefaultUnknownMethodsPath is the record policy's state file under
+// $XDG_STATE_HOME (or ~/.local/state).
+func DefaultUnknownMethodsPath() string {
+	return filepath.Join(stateDir(), "unknown-methods.json")
+}
+
+// NewUnknownMethods returns the policy holder. Under UnknownRecord the state
+// file at path is loaded, so first-seen times survive restarts.
+func NewUnknownMethods(policy UnknownMethodPolicy, path string) (*UnknownMethods, error) {
+	u := &UnknownMethods{policy: policy, path: path, seen: make(map[string]*UnknownMethod)}
+	if policy != UnknownRecord {
+		return u, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading unknown-method state: %w", err)
+	}
+	var list []UnknownMethod
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing unknown-method state %s: %w", path, err)
+	}
+	for i := range list {
+		u.seen[list[i].Method] = &list[i]
+	}
+	return u, nil
+}
+
+// Policy returns the configured policy.
+func (u *UnknownMethods) Policy() UnknownMethodPolicy {
+	return u.policy
+}
+
+// List returns the recorded methods, sorted by name.
+func (u *UnknownMethods) List() []UnknownMethod {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.listLocked()
+}
+
+// Dropped returns how many calls went to methods that weren't recorded
+// because unknownMethodsMax distinct methods already were.
+func (u *UnknownMethods) Dropped() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.dropped
+}
+
+func (u *UnknownMethods) listLocked() []UnknownMethod {
+	out := make([]UnknownMethod, 0, len(u.seen))
+	for _, m := range u.seen {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Method < out[j].Method })
+	return out
+}
+
+// handle answers req, whose method is not in the registry.
+func (u *UnknownMethods) handle(conn net.Conn, req Request) {
+	switch u.policy {
+	case UnknownReject:
+		logx.Debug("RPC: unknown method %q — rejecting", req.Method)
+		WriteError(conn, req.ID, CodeMethodNotFound, "Method not found: "+req.Method)
+		return
+	case UnknownRecord:
+		u.record(req)
+	}
+	logx.Debug("RPC: unknown method %q — returning success (passthrough)", req.Method)
+	WriteResponse(conn, req.ID, nil)
+}
+
+func (u *UnknownMethods) record(req Request) {
+	now := time.Now().UTC()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	m := u.seen[req.Method]
+	if m == nil {
+		if len(u.seen) >= unknownMethodsMax {
+			if u.dropped == 0 {
+				log.Printf("RPC: %d unknown methods recorded; counting calls to further ones without recording them", unknownMethodsMax)
+			}
+			u.dropped++
+			return
+		}
+		m = &UnknownMethod{Method: req.Method, FirstSeen: now, LastSeen: now, Count: 1, ParamsSample: paramsSample(req.Params)}
+		u.seen[req.Method] = m
+		log.Printf("RPC: first call to unknown method %q recorded in %s", req.Method, u.path)
+		if err := u.saveLocked(); err != nil {
+			log.Printf("Saving unknown-method state: %v", err)
+		}
+		return
+	}
+	m.LastSeen = now
+	m.Count++
+	u.dirty = true
+	if u.save == nil {
+		u.save = time.AfterFunc(unknownSaveInterval, func() {
+			if err := u.Flush(); err != nil {
+				log.Printf("Saving unknown-method state: %v", err)
+			}
+		})
+	}
+}
+
+// Flush writes counts not yet in the state file, such as at shutdown.
+func (u *UnknownMethods) Flush() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.save != nil {
+		u.save.Stop()
+		u.save = nil
+	}
+	if !u.dirty {
+		return nil
+	}
+	return u.saveLocked()
+}
+
+// saveLocked writes the state file atomically. Caller holds u.mu.
+func (u *UnknownMethods) saveLocked() error {
+	data, err := json.MarshalIndent(u.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(u.path), 0700); err != nil {
+		return err
+	}
+	tmp := u.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, u.path); err != nil {
+		return err
+	}
+	u.dirty = false
+	return nil
+}
+
+func paramsSample(params json.RawMessage) json.RawMessage {
+	if len(params) == 0 {
+		return nil
+	}
+	sample, err := RedactJSON(params)
+	if err != nil {
+		return nil
+	}
+	if len(sample) > unknownParamsSampleMax {
+		cut, _ := json.Marshal(string(sample[:unknownParamsSampleMax]) + "...")
+		return cut
+	}
+	return sample
+}
+
+// stateDir is $XDG_STATE_HOME/claude-cowork, falling back to
+// ~/.local/state/claude-cowork.
+func stateDir() string {
+	state := os.Getenv("XDG_STATE_HOME")
+	if state == "" {
+		home, _ := os.UserHomeDir()
+		state = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(state, "claude-cowork")
+}
@@ -0,0 +1,146 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func callUnknown(t *testing.T, h *Handler, params interface{}) Response {
+	t.Helper()
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go h.Handle(server, mustRawJSON(t, Request{Method: "getNetworkDrives", Params: mustRawJSON(t, params), ID: 1}))
+	payload, err := ReadMessage(client)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return resp
+}
+
+func TestUnknownMethodReject(t *testing.T) {
+	u, err := NewUnknownMethods(UnknownReject, filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("NewUnknownMethods: %v", err)
+	}
+	h := NewHandler(&recordingBackend{}, false)
+	h.unknown = u
+
+	resp := callUnknown(t, h, map[string]string{})
+	if resp.Success || resp.Code != CodeMethodNotFound || !strings.Contains(resp.Error, "getNetworkDrives") {
+		t.Fatalf("response = %+v, want -32601 naming the method", resp)
+	}
+}
+
+func TestUnknownMethodRecordPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "unknown-methods.json")
+	u, err := NewUnknownMethods(UnknownRecord, path)
+	if err != nil {
+		t.Fatalf("NewUnknownMethods: %v", err)
+	}
+	h := NewHandler(&recordingBackend{}, false)
+	h.unknown = u
+
+	if resp := callUnknown(t, h, map[string]string{"apiToken": "s3cret", "share": "work"}); !resp.Success {
+		t.Fatalf("record policy must pass through, got %+v", resp)
+	}
+	callUnknown(t, h, map[string]string{"share": "other"})
+	if err := u.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	reloaded, err := NewUnknownMethods(UnknownRecord, path)
+	if err != nil {
+		t.Fatalf("reloading state: %v", err)
+	}
+	list := reloaded.List()
+	if len(list) != 1 {
+		t.Fatalf("recorded = %+v, want one method", list)
+	}
+	m := list[0]
+	if m.Method != "getNetworkDrives" || m.Count != 2 || m.FirstSeen.IsZero() || m.LastSeen.Before(m.FirstSeen) {
+		t.Fatalf("recorded = %+v", m)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, m.ParamsSample); err != nil {
+		t.Fatalf("params sample %s: %v", m.ParamsSample, err)
+	}
+	sample := compact.String()
+	if !strings.Contains(sample, `"share":"work"`) || strings.Contains(sample, "s3cret") {
+		t.Fatalf("params sample = %s, want first call's params, redacted", sample)
+	}
+}
+
+// TestUnknownMethodRecordThrottlesWrites checks that only a method's first
+// call writes the state file; later counts wait for the throttled save.
+func TestUnknownMethodRecordThrottlesWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	u, err := NewUnknownMethods(UnknownRecord, path)
+	if err != nil {
+		t.Fatalf("NewUnknownMethods: %v", err)
+	}
+	t.Cleanup(func() { _ = u.Flush() })
+	u.record(Request{Method: "getNetworkDrives"})
+	first, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("first call not saved: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		u.record(Request{Method: "getNetworkDrives"})
+	}
+	if again, _ := os.ReadFile(path); !bytes.Equal(again, first) {
+		t.Fatalf("repeat calls rewrote the state file:\n%s", again)
+	}
+	if err := u.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	reloaded, err := NewUnknownMethods(UnknownRecord, path)
+	if err != nil {
+		t.Fatalf("reloading state: %v", err)
+	}
+	if list := reloaded.List(); len(list) != 1 || list[0].Count != 11 {
+		t.Fatalf("recorded = %+v, want 11 calls", list)
+	}
+}
+
+func TestUnknownMethodRecordCapsMethods(t *testing.T) {
+	u, err := NewUnknownMethods(UnknownRecord, filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("NewUnknownMethods: %v", err)
+	}
+	t.Cleanup(func() { _ = u.Flush() })
+	for i := 0; i < unknownMethodsMax+5; i++ {
+		u.record(Request{Method: fmt.Sprintf("made-up-%d", i)})
+	}
+	u.record(Request{Method: "made-up-0"})
+	if n := len(u.List()); n != unknownMethodsMax {
+		t.Fatalf("recorded %d methods, want %d", n, unknownMethodsMax)
+	}
+	if d := u.Dropped(); d != 5 {
+		t.Fatalf("dropped = %d, want 5", d)
+	}
+}
+
+func TestAdminStatusReportsUnknownMethods(t *testing.T) {
+	u, err := NewUnknownMethods(UnknownRecord, filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("NewUnknownMethods: %v", err)
+	}
+	u.record(Request{Method: "getNetworkDrives"})
+
+	admin := NewAdminServer(filepath.Join(t.TempDir(), "admin.sock"), &recordingBackend{}, "test")
+	admin.SetUnknownMethods(u)
+	st := admin.status()
+	if st.UnknownMethodPolicy != "record" || len(st.UnknownMethods) != 1 || st.UnknownMethods[0].Method != "getNetworkDrives" {
+		t.Fatalf("status = %+v", st)
+	}
+}
@@ -0,0 +1,279 @@
+package pipe
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Minimal RFC 6455 WebSocket support for the gateway: the server handshake
+// and message framing, no extensions. Only what the gateway needs, to stay
+// stdlib-only.
+
+// wsGUID is the fixed key suffix of the opening handshake (RFC 6455 §1.3).
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessage matches ReadMessage's limit on the Unix socket.
+const wsMaxMessage = 10 * 1024 * 1024
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// Close status codes used by the gateway.
+const (
+	wsCloseNormal   = 1000
+	wsCloseProtocol = 1002
+	wsCloseTooBig   = 1009
+)
+
+// errWSClosed is returned by readMessage once the peer sent a close frame.
+var errWSClosed = errors.New("websocket closed by peer")
+
+// wsConn is one WebSocket connection. The server side reads masked frames
+// and writes unmasked ones; client (used by tests) does the reverse.
+type wsConn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// wsAccept computes Sec-WebSocket-Accept for a Sec-WebSocket-Key.
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether a comma-separated header contains token,
+// case-insensitively ("Connection: keep-alive, Upgrade").
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWebSocketUpgrade reports whether r asks to switch to WebSocket.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// upgradeWebSocket completes the server handshake and takes over the
+// connection. subprotocol, when non-empty, is echoed in
+// Sec-WebSocket-Protocol (browsers fail the connection if they offered
+// protocols and none is selected).
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, subprotocol string) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "bad WebSocket handshake", http.StatusBadRequest)
+		return nil, fmt.Errorf("bad WebSocket handshake")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("response writer %T cannot be hijacked", w)
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijacking connection: %w", err)
+	}
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n"
+	if subprotocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	if _, err := conn.Write([]byte(resp + "\r\n")); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("writing handshake: %w", err)
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// readMessage returns the next text or binary message, answering pings and
+// reassembling fragments on the way. It returns errWSClosed after the
+// peer's close frame (which it answers).
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			_ = c.close(wsCloseNormal)
+			return nil, errWSClosed
+		case wsOpText, wsOpBinary:
+			if started {
+				_ = c.close(wsCloseProtocol)
+				return nil, fmt.Errorf("websocket: new message inside a fragmented one")
+			}
+			started = true
+		case wsOpContinuation:
+			if !started {
+				_ = c.close(wsCloseProtocol)
+				return nil, fmt.Errorf("websocket: continuation without a message")
+			}
+		default:
+			_ = c.close(wsCloseProtocol)
+			return nil, fmt.Errorf("websocket: unknown opcode %#x", op)
+		}
+		if len(msg)+len(payload) > wsMaxMessage {
+			_ = c.close(wsCloseTooBig)
+			return nil, fmt.Errorf("websocket: message larger than %d bytes", wsMaxMessage)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	op = hdr[0] & 0x0F
+	if hdr[0]&0x70 != 0 {
+		_ = c.close(wsCloseProtocol)
+		return false, 0, nil, fmt.Errorf("websocket: reserved bits set (no extensions negotiated)")
+	}
+	masked := hdr[1]&0x80 != 0
+	if masked == c.client {
+		// Clients must mask, servers must not (RFC 6455 §5.1).
+		_ = c.close(wsCloseProtocol)
+		return false, 0, nil, fmt.Errorf("websocket: unexpected masking")
+	}
+	length := uint64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsOpClose && (length > 125 || !fin) {
+		_ = c.close(wsCloseProtocol)
+		return false, 0, nil, fmt.Errorf("websocket: malformed control frame")
+	}
+	if length > wsMaxMessage {
+		_ = c.close(wsCloseTooBig)
+		return false, 0, nil, fmt.Errorf("websocket: frame larger than %d bytes", wsMaxMessage)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// writeMessage sends data as one text message (the RPC envelopes are JSON).
+func (c *wsConn) writeMessage(data []byte) error {
+	return c.writeFrame(wsOpText, data)
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, 0x80|op)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		for i := range buf[start:] {
+			buf[start+i] ^= mask[i%4]
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// close sends a close frame with code (once) and closes the connection.
+func (c *wsConn) close(code uint16) error {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	err := c.writeFrame(wsOpClose, payload)
+	c.writeMu.Lock()
+	c.closed = true
+	c.writeMu.Unlock()
+	if cerr := c.conn.Close(); err == nil && !errors.Is(cerr, net.ErrClosed) {
+		err = cerr
+	}
+	return err
+}
@@ -2,6 +2,10 @@ package process
 
 // Event types that match the Windows cowork-svc protocol.
 
+// EventTypes lists the "type" values the daemon can emit, as advertised by
+// getCapabilities.
+var EventTypes = []string{"stdout", "stderr", "exit", "error", "apiReachability", "startupStep", "networkStatus", "vmStarted", "vmStopped"}
+
 // StdoutEvent is emitted when a process writes to stdout.
 // The client expects "id" (not "processId") per the Cowork protocol.
 type StdoutEvent struct {
@@ -114,3 +118,55 @@ func NewNetworkStatusEvent(connected bool) NetworkStatusEvent {
 func NewErrorEvent(processID string, message string, fatal bool) ErrorEvent {
 	return ErrorEvent{Type: "error", ProcessID: processID, Message: message, Fatal: fatal}
 }
+
+// EventType returns the "type" field of an event value as passed to
+// subscriber callbacks: one of the typed events above, or the ad-hoc maps
+// backends emit for vmStarted/vmStopped and forwarded guest events.
+func EventType(event interface{}) string {
+	switch ev := event.(type) {
+	case StdoutEvent:
+		return ev.Type
+	case StderrEvent:
+		return ev.Type
+	case ExitEvent:
+		return ev.Type
+	case ErrorEvent:
+		return ev.Type
+	case APIReachableEvent:
+		return ev.Type
+	case StartupStepEvent:
+		return ev.Type
+	case NetworkStatusEvent:
+		return ev.Type
+	case map[string]string:
+		return ev["type"]
+	case map[string]interface{}:
+		s, _ := ev["type"].(string)
+		return s
+	default:
+		return ""
+	}
+}
+
+// EventProcessID returns the process id an event belongs to, or "" for
+// events that are not about a single process (VM lifecycle, network, API
+// reachability, startup steps).
+func EventProcessID(event interface{}) string {
+	switch ev := event.(type) {
+	case StdoutEvent:
+		return ev.ProcessID
+	case StderrEvent:
+		return ev.ProcessID
+	case ExitEvent:
+		return ev.ProcessID
+	case ErrorEvent:
+		return ev.ProcessID
+	case map[string]string:
+		return ev["id"]
+	case map[string]interface{}:
+		s, _ := ev["id"].(string)
+		return s
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,39 @@
+package remote
+
+import (
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+// Healthy implements pipe.HealthChecker. An unreachable remote is not
+// unhealthy: restarting this daemon wouldn't bring it back.
+func (b *Backend) Healthy() error {
+	return b.events.Healthy(time.Second)
+}
+
+// Status implements pipe.StatusReporter from local state only, since
+// getCapabilities asks for it on every call.
+func (b *Backend) Status() pipe.BackendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	connected := false
+	if b.conn != nil {
+		select {
+		case <-b.conn.Done():
+		default:
+			connected = true
+		}
+	}
+	return pipe.BackendStatus{
+		Backend:        "remote",
+		Running:        b.started,
+		GuestConnected: connected,
+		Subscribers:    b.events.Len(),
+		Details: map[string]interface{}{
+			"remote":        b.addr,
+			"pathMap":       b.paths.String(),
+			"liveProcesses": len(b.live),
+		},
+	}
+}
@@ -0,0 +1,520 @@
+// Package remote implements pipe.VMBackend by relaying every call, and the
+// event stream, to a cowork-svc daemon on another host: over a Unix socket
+// (typically forwarded with ssh -L) or over TCP with mutual TLS. Desktop on
+// a laptop can then run sessions on a workstation's native or KVM backend.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/client"
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+// callTimeout bounds one forwarded call. Desktop gives up on a request
+// after 30s, so there is no point waiting longer for the remote.
+const callTimeout = 30 * time.Second
+
+// Resubscribe backoff after the remote event stream drops.
+const (
+	minResubscribeDelay = 500 * time.Millisecond
+	maxResubscribeDelay = 10 * time.Second
+)
+
+// Backend forwards to a remote daemon. The RPC connection is dialed on
+// first use and redialed after it drops; the event stream is one upstream
+// subscription, resumed with sinceSeq after a drop, fanned out to local
+// subscribers through an EventBus.
+type Backend struct {
+	addr  string
+	dial  client.DialFunc
+	paths PathMap
+	debug atomic.Bool
+
+	events *pipe.EventBus
+
+	mu      sync.Mutex
+	conn    *client.Client
+	started bool
+	live    map[string]struct{} // process ids spawned and not yet exited
+	pumping bool
+	pumped  chan struct{} // closed once the first upstream subscribe was tried
+	stop    chan struct{}
+}
+
+// NewBackend creates a backend that relays to the daemon reached through
+// dial. addr names it in logs and errors. Nothing is dialed until the
+// first call, so the daemon starts while the remote is still unreachable.
+func NewBackend(addr string, dial client.DialFunc, paths PathMap, debug bool) *Backend {
+	b := &Backend{
+		addr:   addr,
+		dial:   dial,
+		paths:  paths,
+		events: pipe.NewEventBus(),
+		live:   make(map[string]struct{}),
+		pumped: make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	b.debug.Store(debug)
+	return b
+}
+
+// client returns the RPC connection, dialing it if needed. Dial failures
+// come back as pipe.ErrGuestNotConnected: to Desktop, an unreachable remote
+// is a guest that isn't there.
+func (b *Backend) client(ctx context.Context) (*client.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		select {
+		case <-b.conn.Done():
+			_ = b.conn.Close()
+			b.conn = nil
+		default:
+			return b.conn, nil
+		}
+	}
+	c, err := client.DialWith(ctx, b.dial)
+	if err != nil {
+		return nil, fmt.Errorf("%w: remote %s: %v", pipe.ErrGuestNotConnected, b.addr, err)
+	}
+	b.conn = c
+	return c, nil
+}
+
+// do runs fn against the RPC connection. Error responses from the remote
+// keep their wire code, so Desktop sees the same -32001/-32002/... it would
+// locally.
+func (b *Backend) do(method string, fn func(ctx context.Context, c *client.Client) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	c, err := b.client(ctx)
+	if err == nil {
+		err = fn(ctx, c)
+	}
+	if b.debug.Load() {
+		log.Printf("[remote] %s → %v", method, err)
+	}
+	return err
+}
+
+func (b *Backend) Configure(memoryMB int, cpuCount int) error {
+	return b.do("configure", func(ctx context.Context, c *client.Client) error {
+		return c.Configure(ctx, memoryMB, cpuCount)
+	})
+}
+
+func (b *Backend) CreateVM(name string) error {
+	return b.do("createVM", func(ctx context.Context, c *client.Client) error {
+		return c.CreateVM(ctx, name)
+	})
+}
+
+func (b *Backend) StartVM(name string, bundlePath string, memoryGB int, cpuCount int, apiProbeURL string) error {
+	err := b.do("startVM", func(ctx context.Context, c *client.Client) error {
+		return c.StartVM(ctx, name, bundlePath, memoryGB, cpuCount, apiProbeURL)
+	})
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.started = true
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Backend) StopVM(name string) error {
+	err := b.do("stopVM", func(ctx context.Context, c *client.Client) error {
+		return c.StopVM(ctx, name)
+	})
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.started = false
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Backend) IsRunning(name string) (running bool, err error) {
+	err = b.do("isRunning", func(ctx context.Context, c *client.Client) (err error) {
+		running, err = c.IsRunning(ctx, name)
+		return err
+	})
+	return running, err
+}
+
+// IsGuestConnected answers false rather than failing while the remote is
+// unreachable; Desktop polls it and waits.
+func (b *Backend) IsGuestConnected(name string) (connected bool, err error) {
+	err = b.do("isGuestConnected", func(ctx context.Context, c *client.Client) (err error) {
+		connected, err = c.IsGuestConnected(ctx, name)
+		return err
+	})
+	if errors.Is(err, pipe.ErrGuestNotConnected) {
+		return false, nil
+	}
+	return connected, err
+}
+
+// Spawn forwards Desktop's raw params, so fields the handler doesn't decode
+// (isResume, allowedDomains, oneShot, ...) reach the remote, with command,
+// args, env, cwd and mounts translated through the path map. Mounts the
+// map doesn't cover are left out and reported in failedMounts, along with
+// whatever the remote itself failed to attach.
+func (b *Backend) Spawn(name string, id string, cmd string, args []string, env map[string]string, cwd string, mounts map[string]pipe.MountSpec, rawParams []byte, oauthToken string) (string, []string, error) {
+	params := map[string]interface{}{}
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			log.Printf("[remote] spawn: could not parse raw params: %v", err)
+			params = map[string]interface{}{}
+		}
+	}
+	if oauthToken != "" {
+		params["oauthToken"] = oauthToken
+	}
+
+	home, _ := os.UserHomeDir()
+	failedMounts := []string{}
+	remoteMounts := make(map[string]pipe.MountSpec, len(mounts))
+	for mountName, mount := range mounts {
+		path, ok := b.paths.remoteMountPath(home, mount.Path)
+		if !ok {
+			log.Printf("[remote] mount %s (%s) is not in the path map; not available on %s", mountName, localMountPath(home, mount.Path), b.addr)
+			failedMounts = append(failedMounts, mountName)
+			continue
+		}
+		remoteMounts[mountName] = pipe.MountSpec{Path: path, Mode: mount.Mode}
+	}
+
+	remoteArgs := make([]string, len(args))
+	for i, a := range args {
+		remoteArgs[i] = b.paths.translate(a)
+	}
+	remoteEnv := make(map[string]string, len(env))
+	for k, v := range env {
+		remoteEnv[k] = b.paths.translate(v)
+	}
+	params["name"] = name
+	params["id"] = id
+	params["command"] = b.paths.translate(cmd)
+	params["args"] = remoteArgs
+	params["env"] = remoteEnv
+	params["cwd"] = b.paths.translate(cwd)
+	params["additionalMounts"] = remoteMounts
+
+	var r struct {
+		ID           string   `json:"id"`
+		FailedMounts []string `json:"failedMounts"`
+	}
+	err := b.do("spawn", func(ctx context.Context, c *client.Client) error {
+		return c.Call(ctx, "spawn", params, &r)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if r.ID == "" {
+		r.ID = id
+	}
+	b.mu.Lock()
+	b.live[r.ID] = struct{}{}
+	b.mu.Unlock()
+	return r.ID, append(failedMounts, r.FailedMounts...), nil
+}
+
+func (b *Backend) Kill(processID string, signal string) error {
+	return b.do("kill", func(ctx context.Context, c *client.Client) error {
+		return c.Kill(ctx, processID, signal)
+	})
+}
+
+func (b *Backend) WriteStdin(processID string, data []byte) error {
+	return b.do("writeStdin", func(ctx context.Context, c *client.Client) error {
+		return c.WriteStdin(ctx, processID, data)
+	})
+}
+
+func (b *Backend) IsProcessRunning(processID string) (running bool, exitCode int, err error) {
+	err = b.do("isProcessRunning", func(ctx context.Context, c *client.Client) (err error) {
+		running, exitCode, err = c.IsProcessRunning(ctx, processID)
+		return err
+	})
+	return running, exitCode, err
+}
+
+func (b *Backend) MountPath(processID string, subpath string, mountName string, mode string) error {
+	home, _ := os.UserHomeDir()
+	path, ok := b.paths.remoteMountPath(home, subpath)
+	if !ok {
+		return &pipe.Error{
+			Code:    pipe.CodeMountFailed,
+			Message: fmt.Sprintf("%s is not in the remote path map", localMountPath(home, subpath)),
+			Data:    map[string]string{"mountName": mountName},
+		}
+	}
+	return b.do("mountPath", func(ctx context.Context, c *client.Client) error {
+		return c.MountPath(ctx, processID, path, mountName, mode)
+	})
+}
+
+func (b *Backend) ReadFile(processName string, filePath string) (data []byte, err error) {
+	err = b.do("readFile", func(ctx context.Context, c *client.Client) (err error) {
+		data, err = c.ReadFile(ctx, processName, b.paths.translate(filePath))
+		return err
+	})
+	return data, err
+}
+
+func (b *Backend) InstallSdk(sdkSubpath string, version string) error {
+	home, _ := os.UserHomeDir()
+	if path, ok := b.paths.remoteMountPath(home, sdkSubpath); ok {
+		sdkSubpath = path
+	}
+	return b.do("installSdk", func(ctx context.Context, c *client.Client) error {
+		return c.InstallSdk(ctx, sdkSubpath, version)
+	})
+}
+
+func (b *Backend) AddApprovedOauthToken(token string) error {
+	return b.do("addApprovedOauthToken", func(ctx context.Context, c *client.Client) error {
+		return c.AddApprovedOauthToken(ctx, token)
+	})
+}
+
+// SetDebugLogging switches logging here and on the remote.
+func (b *Backend) SetDebugLogging(enabled bool) {
+	b.debug.Store(enabled)
+	err := b.do("setDebugLogging", func(ctx context.Context, c *client.Client) error {
+		return c.SetDebugLogging(ctx, enabled)
+	})
+	if err != nil {
+		log.Printf("[remote] setDebugLogging: %v", err)
+	}
+}
+
+// SubscribeEvents starts the upstream subscription on first use and
+// attaches callback to the local bus.
+func (b *Backend) SubscribeEvents(name string, callback func(event interface{})) (func(), error) {
+	b.startPump(name)
+	return b.events.Subscribe(callback), nil
+}
+
+// SubscribeEventsSince implements pipe.EventReplayer against the local bus,
+// which keeps replaying across remote reconnects.
+func (b *Backend) SubscribeEventsSince(name string, sinceSeq uint64, callback func(event interface{})) (func(), error) {
+	b.startPump(name)
+	return b.events.SubscribeSince(sinceSeq, callback), nil
+}
+
+// Touch is part of pipe.VMBackend. Every forwarded call touches the remote
+// backend, so there is nothing to do here.
+func (b *Backend) Touch() {}
+
+// GetDownloadStatus reports the remote's status, or "ready" while it is
+// unreachable so Desktop doesn't start a bundle download for it.
+func (b *Backend) GetDownloadStatus() string {
+	var status string
+	err := b.do("getDownloadStatus", func(ctx context.Context, c *client.Client) (err error) {
+		status, err = c.GetDownloadStatus(ctx)
+		return err
+	})
+	if err != nil {
+		log.Printf("[remote] getDownloadStatus: %v", err)
+		return "ready"
+	}
+	return status
+}
+
+// The disk-management results are normalized like the local backends':
+// Desktop iterates the lists and maps, so they are never null.
+
+func (b *Backend) GetSessionsDiskInfo(lowWaterBytes int64) (info pipe.SessionsDiskInfo, err error) {
+	err = b.do("getSessionsDiskInfo", func(ctx context.Context, c *client.Client) (err error) {
+		info, err = c.GetSessionsDiskInfo(ctx, lowWaterBytes)
+		return err
+	})
+	if info.Sessions == nil {
+		info.Sessions = []interface{}{}
+	}
+	return info, err
+}
+
+func (b *Backend) DeleteSessionDirs(names []string) (result pipe.DeleteSessionDirsResult, err error) {
+	err = b.do("deleteSessionDirs", func(ctx context.Context, c *client.Client) (err error) {
+		result, err = c.DeleteSessionDirs(ctx, names)
+		return err
+	})
+	if result.Deleted == nil {
+		result.Deleted = []string{}
+	}
+	if result.Errors == nil {
+		result.Errors = map[string]string{}
+	}
+	return result, err
+}
+
+func (b *Backend) PruneSessionCaches(onlyIfFreeBytesBelow int64, includeSessionTmp bool, sessionTmpOlderThanSeconds int64) (result pipe.PruneSessionCachesResult, err error) {
+	err = b.do("pruneSessionCaches", func(ctx context.Context, c *client.Client) (err error) {
+		result, err = c.PruneSessionCaches(ctx, onlyIfFreeBytesBelow, includeSessionTmp, sessionTmpOlderThanSeconds)
+		return err
+	})
+	if result.PrunedSessions == nil {
+		result.PrunedSessions = []string{}
+	}
+	if result.SkippedSessions == nil {
+		result.SkippedSessions = []string{}
+	}
+	if result.Errors == nil {
+		result.Errors = map[string]string{}
+	}
+	return result, err
+}
+
+func (b *Backend) CreateDiskImage(diskName string, sizeGiB int) error {
+	return b.do("createDiskImage", func(ctx context.Context, c *client.Client) error {
+		return c.CreateDiskImage(ctx, diskName, sizeGiB)
+	})
+}
+
+func (b *Backend) SendGuestResponse(id string, resultJSON string, errMsg string) error {
+	return b.do("sendGuestResponse", func(ctx context.Context, c *client.Client) error {
+		return c.SendGuestResponse(ctx, id, resultJSON, errMsg)
+	})
+}
+
+// Shutdown kills the processes this daemon spawned on the remote, as the
+// native backend does with its own on exit, and drops the connections.
+func (b *Backend) Shutdown() {
+	log.Printf("[remote] shutting down...")
+	b.mu.Lock()
+	ids := make([]string, 0, len(b.live))
+	for id := range b.live {
+		ids = append(ids, id)
+	}
+	b.mu.Unlock()
+	for _, id := range ids {
+		if err := b.Kill(id, "SIGKILL"); err != nil && b.debug.Load() {
+			log.Printf("[remote] kill %s on shutdown: %v", id, err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+	if b.conn != nil {
+		_ = b.conn.Close()
+		b.conn = nil
+	}
+}
+
+// startPump starts the upstream event subscription once, and waits for its
+// first attempt so a spawn right after subscribeEvents can't emit output
+// before the stream is up.
+func (b *Backend) startPump(name string) {
+	b.mu.Lock()
+	if !b.pumping {
+		b.pumping = true
+		go b.pump(name)
+	}
+	b.mu.Unlock()
+	select {
+	case <-b.pumped:
+	case <-time.After(callTimeout):
+	}
+}
+
+// pump relays the remote event stream into the local bus until Shutdown.
+// After a drop it resubscribes with the last remote seq it saw, so the
+// remote replays what was missed; local subscribers never notice beyond a
+// non-fatal error event.
+func (b *Backend) pump(name string) {
+	var (
+		lastSeq uint64
+		delay   = minResubscribeDelay
+		down    bool
+		first   = true
+	)
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-b.stop:
+			case <-ctx.Done():
+			}
+			cancel()
+		}()
+
+		opts := client.SubscribeOptions{Name: name}
+		if lastSeq > 0 {
+			since := lastSeq
+			opts.SinceSeq = &since
+		}
+		var sub *client.Subscription
+		c, err := b.client(ctx)
+		if err == nil {
+			sub, err = c.Subscribe(ctx, opts)
+		}
+		if first {
+			first = false
+			close(b.pumped)
+		}
+		if err == nil {
+			if down {
+				log.Printf("[remote] event stream from %s restored", b.addr)
+			}
+			down, delay = false, minResubscribeDelay
+			for ev := range sub.Events() {
+				b.relay(ev)
+			}
+			lastSeq = sub.LastSeq()
+			err = sub.Err()
+		}
+		cancel()
+
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+		if !down {
+			down = true
+			log.Printf("[remote] event stream from %s lost: %v; reconnecting", b.addr, err)
+			b.events.Publish(process.NewErrorEvent("", fmt.Sprintf("lost connection to remote daemon %s; reconnecting", b.addr), false))
+		}
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxResubscribeDelay {
+			delay = maxResubscribeDelay
+		}
+	}
+}
+
+// relay publishes one remote event locally. The local bus stamps its own
+// seq, so the remote's is dropped.
+func (b *Backend) relay(ev interface{}) {
+	if m, ok := ev.(map[string]interface{}); ok {
+		delete(m, "seq")
+	}
+	if exit, ok := ev.(process.ExitEvent); ok {
+		b.mu.Lock()
+		delete(b.live, exit.ProcessID)
+		b.mu.Unlock()
+	}
+	b.events.Publish(ev)
+}
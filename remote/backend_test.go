@@ -0,0 +1,277 @@
+package remote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/native"
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/client"
+	"github.com/patrickjaja/claude-cowork-service/pipe/conformance"
+)
+
+// The remote side in these tests is a second daemon on loopback: a native
+// backend behind its own pipe.Server.
+
+func removeSessionLink(t *testing.T, name string) {
+	t.Helper()
+	link := "/sessions/" + name
+	remove := func() {
+		if fi, err := os.Lstat(link); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			_ = os.Remove(link)
+		}
+	}
+	remove()
+	t.Cleanup(remove)
+}
+
+// startRemote starts the remote daemon on a Unix socket.
+func startRemote(t *testing.T) (*pipe.Server, string) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "remote.sock")
+	srv := pipe.NewServer(socket, native.NewBackend(false), false)
+	srv.SetCrashDir(t.TempDir())
+	if err := srv.Start(); err != nil {
+		t.Fatalf("starting remote daemon: %v", err)
+	}
+	t.Cleanup(srv.Stop)
+	return srv, socket
+}
+
+func newShutdownBackend(t *testing.T, addr string, dial client.DialFunc, paths PathMap) *Backend {
+	t.Helper()
+	b := NewBackend(addr, dial, paths, false)
+	t.Cleanup(b.Shutdown)
+	return b
+}
+
+func conformanceTarget(t *testing.T, newBackend func(t *testing.T) pipe.VMBackend) conformance.Target {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(script, []byte(conformance.EchoScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return conformance.Target{
+		New:     newBackend,
+		Command: script,
+		File: func(t *testing.T) (string, []byte) {
+			path := filepath.Join(t.TempDir(), "artifact.html")
+			content := []byte("<html>\x00\xff</html>")
+			if err := os.WriteFile(path, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return path, content
+		},
+	}
+}
+
+func TestConformanceOverUnixSocket(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	removeSessionLink(t, conformance.Session)
+	_, socket := startRemote(t)
+
+	conformance.Run(t, conformanceTarget(t, func(t *testing.T) pipe.VMBackend {
+		return newShutdownBackend(t, "unix:"+socket, client.DialUnix(socket), nil)
+	}))
+}
+
+func TestConformanceOverMutualTLS(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	removeSessionLink(t, conformance.Session)
+	srv, _ := startRemote(t)
+	pki := newTestPKI(t)
+
+	serverCfg, err := pipe.ServerTLSConfig(pki.serverCert, pki.serverKey, pki.ca)
+	if err != nil {
+		t.Fatalf("ServerTLSConfig: %v", err)
+	}
+	if err := srv.ListenTLS("127.0.0.1:0", serverCfg); err != nil {
+		t.Fatalf("ListenTLS: %v", err)
+	}
+	addr := srv.TLSAddr().String()
+	clientCfg, err := pipe.ClientTLSConfig(pki.clientCert, pki.clientKey, pki.ca)
+	if err != nil {
+		t.Fatalf("ClientTLSConfig: %v", err)
+	}
+
+	conformance.Run(t, conformanceTarget(t, func(t *testing.T) pipe.VMBackend {
+		return newShutdownBackend(t, addr, client.DialTLS(addr, clientCfg), nil)
+	}))
+
+	// Without a client certificate the handshake fails and no call gets
+	// through.
+	anon := &tls.Config{RootCAs: clientCfg.RootCAs, MinVersion: tls.VersionTLS13}
+	b := newShutdownBackend(t, addr, client.DialTLS(addr, anon), nil)
+	if _, err := b.IsRunning(conformance.Session); err == nil {
+		t.Fatal("isRunning succeeded without a client certificate")
+	}
+}
+
+func TestSpawnTranslatesMounts(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	removeSessionLink(t, "mapped")
+	_, socket := startRemote(t)
+
+	local := filepath.Join(home, "laptop", "src")
+	remote := filepath.Join(home, "workstation", "src")
+	for _, dir := range []string{local, remote} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths, err := ParsePathMap(local + "=" + remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newShutdownBackend(t, "unix:"+socket, client.DialUnix(socket), paths)
+
+	mounts := map[string]pipe.MountSpec{
+		"src":    {Path: strings.TrimPrefix(local, "/"), Mode: "rw"},
+		"nested": {Path: strings.TrimPrefix(filepath.Join(local, "pkg"), "/"), Mode: "rw"},
+		"other":  {Path: strings.TrimPrefix(filepath.Join(home, "elsewhere"), "/"), Mode: "rw"},
+	}
+	id, failed, err := b.Spawn("mapped", "p1", "/bin/cat", nil, nil, "", mounts, nil, "")
+	if err != nil {
+		t.Fatalf("spawn: %v", err)
+	}
+	t.Cleanup(func() { _ = b.Kill(id, "SIGKILL") })
+	if len(failed) != 1 || failed[0] != "other" {
+		t.Fatalf("failedMounts = %v, want [other]", failed)
+	}
+
+	// The remote daemon attached the mapped directories, not the local ones.
+	mnt := filepath.Join(home, ".local", "share", "claude-cowork", "sessions", "mapped", "mnt")
+	for name, want := range map[string]string{"src": remote, "nested": filepath.Join(remote, "pkg")} {
+		if got, err := os.Readlink(filepath.Join(mnt, name)); err != nil || got != want {
+			t.Errorf("mount %s → %q, %v; want %q", name, got, err, want)
+		}
+	}
+	entries, _ := os.ReadDir(mnt)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "nested,src" {
+		t.Errorf("remote mounts = %v, want [nested src]", names)
+	}
+
+	if err := b.MountPath(id, strings.TrimPrefix(filepath.Join(home, "elsewhere"), "/"), "late", "rw"); !errors.Is(err, pipe.ErrMountFailed) {
+		t.Errorf("mountPath outside the map = %v, want ErrMountFailed", err)
+	}
+}
+
+func TestUnreachableRemote(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "nobody.sock")
+	b := newShutdownBackend(t, "unix:"+socket, client.DialUnix(socket), nil)
+
+	if connected, err := b.IsGuestConnected("s"); err != nil || connected {
+		t.Fatalf("isGuestConnected = %v, %v; want false, nil", connected, err)
+	}
+	if _, _, err := b.Spawn("s", "p1", "claude", nil, nil, "", nil, nil, ""); !errors.Is(err, pipe.ErrGuestNotConnected) {
+		t.Fatalf("spawn = %v, want ErrGuestNotConnected", err)
+	}
+
+	// Once the remote comes up, the next call dials it.
+	srv := pipe.NewServer(socket, native.NewBackend(false), false)
+	srv.SetCrashDir(t.TempDir())
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Stop waits for open connections, so the backend must drop its first.
+	defer srv.Stop()
+	defer b.Shutdown()
+	if connected, err := b.IsGuestConnected("s"); err != nil || !connected {
+		t.Fatalf("isGuestConnected after remote start = %v, %v; want true, nil", connected, err)
+	}
+	if st := b.Status(); !st.GuestConnected || st.Backend != "remote" {
+		t.Fatalf("Status = %+v", st)
+	}
+}
+
+// testPKI is a throwaway CA with one server and one client certificate,
+// written as PEM files.
+type testPKI struct {
+	ca                    string
+	serverCert, serverKey string
+	clientCert, clientKey string
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cowork test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certPath := writePEM(t, dir, name+".crt", "CERTIFICATE", der)
+		keyPath := writePEM(t, dir, name+".key", "EC PRIVATE KEY", keyDER)
+		return certPath, keyPath
+	}
+
+	p := testPKI{ca: writePEM(t, dir, "ca.crt", "CERTIFICATE", caDER)}
+	p.serverCert, p.serverKey = issue("workstation", 2, x509.ExtKeyUsageServerAuth)
+	p.clientCert, p.clientKey = issue("laptop", 3, x509.ExtKeyUsageClientAuth)
+	return p
+}
+
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,106 @@
+package remote
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PathMapping maps one local directory to where the same content lives on
+// the remote host.
+type PathMapping struct {
+	Local  string
+	Remote string
+}
+
+// PathMap translates local host paths into remote ones. Only what it maps
+// exists on the remote as far as the backend is concerned: a mount outside
+// every entry is reported in failedMounts instead of being forwarded.
+type PathMap []PathMapping
+
+// ParsePathMap parses "local=remote,local=remote" (the -remote-path-map
+// flag). Both sides must be absolute. The longest local prefix wins, so a
+// nested entry can override its parent.
+func ParsePathMap(s string) (PathMap, error) {
+	var m PathMap
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		local, remote, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("path map entry %q: want local=remote", entry)
+		}
+		local, remote = strings.TrimSpace(local), strings.TrimSpace(remote)
+		if !filepath.IsAbs(local) || !filepath.IsAbs(remote) {
+			return nil, fmt.Errorf("path map entry %q: both paths must be absolute", entry)
+		}
+		m = append(m, PathMapping{Local: filepath.Clean(local), Remote: filepath.Clean(remote)})
+	}
+	sort.SliceStable(m, func(i, j int) bool { return len(m[i].Local) > len(m[j].Local) })
+	return m, nil
+}
+
+func (m PathMap) String() string {
+	parts := make([]string, len(m))
+	for i, e := range m {
+		parts[i] = e.Local + "=" + e.Remote
+	}
+	return strings.Join(parts, ",")
+}
+
+// ToRemote translates an absolute local path. ok is false when no entry
+// covers it.
+func (m PathMap) ToRemote(path string) (string, bool) {
+	if !filepath.IsAbs(path) {
+		return path, false
+	}
+	for _, e := range m {
+		rel, err := filepath.Rel(e.Local, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return filepath.Join(e.Remote, rel), true
+		}
+	}
+	return path, false
+}
+
+// translate rewrites a spawn argument or env value that is a mapped path,
+// alone or as the value of "--flag=path", and leaves anything else alone.
+func (m PathMap) translate(v string) string {
+	if remote, ok := m.ToRemote(v); ok {
+		return remote
+	}
+	if key, val, ok := strings.Cut(v, "="); ok {
+		if remote, ok := m.ToRemote(val); ok {
+			return key + "=" + remote
+		}
+	}
+	return v
+}
+
+// localMountPath resolves a mount's shared path (root-relative like
+// "home/user/src", or legacy home-relative like ".config/Claude") to an
+// absolute local path, as the native backend does.
+func localMountPath(home, rel string) string {
+	if rel == "" {
+		return home
+	}
+	asRoot := filepath.Clean("/" + rel)
+	if asRoot == home || strings.HasPrefix(asRoot, home+"/") {
+		return asRoot
+	}
+	return filepath.Join(home, rel)
+}
+
+// remoteMountPath translates a mount's shared path to the root-relative
+// form for the remote daemon. Remote daemons resolve root-relative paths
+// only under their user's home, so mapped targets must lie there.
+func (m PathMap) remoteMountPath(home, rel string) (string, bool) {
+	remote, ok := m.ToRemote(localMountPath(home, rel))
+	if !ok {
+		return "", false
+	}
+	return strings.TrimPrefix(remote, "/"), true
+}
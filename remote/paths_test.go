@@ -0,0 +1,82 @@
+package remote
+
+import "testing"
+
+func TestParsePathMap(t *testing.T) {
+	m, err := ParsePathMap(" /home/me=/home/ws , /home/me/src/big=/data/big,")
+	if err != nil {
+		t.Fatalf("ParsePathMap: %v", err)
+	}
+	// Longest local prefix first, so the nested entry wins.
+	if got := m.String(); got != "/home/me/src/big=/data/big,/home/me=/home/ws" {
+		t.Fatalf("String() = %q", got)
+	}
+
+	for _, bad := range []string{"/home/me", "home/me=/home/ws", "/home/me=ws"} {
+		if _, err := ParsePathMap(bad); err == nil {
+			t.Errorf("ParsePathMap(%q) accepted", bad)
+		}
+	}
+}
+
+func TestPathMapToRemote(t *testing.T) {
+	m, err := ParsePathMap("/home/me=/home/ws,/home/me/src/big=/data/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"/home/me", "/home/ws", true},
+		{"/home/me/src/app", "/home/ws/src/app", true},
+		{"/home/me/src/big/x", "/data/big/x", true},
+		{"/home/meta/x", "/home/meta/x", false},
+		{"/etc/passwd", "/etc/passwd", false},
+		{"relative/path", "relative/path", false},
+	} {
+		got, ok := m.ToRemote(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("ToRemote(%q) = %q, %v; want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestPathMapTranslate(t *testing.T) {
+	m, err := ParsePathMap("/home/me=/home/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in, want := range map[string]string{
+		"/home/me/.local/bin/claude":      "/home/ws/.local/bin/claude",
+		"--plugin-dir=/home/me/.config/x": "--plugin-dir=/home/ws/.config/x",
+		"--model":                         "--model",
+		"/sessions/s1/mnt/outputs":        "/sessions/s1/mnt/outputs",
+		"A=b":                             "A=b",
+	} {
+		if got := m.translate(in); got != want {
+			t.Errorf("translate(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRemoteMountPath(t *testing.T) {
+	m, err := ParsePathMap("/home/me/src=/home/ws/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Root-relative and legacy home-relative shared paths both resolve
+	// against the local home first.
+	for rel, want := range map[string]string{
+		"home/me/src/app": "home/ws/src/app",
+		"src/app":         "home/ws/src/app",
+	} {
+		if got, ok := m.remoteMountPath("/home/me", rel); !ok || got != want {
+			t.Errorf("remoteMountPath(%q) = %q, %v; want %q", rel, got, ok, want)
+		}
+	}
+	if _, ok := m.remoteMountPath("/home/me", ".config/Claude"); ok {
+		t.Error("unmapped mount translated")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/logx"
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+// runReplay implements `cowork-svc-linux replay [flags] <capture>...`:
+// feed a capture recorded with -capture back into a backend and print where
+// its responses and events diverge. Rotated files may be passed oldest
+// first (capture.jsonl.2 capture.jsonl.1 capture.jsonl).
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	backendName := fs.String("backend", defaultBackend(), "Backend to replay against: native or kvm")
+	bundlesDir := fs.String("bundles-dir", defaultBundlesDir(), "VM bundles directory (kvm backend only)")
+	debug := fs.Bool("debug", false, "Enable debug logging")
+	timeout := fs.Duration("timeout", 35*time.Second, "Max wait for each captured response")
+	settle := fs.Duration("settle", 2*time.Second, "How long to keep collecting events after the last request")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay [flags] <capture.jsonl>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var records []pipe.CaptureRecord
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+			return 1
+		}
+		recs, err := pipe.ReadCapture(f)
+		_ = f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %s: %v\n", path, err)
+			return 1
+		}
+		records = append(records, recs...)
+	}
+
+	logx.Configure(*debug, false, 160)
+	backend, err := newBackend(*backendName, *bundlesDir, *debug, nativeConfig{}, remoteConfig{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+	defer backend.Shutdown()
+
+	res, err := pipe.Replay(records, backend, pipe.ReplayOptions{Timeout: *timeout, Settle: *settle})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+	for _, m := range res.Mismatches {
+		fmt.Println(m)
+	}
+	fmt.Printf("replayed %d requests against %s backend: %d responses, %d events, %d mismatches\n",
+		res.Requests, *backendName, res.Responses, res.Events, len(res.Mismatches))
+	if len(res.Mismatches) > 0 {
+		return 1
+	}
+	return 0
+}
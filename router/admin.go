@@ -0,0 +1,69 @@
+package router
+
+import (
+	"sort"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+// Healthy implements pipe.HealthChecker for the router's own bus. The
+// routed backends are checked through their own sockets' watchdog.
+func (b *Backend) Healthy() error {
+	return b.events.Healthy(time.Second)
+}
+
+// Status implements pipe.StatusReporter.
+func (b *Backend) Status() pipe.BackendStatus {
+	vm := b.vmHealthy("")
+	running, _ := b.IsRunning("")
+	connected, _ := b.IsGuestConnected("")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	route := "native"
+	if vm {
+		route = "kvm"
+	}
+	processes := map[string]int{}
+	for _, backend := range b.owners {
+		processes[b.name(backend)]++
+	}
+	return pipe.BackendStatus{
+		Backend:        "route",
+		Running:        running,
+		GuestConnected: connected,
+		Subscribers:    b.events.Len(),
+		Details: map[string]interface{}{
+			"policy":    PolicyPreferKVM.String(),
+			"route":     route,
+			"fellBack":  b.fellBack,
+			"processes": processes,
+		},
+	}
+}
+
+// ListProcesses implements pipe.ProcessLister with both backends' processes.
+func (b *Backend) ListProcesses() []pipe.ProcessInfo {
+	var out []pipe.ProcessInfo
+	for _, backend := range []pipe.VMBackend{b.kvm, b.native} {
+		if pl, ok := backend.(pipe.ProcessLister); ok {
+			out = append(out, pl.ListProcesses()...)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	if out == nil {
+		out = []pipe.ProcessInfo{}
+	}
+	return out
+}
+
+// ListSessions implements pipe.SessionLister with both backends' sessions.
+func (b *Backend) ListSessions() []pipe.SessionInfo {
+	out := []pipe.SessionInfo{}
+	for _, backend := range []pipe.VMBackend{b.kvm, b.native} {
+		if sl, ok := backend.(pipe.SessionLister); ok {
+			out = append(out, sl.ListSessions()...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,365 @@
+// Package router implements pipe.VMBackend over the KVM and native backends
+// of one daemon (-backend both -route prefer-kvm). Sessions run in the VM
+// while it is healthy; when it fails to boot or its guest is gone, spawns
+// fall back to running natively on the host instead of failing.
+package router
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/client"
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+// Backend routes between a KVM and a native backend. Both are shared with
+// the daemon's per-backend sockets and are shut down by their owner, not
+// here.
+type Backend struct {
+	kvm    pipe.VMBackend
+	native pipe.VMBackend
+	debug  atomic.Bool
+
+	events *pipe.EventBus
+
+	mu       sync.Mutex
+	owners   map[string]pipe.VMBackend // process id → backend that spawned it
+	exited   []string                  // owners entries kept after exit, oldest first
+	sessions map[string]pipe.VMBackend // spawn name → backend of its latest spawn
+	fellBack bool                      // the VM failed to start; lifecycle runs natively
+	relaying bool
+	cancels  []func()
+}
+
+// maxExited bounds how many exited processes keep their owner, so an
+// isProcessRunning after the exit event still reaches the right backend.
+const maxExited = 256
+
+// New creates a router over kvm and native.
+func New(kvm, native pipe.VMBackend, debug bool) *Backend {
+	b := &Backend{
+		kvm:      kvm,
+		native:   native,
+		events:   pipe.NewEventBus(),
+		owners:   make(map[string]pipe.VMBackend),
+		sessions: make(map[string]pipe.VMBackend),
+	}
+	b.debug.Store(debug)
+	return b
+}
+
+func (b *Backend) name(backend pipe.VMBackend) string {
+	if backend == b.kvm {
+		return "kvm"
+	}
+	return "native"
+}
+
+// vmHealthy reports whether the VM is up with its guest connected.
+func (b *Backend) vmHealthy(name string) bool {
+	connected, err := b.kvm.IsGuestConnected(name)
+	return err == nil && connected
+}
+
+// current is the backend new work goes to: the VM while it is healthy,
+// the host otherwise.
+func (b *Backend) current(name string) pipe.VMBackend {
+	if b.vmHealthy(name) {
+		return b.kvm
+	}
+	return b.native
+}
+
+// owner returns the backend that spawned processID, or the current route
+// for ids this router didn't spawn.
+func (b *Backend) owner(processID string) pipe.VMBackend {
+	b.mu.Lock()
+	backend := b.owners[processID]
+	b.mu.Unlock()
+	if backend == nil {
+		return b.current("")
+	}
+	return backend
+}
+
+func (b *Backend) isFallenBack() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.fellBack
+}
+
+func (b *Backend) Configure(memoryMB int, cpuCount int) error {
+	if err := b.native.Configure(memoryMB, cpuCount); err != nil {
+		log.Printf("[route] native configure: %v", err)
+	}
+	return b.kvm.Configure(memoryMB, cpuCount)
+}
+
+func (b *Backend) CreateVM(name string) error {
+	if err := b.native.CreateVM(name); err != nil {
+		log.Printf("[route] native createVM: %v", err)
+	}
+	return b.kvm.CreateVM(name)
+}
+
+// StartVM boots the VM. When it can't, the session runtime is started
+// natively and the call succeeds, so Desktop carries on with host sessions.
+func (b *Backend) StartVM(name string, bundlePath string, memoryGB int, cpuCount int, apiProbeURL string) error {
+	err := b.kvm.StartVM(name, bundlePath, memoryGB, cpuCount, apiProbeURL)
+	b.mu.Lock()
+	b.fellBack = err != nil
+	b.mu.Unlock()
+	if err == nil {
+		return nil
+	}
+	log.Printf("[route] VM failed to start (%v); sessions will run natively", err)
+	return b.native.StartVM(name, bundlePath, memoryGB, cpuCount, apiProbeURL)
+}
+
+// StopVM stops the VM, and the native runtime only if StartVM fell back to
+// it: native StopVM kills every native process, including those started
+// through the daemon's native socket.
+func (b *Backend) StopVM(name string) error {
+	err := b.kvm.StopVM(name)
+	if b.isFallenBack() {
+		if nerr := b.native.StopVM(name); err == nil {
+			err = nerr
+		}
+		b.mu.Lock()
+		b.fellBack = false
+		b.mu.Unlock()
+	}
+	return err
+}
+
+func (b *Backend) IsRunning(name string) (bool, error) {
+	if running, err := b.kvm.IsRunning(name); err == nil && running {
+		return true, nil
+	}
+	if b.isFallenBack() {
+		return b.native.IsRunning(name)
+	}
+	return false, nil
+}
+
+// IsGuestConnected is true once the VM's guest is up, or right away after
+// a fallback. While the VM boots it is false, so Desktop waits for it.
+func (b *Backend) IsGuestConnected(name string) (bool, error) {
+	if b.vmHealthy(name) {
+		return true, nil
+	}
+	if b.isFallenBack() {
+		return b.native.IsGuestConnected(name)
+	}
+	return false, nil
+}
+
+// Spawn runs the process in the VM when it is healthy, natively otherwise.
+// A VM spawn refused because the guest went away, or because the session
+// already has live native processes, is retried natively.
+func (b *Backend) Spawn(name string, id string, cmd string, args []string, env map[string]string, cwd string, mounts map[string]pipe.MountSpec, rawParams []byte, oauthToken string) (string, []string, error) {
+	backend := b.current(name)
+	processID, failedMounts, err := backend.Spawn(name, id, cmd, args, env, cwd, mounts, rawParams, oauthToken)
+	if err != nil && backend == b.kvm && fallBackOn(err) {
+		log.Printf("[route] spawn %s in VM failed (%v); running it natively", id, err)
+		backend = b.native
+		processID, failedMounts, err = backend.Spawn(name, id, cmd, args, env, cwd, mounts, rawParams, oauthToken)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	log.Printf("[route] spawn %s (session %s) → %s", processID, name, b.name(backend))
+	b.mu.Lock()
+	b.owners[processID] = backend
+	b.sessions[name] = backend
+	b.mu.Unlock()
+	return processID, failedMounts, nil
+}
+
+// fallBackOn reports whether a VM spawn error means the host should run
+// the process instead.
+func fallBackOn(err error) bool {
+	return errors.Is(err, pipe.ErrGuestNotConnected) ||
+		errors.Is(err, pipe.ErrVMNotStarted) ||
+		errors.Is(err, pipe.ErrSessionBusy)
+}
+
+func (b *Backend) Kill(processID string, signal string) error {
+	return b.owner(processID).Kill(processID, signal)
+}
+
+func (b *Backend) WriteStdin(processID string, data []byte) error {
+	return b.owner(processID).WriteStdin(processID, data)
+}
+
+func (b *Backend) IsProcessRunning(processID string) (bool, int, error) {
+	return b.owner(processID).IsProcessRunning(processID)
+}
+
+func (b *Backend) MountPath(processID string, subpath string, mountName string, mode string) error {
+	return b.owner(processID).MountPath(processID, subpath, mountName, mode)
+}
+
+// ReadFile reads through the backend the named session last spawned on,
+// which is where its files were written.
+func (b *Backend) ReadFile(processName string, filePath string) ([]byte, error) {
+	b.mu.Lock()
+	backend := b.sessions[processName]
+	b.mu.Unlock()
+	if backend == nil {
+		backend = b.current(processName)
+	}
+	return backend.ReadFile(processName, filePath)
+}
+
+// InstallSdk goes to the VM, which queues it until the guest is up. The
+// native backend has nothing to install.
+func (b *Backend) InstallSdk(sdkSubpath string, version string) error {
+	return b.kvm.InstallSdk(sdkSubpath, version)
+}
+
+func (b *Backend) AddApprovedOauthToken(token string) error {
+	nerr := b.native.AddApprovedOauthToken(token)
+	if err := b.kvm.AddApprovedOauthToken(token); err != nil {
+		return err
+	}
+	return nerr
+}
+
+func (b *Backend) SetDebugLogging(enabled bool) {
+	b.debug.Store(enabled)
+	b.kvm.SetDebugLogging(enabled)
+	b.native.SetDebugLogging(enabled)
+}
+
+// SubscribeEvents starts relaying both backends' events on first use and
+// attaches callback to the router's own bus.
+func (b *Backend) SubscribeEvents(name string, callback func(event interface{})) (func(), error) {
+	if err := b.startRelay(name); err != nil {
+		return nil, err
+	}
+	return b.events.Subscribe(callback), nil
+}
+
+// SubscribeEventsSince implements pipe.EventReplayer against the router's
+// bus, whose seq numbers are independent of either backend's.
+func (b *Backend) SubscribeEventsSince(name string, sinceSeq uint64, callback func(event interface{})) (func(), error) {
+	if err := b.startRelay(name); err != nil {
+		return nil, err
+	}
+	return b.events.SubscribeSince(sinceSeq, callback), nil
+}
+
+// GetDownloadStatus reports the VM bundle's status: Desktop downloads it
+// even when sessions end up running natively.
+func (b *Backend) GetDownloadStatus() string {
+	return b.kvm.GetDownloadStatus()
+}
+
+func (b *Backend) GetSessionsDiskInfo(lowWaterBytes int64) (pipe.SessionsDiskInfo, error) {
+	return b.current("").GetSessionsDiskInfo(lowWaterBytes)
+}
+
+func (b *Backend) DeleteSessionDirs(names []string) (pipe.DeleteSessionDirsResult, error) {
+	return b.current("").DeleteSessionDirs(names)
+}
+
+func (b *Backend) PruneSessionCaches(onlyIfFreeBytesBelow int64, includeSessionTmp bool, sessionTmpOlderThanSeconds int64) (pipe.PruneSessionCachesResult, error) {
+	return b.current("").PruneSessionCaches(onlyIfFreeBytesBelow, includeSessionTmp, sessionTmpOlderThanSeconds)
+}
+
+func (b *Backend) CreateDiskImage(diskName string, sizeGiB int) error {
+	return b.kvm.CreateDiskImage(diskName, sizeGiB)
+}
+
+func (b *Backend) SendGuestResponse(id string, resultJSON string, errMsg string) error {
+	return b.current("").SendGuestResponse(id, resultJSON, errMsg)
+}
+
+// Touch keeps the VM's keepalive watchdog fed.
+func (b *Backend) Touch() {
+	b.kvm.Touch()
+	b.native.Touch()
+}
+
+// PublishEvent implements pipe.EventPublisher.
+func (b *Backend) PublishEvent(event interface{}) {
+	b.events.Publish(event)
+}
+
+// Shutdown stops the relays. The routed backends are left to their owner.
+func (b *Backend) Shutdown() {
+	b.mu.Lock()
+	cancels := b.cancels
+	b.cancels = nil
+	b.mu.Unlock()
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+// startRelay subscribes to both backends once. Every VM event is relayed.
+// Native process events are too, but native lifecycle events (vmStarted,
+// startupStep, ...) only after a fallback, so Desktop doesn't see a second
+// runtime start next to the VM.
+func (b *Backend) startRelay(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.relaying {
+		return nil
+	}
+	kvmCancel, err := b.kvm.SubscribeEvents(name, func(ev interface{}) { b.relay(ev, true) })
+	if err != nil {
+		return err
+	}
+	nativeCancel, err := b.native.SubscribeEvents(name, func(ev interface{}) { b.relay(ev, false) })
+	if err != nil {
+		kvmCancel()
+		return err
+	}
+	b.cancels = append(b.cancels, kvmCancel, nativeCancel)
+	b.relaying = true
+	return nil
+}
+
+// relay republishes one backend event on the router's bus, which stamps
+// its own seq.
+func (b *Backend) relay(ev interface{}, fromVM bool) {
+	enc, ok := ev.(pipe.EncodedEvent)
+	if !ok {
+		return
+	}
+	event, _, err := client.DecodeEvent(enc.JSON)
+	if err != nil {
+		log.Printf("[route] dropping undecodable event: %v", err)
+		return
+	}
+	if !fromVM && process.EventProcessID(event) == "" && !b.isFallenBack() {
+		return
+	}
+	if m, ok := event.(map[string]interface{}); ok {
+		delete(m, "seq")
+	}
+	if exit, ok := event.(process.ExitEvent); ok {
+		b.forgetExited(exit.ProcessID)
+	}
+	b.events.Publish(event)
+}
+
+// forgetExited queues an exited process's owner for removal once
+// maxExited later exits have happened.
+func (b *Backend) forgetExited(processID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.owners[processID]; !ok {
+		return
+	}
+	b.exited = append(b.exited, processID)
+	if len(b.exited) > maxExited {
+		delete(b.owners, b.exited[0])
+		b.exited = b.exited[1:]
+	}
+}
@@ -0,0 +1,276 @@
+package router
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/native"
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/client"
+	"github.com/patrickjaja/claude-cowork-service/pipe/conformance"
+	"github.com/patrickjaja/claude-cowork-service/process"
+	"github.com/patrickjaja/claude-cowork-service/sessions"
+)
+
+// fakeVM stands in for the KVM backend: a second native backend whose
+// health the test controls, registered with the session registry as "kvm".
+type fakeVM struct {
+	*native.Backend
+	healthy  atomic.Bool
+	startErr error
+	registry *sessions.Registry
+
+	mu   sync.Mutex
+	live map[string]string // process id → session
+}
+
+func newFakeVM(t *testing.T, registry *sessions.Registry) *fakeVM {
+	t.Helper()
+	f := &fakeVM{Backend: native.NewBackend(false), registry: registry, live: map[string]string{}}
+	t.Cleanup(f.Shutdown)
+	if registry != nil {
+		registry.Register("kvm", func(session string) bool {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			for id, s := range f.live {
+				if running, _, _ := f.Backend.IsProcessRunning(id); s == session && running {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	return f
+}
+
+func (f *fakeVM) StartVM(name string, bundlePath string, memoryGB int, cpuCount int, apiProbeURL string) error {
+	if f.startErr != nil {
+		return f.startErr
+	}
+	f.healthy.Store(true)
+	return f.Backend.StartVM(name, bundlePath, memoryGB, cpuCount, apiProbeURL)
+}
+
+func (f *fakeVM) StopVM(name string) error {
+	f.healthy.Store(false)
+	return f.Backend.StopVM(name)
+}
+
+func (f *fakeVM) IsRunning(string) (bool, error)        { return f.healthy.Load(), nil }
+func (f *fakeVM) IsGuestConnected(string) (bool, error) { return f.healthy.Load(), nil }
+
+func (f *fakeVM) Spawn(name string, id string, cmd string, args []string, env map[string]string, cwd string, mounts map[string]pipe.MountSpec, rawParams []byte, oauthToken string) (string, []string, error) {
+	if !f.healthy.Load() {
+		return "", nil, pipe.ErrVMNotStarted
+	}
+	release, err := f.registry.Acquire(name, "kvm")
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+	pid, failed, err := f.Backend.Spawn(name, id, cmd, args, env, cwd, mounts, rawParams, oauthToken)
+	if err == nil {
+		f.mu.Lock()
+		f.live[pid] = name
+		f.mu.Unlock()
+	}
+	return pid, failed, err
+}
+
+func removeSessionLink(t *testing.T, name string) {
+	t.Helper()
+	link := "/sessions/" + name
+	remove := func() {
+		if fi, err := os.Lstat(link); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			_ = os.Remove(link)
+		}
+	}
+	remove()
+	t.Cleanup(remove)
+}
+
+func newNative(t *testing.T, registry *sessions.Registry) *native.Backend {
+	t.Helper()
+	nb := native.NewBackend(false)
+	if registry != nil {
+		nb.SetSessions(registry)
+	}
+	t.Cleanup(nb.Shutdown)
+	return nb
+}
+
+func newRouter(t *testing.T, kvm, nb pipe.VMBackend) *Backend {
+	t.Helper()
+	r := New(kvm, nb, false)
+	t.Cleanup(r.Shutdown)
+	return r
+}
+
+func echoScript(t *testing.T) string {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(script, []byte(conformance.EchoScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func conformanceTarget(t *testing.T, newBackend func(t *testing.T) pipe.VMBackend) conformance.Target {
+	t.Helper()
+	return conformance.Target{
+		New:     newBackend,
+		Command: echoScript(t),
+		File: func(t *testing.T) (string, []byte) {
+			path := filepath.Join(t.TempDir(), "artifact.html")
+			content := []byte("<html>\x00\xff</html>")
+			if err := os.WriteFile(path, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return path, content
+		},
+	}
+}
+
+func TestConformanceThroughVM(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	removeSessionLink(t, conformance.Session)
+	conformance.Run(t, conformanceTarget(t, func(t *testing.T) pipe.VMBackend {
+		return newRouter(t, newFakeVM(t, nil), newNative(t, nil))
+	}))
+}
+
+func TestConformanceAfterFallback(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	removeSessionLink(t, conformance.Session)
+	conformance.Run(t, conformanceTarget(t, func(t *testing.T) pipe.VMBackend {
+		vm := newFakeVM(t, nil)
+		vm.startErr = errors.New("KVM unavailable: /dev/kvm missing")
+		return newRouter(t, vm, newNative(t, nil))
+	}))
+}
+
+// awaitExit waits for processID's exit event on the router's bus.
+func awaitExit(t *testing.T, r *Backend, processID string) {
+	t.Helper()
+	exited := make(chan struct{})
+	var once sync.Once
+	cancel, err := r.SubscribeEventsSince("", 0, func(ev interface{}) {
+		enc, ok := ev.(pipe.EncodedEvent)
+		if !ok || enc.Type != "exit" {
+			return
+		}
+		if event, _, err := client.DecodeEvent(enc.JSON); err == nil && process.EventProcessID(event) == processID {
+			once.Do(func() { close(exited) })
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no exit event for %s", processID)
+	}
+}
+
+func TestSpawnRoutesByVMHealth(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	removeSessionLink(t, "routed")
+	vm, nb := newFakeVM(t, nil), newNative(t, nil)
+	r := newRouter(t, vm, nb)
+	script := echoScript(t)
+
+	if err := r.StartVM("cowork", "", 0, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	inVM, _, err := r.Spawn("routed", "p-vm", script, nil, nil, "", nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running, _, _ := vm.Backend.IsProcessRunning(inVM); !running {
+		t.Fatal("spawn with a healthy VM did not run in the VM")
+	}
+
+	// The guest goes away: new spawns run natively, while the VM's process
+	// stays reachable through the VM.
+	vm.healthy.Store(false)
+	onHost, _, err := r.Spawn("routed", "p-host", script, nil, nil, "", nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running, _, _ := nb.IsProcessRunning(onHost); !running {
+		t.Fatal("spawn with an unhealthy VM did not run natively")
+	}
+	if got := r.Status().Details["processes"]; got.(map[string]int)["kvm"] != 1 || got.(map[string]int)["native"] != 1 {
+		t.Fatalf("processes = %v, want one per backend", got)
+	}
+
+	if err := r.WriteStdin(inVM, []byte("exit 3\n")); err != nil {
+		t.Fatal(err)
+	}
+	awaitExit(t, r, inVM)
+	if running, code, err := r.IsProcessRunning(inVM); err != nil || running || code != 3 {
+		t.Fatalf("isProcessRunning(%s) = %v, %d, %v; want false, 3, nil", inVM, running, code, err)
+	}
+	_ = r.Kill(onHost, "SIGKILL")
+}
+
+func TestStartVMFallsBackToNative(t *testing.T) {
+	vm := newFakeVM(t, nil)
+	vm.startErr = errors.New("KVM unavailable")
+	r := newRouter(t, vm, newNative(t, nil))
+
+	if connected, _ := r.IsGuestConnected("cowork"); connected {
+		t.Fatal("guest connected before startVM")
+	}
+	if err := r.StartVM("cowork", "", 0, 0, ""); err != nil {
+		t.Fatalf("startVM = %v, want fallback to native", err)
+	}
+	if connected, _ := r.IsGuestConnected("cowork"); !connected {
+		t.Fatal("guest not connected after falling back")
+	}
+	if running, _ := r.IsRunning("cowork"); !running {
+		t.Fatal("not running after falling back")
+	}
+	if err := r.StopVM("cowork"); err != nil {
+		t.Fatal(err)
+	}
+	if running, _ := r.IsRunning("cowork"); running {
+		t.Fatal("still running after stopVM")
+	}
+}
+
+func TestBusySessionStaysOnItsBackend(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	removeSessionLink(t, "shared")
+	registry := sessions.NewRegistry()
+	vm, nb := newFakeVM(t, registry), newNative(t, registry)
+	vm.healthy.Store(true)
+	r := newRouter(t, vm, nb)
+	script := echoScript(t)
+
+	// Desktop on the native socket already runs the session on the host.
+	onHost, _, err := nb.Spawn("shared", "p-native", script, nil, nil, "", nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = nb.Kill(onHost, "SIGKILL") })
+
+	if _, _, err := vm.Spawn("shared", "p-direct", script, nil, nil, "", nil, nil, ""); !errors.Is(err, pipe.ErrSessionBusy) {
+		t.Fatalf("VM spawn into a busy native session = %v, want ErrSessionBusy", err)
+	}
+	routed, _, err := r.Spawn("shared", "p-routed", script, nil, nil, "", nil, nil, "")
+	if err != nil {
+		t.Fatalf("routed spawn: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Kill(routed, "SIGKILL") })
+	if running, _, _ := nb.IsProcessRunning(routed); !running {
+		t.Fatal("routed spawn into a busy native session did not run natively")
+	}
+}
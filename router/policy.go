@@ -0,0 +1,37 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy decides which backend serves the KVM socket of a daemon running
+// both backends (-backend both).
+type Policy int
+
+const (
+	// PolicyFixed serves each socket from its own backend.
+	PolicyFixed Policy = iota
+	// PolicyPreferKVM serves the KVM socket from a router: sessions run in
+	// the VM while it is healthy and natively otherwise.
+	PolicyPreferKVM
+)
+
+func (p Policy) String() string {
+	if p == PolicyPreferKVM {
+		return "prefer-kvm"
+	}
+	return "fixed"
+}
+
+// ParsePolicy parses the -route flag.
+func ParsePolicy(s string) (Policy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "fixed":
+		return PolicyFixed, nil
+	case "prefer-kvm":
+		return PolicyPreferKVM, nil
+	default:
+		return PolicyFixed, fmt.Errorf("unknown route policy %q (expected fixed or prefer-kvm)", s)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/client"
+	"github.com/patrickjaja/claude-cowork-service/process"
+)
+
+const runUsage = `Usage: %s run [flags] [-- claude args...]
+
+Drives a session on a running daemon the way Claude Desktop does: configure,
+startVM, subscribeEvents, then spawn claude with stream-json I/O. The CLI's
+stream-json output is printed to stdout. Each line read from stdin is sent as
+a stream-json user message. At end of input, run waits for the pending
+replies, then kills the process. Ctrl-C kills it immediately.
+
+Example:
+  %s run -mount work=$PWD -prompt "list the files" </dev/null
+
+Flags:
+`
+
+// runDesktopArgs are the stream-json flags Desktop passes on every spawn.
+var runDesktopArgs = []string{"--output-format", "stream-json", "--verbose", "--input-format", "stream-json"}
+
+// runPassthroughEnv are forwarded from the caller's environment when set,
+// since the daemon (a systemd user service) usually doesn't have them.
+var runPassthroughEnv = []string{"ANTHROPIC_API_KEY", "ANTHROPIC_BASE_URL", "CLAUDE_CODE_OAUTH_TOKEN"}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// runRun implements `cowork-svc-linux run`.
+func runRun(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	backendName := fs.String("backend", defaultBackend(), "Backend whose daemon to talk to (selects the default socket)")
+	socketPath := fs.String("socket", "", "RPC socket path (default depends on backend)")
+	name := fs.String("name", "", "Session name (default run-<unix time>)")
+	command := fs.String("command", "", "claude binary to spawn (default: claude from PATH)")
+	prompt := fs.String("prompt", "", "Send this as the first user message")
+	memoryGB := fs.Int("memory-gb", 0, "VM memory for configure/startVM (0: daemon default)")
+	cpus := fs.Int("cpus", 0, "VM CPUs for configure/startVM (0: daemon default)")
+	var mounts, envs stringList
+	fs.Var(&mounts, "mount", "Workspace mount `name=/host/path[:ro|rw|rwd]`, repeatable (default: the current directory, rw)")
+	fs.Var(&envs, "env", "Spawn env `KEY=VALUE`, repeatable")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), runUsage, os.Args[0], os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if *socketPath == "" {
+		*socketPath = defaultSocketPath(*backendName)
+	}
+	if *name == "" {
+		*name = fmt.Sprintf("run-%d", time.Now().Unix())
+	}
+	if *command == "" {
+		*command = "claude"
+		if p, err := exec.LookPath("claude"); err == nil {
+			*command = p
+		}
+	}
+	spawn := client.SpawnParams{
+		Name:    *name,
+		Command: *command,
+		Args:    append(append([]string{}, runDesktopArgs...), fs.Args()...),
+		Env:     make(map[string]string),
+		// Desktop spawns in the session root; the daemon picks the
+		// workspace mount to run in (chooseSpawnCwd on native).
+		Cwd: "/sessions/" + *name,
+	}
+	var err error
+	if spawn.AdditionalMounts, err = parseRunMounts(mounts); err != nil {
+		fmt.Fprintf(os.Stderr, "run: %v\n", err)
+		return 2
+	}
+	for _, k := range runPassthroughEnv {
+		if v := os.Getenv(k); v != "" {
+			spawn.Env[k] = v
+		}
+	}
+	for _, kv := range envs {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			fmt.Fprintf(os.Stderr, "run: invalid -env %q (want KEY=VALUE)\n", kv)
+			return 2
+		}
+		spawn.Env[k] = v
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	code, err := runSession(ctx, *socketPath, spawn, *prompt, *memoryGB, *cpus)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run: %v\n", err)
+	}
+	return code
+}
+
+// parseRunMounts turns -mount flags into additionalMounts. Paths are sent
+// root-relative, as Desktop sends them.
+func parseRunMounts(flags []string) (map[string]pipe.MountSpec, error) {
+	if len(flags) == 0 {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(wd)
+		if strings.HasPrefix(name, ".") || name == "/" {
+			name = "workspace"
+		}
+		flags = []string{name + "=" + wd}
+	}
+	out := make(map[string]pipe.MountSpec, len(flags))
+	for _, f := range flags {
+		name, path, ok := strings.Cut(f, "=")
+		if !ok || name == "" || path == "" {
+			return nil, fmt.Errorf("invalid -mount %q (want name=/host/path[:mode])", f)
+		}
+		mode := "rw"
+		if i := strings.LastIndex(path, ":"); i >= 0 {
+			switch m := path[i+1:]; m {
+			case "ro", "rw", "rwd":
+				path, mode = path[:i], m
+			}
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = pipe.MountSpec{Path: strings.TrimPrefix(abs, "/"), Mode: mode}
+	}
+	return out, nil
+}
+
+// runSession drives one spawned session and returns the exit code for run:
+// the process's own, 0 when run ended it after the last reply, or 130 on
+// interrupt.
+func runSession(ctx context.Context, socketPath string, spawn client.SpawnParams, prompt string, memoryGB, cpus int) (int, error) {
+	c, err := client.Dial(ctx, socketPath)
+	if err != nil {
+		return 1, fmt.Errorf("%w (is the daemon running?)", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	if err := c.Configure(ctx, memoryGB*1024, cpus); err != nil {
+		return 1, fmt.Errorf("configure: %w", err)
+	}
+	if err := c.StartVM(ctx, "", "", memoryGB, cpus, ""); err != nil {
+		return 1, fmt.Errorf("startVM: %w", err)
+	}
+	// Subscribe before spawning so no output is missed.
+	sub, err := c.Subscribe(context.Background(), client.SubscribeOptions{})
+	if err != nil {
+		return 1, fmt.Errorf("subscribeEvents: %w", err)
+	}
+	defer func() { _ = sub.Close() }()
+
+	id, failed, err := c.Spawn(ctx, spawn)
+	if err != nil {
+		return 1, fmt.Errorf("spawn: %w", err)
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "run: mounts failed to attach: %s\n", strings.Join(failed, ", "))
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	// pending counts user messages without a "result" yet; once stdin is
+	// done and it drops to zero the session is over.
+	var (
+		pending  int
+		inputEOF bool
+		killed   bool
+		giveUp   <-chan time.Time // after kill, stop waiting for the exit event
+		out      strings.Builder  // partial stream-json line
+	)
+	send := func(text string) error {
+		if strings.TrimSpace(text) == "" {
+			return nil
+		}
+		// Like Desktop, back off and retry while the stdin queue is full.
+		for attempt := 1; ; attempt++ {
+			err := c.WriteStdin(ctx, id, userMessage(text))
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, pipe.ErrBackpressure) || attempt == 5 {
+				return fmt.Errorf("writeStdin: %w", err)
+			}
+			time.Sleep(time.Duration(attempt) * 200 * time.Millisecond)
+		}
+		pending++
+		return nil
+	}
+	finish := func() {
+		if killed {
+			return
+		}
+		killed = true
+		giveUp = time.After(15 * time.Second)
+		kctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := c.Kill(kctx, id, "SIGTERM"); err != nil && !errors.Is(err, pipe.ErrProcessNotFound) {
+			fmt.Fprintf(os.Stderr, "run: kill: %v\n", err)
+		}
+	}
+
+	if prompt != "" {
+		if err := send(prompt); err != nil {
+			finish()
+			return 1, err
+		}
+	}
+	interrupted := ctx.Done()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				lines, inputEOF = nil, true
+				if pending == 0 {
+					finish()
+				}
+				continue
+			}
+			if err := send(line); err != nil {
+				finish()
+				return 1, err
+			}
+		case <-interrupted:
+			interrupted = nil
+			fmt.Fprintln(os.Stderr, "run: interrupted, killing", id)
+			finish()
+		case <-giveUp:
+			return 1, fmt.Errorf("no exit event for %s after kill", id)
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return 1, fmt.Errorf("event stream ended: %v", sub.Err())
+			}
+			if process.EventProcessID(ev) != id {
+				continue
+			}
+			switch ev := ev.(type) {
+			case process.StdoutEvent:
+				fmt.Print(ev.Data)
+				out.WriteString(ev.Data)
+				for {
+					s := out.String()
+					nl := strings.IndexByte(s, '\n')
+					if nl < 0 {
+						break
+					}
+					if isResultLine(s[:nl]) && pending > 0 {
+						pending--
+					}
+					out.Reset()
+					out.WriteString(s[nl+1:])
+				}
+				if inputEOF && pending == 0 {
+					finish()
+				}
+			case process.StderrEvent:
+				fmt.Fprint(os.Stderr, ev.Data)
+			case process.ErrorEvent:
+				fmt.Fprintf(os.Stderr, "run: %s: %s\n", id, ev.Message)
+			case process.ExitEvent:
+				switch {
+				case interrupted == nil && ctx.Err() != nil:
+					return 130, nil
+				case killed:
+					return 0, nil
+				}
+				if ev.Signal != "" {
+					return 1, fmt.Errorf("%s killed by %s", id, ev.Signal)
+				}
+				return ev.ExitCode, nil
+			}
+		}
+	}
+}
+
+// userMessage encodes one line of input as a stream-json user message.
+func userMessage(text string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":    "user",
+		"message": map[string]string{"role": "user", "content": text},
+	})
+	return append(data, '\n')
+}
+
+// isResultLine reports whether a stream-json line is the CLI's end-of-turn
+// "result" message.
+func isResultLine(line string) bool {
+	var probe struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal([]byte(line), &probe) == nil && probe.Type == "result"
+}
@@ -194,7 +194,11 @@ Description=Claude Cowork Service (native Linux backend)
 After=default.target
 
 [Service]
+Type=notify
+NotifyAccess=main
+WatchdogSec=30
 ExecStart=$BINARY_PATH
+Delegate=memory cpu
 Restart=on-failure
 RestartSec=5
 
@@ -0,0 +1,124 @@
+// Package sessions owns the per-session state directory shared by every
+// backend, ~/.local/share/claude-cowork/sessions/<name>, and coordinates
+// access to it when the native and KVM backends run in one daemon.
+//
+// The two backends treat that directory differently: native spawns create
+// absolute symlinks under mnt/ (and through parent mounts into the user's
+// folders), KVM spawns remove those same symlinks because they dangle in the
+// guest, and both copy --resume transcripts between project slugs. Run
+// concurrently on one session they would undo each other's work under a
+// live process, so a Registry serializes that setup per session and refuses
+// a spawn while the other backend still has processes in the session.
+package sessions
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+// Root returns the directory holding all session dirs.
+func Root(home string) string {
+	return filepath.Join(home, ".local", "share", "claude-cowork", "sessions")
+}
+
+// Dir returns the state directory of the named session.
+func Dir(home, name string) string {
+	return filepath.Join(Root(home), name)
+}
+
+// Registry coordinates the backends of one daemon. A nil *Registry is valid
+// and coordinates nothing, which is what a single-backend daemon uses.
+type Registry struct {
+	mu       sync.Mutex
+	backends []registered
+	locks    map[string]*sessionLock
+}
+
+type registered struct {
+	name   string
+	active func(session string) bool
+}
+
+// sessionLock serializes setup for one session. refs counts holders and
+// waiters so the entry can be dropped once nobody needs it.
+type sessionLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{locks: make(map[string]*sessionLock)}
+}
+
+// Register adds a backend. active reports whether it has live processes in
+// a session; it is called without any Registry lock held.
+func (r *Registry) Register(name string, active func(session string) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backends = append(r.backends, registered{name: name, active: active})
+}
+
+// Acquire is called by backend at the start of a spawn into session and
+// held until the spawn returns, covering its host-side session setup. It
+// fails with pipe.ErrSessionBusy when another registered backend has live
+// processes in the session.
+func (r *Registry) Acquire(session, backend string) (release func(), err error) {
+	if r == nil {
+		return func() {}, nil
+	}
+	r.mu.Lock()
+	l := r.locks[session]
+	if l == nil {
+		l = &sessionLock{}
+		r.locks[session] = l
+	}
+	l.refs++
+	r.mu.Unlock()
+
+	l.mu.Lock()
+	release = func() {
+		l.mu.Unlock()
+		r.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(r.locks, session)
+		}
+		r.mu.Unlock()
+	}
+	if other := r.busyWith(session, backend); other != "" {
+		release()
+		return nil, fmt.Errorf("%w: %s backend has live processes in session %s", pipe.ErrSessionBusy, other, session)
+	}
+	return release, nil
+}
+
+// Busy reports whether a backend other than backend has live processes in
+// session, for disk management that must not delete it underneath them.
+func (r *Registry) Busy(session, backend string) bool {
+	if r == nil {
+		return false
+	}
+	return r.busyWith(session, backend) != ""
+}
+
+// busyWith returns the name of another backend with live processes in
+// session, or "".
+func (r *Registry) busyWith(session, backend string) string {
+	r.mu.Lock()
+	others := make([]registered, 0, len(r.backends))
+	for _, b := range r.backends {
+		if b.name != backend {
+			others = append(others, b)
+		}
+	}
+	r.mu.Unlock()
+	for _, b := range others {
+		if b.active(session) {
+			return b.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package sessions
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+func TestNilRegistryCoordinatesNothing(t *testing.T) {
+	var r *Registry
+	release, err := r.Acquire("s", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if r.Busy("s", "native") {
+		t.Fatal("nil registry reports busy")
+	}
+}
+
+func TestAcquireRefusesSessionActiveElsewhere(t *testing.T) {
+	r := NewRegistry()
+	var kvmActive atomic.Bool
+	r.Register("native", func(string) bool { return false })
+	r.Register("kvm", func(session string) bool { return session == "s" && kvmActive.Load() })
+
+	kvmActive.Store(true)
+	if _, err := r.Acquire("s", "native"); !errors.Is(err, pipe.ErrSessionBusy) {
+		t.Fatalf("acquire while kvm is active = %v, want ErrSessionBusy", err)
+	}
+	if !r.Busy("s", "native") {
+		t.Fatal("busy = false while kvm is active")
+	}
+	// A backend is never blocked by its own processes, nor by other sessions.
+	if release, err := r.Acquire("s", "kvm"); err != nil {
+		t.Fatalf("acquire by the active backend: %v", err)
+	} else {
+		release()
+	}
+	if release, err := r.Acquire("other", "native"); err != nil {
+		t.Fatalf("acquire of an unrelated session: %v", err)
+	} else {
+		release()
+	}
+
+	kvmActive.Store(false)
+	release, err := r.Acquire("s", "native")
+	if err != nil {
+		t.Fatalf("acquire after kvm went idle: %v", err)
+	}
+	release()
+	if len(r.locks) != 0 {
+		t.Fatalf("locks = %v after every release, want none", r.locks)
+	}
+}
+
+func TestAcquireSerializesSession(t *testing.T) {
+	r := NewRegistry()
+	release, err := r.Acquire("s", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		second, err := r.Acquire("s", "kvm")
+		if err == nil {
+			second()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second acquire did not wait for the first release")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second acquire never completed")
+	}
+}
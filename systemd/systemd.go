@@ -0,0 +1,99 @@
+// Package systemd implements the two bits of the systemd service protocol
+// the daemon needs, without linking libsystemd: socket activation
+// (sd_listen_fds) and readiness/watchdog notification (sd_notify).
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listenFdsStart is SD_LISTEN_FDS_START: passed sockets begin at fd 3.
+const listenFdsStart = 3
+
+// Listeners returns the sockets systemd passed to this process via
+// LISTEN_PID/LISTEN_FDS, in order, or nil when the process was not socket
+// activated. The variables are unset so child processes (the Claude CLI)
+// don't mistake the fds for their own.
+func Listeners() ([]net.Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i := fd - listenFdsStart; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		// FileListener dups the fd; the original is no longer needed.
+		_ = f.Close()
+		if err != nil {
+			for _, prev := range listeners {
+				_ = prev.Close()
+			}
+			return nil, fmt.Errorf("socket activation fd %d (%s): %w", fd, name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Notify sends state (e.g. "READY=1", "STATUS=...", "WATCHDOG=1") to the
+// service manager. It reports false without error when NOTIFY_SOCKET is
+// unset, i.e. the daemon is not running under a Type=notify unit.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// A leading "@" names a socket in the abstract namespace.
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the WatchdogSec= the unit configured, or false
+// when the watchdog is off or meant for another process. Callers should
+// ping at about half this interval.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if p := os.Getenv("WATCHDOG_PID"); p != "" {
+		if pid, err := strconv.Atoi(p); err != nil || pid != os.Getpid() {
+			return 0, false
+		}
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
@@ -0,0 +1,71 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotifySendsState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	sent, err := Notify("READY=1\nSTATUS=ok")
+	if err != nil || !sent {
+		t.Fatalf("Notify = %v, %v", sent, err)
+	}
+	buf := make([]byte, 128)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got := string(buf[:n]); got != "READY=1\nSTATUS=ok" {
+		t.Fatalf("received %q", got)
+	}
+}
+
+func TestNotifyWithoutSocketIsNoop(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Fatalf("Notify = %v, %v; want false, nil", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if d, ok := WatchdogInterval(); !ok || d != 30*time.Second {
+		t.Fatalf("WatchdogInterval = %v, %v", d, ok)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if _, ok := WatchdogInterval(); ok {
+		t.Fatalf("watchdog meant for another pid was accepted")
+	}
+
+	t.Setenv("WATCHDOG_USEC", "")
+	if _, ok := WatchdogInterval(); ok {
+		t.Fatalf("watchdog enabled without WATCHDOG_USEC")
+	}
+}
+
+func TestListenersIgnoresOtherPID(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	ls, err := Listeners()
+	if err != nil || ls != nil {
+		t.Fatalf("Listeners = %v, %v; want nil, nil", ls, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Fatalf("LISTEN_FDS not cleared")
+	}
+}
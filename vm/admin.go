@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"sort"
+	"time"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+)
+
+// Healthy implements pipe.HealthChecker.
+func (b *KvmBackend) Healthy() error {
+	return b.events.Healthy(time.Second)
+}
+
+// Status implements pipe.StatusReporter.
+func (b *KvmBackend) Status() pipe.BackendStatus {
+	b.mu.RLock()
+	st := pipe.BackendStatus{
+		Backend: "kvm",
+		Running: b.started,
+		Details: map[string]interface{}{
+			"memoryMB":   b.memoryMB,
+			"cpus":       b.cpus,
+			"starting":   b.starting,
+			"session":    b.sessionName,
+			"sessionDir": b.sessionDir,
+			"bundleDir":  b.bundleDir,
+		},
+	}
+	if b.bridge != nil {
+		st.GuestConnected = b.bridge.IsConnected()
+	}
+	if b.qemu != nil && b.qemu.cmd != nil && b.qemu.cmd.Process != nil {
+		st.Details["qemuPid"] = b.qemu.cmd.Process.Pid
+	}
+	b.mu.RUnlock()
+
+	st.Subscribers = b.events.Len()
+	b.procMu.Lock()
+	st.Details["liveProcesses"] = len(b.processes)
+	b.procMu.Unlock()
+	return st
+}
+
+// ListProcesses implements pipe.ProcessLister. The guest owns the real
+// processes; the host only knows which ids it spawned and has not yet seen
+// exit, all of which belong to the single running VM session.
+func (b *KvmBackend) ListProcesses() []pipe.ProcessInfo {
+	b.mu.RLock()
+	session := b.sessionName
+	b.mu.RUnlock()
+
+	b.procMu.Lock()
+	out := make([]pipe.ProcessInfo, 0, len(b.processes))
+	for id := range b.processes {
+		out = append(out, pipe.ProcessInfo{ID: id, Session: session, Running: true})
+	}
+	b.procMu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// ListSessions implements pipe.SessionLister: the VM runs one session at a
+// time, so this is empty or a single entry.
+func (b *KvmBackend) ListSessions() []pipe.SessionInfo {
+	b.mu.RLock()
+	started, name, dir := b.started, b.sessionName, b.sessionDir
+	b.mu.RUnlock()
+	if !started {
+		return []pipe.SessionInfo{}
+	}
+	procs := b.ListProcesses()
+	ids := make([]string, 0, len(procs))
+	for _, p := range procs {
+		ids = append(ids, p.ID)
+	}
+	return []pipe.SessionInfo{{Name: name, Dir: dir, Processes: ids}}
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/patrickjaja/claude-cowork-service/pipe"
 	"github.com/patrickjaja/claude-cowork-service/probe"
 	"github.com/patrickjaja/claude-cowork-service/process"
+	"github.com/patrickjaja/claude-cowork-service/sessions"
 )
 
 // KvmBackend runs guest workloads inside a QEMU/KVM virtual machine sharing
@@ -53,16 +54,23 @@ type KvmBackend struct {
 	pendingSdkInstall *pendingSdkInstall
 	pendingSdkBind    *pendingBind
 
-	// Process bookkeeping — existence only; stdout/stderr/exit flow via events.
-	processes map[string]struct{}
+	// Process bookkeeping — id → spawn (session) name only; stdout/stderr/exit
+	// flow via events.
+	processes map[string]string
+	// exitCodes keeps the exit codes of recently exited processes for
+	// IsProcessRunning, oldest first in exitOrder.
+	exitCodes map[string]int
+	exitOrder []string
 	procMu    sync.Mutex
 
 	lastActivity atomic.Int64 // unix nanos — updated by Touch()
 	watchdogStop chan struct{}
 
-	subscribers map[uint64]func(event interface{})
-	nextSubID   uint64
-	subMu       sync.RWMutex
+	events *pipe.EventBus
+
+	// sessions coordinates session setup with the native backend when both
+	// run in one daemon; nil otherwise.
+	sessions *sessions.Registry
 }
 
 type pendingBind struct {
@@ -101,6 +109,11 @@ func (p *pendingSdkInstall) forwardParams() map[string]interface{} {
 // Desktop's keepalive cadence is ~2s, so 30s tolerates a brief hiccup.
 const keepaliveTimeout = 30 * time.Second
 
+// maxExitCodes bounds how many exited processes IsProcessRunning remembers.
+// Desktop polls a process shortly after its exit event, so a few hundred is
+// plenty.
+const maxExitCodes = 256
+
 // NewKvmBackend creates a KVM backend. bundlesDir is where Claude Desktop
 // drops downloaded VM bundles (typically ~/.config/Claude/vm_bundles).
 func NewKvmBackend(bundlesDir string, debug bool) *KvmBackend {
@@ -110,16 +123,38 @@ func NewKvmBackend(bundlesDir string, debug bool) *KvmBackend {
 		log.Printf("[kvm] MkdirAll %s: %v", baseDir, err)
 	}
 	return &KvmBackend{
-		baseDir:     baseDir,
-		bundlesDir:  bundlesDir,
-		debug:       debug,
-		memoryMB:    4096,
-		cpus:        4,
-		processes:   make(map[string]struct{}),
-		subscribers: make(map[uint64]func(event interface{})),
+		baseDir:    baseDir,
+		bundlesDir: bundlesDir,
+		debug:      debug,
+		memoryMB:   4096,
+		cpus:       4,
+		processes:  make(map[string]string),
+		exitCodes:  make(map[string]int),
+		events:     pipe.NewEventBus(),
 	}
 }
 
+// SetSessions registers the backend with the daemon's session registry, so
+// spawns don't sanitize a session the native backend has live processes in,
+// and vice versa. Must be called before the backend serves requests.
+func (b *KvmBackend) SetSessions(r *sessions.Registry) {
+	b.sessions = r
+	r.Register("kvm", b.sessionActive)
+}
+
+// sessionActive reports whether a process spawned into the named session
+// has not exited yet.
+func (b *KvmBackend) sessionActive(name string) bool {
+	b.procMu.Lock()
+	defer b.procMu.Unlock()
+	for _, session := range b.processes {
+		if session == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *KvmBackend) Configure(memoryMB int, cpuCount int) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -186,6 +221,7 @@ func (b *KvmBackend) StartVM(name string, bundlePath string, memoryGb int, cpuCo
 		return fmt.Errorf("KVM unavailable: %s", check.Reason)
 	}
 
+	prepareStart := time.Now()
 	b.emit(map[string]interface{}{
 		"type": "startupStep", "step": "prepare_session", "status": "running",
 	})
@@ -328,6 +364,8 @@ func (b *KvmBackend) StartVM(name string, bundlePath string, memoryGb int, cpuCo
 	}
 	log.Printf("[kvm] starting VM %s with %dGB memory", name, memGb)
 
+	vmBootStageDuration.With("prepare_session").ObserveSince(prepareStart)
+	launchStart := time.Now()
 	b.emit(map[string]interface{}{
 		"type": "startupStep", "step": "start_vm", "status": "running",
 	})
@@ -396,11 +434,14 @@ func (b *KvmBackend) StartVM(name string, bundlePath string, memoryGb int, cpuCo
 
 	// Wait up to 90s for guest to connect. Return success either way so
 	// Desktop's UI can proceed; actual spawns will fail loud if needed.
+	vmBootStageDuration.With("start_vm").ObserveSince(launchStart)
+	waitStart := time.Now()
 	b.emit(map[string]interface{}{
 		"type": "startupStep", "step": "wait_for_guest", "status": "running",
 	})
 	select {
 	case <-guestReady:
+		vmBootStageDuration.With("wait_for_guest").ObserveSince(waitStart)
 		b.emit(map[string]interface{}{
 			"type": "startupStep", "step": "wait_for_guest", "status": "completed",
 		})
@@ -495,13 +536,20 @@ func (b *KvmBackend) Spawn(name string, id string, cmd string, args []string, en
 	bridge := b.bridge
 	b.mu.RUnlock()
 	if bridge == nil {
-		return "", nil, fmt.Errorf("VM not started")
+		return "", nil, pipe.ErrVMNotStarted
 	}
 
 	// Native-era session state (absolute symlinks created by the native
 	// backend) breaks guest mountpoint creation and transcript resume.
 	// Sanitize and migrate host-side - the guest sdk-daemon is upstream
 	// and cannot be changed.
+	// Hold the session against a concurrent native spawn, which would
+	// recreate the symlinks and write the transcripts handled here.
+	release, err := b.sessions.Acquire(name, "kvm")
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
 	home, _ := os.UserHomeDir()
 	sanitizeNativeArtifacts(home, name, mounts, b.debug)
 
@@ -517,7 +565,11 @@ func (b *KvmBackend) Spawn(name string, id string, cmd string, args []string, en
 			}
 			if err := helper.Bind(mount.Path, mode); err != nil {
 				log.Printf("[kvm] spawn bind failed for %s=%s (%s): %v", mountName, mount.Path, mode, err)
-				return "", nil, fmt.Errorf("vfs bind for %s failed: %w", mountName, err)
+				return "", nil, &pipe.Error{
+					Code:    pipe.CodeMountFailed,
+					Message: fmt.Sprintf("vfs bind for %s failed: %v", mountName, err),
+					Data:    map[string]string{"mountName": mountName},
+				}
 			}
 		}
 	}
@@ -591,8 +643,10 @@ func (b *KvmBackend) Spawn(name string, id string, cmd string, args []string, en
 	}
 
 	b.procMu.Lock()
-	b.processes[id] = struct{}{}
+	b.processes[id] = name
+	b.updateProcessGaugeLocked()
 	b.procMu.Unlock()
+	pipe.ProcessesSpawned.With("kvm").Inc()
 	return id, ack.FailedMounts, nil
 }
 
@@ -611,6 +665,7 @@ func (b *KvmBackend) Kill(processID string, signal string) error {
 	}
 	b.procMu.Lock()
 	delete(b.processes, processID)
+	b.updateProcessGaugeLocked()
 	b.procMu.Unlock()
 	return nil
 }
@@ -631,9 +686,12 @@ func (b *KvmBackend) WriteStdin(processID string, data []byte) error {
 
 func (b *KvmBackend) IsProcessRunning(processID string) (bool, int, error) {
 	b.procMu.Lock()
-	_, ok := b.processes[processID]
-	b.procMu.Unlock()
-	return ok, 0, nil
+	defer b.procMu.Unlock()
+	if _, ok := b.processes[processID]; ok {
+		return true, 0, nil
+	}
+	// Like the native backend, an exited process reports its exit code.
+	return false, b.exitCodes[processID], nil
 }
 
 // MountPath adds a bind mount into the virtiofs staging area. The guest
@@ -651,9 +709,12 @@ func (b *KvmBackend) MountPath(processID string, subpath string, mountName strin
 	if helper == nil {
 		// VM not started yet — should be rare; remember as pending SDK
 		// bind if mode is rw.
-		return fmt.Errorf("vfs helper not started")
+		return fmt.Errorf("%w: vfs helper not started", pipe.ErrVMNotStarted)
+	}
+	if err := helper.Bind(subpath, mode); err != nil {
+		return fmt.Errorf("%w: %s: %v", pipe.ErrMountFailed, subpath, err)
 	}
-	return helper.Bind(subpath, mode)
+	return nil
 }
 
 // ReadFile forwards to the guest when connected, else falls back to host
@@ -797,18 +858,12 @@ func (b *KvmBackend) SetDebugLogging(enabled bool) {
 }
 
 func (b *KvmBackend) SubscribeEvents(name string, callback func(event interface{})) (func(), error) {
-	b.subMu.Lock()
-	b.nextSubID++
-	id := b.nextSubID
-	b.subscribers[id] = callback
-	b.subMu.Unlock()
-
-	cancel := func() {
-		b.subMu.Lock()
-		delete(b.subscribers, id)
-		b.subMu.Unlock()
-	}
-	return cancel, nil
+	return b.events.Subscribe(callback), nil
+}
+
+// SubscribeEventsSince implements pipe.EventReplayer.
+func (b *KvmBackend) SubscribeEventsSince(name string, sinceSeq uint64, callback func(event interface{})) (func(), error) {
+	return b.events.SubscribeSince(sinceSeq, callback), nil
 }
 
 func (b *KvmBackend) GetDownloadStatus() string {
@@ -838,7 +893,7 @@ func (b *KvmBackend) GetSessionsDiskInfo(lowWaterBytes int64) (pipe.SessionsDisk
 	bridge := b.bridge
 	b.mu.RUnlock()
 	if bridge == nil || !bridge.IsConnected() {
-		return pipe.SessionsDiskInfo{}, fmt.Errorf("guest not connected")
+		return pipe.SessionsDiskInfo{}, pipe.ErrGuestNotConnected
 	}
 	resp, err := bridge.Forward("getSessionsDiskInfo", map[string]interface{}{
 		"lowWaterBytes": lowWaterBytes,
@@ -850,6 +905,9 @@ func (b *KvmBackend) GetSessionsDiskInfo(lowWaterBytes int64) (pipe.SessionsDisk
 	if err := json.Unmarshal(resp, &info); err != nil {
 		return pipe.SessionsDiskInfo{}, fmt.Errorf("parsing getSessionsDiskInfo response: %w", err)
 	}
+	if info.Sessions == nil {
+		info.Sessions = []interface{}{}
+	}
 	return info, nil
 }
 
@@ -858,7 +916,7 @@ func (b *KvmBackend) DeleteSessionDirs(names []string) (pipe.DeleteSessionDirsRe
 	bridge := b.bridge
 	b.mu.RUnlock()
 	if bridge == nil || !bridge.IsConnected() {
-		return pipe.DeleteSessionDirsResult{}, fmt.Errorf("guest not connected")
+		return pipe.DeleteSessionDirsResult{}, pipe.ErrGuestNotConnected
 	}
 	resp, err := bridge.Forward("deleteSessionDirs", map[string]interface{}{
 		"names": names,
@@ -870,6 +928,9 @@ func (b *KvmBackend) DeleteSessionDirs(names []string) (pipe.DeleteSessionDirsRe
 	if err := json.Unmarshal(resp, &result); err != nil {
 		return pipe.DeleteSessionDirsResult{}, fmt.Errorf("parsing deleteSessionDirs response: %w", err)
 	}
+	if result.Deleted == nil {
+		result.Deleted = []string{}
+	}
 	if result.Errors == nil {
 		result.Errors = map[string]string{}
 	}
@@ -886,7 +947,7 @@ func (b *KvmBackend) PruneSessionCaches(onlyIfFreeBytesBelow int64, includeSessi
 	bridge := b.bridge
 	b.mu.RUnlock()
 	if bridge == nil || !bridge.IsConnected() {
-		return pipe.PruneSessionCachesResult{}, fmt.Errorf("guest not connected")
+		return pipe.PruneSessionCachesResult{}, pipe.ErrGuestNotConnected
 	}
 	resp, err := bridge.Forward("pruneSessionCaches", map[string]interface{}{
 		"onlyIfFreeBytesBelow":       onlyIfFreeBytesBelow,
@@ -990,44 +1051,59 @@ func (b *KvmBackend) watchdogLoop(stop <-chan struct{}) {
 
 func (b *KvmBackend) emit(event interface{}) {
 	b.noteProcessEvent(event)
-	b.subMu.RLock()
-	subs := make([]func(event interface{}), 0, len(b.subscribers))
-	for _, s := range b.subscribers {
-		subs = append(subs, s)
-	}
-	b.subMu.RUnlock()
-	for _, cb := range subs {
-		go cb(event)
-	}
+	b.events.Publish(event)
+}
+
+// PublishEvent implements pipe.EventPublisher.
+func (b *KvmBackend) PublishEvent(event interface{}) {
+	b.emit(event)
 }
 
 func (b *KvmBackend) noteProcessEvent(event interface{}) {
-	processID, exited := exitedProcessID(event)
+	processID, code, exited := exitedProcess(event)
 	if !exited || processID == "" {
 		return
 	}
 	b.procMu.Lock()
 	delete(b.processes, processID)
+	if _, seen := b.exitCodes[processID]; !seen {
+		b.exitOrder = append(b.exitOrder, processID)
+		if len(b.exitOrder) > maxExitCodes {
+			delete(b.exitCodes, b.exitOrder[0])
+			b.exitOrder = b.exitOrder[1:]
+		}
+	}
+	b.exitCodes[processID] = code
+	b.updateProcessGaugeLocked()
 	b.procMu.Unlock()
 }
 
-func exitedProcessID(event interface{}) (string, bool) {
+// updateProcessGaugeLocked publishes the live process count. Caller holds
+// b.procMu.
+func (b *KvmBackend) updateProcessGaugeLocked() {
+	pipe.ProcessesLive.With("kvm").Set(float64(len(b.processes)))
+}
+
+// exitedProcess returns the process id and exit code of an exit event.
+func exitedProcess(event interface{}) (string, int, bool) {
 	switch ev := event.(type) {
 	case process.ExitEvent:
-		return ev.ProcessID, true
+		return ev.ProcessID, ev.ExitCode, true
 	case *process.ExitEvent:
 		if ev == nil {
-			return "", false
+			return "", 0, false
 		}
-		return ev.ProcessID, true
+		return ev.ProcessID, ev.ExitCode, true
 	case map[string]interface{}:
 		typ, _ := ev["type"].(string)
 		if typ != "exit" {
-			return "", false
+			return "", 0, false
 		}
-		return eventProcessID(ev["id"]), true
+		// Guest exit events carry "code"; the bridge renames it.
+		code, _ := ev["exitCode"].(float64)
+		return eventProcessID(ev["id"]), int(code), true
 	default:
-		return "", false
+		return "", 0, false
 	}
 }
 
@@ -1206,7 +1282,8 @@ func (b *KvmBackend) cleanupVMRuntime(state vmRuntimeState, name string, reason
 	}
 
 	b.procMu.Lock()
-	b.processes = make(map[string]struct{})
+	b.processes = make(map[string]string)
+	b.updateProcessGaugeLocked()
 	b.procMu.Unlock()
 
 	stopName := name
@@ -78,7 +78,7 @@ func TestEmitRemovesExitedProcessesFromRunningState(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			b := NewKvmBackend("", false)
-			b.processes[tc.id] = struct{}{}
+			b.processes[tc.id] = "s"
 
 			b.emit(tc.event)
 
@@ -15,6 +15,7 @@ import (
 	"unsafe"
 
 	"github.com/patrickjaja/claude-cowork-service/logx"
+	"github.com/patrickjaja/claude-cowork-service/pipe"
 )
 
 const (
@@ -330,7 +331,7 @@ func (g *GuestBridge) Forward(method string, params interface{}) (json.RawMessag
 	conn := g.conn
 	g.connMu.RUnlock()
 	if conn == nil || !g.connected.Load() {
-		return nil, fmt.Errorf("guest not connected")
+		return nil, pipe.ErrGuestNotConnected
 	}
 
 	id := strconv.FormatUint(atomic.AddUint64(&g.nextID, 1), 10)
@@ -345,6 +346,7 @@ func (g *GuestBridge) Forward(method string, params interface{}) (json.RawMessag
 		"params": params,
 		"id":     id,
 	}
+	start := time.Now()
 	if err := g.write(conn, req); err != nil {
 		g.pendMu.Lock()
 		delete(g.pending, id)
@@ -354,8 +356,9 @@ func (g *GuestBridge) Forward(method string, params interface{}) (json.RawMessag
 
 	select {
 	case resp, ok := <-ch:
+		guestForwardDuration.With(method).ObserveSince(start)
 		if !ok {
-			return nil, fmt.Errorf("guest disconnected while waiting for %s", method)
+			return nil, fmt.Errorf("%w: disconnected while waiting for %s", pipe.ErrGuestNotConnected, method)
 		}
 		if resp.err != nil {
 			return nil, fmt.Errorf("guest %s failed: %w", method, resp.err)
@@ -365,6 +368,7 @@ func (g *GuestBridge) Forward(method string, params interface{}) (json.RawMessag
 		g.pendMu.Lock()
 		delete(g.pending, id)
 		g.pendMu.Unlock()
+		guestForwardTimeouts.With(method).Inc()
 		return nil, fmt.Errorf("guest timeout waiting for %s", method)
 	}
 }
@@ -375,7 +379,7 @@ func (g *GuestBridge) Notify(method string, params interface{}) error {
 	conn := g.conn
 	g.connMu.RUnlock()
 	if conn == nil || !g.connected.Load() {
-		return fmt.Errorf("guest not connected")
+		return pipe.ErrGuestNotConnected
 	}
 	return g.write(conn, map[string]interface{}{
 		"type":   "notification",
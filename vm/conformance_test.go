@@ -0,0 +1,210 @@
+package vm
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/pipe/conformance"
+)
+
+// mockGuestBackend is a KvmBackend whose VM is a mockGuest on a socketpair:
+// StartVM connects the bridge to it instead of booting QEMU, so everything
+// past the vsock connection is the real backend.
+type mockGuestBackend struct {
+	*KvmBackend
+	files map[string][]byte
+}
+
+func (m *mockGuestBackend) StartVM(name string, bundlePath string, memoryGb int, cpuCount int, apiProbeURL string) error {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	conn := &vsockConn{file: os.NewFile(uintptr(fds[0]), "mock-guest-host")}
+	guest := &mockGuest{conn: os.NewFile(uintptr(fds[1]), "mock-guest"), files: m.files, live: map[string]string{}}
+
+	bridge := NewGuestBridge(VsockGuestPort, false, m.emit)
+	bridge.conn = conn
+	bridge.connected.Store(true)
+	go bridge.readLoop(conn)
+	go guest.serve()
+
+	m.mu.Lock()
+	m.bridge = bridge
+	m.mu.Unlock()
+	m.emit(map[string]interface{}{"type": "vmStarted", "name": name})
+	return nil
+}
+
+func (m *mockGuestBackend) StopVM(name string) error {
+	m.mu.Lock()
+	bridge := m.bridge
+	m.bridge = nil
+	m.mu.Unlock()
+	if bridge != nil {
+		bridge.Close()
+	}
+	return nil
+}
+
+func (m *mockGuestBackend) IsRunning(name string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.bridge != nil, nil
+}
+
+// mockGuest speaks the sdk-daemon side of the vsock protocol the way the
+// upstream guest does: exit events carry "code", and empty result fields
+// are omitted rather than sent as [] or {}.
+type mockGuest struct {
+	conn  *os.File
+	files map[string][]byte
+	live  map[string]string // process id → session name
+}
+
+func (g *mockGuest) serve() {
+	defer func() { _ = g.conn.Close() }()
+	for {
+		raw, err := readFramed(g.conn)
+		if err != nil {
+			return
+		}
+		var msg struct {
+			Type   string          `json:"type"`
+			Method string          `json:"method"`
+			ID     string          `json:"id"`
+			Params json.RawMessage `json:"params"`
+		}
+		if json.Unmarshal(raw, &msg) != nil {
+			continue
+		}
+		if msg.Type == "notification" && msg.Method == "stdin" {
+			g.stdin(msg.Params)
+			continue
+		}
+		if msg.Type != "request" {
+			continue
+		}
+		result, errMsg := g.handle(msg.Method, msg.Params)
+		reply := map[string]interface{}{"type": "response", "id": msg.ID}
+		if errMsg != "" {
+			reply["error"] = errMsg
+		} else {
+			reply["result"] = result
+		}
+		g.send(reply)
+	}
+}
+
+func (g *mockGuest) handle(method string, params json.RawMessage) (map[string]interface{}, string) {
+	var p struct {
+		ID       string   `json:"id"`
+		Name     string   `json:"name"`
+		FilePath string   `json:"filePath"`
+		Names    []string `json:"names"`
+	}
+	_ = json.Unmarshal(params, &p)
+	result := map[string]interface{}{}
+	switch method {
+	case "spawn":
+		g.live[p.ID] = p.Name
+	case "kill":
+		if _, ok := g.live[p.ID]; ok {
+			g.exit(p.ID, 143)
+		}
+	case "readFile":
+		content, ok := g.files[p.FilePath]
+		if !ok {
+			return nil, "ENOENT: " + p.FilePath
+		}
+		result["content"] = base64.StdEncoding.EncodeToString(content)
+	case "getSessionsDiskInfo":
+		result["totalBytes"] = 100 << 30
+		result["freeBytes"] = 40 << 30
+	case "deleteSessionDirs":
+		var deleted []string
+		errs := map[string]string{}
+		for _, name := range p.Names {
+			if g.sessionRunning(name) {
+				errs[name] = "session has a running process"
+			} else {
+				deleted = append(deleted, name)
+			}
+		}
+		if len(deleted) > 0 {
+			result["deleted"] = deleted
+		}
+		if len(errs) > 0 {
+			result["errors"] = errs
+		}
+	case "pruneSessionCaches":
+		result["freedBytes"] = 0
+	}
+	return result, ""
+}
+
+// stdin echoes each line as stdout; "exit N" ends the process instead.
+func (g *mockGuest) stdin(params json.RawMessage) {
+	var p struct {
+		ID   string `json:"id"`
+		Data string `json:"data"`
+	}
+	if json.Unmarshal(params, &p) != nil {
+		return
+	}
+	if _, ok := g.live[p.ID]; !ok {
+		return
+	}
+	for _, line := range strings.SplitAfter(p.Data, "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimSuffix(line, "\n"), "exit "); ok {
+			code, _ := strconv.Atoi(rest)
+			g.exit(p.ID, code)
+			return
+		}
+		if line != "" {
+			g.send(map[string]interface{}{"type": "stdout", "id": p.ID, "data": line})
+		}
+	}
+}
+
+func (g *mockGuest) exit(id string, code int) {
+	delete(g.live, id)
+	g.send(map[string]interface{}{"type": "exit", "id": id, "code": code})
+}
+
+func (g *mockGuest) sessionRunning(name string) bool {
+	for _, session := range g.live {
+		if session == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *mockGuest) send(msg map[string]interface{}) {
+	data, _ := json.Marshal(msg)
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	_, _ = g.conn.Write(append(buf, data...))
+}
+
+func TestConformance(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	const artifact = "/sessions/" + conformance.Session + "/mnt/outputs/artifact.html"
+	content := []byte("<html>\x00\xff</html>")
+
+	conformance.Run(t, conformance.Target{
+		New: func(t *testing.T) pipe.VMBackend {
+			b := NewKvmBackend(t.TempDir(), false)
+			return &mockGuestBackend{KvmBackend: b, files: map[string][]byte{artifact: content}}
+		},
+		Command: "/usr/local/bin/claude",
+		File:    func(t *testing.T) (string, []byte) { return artifact, content },
+	})
+}
@@ -0,0 +1,12 @@
+package vm
+
+import "github.com/patrickjaja/claude-cowork-service/metrics"
+
+var (
+	guestForwardDuration = metrics.NewHistogramVec("cowork_guest_forward_duration_seconds",
+		"Round-trip latency of requests forwarded to the guest sdk-daemon, by method.", nil, "method")
+	guestForwardTimeouts = metrics.NewCounterVec("cowork_guest_forward_timeouts_total",
+		"Requests to the guest sdk-daemon that hit the 30s reply timeout, by method.", "method")
+	vmBootStageDuration = metrics.NewHistogramVec("cowork_vm_boot_stage_duration_seconds",
+		"Duration of each StartVM stage (prepare_session, start_vm, wait_for_guest).", metrics.BootBuckets, "stage")
+)
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/patrickjaja/claude-cowork-service/pipe"
+	"github.com/patrickjaja/claude-cowork-service/sessions"
 	"github.com/patrickjaja/claude-cowork-service/transcript"
 )
 
@@ -27,7 +28,7 @@ func sanitizeNativeArtifacts(home, sessionName string, mounts map[string]pipe.Mo
 	// Session mnt/ directory: every symlink in there is a native-backend
 	// artifact. Never remove non-symlinks.
 	if sessionName != "" {
-		dir := filepath.Join(home, ".local", "share", "claude-cowork", "sessions", sessionName, "mnt")
+		dir := filepath.Join(sessions.Dir(home, sessionName), "mnt")
 		entries, err := os.ReadDir(dir)
 		if err != nil {
 			// Missing dir means no native-era state - nothing to do.